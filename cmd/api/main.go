@@ -16,14 +16,17 @@ import (
 	"github.com/labstack/echo-contrib/echoprometheus"
 	"github.com/labstack/echo/v4"
 	echomw "github.com/labstack/echo/v4/middleware"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 
 	apimw "github.com/fabriziosalmi/rainlogs/internal/api/middleware"
 	"github.com/fabriziosalmi/rainlogs/internal/api/routes"
+	"github.com/fabriziosalmi/rainlogs/internal/breaker"
 	"github.com/fabriziosalmi/rainlogs/internal/config"
 	"github.com/fabriziosalmi/rainlogs/internal/db"
 	"github.com/fabriziosalmi/rainlogs/internal/kms"
 	"github.com/fabriziosalmi/rainlogs/internal/storage"
+	"github.com/fabriziosalmi/rainlogs/internal/worker"
 	"github.com/fabriziosalmi/rainlogs/pkg/logger"
 )
 
@@ -59,7 +62,7 @@ func run() error {
 		return fmt.Errorf("failed to init kms: %w", err)
 	}
 
-	// 3. Init Storage (for log download)
+	// 3. Init Storage (for log download and Logpush batches)
 	var backend storage.Backend
 	switch cfg.Storage.Backend {
 	case "s3":
@@ -72,7 +75,7 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("failed to init storage: %w", err)
 	}
-	multiStore := storage.NewMultiStore(backend)
+	multiStore := storage.NewMultiStore(backend).WithSpool(cfg.Storage.SpoolDir, cfg.Storage.SpoolMaxBytes)
 
 	// 4. Init Queue client (for trigger-pull)
 	redisOpt := asynq.RedisClientOpt{
@@ -83,6 +86,21 @@ func run() error {
 	queueClient := asynq.NewClient(redisOpt)
 	defer queueClient.Close()
 
+	// Circuit breakers are read from the Redis the workers keep them in, to
+	// report them in zone health. Cloudflare calls made by the API (Ray
+	// lookups) share the workers' tokens, request budgets and breakers.
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	defer rdb.Close()
+	breakers := breaker.New(rdb, cfg.Worker.BreakerThreshold, cfg.Worker.BreakerCooldown)
+	limits := worker.NewRateLimiter(rdb, cfg.RateLimits, cfg.Cloudflare, appLog)
+	credentials := worker.NewCredentials(kmsService)
+	// Plans a customer sets are handed over like detected ones.
+	plans := worker.NewPlanSwitcher(database, queueClient, cfg.Worker, appLog)
+
 	// 5. Init Echo
 	e := echo.New()
 	e.HideBanner = true
@@ -126,7 +144,7 @@ func run() error {
 	}
 
 	// 6. Register Routes
-	routes.Register(e, database, kmsService, jwtSecret, queueClient, multiStore)
+	routes.Register(e, database, kmsService, jwtSecret, queueClient, multiStore, cfg.Cloudflare, breakers, credentials, limits, plans)
 
 	// 7. Enhanced health check
 	e.GET("/health", func(c echo.Context) error {
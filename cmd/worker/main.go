@@ -10,9 +10,13 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 
+	"github.com/fabriziosalmi/rainlogs/internal/breaker"
 	"github.com/fabriziosalmi/rainlogs/internal/config"
 	"github.com/fabriziosalmi/rainlogs/internal/db"
 	"github.com/fabriziosalmi/rainlogs/internal/kms"
@@ -98,7 +102,7 @@ func run() error {
 		return fmt.Errorf("unknown storage backend: %s", cfg.Storage.Backend)
 	}
 
-	s3Client := storage.NewMultiStore(backends...)
+	s3Client := storage.NewMultiStore(backends...).WithSpool(cfg.Storage.SpoolDir, cfg.Storage.SpoolMaxBytes)
 
 	// 4. Init Queue
 	redisOpt := asynq.RedisClientOpt{
@@ -119,20 +123,44 @@ func run() error {
 		appLog.Info("alerting configured", zap.String("backend", "console"))
 	}
 
-	// 6. Init Processors
-	pullProcessor := worker.NewLogPullProcessor(database, kmsService, s3Client, queueClient, *cfg, appLog, notifier)
-	securityProcessor := worker.NewSecurityEventsProcessor(database, kmsService, s3Client, queueClient, cfg.Cloudflare, appLog, notifier)
+	// 6. Init Processors. Cloudflare requests draw on per-token budgets
+	// shared by all workers through Redis, and are paused per account and
+	// API family by circuit breakers kept there too.
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	defer rdb.Close()
+	limits := worker.NewRateLimiter(rdb, cfg.RateLimits, cfg.Cloudflare, appLog)
+	breakers := breaker.New(rdb, cfg.Worker.BreakerThreshold, cfg.Worker.BreakerCooldown)
+
+	credentials := worker.NewCredentials(kmsService)
+	pullProcessor := worker.NewLogPullProcessor(database, credentials, s3Client, queueClient, *cfg, limits, breakers, appLog, notifier)
+	securityProcessor := worker.NewSecurityEventsProcessor(database, credentials, s3Client, queueClient, cfg.Cloudflare, limits, breakers, appLog, notifier)
+	settleProcessor := worker.NewLogSettleProcessor(database, credentials, s3Client, queueClient, cfg.Cloudflare, limits, breakers, appLog, notifier)
 
 	verifyProcessor := worker.NewLogVerifyProcessor(database, s3Client, appLog)
 	expireProcessor := worker.NewLogExpireProcessor(database.LogJobs, s3Client, appLog)
 	exportProcessor := worker.NewLogExportProcessor(database, kmsService, s3Client, appLog, notifier)
+	gapScanProcessor := worker.NewGapScanProcessor(database, queueClient, cfg.Worker, appLog, notifier)
+	plans := worker.NewPlanSwitcher(database, queueClient, cfg.Worker, appLog)
+	discoveryProcessor := worker.NewZoneDiscoveryProcessor(database, credentials, plans, cfg.Cloudflare, limits, appLog, notifier)
+	preflightProcessor := worker.NewPreflightProcessor(database, credentials, cfg.Cloudflare, cfg.Worker, limits, appLog, notifier)
+	auditLogProcessor := worker.NewAuditLogProcessor(database, credentials, s3Client, queueClient, rdb, cfg.Cloudflare, limits, appLog, notifier)
 
 	// 6b. Init Instant Logs Daemon
-	instantLogsManager := worker.NewInstantLogsManager(database, kmsService, s3Client, cfg.Cloudflare, appLog, notifier)
+	replicaID := cfg.Worker.ReplicaID
+	if replicaID == "" {
+		host, _ := os.Hostname()
+		replicaID = host + "-" + uuid.NewString()[:8]
+	}
+	leases := worker.NewLeaseStore(rdb, replicaID, cfg.Worker.LeaseTTL)
+	instantLogsManager := worker.NewInstantLogsManager(database, credentials, s3Client, queueClient, leases, cfg.Cloudflare, cfg.Worker, limits, breakers, appLog, notifier)
 	go instantLogsManager.Start(ctx)
 
 	// 7. Start Scheduler
-	scheduler := worker.NewZoneScheduler(database, queueClient, appLog, cfg.Worker.SchedulerInterval)
+	scheduler := worker.NewZoneScheduler(database, queueClient, breakers, appLog, cfg.Worker)
 	go scheduler.Run(ctx)
 
 	// 7. Start Worker Server
@@ -140,6 +168,10 @@ func run() error {
 		redisOpt,
 		asynq.Config{
 			Concurrency: cfg.Worker.Concurrency,
+			// Cloudflare 429s wait out their Retry-After without using up a
+			// retry; other failures back off with jitter.
+			RetryDelayFunc: worker.RetryDelay,
+			IsFailure:      worker.IsFailure,
 			Queues: map[string]int{
 				queue.QueueCritical: 6,
 				queue.QueueDefault:  3,
@@ -149,11 +181,17 @@ func run() error {
 	)
 
 	mux := asynq.NewServeMux()
+	mux.Use(worker.SkipPermanent)
 	mux.HandleFunc(queue.TypeLogPull, pullProcessor.ProcessTask)
 	mux.HandleFunc(queue.TypeSecurityPoll, securityProcessor.ProcessTask)
 	mux.HandleFunc(queue.TypeLogVerify, verifyProcessor.ProcessTask)
+	mux.HandleFunc(queue.TypeLogSettle, settleProcessor.ProcessTask)
 	mux.HandleFunc(queue.TypeLogExport, exportProcessor.ProcessTask)
 	mux.HandleFunc(queue.TypeLogExpire, expireProcessor.ProcessTask)
+	mux.HandleFunc(queue.TypeGapScan, gapScanProcessor.ProcessTask)
+	mux.HandleFunc(queue.TypeZoneDiscover, discoveryProcessor.ProcessTask)
+	mux.HandleFunc(queue.TypePreflight, preflightProcessor.ProcessTask)
+	mux.HandleFunc(queue.TypeAuditLogPull, auditLogProcessor.ProcessTask)
 
 	errChan := make(chan error, 1)
 
@@ -176,9 +214,18 @@ func run() error {
 			type queueInfo struct {
 				Size int `json:"size"`
 			}
+			type leaseInfo struct {
+				ZoneID string `json:"zone_id"`
+				Name   string `json:"name"`
+			}
+			type instantInfo struct {
+				Replica string      `json:"replica"`
+				Leases  []leaseInfo `json:"leases"`
+			}
 			type resp struct {
-				Status string               `json:"status"`
-				Queues map[string]queueInfo `json:"queues"`
+				Status      string               `json:"status"`
+				Queues      map[string]queueInfo `json:"queues"`
+				InstantLogs instantInfo          `json:"instant_logs"`
 			}
 			queues := map[string]queueInfo{}
 			overall := "ok"
@@ -191,13 +238,21 @@ func run() error {
 				}
 				queues[qName] = queueInfo{Size: info.Size}
 			}
+			replica, zones := instantLogsManager.Leases()
+			instant := instantInfo{Replica: replica, Leases: []leaseInfo{}}
+			for _, z := range zones {
+				instant.Leases = append(instant.Leases, leaseInfo{ZoneID: z.ID.String(), Name: z.Name})
+			}
 			w.Header().Set("Content-Type", "application/json")
 			if overall != "ok" {
 				w.WriteHeader(http.StatusServiceUnavailable)
 			}
-			_ = json.NewEncoder(w).Encode(resp{Status: overall, Queues: queues})
+			_ = json.NewEncoder(w).Encode(resp{Status: overall, Queues: queues, InstantLogs: instant})
 		})
 
+		// Worker metrics (Instant Logs spool backpressure etc.)
+		http.Handle("/metrics", promhttp.Handler())
+
 		if err := healthSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			appLog.Error("worker health server stopped", zap.Error(err))
 		}
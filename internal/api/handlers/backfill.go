@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/labstack/echo/v4"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/queue"
+)
+
+// ── Backfill Handlers ─────────────────────────────────────────────────────────
+
+type CreateBackfillRequest struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// backfillProgress is the backfill resource: the request plus the state of
+// its hour windows, derived from the zone's log jobs.
+type backfillProgress struct {
+	models.Backfill
+	Status  string          `json:"status"` // running, completed or failed
+	Windows backfillWindows `json:"windows"`
+}
+
+type backfillWindows struct {
+	Total   int `json:"total"`
+	Done    int `json:"done"`
+	Running int `json:"running"`
+	Failed  int `json:"failed"`
+	Queued  int `json:"queued"`
+}
+
+// CreateBackfill pulls a historical range still inside Cloudflare's Logpull
+// retention. The range is split into hour windows aligned to the clock; windows
+// that already have a done job are skipped and the rest are enqueued as
+// low-priority pull tasks.
+func (h *Handlers) CreateBackfill(c echo.Context) error {
+	customerID, err := mustCustomerID(c)
+	if err != nil {
+		return err
+	}
+
+	zoneID, err := uuid.Parse(c.Param("zone_id"))
+	if err != nil {
+		return apiErr(c, http.StatusBadRequest, "invalid zone_id", "INVALID_REQUEST")
+	}
+
+	var req CreateBackfillRequest
+	if err := c.Bind(&req); err != nil {
+		return apiErr(c, http.StatusBadRequest, "invalid request body", "INVALID_REQUEST")
+	}
+
+	ctx := c.Request().Context()
+	zone, err := h.db.Zones.GetByID(ctx, zoneID)
+	if err != nil {
+		return apiErr(c, http.StatusNotFound, "zone not found", "ZONE_NOT_FOUND")
+	}
+	if zone.CustomerID != customerID {
+		return apiErr(c, http.StatusForbidden, "access denied", "ACCESS_DENIED")
+	}
+	if zone.Plan != models.PlanEnterprise {
+		return apiErr(c, http.StatusConflict, "backfill requires Logpull (enterprise plan)", "PLAN_NOT_SUPPORTED")
+	}
+
+	now := time.Now().UTC()
+	from, to := req.From.UTC().Truncate(time.Second), req.To.UTC().Truncate(time.Second)
+	horizon := now.Add(-cloudflare.LogRetention)
+	latest := now.Add(-cloudflare.LogAvailabilityDelay)
+	switch {
+	case from.IsZero() || to.IsZero() || !from.Before(to):
+		return apiErr(c, http.StatusBadRequest, "from and to are required and from must be before to", "INVALID_REQUEST")
+	case from.Before(horizon):
+		return apiErr(c, http.StatusBadRequest,
+			fmt.Sprintf("from is beyond Cloudflare's Logpull retention (earliest %s)", horizon.Format(time.RFC3339)), "OUT_OF_RETENTION")
+	case to.After(latest):
+		return apiErr(c, http.StatusBadRequest,
+			fmt.Sprintf("to is not yet available from Logpull (latest %s)", latest.Format(time.RFC3339)), "INVALID_REQUEST")
+	}
+
+	windows := cloudflare.AlignedWindows(from, to, time.Hour)
+	backfill := &models.Backfill{
+		ID:           uuid.New(),
+		CustomerID:   customerID,
+		ZoneID:       zone.ID,
+		PeriodStart:  from,
+		PeriodEnd:    to,
+		WindowsTotal: len(windows),
+	}
+
+	// Windows already archived, by any mix of jobs, are skipped.
+	periods, err := h.db.LogJobs.ListPeriods(ctx, zone.ID, models.LogTypeLogpull, from, to)
+	if err != nil {
+		c.Logger().Errorf("backfill zone %s: list periods: %v", zoneID, err)
+		return apiErr(c, http.StatusInternalServerError, "failed to plan backfill")
+	}
+	for _, w := range windows {
+		if models.Covered(w.Start, w.End, periods, models.JobStatusDone) {
+			backfill.WindowsSkipped++
+			continue
+		}
+
+		task, err := queue.NewLogPullTask(queue.LogPullPayload{
+			ZoneID:      zone.ID,
+			CustomerID:  customerID,
+			PeriodStart: w.Start,
+			PeriodEnd:   w.End,
+		}, asynq.Queue(queue.QueueLow))
+		if err != nil {
+			return apiErr(c, http.StatusInternalServerError, "failed to create pull task")
+		}
+		// The task ID makes overlapping backfills enqueue each window once.
+		taskID := fmt.Sprintf("backfill-%s-%d-%d", zone.ID, w.Start.Unix(), w.End.Unix())
+		if _, err := h.queue.EnqueueContext(ctx, task, asynq.TaskID(taskID)); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+			c.Logger().Errorf("backfill zone %s: enqueue window %s: %v", zoneID, w.Start, err)
+			return apiErr(c, http.StatusInternalServerError, "failed to enqueue backfill")
+		}
+	}
+
+	if err := h.db.Backfills.Create(ctx, backfill); err != nil {
+		c.Logger().Errorf("create backfill for zone %s: %v", zoneID, err)
+		return apiErr(c, http.StatusInternalServerError, "failed to record backfill")
+	}
+
+	progress, err := h.backfillProgress(c, backfill)
+	if err != nil {
+		c.Logger().Errorf("backfill %s progress: %v", backfill.ID, err)
+		return apiErr(c, http.StatusInternalServerError, "failed to load backfill progress")
+	}
+	c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/api/v1/zones/%s/backfills/%s", zone.ID, backfill.ID))
+	return c.JSON(http.StatusAccepted, progress)
+}
+
+// GetBackfill returns the progress of a backfill.
+func (h *Handlers) GetBackfill(c echo.Context) error {
+	customerID, err := mustCustomerID(c)
+	if err != nil {
+		return err
+	}
+
+	zoneID, err := uuid.Parse(c.Param("zone_id"))
+	if err != nil {
+		return apiErr(c, http.StatusBadRequest, "invalid zone_id", "INVALID_REQUEST")
+	}
+	backfillID, err := uuid.Parse(c.Param("backfill_id"))
+	if err != nil {
+		return apiErr(c, http.StatusBadRequest, "invalid backfill_id", "INVALID_REQUEST")
+	}
+
+	backfill, err := h.db.Backfills.GetByID(c.Request().Context(), backfillID)
+	if err != nil || backfill.ZoneID != zoneID {
+		return apiErr(c, http.StatusNotFound, "backfill not found", "NOT_FOUND")
+	}
+	if backfill.CustomerID != customerID {
+		return apiErr(c, http.StatusForbidden, "access denied", "ACCESS_DENIED")
+	}
+
+	progress, err := h.backfillProgress(c, backfill)
+	if err != nil {
+		c.Logger().Errorf("backfill %s progress: %v", backfill.ID, err)
+		return apiErr(c, http.StatusInternalServerError, "failed to load backfill progress")
+	}
+	return c.JSON(http.StatusOK, progress)
+}
+
+func (h *Handlers) backfillProgress(c echo.Context, b *models.Backfill) (*backfillProgress, error) {
+	periods, err := h.db.LogJobs.ListPeriods(c.Request().Context(), b.ZoneID, models.LogTypeLogpull, b.PeriodStart, b.PeriodEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &backfillProgress{Backfill: *b}
+	p.Windows = countBackfillWindows(cloudflare.AlignedWindows(b.PeriodStart, b.PeriodEnd, time.Hour), periods)
+	switch {
+	case p.Windows.Done == p.Windows.Total:
+		p.Status = "completed"
+	case p.Windows.Running == 0 && p.Windows.Queued == 0:
+		p.Status = "failed"
+	default:
+		p.Status = "running"
+	}
+	return p, nil
+}
+
+// countBackfillWindows classifies each window: done when covered by done jobs,
+// running when a job for it is in flight, failed when only failed jobs exist,
+// and queued when no job has started yet.
+func countBackfillWindows(windows []cloudflare.Window, periods []models.JobPeriod) backfillWindows {
+	out := backfillWindows{Total: len(windows)}
+	for _, w := range windows {
+		if models.Covered(w.Start, w.End, periods, models.JobStatusDone) {
+			out.Done++
+			continue
+		}
+		var running, failed bool
+		for _, p := range periods {
+			if !p.Start.Before(w.End) || !p.End.After(w.Start) {
+				continue
+			}
+			switch p.Status {
+			case models.JobStatusPending, models.JobStatusRunning:
+				running = true
+			case models.JobStatusFailed:
+				failed = true
+			}
+		}
+		switch {
+		case running:
+			out.Running++
+		case failed:
+			out.Failed++
+		default:
+			out.Queued++
+		}
+	}
+	return out
+}
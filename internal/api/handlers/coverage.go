@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+// ── Coverage Handlers ─────────────────────────────────────────────────────────
+
+// maxCoverageRange bounds a coverage query to a little over the default
+// archive retention.
+const maxCoverageRange = 400 * 24 * time.Hour
+
+// coverageReport lists which parts of a range have archived logs.
+type coverageReport struct {
+	ZoneID        uuid.UUID         `json:"zone_id"`
+	LogType       string            `json:"log_type"`
+	From          time.Time         `json:"from"`
+	To            time.Time         `json:"to"`
+	CoverageRatio float64           `json:"coverage_ratio"`
+	Covered       []models.Interval `json:"covered"`
+	Missing       []coverageGap     `json:"missing"`
+}
+
+// coverageGap is a missing interval. Recoverable gaps are still held by
+// Cloudflare and are pulled again by the worker's gap scan.
+type coverageGap struct {
+	models.Interval
+	Recoverable bool `json:"recoverable"`
+}
+
+// GetZoneCoverage returns the intervals of [from, to) covered by archived log
+// jobs and the intervals missing. from and to default to the seven days up to
+// the zone's last scheduled pull; log_type defaults to the one the zone's plan
+// collects.
+func (h *Handlers) GetZoneCoverage(c echo.Context) error {
+	customerID, err := mustCustomerID(c)
+	if err != nil {
+		return err
+	}
+
+	zoneID, err := uuid.Parse(c.Param("zone_id"))
+	if err != nil {
+		return apiErr(c, http.StatusBadRequest, "invalid zone_id", "INVALID_REQUEST")
+	}
+
+	ctx := c.Request().Context()
+	zone, err := h.db.Zones.GetByID(ctx, zoneID)
+	if err != nil {
+		return apiErr(c, http.StatusNotFound, "zone not found", "ZONE_NOT_FOUND")
+	}
+	if zone.CustomerID != customerID {
+		return apiErr(c, http.StatusForbidden, "access denied", "ACCESS_DENIED")
+	}
+
+	logType := models.LogTypeForPlan(zone.Plan)
+	if lt := c.QueryParam("log_type"); lt != "" {
+		switch lt {
+		case models.LogTypeLogpull, models.LogTypeSecurity, models.LogTypeInstant:
+			logType = lt
+		default:
+			return apiErr(c, http.StatusBadRequest, "log_type must be logs, security or instant", "INVALID_REQUEST")
+		}
+	}
+
+	now := time.Now().UTC()
+	to := now
+	if logType != models.LogTypeInstant && zone.LastPulledAt != nil {
+		to = zone.LastPulledAt.UTC()
+	}
+	if v := c.QueryParam("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			return apiErr(c, http.StatusBadRequest, "to must be an RFC 3339 timestamp", "INVALID_REQUEST")
+		}
+	}
+	from := to.Add(-7 * 24 * time.Hour)
+	if v := c.QueryParam("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			return apiErr(c, http.StatusBadRequest, "from must be an RFC 3339 timestamp", "INVALID_REQUEST")
+		}
+	}
+	from, to = from.UTC(), to.UTC()
+	if to.After(now) {
+		to = now
+	}
+	if !from.Before(to) || to.Sub(from) > maxCoverageRange {
+		return apiErr(c, http.StatusBadRequest, "from must be before to and the range at most 400 days", "INVALID_REQUEST")
+	}
+
+	periods, err := h.db.LogJobs.ListPeriods(ctx, zone.ID, logType, from, to)
+	if err != nil {
+		c.Logger().Errorf("coverage zone %s: list periods: %v", zoneID, err)
+		return apiErr(c, http.StatusInternalServerError, "failed to load coverage")
+	}
+	// Expired jobs were archived and later pruned by retention, not missed.
+	covered, missing := models.Coverage(from, to, periods, models.JobStatusDone, models.JobStatusExpired)
+
+	report := coverageReport{
+		ZoneID:  zone.ID,
+		LogType: logType,
+		From:    from,
+		To:      to,
+		Covered: make([]models.Interval, 0, len(covered)),
+		Missing: make([]coverageGap, 0, len(missing)),
+	}
+	var coveredDur time.Duration
+	for _, iv := range covered {
+		coveredDur += iv.Duration()
+		report.Covered = append(report.Covered, iv)
+	}
+	report.CoverageRatio = coveredDur.Seconds() / to.Sub(from).Seconds()
+
+	horizon := now.Add(-cloudflare.PullRetention(logType))
+	for _, gap := range missing {
+		if gap.Start.Before(horizon) && gap.End.After(horizon) {
+			report.Missing = append(report.Missing,
+				coverageGap{Interval: models.Interval{Start: gap.Start, End: horizon}},
+				coverageGap{Interval: models.Interval{Start: horizon, End: gap.End}, Recoverable: true})
+			continue
+		}
+		report.Missing = append(report.Missing, coverageGap{Interval: gap, Recoverable: !gap.Start.Before(horizon)})
+	}
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+// ── Zone Dataset Handlers ────────────────────────────────────────────────────
+
+type PutZoneDatasetRequest struct {
+	PullIntervalSecs int `json:"pull_interval_secs"`
+	RetentionDays    int `json:"retention_days"`
+}
+
+// zoneDataset is a dataset a zone archives and the collector that fills it:
+// logs, instant or security for pulled datasets, logpush for pushed ones.
+type zoneDataset struct {
+	models.ZoneDataset
+	Collector string `json:"collector"`
+}
+
+// datasetCollector returns the log type of the collector archiving ds for
+// zone.
+func datasetCollector(zone *models.Zone, ds *models.ZoneDataset) string {
+	switch {
+	case ds.Dataset == models.DatasetForPlan(zone.Plan):
+		return models.LogTypeForPlan(zone.Plan)
+	case ds.PullIntervalSecs > 0 && models.DatasetPullable(zone.Plan, ds.Dataset):
+		return models.LogTypeSecurity
+	default:
+		return models.LogTypeLogpush
+	}
+}
+
+// ListZoneDatasets returns the datasets a zone archives: the one its plan
+// collector pulls, on the zone's schedule, and those configured on the zone.
+func (h *Handlers) ListZoneDatasets(c echo.Context) error {
+	zone, err := h.ownedZone(c)
+	if zone == nil {
+		return err
+	}
+
+	configured, err := h.db.Datasets.ListByZone(c.Request().Context(), zone.ID)
+	if err != nil {
+		c.Logger().Errorf("list zone datasets: %v", err)
+		return apiErr(c, http.StatusInternalServerError, "failed to list datasets")
+	}
+
+	plan := &models.ZoneDataset{ZoneID: zone.ID, Dataset: models.DatasetForPlan(zone.Plan)}
+	resp := []zoneDataset{{}}
+	for _, ds := range configured {
+		if ds.Dataset == plan.Dataset {
+			plan = ds
+			continue
+		}
+		resp = append(resp, zoneDataset{ZoneDataset: *ds, Collector: datasetCollector(zone, ds)})
+	}
+	plan.PullIntervalSecs, plan.LastPulledAt = zone.PullIntervalSecs, zone.LastPulledAt
+	resp[0] = zoneDataset{ZoneDataset: *plan, Collector: datasetCollector(zone, plan)}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// PutZoneDataset adds a dataset to a zone or reconfigures it. Only firewall
+// events on zones whose plan collector doesn't pull them take a pull
+// interval; every other dataset is Logpush-only.
+func (h *Handlers) PutZoneDataset(c echo.Context) error {
+	zone, err := h.ownedZone(c)
+	if zone == nil {
+		return err
+	}
+
+	dataset := c.Param("dataset")
+	if !models.IsDataset(dataset) {
+		return apiErr(c, http.StatusBadRequest, "unknown dataset", "INVALID_DATASET")
+	}
+	var req PutZoneDatasetRequest
+	if err := c.Bind(&req); err != nil {
+		return apiErr(c, http.StatusBadRequest, "invalid request body", "INVALID_REQUEST")
+	}
+	if req.RetentionDays < 0 {
+		return apiErr(c, http.StatusBadRequest, "retention_days must not be negative", "INVALID_REQUEST")
+	}
+	if err := checkDatasetPull(zone.Plan, dataset, req.PullIntervalSecs); err != nil {
+		return apiErr(c, http.StatusBadRequest, err.Error(), "INVALID_REQUEST")
+	}
+
+	ds := &models.ZoneDataset{
+		ZoneID:           zone.ID,
+		Dataset:          dataset,
+		PullIntervalSecs: req.PullIntervalSecs,
+		RetentionDays:    req.RetentionDays,
+	}
+	if err := h.db.Datasets.Upsert(c.Request().Context(), ds); err != nil {
+		c.Logger().Errorf("upsert zone dataset: %v", err)
+		return apiErr(c, http.StatusInternalServerError, "failed to save dataset")
+	}
+	return c.JSON(http.StatusOK, zoneDataset{ZoneDataset: *ds, Collector: datasetCollector(zone, ds)})
+}
+
+// checkDatasetPull checks the pull interval of dataset on a zone on plan. 0 is
+// always valid; only firewall events, where the plan collector doesn't pull
+// them, take an interval, since every other dataset is Logpush-only.
+func checkDatasetPull(plan models.PlanType, dataset string, secs int) error {
+	const maxPullIntervalSecs = 518400 // as for zones
+	switch {
+	case secs == 0:
+		return nil
+	case dataset != models.DatasetFirewallEvents:
+		return errors.New("dataset is Logpush-only: pull_interval_secs must be 0")
+	case !models.DatasetPullable(plan, dataset):
+		return errors.New("dataset is the zone's plan dataset and follows its pull_interval_secs: pull_interval_secs must be 0")
+	case secs < 300 || secs > maxPullIntervalSecs:
+		return errors.New("pull_interval_secs out of range [300, 518400]")
+	}
+	return nil
+}
+
+// DeleteZoneDataset stops a zone's configured dataset from being pulled and
+// drops its retention override. Archived jobs stay until they expire.
+func (h *Handlers) DeleteZoneDataset(c echo.Context) error {
+	zone, err := h.ownedZone(c)
+	if zone == nil {
+		return err
+	}
+
+	deleted, err := h.db.Datasets.Delete(c.Request().Context(), zone.ID, c.Param("dataset"))
+	if err != nil {
+		c.Logger().Errorf("delete zone dataset: %v", err)
+		return apiErr(c, http.StatusInternalServerError, "failed to delete dataset")
+	}
+	if !deleted {
+		return apiErr(c, http.StatusNotFound, "dataset not configured on zone", "DATASET_NOT_FOUND")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
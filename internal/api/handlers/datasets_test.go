@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+func TestCheckDatasetPull(t *testing.T) {
+	tests := []struct {
+		name    string
+		plan    models.PlanType
+		dataset string
+		secs    int
+		wantErr string
+	}{
+		{"pushed dataset without interval", models.PlanEnterprise, models.DatasetDNSLogs, 0, ""},
+		{"firewall events on enterprise", models.PlanEnterprise, models.DatasetFirewallEvents, 900, ""},
+		{"firewall events on business", models.PlanBusiness, models.DatasetFirewallEvents, 518400, ""},
+		{"plan dataset without interval", models.PlanFreePro, models.DatasetFirewallEvents, 0, ""},
+		{"dns logs", models.PlanEnterprise, models.DatasetDNSLogs, 900, "Logpush-only"},
+		{"http requests", models.PlanEnterprise, models.DatasetHTTPRequests, 900, "Logpush-only"},
+		{"spectrum on free_pro", models.PlanFreePro, models.DatasetSpectrum, 900, "Logpush-only"},
+		{"firewall events on free_pro", models.PlanFreePro, models.DatasetFirewallEvents, 900, "plan dataset"},
+		{"interval too short", models.PlanEnterprise, models.DatasetFirewallEvents, 299, "out of range"},
+		{"interval too long", models.PlanEnterprise, models.DatasetFirewallEvents, 518401, "out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDatasetPull(tt.plan, tt.dataset, tt.secs)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/labstack/echo/v4"
+
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/queue"
+)
+
+// ── Cloudflare Zone Discovery Handlers ───────────────────────────────────────
+
+// discoveredZone is a zone of the customer's Cloudflare account, with the
+// registered zone it maps to, if any.
+type discoveredZone struct {
+	models.CloudflareZone
+	LogType      string     `json:"log_type"` // collector selected by plan
+	Registered   bool       `json:"registered"`
+	RegisteredID *uuid.UUID `json:"registered_zone_id,omitempty"`
+}
+
+type discoveredZones struct {
+	AutoEnrollZones bool             `json:"auto_enroll_zones"`
+	Zones           []discoveredZone `json:"zones"`
+}
+
+type UpdateCustomerRequest struct {
+	AutoEnrollZones *bool `json:"auto_enroll_zones"`
+	// CFAPIKey rotates the Cloudflare API token; it is verified first.
+	CFAPIKey *string `json:"cf_api_key"`
+}
+
+// ListCloudflareZones returns the zones found in the customer's Cloudflare
+// account by the last discovery run, with the plan Cloudflare reports and
+// whether each is registered.
+func (h *Handlers) ListCloudflareZones(c echo.Context) error {
+	customerID, err := mustCustomerID(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	customer, err := h.db.Customers.GetByID(ctx, customerID)
+	if err != nil {
+		return apiErr(c, http.StatusNotFound, "customer not found")
+	}
+	found, err := h.db.CFZones.ListByCustomer(ctx, customerID)
+	if err != nil {
+		c.Logger().Errorf("list cloudflare zones: %v", err)
+		return apiErr(c, http.StatusInternalServerError, "failed to list cloudflare zones")
+	}
+	zones, err := h.db.Zones.ListByCustomer(ctx, customerID)
+	if err != nil {
+		return apiErr(c, http.StatusInternalServerError, "failed to list zones")
+	}
+	registered := make(map[string]uuid.UUID, len(zones))
+	for _, z := range zones {
+		registered[z.ZoneID] = z.ID
+	}
+
+	resp := discoveredZones{AutoEnrollZones: customer.AutoEnrollZones, Zones: make([]discoveredZone, len(found))}
+	for i, cz := range found {
+		resp.Zones[i] = discoveredZone{CloudflareZone: *cz, LogType: models.LogTypeForPlan(cz.Plan)}
+		if id, ok := registered[cz.CFZoneID]; ok {
+			resp.Zones[i].Registered = true
+			resp.Zones[i].RegisteredID = &id
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// DiscoverCloudflareZones queues a discovery run for the customer's account.
+func (h *Handlers) DiscoverCloudflareZones(c echo.Context) error {
+	customerID, err := mustCustomerID(c)
+	if err != nil {
+		return err
+	}
+	if err := h.enqueueDiscovery(c.Request().Context(), customerID); err != nil {
+		c.Logger().Errorf("enqueue zone discovery: %v", err)
+		return apiErr(c, http.StatusInternalServerError, "failed to enqueue zone discovery")
+	}
+	return c.JSON(http.StatusAccepted, map[string]string{"status": "queued"})
+}
+
+// UpdateCustomer changes the customer's settings. Enabling auto_enroll_zones
+// queues a discovery run so existing zones are enrolled right away; a new
+// cf_api_key is verified before it replaces the old one and queues a token
+// preflight.
+func (h *Handlers) UpdateCustomer(c echo.Context) error {
+	customerID, err := mustCustomerID(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return apiErr(c, http.StatusBadRequest, "invalid id", "INVALID_REQUEST")
+	}
+	if id != customerID {
+		return apiErr(c, http.StatusForbidden, "access denied", "ACCESS_DENIED")
+	}
+
+	var req UpdateCustomerRequest
+	if err := c.Bind(&req); err != nil {
+		return apiErr(c, http.StatusBadRequest, "invalid request body", "INVALID_REQUEST")
+	}
+
+	ctx := c.Request().Context()
+	customer, err := h.db.Customers.GetByID(ctx, id)
+	if err != nil {
+		return apiErr(c, http.StatusNotFound, "customer not found")
+	}
+	if req.CFAPIKey != nil {
+		if *req.CFAPIKey == "" {
+			return apiErr(c, http.StatusBadRequest, "cf_api_key must not be empty", "INVALID_REQUEST")
+		}
+		token, err := h.verifyCFToken(ctx, customer.CFAccountID, *req.CFAPIKey)
+		if err != nil {
+			return apiErr(c, http.StatusUnprocessableEntity, err.Error(), "INVALID_CF_TOKEN")
+		}
+		encKey, err := h.kms.Encrypt(*req.CFAPIKey)
+		if err != nil {
+			return apiErr(c, http.StatusInternalServerError, "failed to encrypt api key")
+		}
+		if err := h.db.Customers.UpdateAPIKey(ctx, id, encKey); err != nil {
+			c.Logger().Errorf("rotate api key for customer %s: %v", id, err)
+			return apiErr(c, http.StatusInternalServerError, "failed to update customer")
+		}
+		h.recordTokenCheck(c, customer, token)
+	}
+	if req.AutoEnrollZones != nil {
+		if err := h.db.Customers.SetAutoEnrollZones(ctx, id, *req.AutoEnrollZones); err != nil {
+			c.Logger().Errorf("update customer %s: %v", id, err)
+			return apiErr(c, http.StatusInternalServerError, "failed to update customer")
+		}
+		if *req.AutoEnrollZones {
+			if err := h.enqueueDiscovery(ctx, id); err != nil {
+				c.Logger().Errorf("enqueue zone discovery: %v", err)
+			}
+		}
+	}
+
+	customer, err = h.db.Customers.GetByID(ctx, id)
+	if err != nil {
+		return apiErr(c, http.StatusNotFound, "customer not found")
+	}
+	return c.JSON(http.StatusOK, customer)
+}
+
+// enqueueDiscovery queues an on-demand discovery run; requests within the same
+// minute share one task.
+func (h *Handlers) enqueueDiscovery(ctx context.Context, customerID uuid.UUID) error {
+	task, err := queue.NewZoneDiscoverTask(queue.ZoneDiscoverPayload{CustomerID: customerID})
+	if err != nil {
+		return err
+	}
+	taskID := fmt.Sprintf("discover-%s-now-%d", customerID, time.Now().Truncate(time.Minute).Unix())
+	if _, err := h.queue.EnqueueContext(ctx, task, asynq.TaskID(taskID)); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return err
+	}
+	return nil
+}
@@ -29,6 +29,7 @@ type CreateExportRequest struct {
 	S3Config models.ExportS3Config `json:"s3_config"`
 	Start    time.Time             `json:"start"`
 	End      time.Time             `json:"end"`
+	Datasets []string              `json:"datasets"` // empty exports every dataset
 }
 
 func (h *ExportHandler) Create(c echo.Context) error {
@@ -36,6 +37,11 @@ func (h *ExportHandler) Create(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
 	}
+	for _, ds := range req.Datasets {
+		if !models.IsJobDataset(ds) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "unknown dataset " + ds})
+		}
+	}
 
 	customerID := c.Get("customer_id").(uuid.UUID)
 
@@ -47,12 +53,13 @@ func (h *ExportHandler) Create(c echo.Context) error {
 	}
 
 	export := &models.LogExport{
-		ID:          uuid.New(),
-		CustomerID:  customerID,
-		S3ConfigEnc: configEnc,
-		FilterStart: req.Start,
-		FilterEnd:   req.End,
-		Status:      models.ExportStatusPending,
+		ID:             uuid.New(),
+		CustomerID:     customerID,
+		S3ConfigEnc:    configEnc,
+		FilterStart:    req.Start,
+		FilterEnd:      req.End,
+		FilterDatasets: req.Datasets,
+		Status:         models.ExportStatusPending,
 	}
 
 	if err := h.db.LogExports.Create(c.Request().Context(), export); err != nil {
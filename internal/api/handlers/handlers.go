@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -14,6 +17,9 @@ import (
 
 	"github.com/fabriziosalmi/rainlogs/internal/api/middleware"
 	"github.com/fabriziosalmi/rainlogs/internal/auth"
+	"github.com/fabriziosalmi/rainlogs/internal/breaker"
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/config"
 	"github.com/fabriziosalmi/rainlogs/internal/db"
 	"github.com/fabriziosalmi/rainlogs/internal/kms"
 	"github.com/fabriziosalmi/rainlogs/internal/models"
@@ -21,21 +27,56 @@ import (
 	"github.com/fabriziosalmi/rainlogs/internal/storage"
 )
 
+// Credentials resolves the Cloudflare API token a zone is collected with.
+type Credentials interface {
+	ForZone(customer *models.Customer, zone *models.Zone) (string, error)
+}
+
+// RateLimits hands out the Cloudflare request budget of a token, shared with
+// the workers.
+type RateLimits interface {
+	ForZone(apiKey string, plan models.PlanType) cloudflare.Limiter
+}
+
+// PlanSwitcher moves a zone to another plan's collector with the workers'
+// handover, and audits the switch.
+type PlanSwitcher interface {
+	Switch(ctx context.Context, zone *models.Zone, to models.PlanType, reason, requestID string) (bool, error)
+}
+
 type Handlers struct {
-	db      *db.DB
-	kms     *kms.Encryptor
-	queue   *asynq.Client
-	storage *storage.MultiStore
-	Export  *ExportHandler
+	db       *db.DB
+	kms      *kms.Encryptor
+	queue    *asynq.Client
+	storage  *storage.MultiStore
+	cfCfg    config.CloudflareConfig
+	breakers *breaker.Breakers
+	creds    Credentials
+	limits   RateLimits
+	plans    PlanSwitcher
+	logpush  *logpushReceiver
+	Export   *ExportHandler
 }
 
-func NewHandlers(db *db.DB, kms *kms.Encryptor, queue *asynq.Client, store *storage.MultiStore) *Handlers {
+func NewHandlers(db *db.DB, kms *kms.Encryptor, queue *asynq.Client, store *storage.MultiStore, cfCfg config.CloudflareConfig, breakers *breaker.Breakers, creds Credentials, limits RateLimits, plans PlanSwitcher) *Handlers {
 	return &Handlers{
-		db:      db,
-		kms:     kms,
-		queue:   queue,
-		storage: store,
-		Export:  NewExportHandler(db, queue, kms),
+		db:       db,
+		kms:      kms,
+		queue:    queue,
+		storage:  store,
+		cfCfg:    cfCfg,
+		breakers: breakers,
+		creds:    creds,
+		limits:   limits,
+		plans:    plans,
+		logpush: &logpushReceiver{
+			zones:     db.Zones,
+			customers: db.Customers,
+			jobs:      db.LogJobs,
+			storage:   store,
+			queue:     queue,
+		},
+		Export: NewExportHandler(db, queue, kms),
 	}
 }
 
@@ -82,6 +123,8 @@ type CreateCustomerRequest struct {
 	CFAccountID   string `json:"cf_account_id"  validate:"required"`
 	CFAPIKey      string `json:"cf_api_key"     validate:"required"`
 	RetentionDays int    `json:"retention_days" validate:"required,min=1"`
+	// AutoEnrollZones registers zones found in the Cloudflare account automatically.
+	AutoEnrollZones bool `json:"auto_enroll_zones"`
 }
 
 func (h *Handlers) CreateCustomer(c echo.Context) error {
@@ -93,27 +136,40 @@ func (h *Handlers) CreateCustomer(c echo.Context) error {
 		return apiErr(c, http.StatusBadRequest, "missing required fields", "INVALID_REQUEST")
 	}
 
+	ctx := c.Request().Context()
+	token, err := h.verifyCFToken(ctx, req.CFAccountID, req.CFAPIKey)
+	if err != nil {
+		return apiErr(c, http.StatusUnprocessableEntity, err.Error(), "INVALID_CF_TOKEN")
+	}
+
 	encKey, err := h.kms.Encrypt(req.CFAPIKey)
 	if err != nil {
 		return apiErr(c, http.StatusInternalServerError, "failed to encrypt api key")
 	}
 
 	customer := &models.Customer{
-		ID:            uuid.New(),
-		Name:          req.Name,
-		Email:         req.Email,
-		CFAccountID:   req.CFAccountID,
-		CFAPIKeyEnc:   encKey,
-		RetentionDays: req.RetentionDays,
+		ID:              uuid.New(),
+		Name:            req.Name,
+		Email:           req.Email,
+		CFAccountID:     req.CFAccountID,
+		CFAPIKeyEnc:     encKey,
+		RetentionDays:   req.RetentionDays,
+		AutoEnrollZones: req.AutoEnrollZones,
 	}
 
-	if err := h.db.Customers.Create(c.Request().Context(), customer); err != nil {
+	if err := h.db.Customers.Create(ctx, customer); err != nil {
 		if isUniqueViolation(err) {
 			return apiErr(c, http.StatusConflict, "email already registered", "CUSTOMER_EMAIL_EXISTS")
 		}
 		c.Logger().Errorf("create customer: %v", err)
 		return apiErr(c, http.StatusInternalServerError, "failed to create customer")
 	}
+	h.recordTokenCheck(c, customer, token)
+
+	// Discover the account's zones so they can be listed (or enrolled) right away.
+	if err := h.enqueueDiscovery(ctx, customer.ID); err != nil {
+		c.Logger().Errorf("enqueue zone discovery: %v", err)
+	}
 
 	return c.JSON(http.StatusCreated, customer)
 }
@@ -158,7 +214,7 @@ func (h *Handlers) DeleteCustomer(c echo.Context) error {
 	ctx := c.Request().Context()
 
 	// 1. Delete all stored log objects from object storage (best-effort).
-	jobs, err := h.db.LogJobs.ListByCustomer(ctx, customerID, 9999, 0)
+	jobs, err := h.db.LogJobs.ListByCustomer(ctx, customerID, "", 9999, 0)
 	if err != nil {
 		c.Logger().Errorf("list jobs for erasure %s: %v", customerID, err)
 	} else {
@@ -193,14 +249,49 @@ func (h *Handlers) DeleteCustomer(c echo.Context) error {
 
 // ── Zone Handlers ─────────────────────────────────────────────────────────────
 
-// zoneResponse adds a computed health field to the Zone model.
+// zoneResponse adds computed health, circuit breaker and Logpush fields to
+// the Zone model.
 type zoneResponse struct {
 	models.Zone
-	Health string `json:"health"`
+	Health         string        `json:"health"`
+	CircuitBreaker breaker.State `json:"circuit_breaker,omitempty"`
+	LogpushEnabled bool          `json:"logpush_enabled"`
+	// ZoneCFAPIKey is set when the zone has its own Cloudflare API token.
+	ZoneCFAPIKey bool `json:"zone_cf_api_key"`
+}
+
+// newZoneResponse builds the response for z. The breaker of the zone's
+// collector API is left out if Redis can't be read.
+func (h *Handlers) newZoneResponse(c echo.Context, z *models.Zone) zoneResponse {
+	state, err := h.breakers.State(c.Request().Context(), z.CustomerID, breaker.FamilyFor(z.Plan))
+	if err != nil {
+		c.Logger().Warnf("zone %s circuit breaker: %v", z.ID, err)
+		state = ""
+	}
+	return zoneResponse{
+		Zone:           *z,
+		Health:         zoneHealth(z, state),
+		CircuitBreaker: state,
+		LogpushEnabled: z.LogpushSecretHash != "",
+		ZoneCFAPIKey:   z.CFAPIKeyEnc != "",
+	}
 }
 
-// zoneHealth returns "ok", "stale", or "never_pulled" based on last pull time.
-func zoneHealth(z *models.Zone) string {
+// zoneHealth returns "ok", "stale", or "never_pulled" based on last pull time,
+// "deleted_in_cloudflare" once discovery no longer finds the zone,
+// "missing_permissions" when the token preflight found the zone's collector
+// lacking permissions, or "circuit_open" while the breaker of the collector's
+// API is not closed.
+func zoneHealth(z *models.Zone, state breaker.State) string {
+	if z.CFDeletedAt != nil {
+		return "deleted_in_cloudflare"
+	}
+	if len(z.MissingPermissions) > 0 {
+		return "missing_permissions"
+	}
+	if state == breaker.Open || state == breaker.HalfOpen {
+		return "circuit_open"
+	}
 	if z.LastPulledAt == nil {
 		return "never_pulled"
 	}
@@ -210,11 +301,38 @@ func zoneHealth(z *models.Zone) string {
 	return "ok"
 }
 
+// isJSONNull reports whether raw is an explicit JSON null.
+func isJSONNull(raw json.RawMessage) bool {
+	return string(bytes.TrimSpace(raw)) == "null"
+}
+
 type CreateZoneRequest struct {
 	ZoneID           string          `json:"zone_id"            validate:"required"`
 	Name             string          `json:"name"               validate:"required"`
 	Plan             models.PlanType `json:"plan"`
 	PullIntervalSecs int             `json:"pull_interval_secs" validate:"required,min=300"`
+	// FieldProfile and LogFields select Logpull fields; see cloudflare.ResolveFields.
+	FieldProfile string   `json:"field_profile"`
+	LogFields    []string `json:"log_fields"`
+	// Instant Logs session settings (Business zones); see cloudflare.InstantLogsOptions.
+	InstantFields []string        `json:"instant_fields"`
+	InstantSample int             `json:"instant_sample"`
+	InstantFilter json.RawMessage `json:"instant_filter"`
+	// CFAPIKey is an optional zone-scoped Cloudflare API token used instead
+	// of the customer's; it is verified first.
+	CFAPIKey string `json:"cf_api_key"`
+	// SettleDelaySecs enables settle mode: each Logpull window is pulled again
+	// this long after it ends and late lines are archived. 0 disables it.
+	SettleDelaySecs int `json:"settle_delay_secs"`
+	// AdaptiveWindow sizes the zone's Logpull windows from its traffic
+	// instead of PullIntervalSecs, which still sets the first window.
+	AdaptiveWindow bool `json:"adaptive_window"`
+}
+
+// validSettleDelay reports whether secs is a valid settle_delay_secs: 0, or a
+// delay that lets the re-pull run within Cloudflare's 7-day log retention.
+func validSettleDelay(secs int) bool {
+	return secs == 0 || (secs >= 300 && secs <= 518400)
 }
 
 func (h *Handlers) CreateZone(c echo.Context) error {
@@ -237,6 +355,9 @@ func (h *Handlers) CreateZone(c echo.Context) error {
 	if req.ZoneID == "" || req.Name == "" || req.PullIntervalSecs < 300 || req.PullIntervalSecs > maxPullIntervalSecs {
 		return apiErr(c, http.StatusBadRequest, "missing required fields or pull_interval_secs out of range [300, 518400]", "INVALID_REQUEST")
 	}
+	if !validSettleDelay(req.SettleDelaySecs) {
+		return apiErr(c, http.StatusBadRequest, "settle_delay_secs must be 0 or in range [300, 518400]", "INVALID_REQUEST")
+	}
 
 	// Validate Plan
 	switch req.Plan {
@@ -246,6 +367,17 @@ func (h *Handlers) CreateZone(c echo.Context) error {
 		return apiErr(c, http.StatusBadRequest, "invalid plan type", "INVALID_PLAN")
 	}
 
+	if _, err := cloudflare.ResolveFields(req.FieldProfile, req.LogFields); err != nil {
+		return apiErr(c, http.StatusBadRequest, err.Error(), "INVALID_LOG_FIELDS")
+	}
+	if isJSONNull(req.InstantFilter) {
+		req.InstantFilter = nil
+	}
+	instant := cloudflare.InstantLogsOptions{Fields: req.InstantFields, Sample: req.InstantSample, Filter: req.InstantFilter}
+	if err := instant.Validate(); err != nil {
+		return apiErr(c, http.StatusBadRequest, err.Error(), "INVALID_INSTANT_LOGS")
+	}
+
 	zone := &models.Zone{
 		ID:               uuid.New(),
 		CustomerID:       customerID,
@@ -254,12 +386,30 @@ func (h *Handlers) CreateZone(c echo.Context) error {
 		Plan:             req.Plan,
 		PullIntervalSecs: req.PullIntervalSecs,
 		Active:           true,
+		LogFields:        req.LogFields,
+		FieldProfile:     req.FieldProfile,
+		InstantFields:    req.InstantFields,
+		InstantSample:    req.InstantSample,
+		InstantFilter:    req.InstantFilter,
+		SettleDelaySecs:  req.SettleDelaySecs,
+		AdaptiveWindow:   req.AdaptiveWindow,
+	}
+	if req.CFAPIKey != "" {
+		enc, ok := h.zoneAPIKey(c, customerID, req.CFAPIKey)
+		if !ok {
+			return nil
+		}
+		zone.CFAPIKeyEnc = enc
 	}
 
 	if err := h.db.Zones.Create(c.Request().Context(), zone); err != nil {
 		c.Logger().Errorf("create zone: %v", err)
 		return apiErr(c, http.StatusInternalServerError, "failed to create zone")
 	}
+	// Check the token grants what the zone's collector needs.
+	if err := h.enqueuePreflight(c.Request().Context(), customerID); err != nil {
+		c.Logger().Errorf("enqueue token preflight: %v", err)
+	}
 
 	return c.JSON(http.StatusCreated, zone)
 }
@@ -277,7 +427,7 @@ func (h *Handlers) ListZones(c echo.Context) error {
 
 	resp := make([]zoneResponse, len(zones))
 	for i, z := range zones {
-		resp[i] = zoneResponse{Zone: *z, Health: zoneHealth(z)}
+		resp[i] = h.newZoneResponse(c, z)
 	}
 	return c.JSON(http.StatusOK, resp)
 }
@@ -316,6 +466,17 @@ type UpdateZoneRequest struct {
 	Plan             *models.PlanType `json:"plan"`
 	PullIntervalSecs *int             `json:"pull_interval_secs"`
 	Active           *bool            `json:"active"`
+	FieldProfile     *string          `json:"field_profile"`
+	LogFields        *[]string        `json:"log_fields"`
+	InstantFields    *[]string        `json:"instant_fields"`
+	InstantSample    *int             `json:"instant_sample"`
+	// InstantFilter is left unchanged when absent and cleared by an explicit null.
+	InstantFilter json.RawMessage `json:"instant_filter"`
+	// CFAPIKey sets or rotates the zone's own Cloudflare API token, verified
+	// first; an empty string removes it, so the customer's token is used.
+	CFAPIKey        *string `json:"cf_api_key"`
+	SettleDelaySecs *int    `json:"settle_delay_secs"`
+	AdaptiveWindow  *bool   `json:"adaptive_window"`
 }
 
 // UpdateZone patches a zone (pause/resume/rename) without deleting it.
@@ -345,45 +506,110 @@ func (h *Handlers) UpdateZone(c echo.Context) error {
 	}
 
 	// Apply only the provided fields.
-	name := zone.Name
-	plan := zone.Plan
-	intervalSecs := zone.PullIntervalSecs
-	active := zone.Active
-
 	if req.Name != nil {
-		name = *req.Name
+		zone.Name = *req.Name
 	}
+	// A new plan is applied by the handover once the other fields are saved.
+	planChanged := false
 	if req.Plan != nil {
-		p := *req.Plan
-		switch p {
+		switch *req.Plan {
 		case models.PlanEnterprise, models.PlanBusiness, models.PlanFreePro:
-			plan = p
+			planChanged = *req.Plan != zone.Plan
 		default:
 			return apiErr(c, http.StatusBadRequest, "invalid plan_type", "INVALID_PLAN")
 		}
 	}
+	if planChanged && zone.PlanChangedAt != nil && zone.PlanChangedAt.After(time.Now()) {
+		return apiErr(c, http.StatusConflict, "zone plan is being handed over until "+zone.PlanChangedAt.UTC().Format(time.RFC3339), "PLAN_CHANGE_PENDING")
+	}
 	if req.PullIntervalSecs != nil {
 		const maxPullIntervalSecs = 518400
 		if *req.PullIntervalSecs < 300 || *req.PullIntervalSecs > maxPullIntervalSecs {
 			return apiErr(c, http.StatusBadRequest, "pull_interval_secs out of range [300, 518400]", "INVALID_REQUEST")
 		}
-		intervalSecs = *req.PullIntervalSecs
+		zone.PullIntervalSecs = *req.PullIntervalSecs
+	}
+	if req.SettleDelaySecs != nil {
+		if !validSettleDelay(*req.SettleDelaySecs) {
+			return apiErr(c, http.StatusBadRequest, "settle_delay_secs must be 0 or in range [300, 518400]", "INVALID_REQUEST")
+		}
+		zone.SettleDelaySecs = *req.SettleDelaySecs
+	}
+	if req.AdaptiveWindow != nil {
+		zone.AdaptiveWindow = *req.AdaptiveWindow
 	}
 	if req.Active != nil {
-		active = *req.Active
+		zone.Active = *req.Active
+	}
+	if req.FieldProfile != nil {
+		zone.FieldProfile = *req.FieldProfile
+	}
+	if req.LogFields != nil {
+		zone.LogFields = *req.LogFields
+	}
+	if _, err := cloudflare.ResolveFields(zone.FieldProfile, zone.LogFields); err != nil {
+		return apiErr(c, http.StatusBadRequest, err.Error(), "INVALID_LOG_FIELDS")
+	}
+	if req.InstantFields != nil {
+		zone.InstantFields = *req.InstantFields
+	}
+	if req.InstantSample != nil {
+		zone.InstantSample = *req.InstantSample
+	}
+	if req.InstantFilter != nil {
+		zone.InstantFilter = req.InstantFilter
+		if isJSONNull(req.InstantFilter) {
+			zone.InstantFilter = nil
+		}
+	}
+	// Running streams pick up changed settings on the worker's next sync.
+	instant := cloudflare.InstantLogsOptions{Fields: zone.InstantFields, Sample: zone.InstantSample, Filter: zone.InstantFilter}
+	if err := instant.Validate(); err != nil {
+		return apiErr(c, http.StatusBadRequest, err.Error(), "INVALID_INSTANT_LOGS")
 	}
 
-	if err := h.db.Zones.Update(ctx, zoneID, customerID, name, plan, intervalSecs, active); err != nil {
+	var encKey string
+	if req.CFAPIKey != nil && *req.CFAPIKey != "" {
+		enc, ok := h.zoneAPIKey(c, customerID, *req.CFAPIKey)
+		if !ok {
+			return nil
+		}
+		encKey = enc
+	}
+
+	if err := h.db.Zones.Update(ctx, zone); err != nil {
 		c.Logger().Errorf("update zone %s: %v", zoneID, err)
 		return apiErr(c, http.StatusInternalServerError, "failed to update zone")
 	}
+	if req.CFAPIKey != nil {
+		if err := h.db.Zones.SetCFAPIKey(ctx, zoneID, encKey); err != nil {
+			c.Logger().Errorf("set api key for zone %s: %v", zoneID, err)
+			return apiErr(c, http.StatusInternalServerError, "failed to update zone")
+		}
+	}
+	if planChanged {
+		reqID, _ := c.Get(middleware.ContextKeyRequestID).(string)
+		switched, err := h.plans.Switch(ctx, zone, *req.Plan, "set by customer", reqID)
+		if err != nil {
+			c.Logger().Errorf("switch plan of zone %s: %v", zoneID, err)
+			return apiErr(c, http.StatusInternalServerError, "failed to update zone")
+		}
+		if !switched {
+			return apiErr(c, http.StatusConflict, "zone plan changed concurrently", "PLAN_CHANGE_PENDING")
+		}
+	}
+	if planChanged || req.CFAPIKey != nil {
+		if err := h.enqueuePreflight(ctx, customerID); err != nil {
+			c.Logger().Errorf("enqueue token preflight: %v", err)
+		}
+	}
 
 	// Return the updated zone.
 	updated, err := h.db.Zones.GetByID(ctx, zoneID)
 	if err != nil {
 		return apiErr(c, http.StatusInternalServerError, "failed to retrieve updated zone")
 	}
-	return c.JSON(http.StatusOK, zoneResponse{Zone: *updated, Health: zoneHealth(updated)})
+	return c.JSON(http.StatusOK, h.newZoneResponse(c, updated))
 }
 
 // TriggerPull enqueues an immediate log pull for a zone.
@@ -406,17 +632,21 @@ func (h *Handlers) TriggerPull(c echo.Context) error {
 		return apiErr(c, http.StatusForbidden, "access denied", "ACCESS_DENIED")
 	}
 
-	now := time.Now().UTC()
-	start := now.Add(-time.Duration(zone.PullIntervalSecs) * time.Second)
+	// The worker splits windows longer than one hour into sequential chunks.
+	end := time.Now().UTC().Add(-cloudflare.LogAvailabilityDelay)
+	start := end.Add(-time.Duration(zone.PullIntervalSecs) * time.Second)
 	if zone.LastPulledAt != nil {
 		start = *zone.LastPulledAt
 	}
+	if !start.Before(end) {
+		return apiErr(c, http.StatusConflict, "zone is already up to date", "NOTHING_TO_PULL")
+	}
 
 	task, err := queue.NewLogPullTask(queue.LogPullPayload{
 		ZoneID:      zone.ID,
 		CustomerID:  customerID,
 		PeriodStart: start,
-		PeriodEnd:   now,
+		PeriodEnd:   end,
 	})
 	if err != nil {
 		return apiErr(c, http.StatusInternalServerError, "failed to create pull task")
@@ -468,7 +698,12 @@ func (h *Handlers) GetZoneLogs(c echo.Context) error {
 		}
 	}
 
-	jobs, err := h.db.LogJobs.ListByZone(c.Request().Context(), customerID, zoneID, limit, offset)
+	dataset := c.QueryParam("dataset")
+	if dataset != "" && !models.IsDataset(dataset) {
+		return apiErr(c, http.StatusBadRequest, "unknown dataset", "INVALID_DATASET")
+	}
+
+	jobs, err := h.db.LogJobs.ListByZone(c.Request().Context(), customerID, zoneID, dataset, limit, offset)
 	if err != nil {
 		return apiErr(c, http.StatusInternalServerError, "failed to list zone log jobs")
 	}
@@ -597,7 +832,12 @@ func (h *Handlers) ListLogJobs(c echo.Context) error {
 		}
 	}
 
-	jobs, err := h.db.LogJobs.ListByCustomer(c.Request().Context(), customerID, limit, offset)
+	dataset := c.QueryParam("dataset")
+	if dataset != "" && !models.IsJobDataset(dataset) {
+		return apiErr(c, http.StatusBadRequest, "unknown dataset", "INVALID_DATASET")
+	}
+
+	jobs, err := h.db.LogJobs.ListByCustomer(c.Request().Context(), customerID, dataset, limit, offset)
 	if err != nil {
 		return apiErr(c, http.StatusInternalServerError, "failed to list log jobs")
 	}
@@ -694,7 +934,7 @@ func (h *Handlers) ExportCustomerData(c echo.Context) error {
 		return apiErr(c, http.StatusInternalServerError, "failed to fetch api keys")
 	}
 
-	jobs, err := h.db.LogJobs.ListByCustomer(ctx, customerID, 500, 0)
+	jobs, err := h.db.LogJobs.ListByCustomer(ctx, customerID, "", 500, 0)
 	if err != nil {
 		return apiErr(c, http.StatusInternalServerError, "failed to fetch log jobs")
 	}
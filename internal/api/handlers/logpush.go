@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/labstack/echo/v4"
+
+	"github.com/fabriziosalmi/rainlogs/internal/auth"
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/queue"
+	"github.com/fabriziosalmi/rainlogs/pkg/worm"
+)
+
+// ── Logpush Receiver ─────────────────────────────────────────────────────────
+
+// LogpushSecretHeader carries the per-zone secret on Logpush requests. The
+// Logpush job sets it through a header_ parameter of its destination_conf.
+const LogpushSecretHeader = "X-Logpush-Secret"
+
+const (
+	// maxLogpushBody caps a request body as sent; Logpush batches are gzipped.
+	maxLogpushBody = 64 << 20
+	// maxLogpushBatch caps a batch once decompressed.
+	maxLogpushBatch = 512 << 20
+	// maxLogpushControl is the largest body checked for a control file.
+	maxLogpushControl = 64 << 10
+)
+
+var (
+	errLogpushTooLarge = errors.New("batch exceeds the size limit")
+	errLogpushSpool    = errors.New("spool batch")
+)
+
+// logpushSetup is returned once when the receiver is enabled: the secret is
+// not stored and can't be read back.
+type logpushSetup struct {
+	Secret          string            `json:"secret"`
+	Header          string            `json:"header"`
+	DestinationConf map[string]string `json:"destination_conf"` // by dataset
+}
+
+// EnableLogpush issues a new Logpush secret for a zone, replacing any
+// previous one, and returns the destination_conf for each dataset.
+func (h *Handlers) EnableLogpush(c echo.Context) error {
+	zone, err := h.ownedZone(c)
+	if zone == nil {
+		return err
+	}
+
+	secret, hash, err := auth.GenerateSecret()
+	if err != nil {
+		return apiErr(c, http.StatusInternalServerError, "failed to generate secret")
+	}
+	if err := h.db.Zones.SetLogpushSecret(c.Request().Context(), zone.ID, hash); err != nil {
+		c.Logger().Errorf("set logpush secret: %v", err)
+		return apiErr(c, http.StatusInternalServerError, "failed to enable logpush")
+	}
+
+	base := fmt.Sprintf("%s://%s/ingest/logpush/%s", c.Scheme(), c.Request().Host, zone.ID)
+	conf := make(map[string]string)
+	for _, ds := range cloudflare.LogpushDatasets() {
+		conf[ds] = fmt.Sprintf("%s?dataset=%s&header_%s=%s", base, ds, LogpushSecretHeader, secret)
+	}
+	return c.JSON(http.StatusCreated, logpushSetup{
+		Secret:          secret,
+		Header:          LogpushSecretHeader,
+		DestinationConf: conf,
+	})
+}
+
+// DisableLogpush revokes a zone's Logpush secret; batches sent with it are
+// refused from then on.
+func (h *Handlers) DisableLogpush(c echo.Context) error {
+	zone, err := h.ownedZone(c)
+	if zone == nil {
+		return err
+	}
+	if err := h.db.Zones.SetLogpushSecret(c.Request().Context(), zone.ID, ""); err != nil {
+		c.Logger().Errorf("clear logpush secret: %v", err)
+		return apiErr(c, http.StatusInternalServerError, "failed to disable logpush")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// logpushReceiver archives Logpush batches. It reaches the database,
+// storage and queue through the narrow interfaces below.
+type logpushReceiver struct {
+	zones     logpushZones
+	customers customerReader
+	jobs      logpushJobs
+	storage   logpushStorage
+	queue     taskEnqueuer
+}
+
+type logpushZones interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Zone, error)
+	SetLogpushChallenge(ctx context.Context, id uuid.UUID, challenge string) error
+}
+
+type customerReader interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Customer, error)
+}
+
+type logpushJobs interface {
+	GetCurrentUsage(ctx context.Context, customerID uuid.UUID) (int64, error)
+	CreateIfAbsent(ctx context.Context, j *models.LogJob) (bool, error)
+	GetByID(ctx context.Context, id uuid.UUID) (*models.LogJob, error)
+	Update(ctx context.Context, j *models.LogJob) error
+	FinishChained(ctx context.Context, j *models.LogJob, link func(prevChainHash string) string) error
+}
+
+type logpushStorage interface {
+	Spool() (*os.File, error)
+	PutLogsStream(ctx context.Context, customerID, zoneID uuid.UUID, from, to time.Time, r io.Reader, logType, dataset string, jobID uuid.UUID) (key, sha256hex, provider string, compressedBytes, logLines int64, err error)
+}
+
+type taskEnqueuer interface {
+	EnqueueContext(ctx context.Context, task *asynq.Task, opts ...asynq.Option) (*asynq.TaskInfo, error)
+}
+
+// IngestLogpush is the Logpush HTTP destination. It takes gzipped NDJSON
+// batches of one dataset for one zone and archives each as a log job,
+// chained like pulled logs. Control files (the test file and ownership
+// challenges) are acknowledged; challenges are kept on the zone.
+func (h *Handlers) IngestLogpush(c echo.Context) error {
+	return h.logpush.ingest(c)
+}
+
+func (r *logpushReceiver) ingest(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	dataset := c.QueryParam("dataset")
+	if !cloudflare.IsLogpushDataset(dataset) {
+		return apiErr(c, http.StatusBadRequest, "unsupported dataset", "INVALID_DATASET")
+	}
+	zoneID, err := uuid.Parse(c.Param("zone_id"))
+	if err != nil {
+		return apiErr(c, http.StatusBadRequest, "invalid zone_id", "INVALID_REQUEST")
+	}
+	zone, err := r.zones.GetByID(ctx, zoneID)
+	if err != nil || !auth.ValidateSecret(c.Request().Header.Get(LogpushSecretHeader), zone.LogpushSecretHash) {
+		return apiErr(c, http.StatusUnauthorized, "invalid logpush secret", "UNAUTHORIZED")
+	}
+
+	// Batches over quota are refused before they are read, like pulls.
+	// Logpush retries them, so none is lost if the quota is raised in time.
+	customer, err := r.customers.GetByID(ctx, zone.CustomerID)
+	if err != nil {
+		c.Logger().Errorf("get logpush customer: %v", err)
+		return apiErr(c, http.StatusInternalServerError, "failed to record batch")
+	}
+	if customer.QuotaBytes != -1 {
+		usage, err := r.jobs.GetCurrentUsage(ctx, customer.ID)
+		if err != nil {
+			c.Logger().Errorf("check quota: %v", err)
+			return apiErr(c, http.StatusInternalServerError, "failed to record batch")
+		}
+		if usage >= customer.QuotaBytes {
+			return apiErr(c, http.StatusInsufficientStorage, "storage quota exceeded", "QUOTA_EXCEEDED")
+		}
+	}
+
+	batch, err := spoolLogpushBody(c.Request(), r.storage.Spool)
+	switch {
+	case errors.Is(err, errLogpushTooLarge):
+		return apiErr(c, http.StatusRequestEntityTooLarge, err.Error(), "BATCH_TOO_LARGE")
+	case errors.Is(err, errLogpushSpool):
+		c.Logger().Errorf("logpush: %v", err)
+		return apiErr(c, http.StatusServiceUnavailable, "failed to archive batch")
+	case err != nil:
+		return apiErr(c, http.StatusBadRequest, err.Error(), "INVALID_BATCH")
+	}
+	defer batch.Close()
+
+	if batch.small() {
+		if ctl, ok := cloudflare.ParseLogpushControl(batch.head); ok {
+			if ctl.IsOwnershipChallenge() {
+				if err := r.zones.SetLogpushChallenge(ctx, zone.ID, ctl.Content); err != nil {
+					c.Logger().Errorf("store logpush challenge: %v", err)
+					return apiErr(c, http.StatusInternalServerError, "failed to store challenge")
+				}
+			}
+			return c.NoContent(http.StatusOK)
+		}
+		if len(bytes.TrimSpace(batch.head)) == 0 {
+			return c.NoContent(http.StatusOK)
+		}
+	}
+
+	from, to, ok := cloudflare.LogpushWindow(batch, dataset)
+	if !ok {
+		from = time.Now().UTC().Truncate(time.Second)
+		to = from.Add(time.Second)
+	}
+	// Logpush retries non-2xx responses. The job is keyed on the batch, so a
+	// redelivered batch resumes its job instead of being archived again.
+	job := &models.LogJob{
+		ID:          logpushJobID(zone.ID, dataset, batch.rawHash),
+		ZoneID:      zone.ID,
+		CustomerID:  zone.CustomerID,
+		PeriodStart: from,
+		PeriodEnd:   to,
+		LogType:     models.LogTypeLogpush,
+		Dataset:     dataset,
+		Status:      models.JobStatusPending,
+	}
+	created, err := r.jobs.CreateIfAbsent(ctx, job)
+	if err == nil && !created {
+		job, err = r.jobs.GetByID(ctx, job.ID)
+	}
+	if err != nil {
+		c.Logger().Errorf("create logpush job: %v", err)
+		return apiErr(c, http.StatusInternalServerError, "failed to record batch")
+	}
+	if job.Status == models.JobStatusDone || job.Status == models.JobStatusExpired {
+		return c.NoContent(http.StatusOK)
+	}
+
+	// An earlier delivery may have archived the batch and failed to chain it.
+	if job.S3Key == "" {
+		if _, err := batch.Seek(0, io.SeekStart); err != nil {
+			r.failJob(c, job, fmt.Errorf("rewind batch: %w", err))
+			return apiErr(c, http.StatusServiceUnavailable, "failed to archive batch")
+		}
+		s3Key, s3Hash, provider, byteCount, logCount, err := r.storage.PutLogsStream(ctx, zone.CustomerID, zone.ID, job.PeriodStart, job.PeriodEnd, batch, job.LogType, job.Dataset, job.ID)
+		if err != nil {
+			r.failJob(c, job, fmt.Errorf("s3 upload: %w", err))
+			return apiErr(c, http.StatusServiceUnavailable, "failed to archive batch")
+		}
+		job.S3Key, job.S3Provider, job.SHA256 = s3Key, provider, s3Hash
+		job.ByteCount, job.LogCount = byteCount, logCount
+	}
+
+	err = r.jobs.FinishChained(ctx, job, func(prev string) string {
+		if prev == "" {
+			prev = worm.GenesisHash
+		}
+		return worm.ChainHash(prev, hex.EncodeToString(batch.rawHash[:]), job.ID.String())
+	})
+	if err != nil {
+		r.failJob(c, job, fmt.Errorf("chain job: %w", err))
+		return apiErr(c, http.StatusServiceUnavailable, "failed to archive batch")
+	}
+
+	// The batch is archived; a verify task that can't be enqueued must not
+	// make Logpush send it again.
+	verifyTask, err := queue.NewLogVerifyTask(queue.LogVerifyPayload{JobID: job.ID})
+	if err == nil {
+		_, err = r.queue.EnqueueContext(ctx, verifyTask)
+	}
+	if err != nil {
+		c.Logger().Errorf("enqueue verify task for job %s: %v", job.ID, err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// ownedZone loads the :zone_id zone of the authenticated customer. It returns
+// a nil zone once it has written the error response.
+func (h *Handlers) ownedZone(c echo.Context) (*models.Zone, error) {
+	customerID, err := mustCustomerID(c)
+	if err != nil || c.Response().Committed {
+		return nil, err
+	}
+	zoneID, err := uuid.Parse(c.Param("zone_id"))
+	if err != nil {
+		return nil, apiErr(c, http.StatusBadRequest, "invalid zone_id", "INVALID_REQUEST")
+	}
+	zone, err := h.db.Zones.GetByID(c.Request().Context(), zoneID)
+	if err != nil {
+		return nil, apiErr(c, http.StatusNotFound, "zone not found", "ZONE_NOT_FOUND")
+	}
+	if zone.CustomerID != customerID {
+		return nil, apiErr(c, http.StatusForbidden, "access denied", "ACCESS_DENIED")
+	}
+	return zone, nil
+}
+
+// logpushJobNamespace scopes the name-based UUIDs of Logpush jobs.
+var logpushJobNamespace = uuid.MustParse("6f0e7c1a-8d8b-4f6e-9a37-2b1f5c3d9e40")
+
+// logpushJobID derives the job ID of a batch from its zone, dataset and
+// content, so every delivery of the batch maps to the same job.
+func logpushJobID(zoneID uuid.UUID, dataset string, rawHash [sha256.Size]byte) uuid.UUID {
+	return uuid.NewSHA1(logpushJobNamespace, []byte(zoneID.String()+"/"+dataset+"/"+hex.EncodeToString(rawHash[:])))
+}
+
+func (r *logpushReceiver) failJob(c echo.Context, job *models.LogJob, err error) {
+	c.Logger().Errorf("logpush job %s: %v", job.ID, err)
+	job.Attempts++
+	job.Status = models.JobStatusFailed
+	job.ErrMsg = err.Error()
+	_ = r.jobs.Update(c.Request().Context(), job)
+}
+
+// logpushBatch is a decompressed Logpush request body, spooled to a file.
+type logpushBatch struct {
+	*os.File
+	size    int64
+	rawHash [sha256.Size]byte
+	head    []byte // the first maxLogpushControl bytes
+}
+
+// small reports whether head holds the whole batch, as it does for control
+// files.
+func (b *logpushBatch) small() bool {
+	return b.size <= maxLogpushControl
+}
+
+// Close closes and removes the spool file.
+func (b *logpushBatch) Close() error {
+	err := b.File.Close()
+	os.Remove(b.Name())
+	return err
+}
+
+// spoolLogpushBody decompresses a Logpush request body into a file from
+// spool, hashing it on the way, so neither the body nor the batch is held in
+// memory. The batch is read again for its window and its upload, which can
+// only start once its job, keyed on the hash, is known. Logpush always
+// compresses but does not always say so.
+func spoolLogpushBody(r *http.Request, spool func() (*os.File, error)) (*logpushBatch, error) {
+	body := bufio.NewReader(http.MaxBytesReader(nil, r.Body, maxLogpushBody))
+	var src io.Reader = body
+	magic, _ := body.Peek(2)
+	if r.Header.Get("Content-Encoding") == "gzip" || bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, logpushReadError(err)
+		}
+		defer zr.Close()
+		src = zr
+	}
+
+	f, err := spool()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errLogpushSpool, err)
+	}
+	b := &logpushBatch{File: f}
+	h := sha256.New()
+	head := &headWriter{max: maxLogpushControl}
+	b.size, err = io.Copy(io.MultiWriter(f, h, head), io.LimitReader(src, maxLogpushBatch+1))
+	switch {
+	case err != nil:
+		err = logpushReadError(err)
+	case b.size > maxLogpushBatch:
+		err = errLogpushTooLarge
+	}
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	copy(b.rawHash[:], h.Sum(nil))
+	b.head = head.buf
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("%w: %v", errLogpushSpool, err)
+	}
+	return b, nil
+}
+
+// logpushReadError maps a failure reading a Logpush body: an oversized body,
+// a spool file that can't be written, or a malformed body.
+func logpushReadError(err error) error {
+	var tooLarge *http.MaxBytesError
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &tooLarge):
+		return errLogpushTooLarge
+	case errors.As(err, &pathErr):
+		return fmt.Errorf("%w: %v", errLogpushSpool, err)
+	default:
+		return fmt.Errorf("read body: %w", err)
+	}
+}
+
+// headWriter keeps the first max bytes written to it.
+type headWriter struct {
+	buf []byte
+	max int
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if n := w.max - len(w.buf); n > 0 {
+		w.buf = append(w.buf, p[:min(n, len(p))]...)
+	}
+	return len(p), nil
+}
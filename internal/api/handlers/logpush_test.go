@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fabriziosalmi/rainlogs/internal/auth"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/storage"
+)
+
+// MockZones simulates zone database access
+type MockZones struct {
+	mock.Mock
+}
+
+func (m *MockZones) GetByID(ctx context.Context, id uuid.UUID) (*models.Zone, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Zone), args.Error(1)
+}
+
+func (m *MockZones) SetLogpushChallenge(ctx context.Context, id uuid.UUID, challenge string) error {
+	args := m.Called(ctx, id, challenge)
+	return args.Error(0)
+}
+
+// MockCustomers simulates customer lookups
+type MockCustomers struct {
+	mock.Mock
+}
+
+func (m *MockCustomers) GetByID(ctx context.Context, id uuid.UUID) (*models.Customer, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.Customer), args.Error(1)
+}
+
+// MockLogJobs simulates log job database access
+type MockLogJobs struct {
+	mock.Mock
+}
+
+func (m *MockLogJobs) GetCurrentUsage(ctx context.Context, customerID uuid.UUID) (int64, error) {
+	args := m.Called(ctx, customerID)
+	return int64(args.Int(0)), args.Error(1)
+}
+
+func (m *MockLogJobs) CreateIfAbsent(ctx context.Context, j *models.LogJob) (bool, error) {
+	args := m.Called(ctx, j)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockLogJobs) GetByID(ctx context.Context, id uuid.UUID) (*models.LogJob, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.LogJob), args.Error(1)
+}
+
+func (m *MockLogJobs) Update(ctx context.Context, j *models.LogJob) error {
+	args := m.Called(ctx, j)
+	return args.Error(0)
+}
+
+func (m *MockLogJobs) FinishChained(ctx context.Context, j *models.LogJob, link func(prevChainHash string) string) error {
+	args := m.Called(ctx, j)
+	if err := args.Error(0); err != nil {
+		return err
+	}
+	j.Status, j.ChainHash = models.JobStatusDone, link("")
+	return nil
+}
+
+// MockTaskEnqueuer simulates the asynq client
+type MockTaskEnqueuer struct {
+	mock.Mock
+}
+
+func (m *MockTaskEnqueuer) EnqueueContext(ctx context.Context, task *asynq.Task, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	args := m.Called(ctx, task.Type())
+	return &asynq.TaskInfo{}, args.Error(0)
+}
+
+const logpushLines = `{"EdgeStartTimestamp":"2026-03-10T12:00:05Z","RayID":"a"}
+{"EdgeStartTimestamp":"2026-03-10T11:59:58Z","RayID":"b"}
+`
+
+type logpushFixture struct {
+	recv     *logpushReceiver
+	zones    *MockZones
+	jobs     *MockLogJobs
+	queue    *MockTaskEnqueuer
+	store    *storage.MultiStore
+	spool    string
+	customer *models.Customer
+	zone     *models.Zone
+	secret   string
+}
+
+// newLogpushFixture wires a receiver to a filesystem store and mocked
+// repositories, for a zone of a customer without a quota.
+func newLogpushFixture(t *testing.T) *logpushFixture {
+	secret, hash, err := auth.GenerateSecret()
+	require.NoError(t, err)
+	fs, err := storage.NewFSStore(t.TempDir())
+	require.NoError(t, err)
+
+	f := &logpushFixture{
+		zones:    new(MockZones),
+		jobs:     new(MockLogJobs),
+		queue:    new(MockTaskEnqueuer),
+		spool:    t.TempDir(),
+		customer: &models.Customer{ID: uuid.New(), QuotaBytes: -1},
+		secret:   secret,
+	}
+	f.store = storage.NewMultiStore(fs).WithSpool(f.spool, 0)
+	f.zone = &models.Zone{ID: uuid.New(), CustomerID: f.customer.ID, LogpushSecretHash: hash}
+	f.zones.On("GetByID", mock.Anything, f.zone.ID).Return(f.zone, nil)
+	customers := new(MockCustomers)
+	customers.On("GetByID", mock.Anything, f.customer.ID).Return(f.customer, nil)
+	f.recv = &logpushReceiver{zones: f.zones, customers: customers, jobs: f.jobs, storage: f.store, queue: f.queue}
+	return f
+}
+
+// post delivers body to the receiver as Logpush would, with the zone's secret
+// and the given Content-Encoding.
+func (f *logpushFixture) post(t *testing.T, body []byte, encoding, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/ingest/logpush/"+f.zone.ID.String()+"?dataset=http_requests", bytes.NewReader(body))
+	req.Header.Set(LogpushSecretHeader, secret)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("zone_id")
+	c.SetParamValues(f.zone.ID.String())
+	require.NoError(t, f.recv.ingest(c))
+
+	// Nothing is left in the spool, whatever the outcome.
+	files, err := os.ReadDir(f.spool)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+	return rec
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := io.WriteString(zw, s)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestIngestLogpush_SniffsGzip(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     func(t *testing.T) []byte
+		encoding string
+	}{
+		{"plain", func(*testing.T) []byte { return []byte(logpushLines) }, ""},
+		{"gzip", func(t *testing.T) []byte { return gzipped(t, logpushLines) }, "gzip"},
+		{"gzip unlabelled", func(t *testing.T) []byte { return gzipped(t, logpushLines) }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newLogpushFixture(t)
+			var job *models.LogJob
+			f.jobs.On("CreateIfAbsent", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				job = args.Get(1).(*models.LogJob)
+			}).Return(true, nil)
+			f.jobs.On("FinishChained", mock.Anything, mock.Anything).Return(nil)
+			f.queue.On("EnqueueContext", mock.Anything, mock.Anything).Return(nil)
+
+			rec := f.post(t, tt.body(t), tt.encoding, f.secret)
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+			require.NotNil(t, job)
+			assert.Equal(t, models.JobStatusDone, job.Status)
+			assert.Equal(t, int64(2), job.LogCount)
+			assert.True(t, job.PeriodStart.Equal(time.Date(2026, 3, 10, 11, 59, 58, 0, time.UTC)))
+			assert.True(t, job.PeriodEnd.Equal(time.Date(2026, 3, 10, 12, 0, 6, 0, time.UTC)))
+			data, err := f.store.GetLogs(context.Background(), job.S3Key)
+			require.NoError(t, err)
+			assert.Equal(t, logpushLines, string(data))
+		})
+	}
+}
+
+func TestIngestLogpush_RejectsBadSecret(t *testing.T) {
+	f := newLogpushFixture(t)
+
+	rec := f.post(t, []byte(logpushLines), "", "not-the-secret")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = f.post(t, []byte(logpushLines), "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// A zone without Logpush enabled has no secret to match.
+	f.zone.LogpushSecretHash = ""
+	rec = f.post(t, []byte(logpushLines), "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	f.jobs.AssertNotCalled(t, "CreateIfAbsent", mock.Anything, mock.Anything)
+}
+
+func TestIngestLogpush_ControlFiles(t *testing.T) {
+	f := newLogpushFixture(t)
+	f.zones.On("SetLogpushChallenge", mock.Anything, f.zone.ID, "challenge-token").Return(nil).Once()
+
+	challenge := `{"content":"challenge-token","filename":"ownership-challenge-6d1b.txt"}`
+	rec := f.post(t, gzipped(t, challenge), "", f.secret)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// The test file Cloudflare writes when a job is created is acknowledged
+	// and dropped, like an empty batch.
+	rec = f.post(t, gzipped(t, `{"content":"tests","filename":"test.txt"}`), "gzip", f.secret)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = f.post(t, gzipped(t, "\n"), "gzip", f.secret)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	f.zones.AssertExpectations(t)
+	f.jobs.AssertNotCalled(t, "CreateIfAbsent", mock.Anything, mock.Anything)
+}
+
+func TestIngestLogpush_RedeliveryReusesJob(t *testing.T) {
+	f := newLogpushFixture(t)
+	raw := sha256.Sum256([]byte(logpushLines))
+	wantID := logpushJobID(f.zone.ID, "http_requests", raw)
+
+	var ids []uuid.UUID
+	var first *models.LogJob
+	f.jobs.On("CreateIfAbsent", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		j := args.Get(1).(*models.LogJob)
+		ids = append(ids, j.ID)
+		if first == nil {
+			first = j
+		}
+	}).Return(true, nil).Once()
+	f.jobs.On("FinishChained", mock.Anything, mock.Anything).Return(nil).Once()
+	f.queue.On("EnqueueContext", mock.Anything, mock.Anything).Return(nil).Once()
+
+	rec := f.post(t, gzipped(t, logpushLines), "gzip", f.secret)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// Logpush sends the batch again, compressed differently: it maps to the
+	// archived job and is not stored twice.
+	f.jobs.On("CreateIfAbsent", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		ids = append(ids, args.Get(1).(*models.LogJob).ID)
+	}).Return(false, nil).Once()
+	f.jobs.On("GetByID", mock.Anything, wantID).Return(first, nil).Once()
+
+	rec = f.post(t, []byte(logpushLines), "", f.secret)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Equal(t, []uuid.UUID{wantID, wantID}, ids)
+	f.jobs.AssertExpectations(t)
+	f.queue.AssertExpectations(t)
+}
+
+func TestIngestLogpush_Quota(t *testing.T) {
+	f := newLogpushFixture(t)
+	f.customer.QuotaBytes = 1000
+	f.jobs.On("GetCurrentUsage", mock.Anything, f.customer.ID).Return(1000, nil)
+
+	rec := f.post(t, []byte(logpushLines), "", f.secret)
+	assert.Equal(t, http.StatusInsufficientStorage, rec.Code)
+	f.jobs.AssertNotCalled(t, "CreateIfAbsent", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/labstack/echo/v4"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/queue"
+)
+
+// ── Cloudflare Token Preflight ───────────────────────────────────────────────
+
+// verifyCFToken checks a Cloudflare API token before it is stored. It returns
+// an error, fit for the client, for tokens Cloudflare rejects or reports
+// unusable. When Cloudflare can't be asked, the token is accepted with a nil
+// status and left to the worker's preflight.
+func (h *Handlers) verifyCFToken(ctx context.Context, accountID, token string) (*cloudflare.TokenStatus, error) {
+	status, err := cloudflare.NewAccountClient(h.cfCfg, accountID, token).VerifyToken(ctx)
+	switch {
+	case errors.Is(err, cloudflare.ErrInvalidToken):
+		return nil, errors.New("cloudflare does not recognise cf_api_key")
+	case err != nil:
+		return nil, nil
+	case !status.Active(time.Now()):
+		return nil, fmt.Errorf("cf_api_key is not usable (status %q)", status.Status)
+	}
+	return status, nil
+}
+
+// zoneAPIKey verifies a zone-scoped Cloudflare API token against the
+// customer's account and returns it encrypted. On failure it writes the error
+// response and reports false.
+func (h *Handlers) zoneAPIKey(c echo.Context, customerID uuid.UUID, key string) (string, bool) {
+	ctx := c.Request().Context()
+	customer, err := h.db.Customers.GetByID(ctx, customerID)
+	if err != nil {
+		_ = apiErr(c, http.StatusNotFound, "customer not found")
+		return "", false
+	}
+	if _, err := h.verifyCFToken(ctx, customer.CFAccountID, key); err != nil {
+		_ = apiErr(c, http.StatusUnprocessableEntity, err.Error(), "INVALID_CF_TOKEN")
+		return "", false
+	}
+	enc, err := h.kms.Encrypt(key)
+	if err != nil {
+		_ = apiErr(c, http.StatusInternalServerError, "failed to encrypt api key")
+		return "", false
+	}
+	return enc, true
+}
+
+// recordTokenCheck stores a successful verification on the customer and
+// queues the full preflight, which also checks zone permissions.
+func (h *Handlers) recordTokenCheck(c echo.Context, customer *models.Customer, token *cloudflare.TokenStatus) {
+	ctx := c.Request().Context()
+	if token != nil {
+		if err := h.db.Customers.RecordTokenCheck(ctx, customer.ID, token.Status, "", token.ExpiresOn); err != nil {
+			c.Logger().Errorf("record token check: %v", err)
+		} else {
+			now := time.Now().UTC()
+			customer.CFTokenStatus, customer.CFTokenError = token.Status, ""
+			customer.CFTokenExpiresAt, customer.CFTokenCheckedAt = token.ExpiresOn, &now
+		}
+	}
+	if err := h.enqueuePreflight(ctx, customer.ID); err != nil {
+		c.Logger().Errorf("enqueue token preflight: %v", err)
+	}
+}
+
+// enqueuePreflight queues an on-demand token preflight; requests within the
+// same minute share one task.
+func (h *Handlers) enqueuePreflight(ctx context.Context, customerID uuid.UUID) error {
+	task, err := queue.NewPreflightTask(queue.PreflightPayload{CustomerID: customerID})
+	if err != nil {
+		return err
+	}
+	taskID := fmt.Sprintf("preflight-%s-now-%d", customerID, time.Now().Truncate(time.Minute).Unix())
+	if _, err := h.queue.EnqueueContext(ctx, task, asynq.TaskID(taskID)); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/fabriziosalmi/rainlogs/internal/breaker"
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+// ── Ray Lookup Handlers ───────────────────────────────────────────────────────
+
+const (
+	// rayLookupSpan is the archive range searched when from is not given.
+	rayLookupSpan = 24 * time.Hour
+	// maxRayLookupRange bounds the archive range of a lookup.
+	maxRayLookupRange = 31 * 24 * time.Hour
+	// maxRayLookupObjects bounds the archived objects a lookup reads.
+	maxRayLookupObjects = 50
+)
+
+var rayIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// errRayBreakerOpen is returned instead of asking Cloudflare for a ray while
+// the customer's Logpull circuit breaker isn't closed.
+var errRayBreakerOpen = errors.New("cloudflare circuit breaker open")
+
+// rayRecord is the log record of a request found by its Ray ID. Records found
+// in the archive carry the object holding them and the hashes tying it into
+// the zone's WORM chain.
+type rayRecord struct {
+	RayID    string          `json:"ray_id"`
+	Source   string          `json:"source"` // "archive" or "cloudflare"
+	ZoneID   uuid.UUID       `json:"zone_id"`
+	ZoneName string          `json:"zone_name"`
+	Record   json.RawMessage `json:"record"`
+
+	JobID       *uuid.UUID `json:"job_id,omitempty"`
+	S3Key       string     `json:"s3_key,omitempty"`
+	PeriodStart *time.Time `json:"period_start,omitempty"`
+	PeriodEnd   *time.Time `json:"period_end,omitempty"`
+	SHA256      string     `json:"sha256,omitempty"`
+	ChainHash   string     `json:"chain_hash,omitempty"`
+	VerifiedAt  *time.Time `json:"verified_at,omitempty"`
+}
+
+// normalizeRayID lowercases a Ray ID and strips the data center suffix shown
+// on Cloudflare error pages (e.g. "8f1a2b3c4d5e6f70-FRA").
+func normalizeRayID(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+// GetRay returns the log record of a request by its Ray ID. The customer's
+// archived http_requests objects overlapping [from, to) are searched first,
+// newest first; from and to default to the last 24 hours. When the archive
+// doesn't hold the ray and the range reaches into Logpull's retention, the
+// customer's Logpull zones are asked Cloudflare. zone_id limits the lookup to
+// one zone.
+func (h *Handlers) GetRay(c echo.Context) error {
+	customerID, err := mustCustomerID(c)
+	if err != nil {
+		return err
+	}
+
+	rayID := normalizeRayID(c.Param("ray_id"))
+	if !rayIDPattern.MatchString(rayID) {
+		return apiErr(c, http.StatusBadRequest, "ray_id must be a 16-digit hexadecimal Cloudflare Ray ID", "INVALID_REQUEST")
+	}
+
+	now := time.Now().UTC()
+	from, to, err := rayLookupRange(c.QueryParam("from"), c.QueryParam("to"), now)
+	if err != nil {
+		return apiErr(c, http.StatusBadRequest, err.Error(), "INVALID_REQUEST")
+	}
+
+	ctx := c.Request().Context()
+	zoneFilter := uuid.Nil
+	var zones []*models.Zone
+	if v := c.QueryParam("zone_id"); v != "" {
+		if zoneFilter, err = uuid.Parse(v); err != nil {
+			return apiErr(c, http.StatusBadRequest, "invalid zone_id", "INVALID_REQUEST")
+		}
+		zone, err := h.db.Zones.GetByID(ctx, zoneFilter)
+		if err != nil {
+			return apiErr(c, http.StatusNotFound, "zone not found", "ZONE_NOT_FOUND")
+		}
+		if zone.CustomerID != customerID {
+			return apiErr(c, http.StatusForbidden, "access denied", "ACCESS_DENIED")
+		}
+		zones = []*models.Zone{zone}
+	} else if zones, err = h.db.Zones.ListByCustomer(ctx, customerID); err != nil {
+		return apiErr(c, http.StatusInternalServerError, "failed to list zones")
+	}
+
+	rec, err := h.findArchivedRay(c, customerID, zoneFilter, zones, rayID, from, to)
+	if err != nil {
+		return apiErr(c, http.StatusInternalServerError, "failed to search the archive")
+	}
+	if rec == nil && inLogpullRetention(to, now) {
+		if rec, err = h.lookupRay(ctx, customerID, zones, rayID); err != nil {
+			c.Logger().Errorf("ray %s: cloudflare lookup: %v", rayID, err)
+			return apiErr(c, http.StatusBadGateway, "cloudflare ray lookup failed", "CLOUDFLARE_ERROR")
+		}
+	}
+	if rec == nil {
+		return apiErr(c, http.StatusNotFound, "ray not found", "RAY_NOT_FOUND")
+	}
+	return c.JSON(http.StatusOK, rec)
+}
+
+// rayLookupRange parses the from and to of a ray lookup made at now. to
+// defaults to now and is clamped to it; from defaults to rayLookupSpan before
+// to. The range must be non-empty and at most maxRayLookupRange.
+func rayLookupRange(fromParam, toParam string, now time.Time) (from, to time.Time, err error) {
+	to = now
+	if toParam != "" {
+		if to, err = time.Parse(time.RFC3339, toParam); err != nil {
+			return from, to, errors.New("to must be an RFC 3339 timestamp")
+		}
+	}
+	from = to.Add(-rayLookupSpan)
+	if fromParam != "" {
+		if from, err = time.Parse(time.RFC3339, fromParam); err != nil {
+			return from, to, errors.New("from must be an RFC 3339 timestamp")
+		}
+	}
+	from, to = from.UTC(), to.UTC()
+	if to.After(now) {
+		to = now
+	}
+	if !from.Before(to) || to.Sub(from) > maxRayLookupRange {
+		return from, to, errors.New("from must be before to and the range at most 31 days")
+	}
+	return from, to, nil
+}
+
+// inLogpullRetention reports whether a range ending at to still reaches into
+// Logpull's retention at now, so Cloudflare may hold a ray the archive lacks.
+func inLogpullRetention(to, now time.Time) bool {
+	return to.After(now.Add(-cloudflare.LogRetention))
+}
+
+// findArchivedRay searches up to maxRayLookupObjects archived http_requests
+// objects of zones overlapping [from, to) for rayID, streaming each and
+// stopping at the first match. Objects that can't be read are logged and
+// skipped.
+func (h *Handlers) findArchivedRay(c echo.Context, customerID, zoneFilter uuid.UUID, zones []*models.Zone, rayID string, from, to time.Time) (*rayRecord, error) {
+	ctx := c.Request().Context()
+	jobs, err := h.db.LogJobs.ListArchived(ctx, customerID, zoneFilter, models.DatasetHTTPRequests, from, to, maxRayLookupObjects)
+	if err != nil {
+		c.Logger().Errorf("ray %s: list archived jobs: %v", rayID, err)
+		return nil, err
+	}
+	byID := make(map[uuid.UUID]*models.Zone, len(zones))
+	for _, z := range zones {
+		byID[z.ID] = z
+	}
+
+	for _, job := range jobs {
+		zone, ok := byID[job.ZoneID]
+		if !ok {
+			continue
+		}
+		line, err := h.findArchivedLine(ctx, job.S3Key, rayID)
+		if err != nil {
+			c.Logger().Errorf("ray %s: read archive of job %s: %v", rayID, job.ID, err)
+			continue
+		}
+		if line == nil {
+			continue
+		}
+		return &rayRecord{
+			RayID:       rayID,
+			Source:      "archive",
+			ZoneID:      zone.ID,
+			ZoneName:    zone.Name,
+			Record:      line,
+			JobID:       &job.ID,
+			S3Key:       job.S3Key,
+			PeriodStart: &job.PeriodStart,
+			PeriodEnd:   &job.PeriodEnd,
+			SHA256:      job.SHA256,
+			ChainHash:   job.ChainHash,
+			VerifiedAt:  job.VerifiedAt,
+		}, nil
+	}
+	return nil, nil
+}
+
+func (h *Handlers) findArchivedLine(ctx context.Context, key, rayID string) ([]byte, error) {
+	rc, err := h.storage.GetLogsStream(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return findRay(rc, rayID)
+}
+
+// findRay reads NDJSON from r up to the line whose RayID is rayID and returns
+// it, or nil when no line has it.
+func findRay(r io.Reader, rayID string) ([]byte, error) {
+	// Cloudflare logs Ray IDs in lowercase.
+	needle := []byte(rayID)
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if bytes.Contains(line, needle) {
+			var v struct {
+				RayID string `json:"RayID"`
+			}
+			if json.Unmarshal(line, &v) == nil && strings.EqualFold(v.RayID, rayID) {
+				return bytes.TrimSpace(line), nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// lookupRay asks Cloudflare for rayID in each of zones collected through
+// Logpull, with the zone's fields. A failure is returned only when no zone
+// holds the ray.
+func (h *Handlers) lookupRay(ctx context.Context, customerID uuid.UUID, zones []*models.Zone, rayID string) (*rayRecord, error) {
+	customer, err := h.db.Customers.GetByID(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	var firstErr error
+	for _, zone := range zones {
+		if breaker.FamilyFor(zone.Plan) != breaker.Logpull || zone.CFDeletedAt != nil {
+			continue
+		}
+		line, err := h.lookupZoneRay(ctx, customer, zone, rayID)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if line != nil {
+			return &rayRecord{RayID: rayID, Source: "cloudflare", ZoneID: zone.ID, ZoneName: zone.Name, Record: line}, nil
+		}
+	}
+	return nil, firstErr
+}
+
+// lookupZoneRay asks Cloudflare for rayID in zone with the token the zone is
+// collected with, drawing on the same request budget as the workers. It only
+// reads the customer's Logpull breaker and stays off Cloudflare unless it is
+// closed: the half-open probe and counting outcomes are left to the
+// collectors.
+func (h *Handlers) lookupZoneRay(ctx context.Context, customer *models.Customer, zone *models.Zone, rayID string) ([]byte, error) {
+	if state, err := h.breakers.State(ctx, customer.ID, breaker.Logpull); err == nil && state != breaker.Closed {
+		return nil, errRayBreakerOpen
+	}
+	apiKey, err := h.creds.ForZone(customer, zone)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := cloudflare.ResolveFields(zone.FieldProfile, zone.LogFields)
+	if err != nil {
+		return nil, err
+	}
+	client := cloudflare.NewClient(h.cfCfg, zone.ZoneID, apiKey).WithLimiter(h.limits.ForZone(apiKey, zone.Plan))
+	return client.GetRay(ctx, rayID, fields)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fabriziosalmi/rainlogs/internal/api/middleware"
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+)
+
+func TestNormalizeRayID(t *testing.T) {
+	assert.Equal(t, "8f1a2b3c4d5e6f70", normalizeRayID("8F1A2B3C4D5E6F70-FRA"))
+	assert.Equal(t, "8f1a2b3c4d5e6f70", normalizeRayID(" 8f1a2b3c4d5e6f70 "))
+	assert.Equal(t, "", normalizeRayID("-FRA"))
+}
+
+func TestFindRay(t *testing.T) {
+	ndjson := strings.Join([]string{
+		`{"RayID":"0000000000000001","ClientIP":"192.0.2.1"}`,
+		`{"RayID":"0000000000000002","ClientRequestPath":"/8f1a2b3c4d5e6f70"}`,
+		`{"RayID":"8f1a2b3c4d5e6f70","ClientIP":"192.0.2.3"}`,
+		`{"RayID":"0000000000000004"}`,
+	}, "\n")
+
+	line, err := findRay(strings.NewReader(ndjson), "8f1a2b3c4d5e6f70")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"RayID":"8f1a2b3c4d5e6f70","ClientIP":"192.0.2.3"}`, string(line))
+
+	// The last line has no trailing newline.
+	line, err = findRay(strings.NewReader(ndjson), "0000000000000004")
+	require.NoError(t, err)
+	assert.Equal(t, `{"RayID":"0000000000000004"}`, string(line))
+
+	// A ray only mentioned in another request's fields isn't a match.
+	line, err = findRay(strings.NewReader(ndjson+"\n"+`{"RayID":"0000000000000005","Referer":"9f1a2b3c4d5e6f70"}`), "9f1a2b3c4d5e6f70")
+	require.NoError(t, err)
+	assert.Nil(t, line)
+
+	line, err = findRay(strings.NewReader(""), "8f1a2b3c4d5e6f70")
+	require.NoError(t, err)
+	assert.Nil(t, line)
+}
+
+func TestRayLookupRange(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	from, to, err := rayLookupRange("", "", now)
+	require.NoError(t, err)
+	assert.Equal(t, now, to)
+	assert.Equal(t, now.Add(-rayLookupSpan), from)
+
+	// to is clamped to now.
+	from, to, err = rayLookupRange("2026-03-10T00:00:00Z", "2026-03-11T00:00:00Z", now)
+	require.NoError(t, err)
+	assert.Equal(t, now, to)
+	assert.Equal(t, time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC), from)
+
+	// Offsets are normalized to UTC.
+	from, _, err = rayLookupRange("2026-03-10T01:00:00+01:00", "", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.UTC, from.Location())
+	assert.Equal(t, time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC), from)
+
+	for name, tc := range map[string][2]string{
+		"bad from":       {"yesterday", ""},
+		"bad to":         {"", "2026-03-10"},
+		"reversed":       {"2026-03-09T00:00:00Z", "2026-03-08T00:00:00Z"},
+		"empty":          {"2026-03-09T00:00:00Z", "2026-03-09T00:00:00Z"},
+		"too long":       {"2026-02-01T00:00:00Z", "2026-03-05T00:00:00Z"},
+		"from after now": {"2026-03-11T00:00:00Z", ""},
+	} {
+		_, _, err := rayLookupRange(tc[0], tc[1], now)
+		assert.Error(t, err, name)
+	}
+
+	_, _, err = rayLookupRange("2026-02-01T00:00:00Z", "2026-03-04T00:00:00Z", now)
+	assert.NoError(t, err, "31 days")
+}
+
+func TestInLogpullRetention(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	assert.True(t, inLogpullRetention(now, now))
+	assert.True(t, inLogpullRetention(now.Add(-cloudflare.LogRetention+time.Minute), now))
+	assert.False(t, inLogpullRetention(now.Add(-cloudflare.LogRetention), now))
+	assert.False(t, inLogpullRetention(now.Add(-30*24*time.Hour), now))
+}
+
+func TestGetRay_RejectsInvalidRequests(t *testing.T) {
+	// Each request is refused before the database is touched.
+	h := &Handlers{}
+	for name, target := range map[string]string{
+		"bad ray id": "/v1/rays/not-a-ray",
+		"bad from":   "/v1/rays/8f1a2b3c4d5e6f70?from=yesterday",
+		"too long":   "/v1/rays/8f1a2b3c4d5e6f70?from=2020-01-01T00:00:00Z&to=2020-03-01T00:00:00Z",
+		"reversed":   "/v1/rays/8f1a2b3c4d5e6f70?from=2020-03-01T00:00:00Z&to=2020-01-01T00:00:00Z",
+		"bad zone":   "/v1/rays/8f1a2b3c4d5e6f70?zone_id=nope",
+	} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("ray_id")
+		c.SetParamValues(strings.TrimPrefix(strings.SplitN(target, "?", 2)[0], "/v1/rays/"))
+		c.Set(middleware.ContextKeyCustomerID, uuid.New())
+
+		require.NoError(t, h.GetRay(c), name)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, name)
+		assert.Contains(t, rec.Body.String(), "INVALID_REQUEST", name)
+	}
+}
@@ -188,6 +188,14 @@ func auditAction(method, path string) string {
 		return "ZONE_UPDATE"
 	case "POST /zones/:zone_id/pull":
 		return "ZONE_PULL"
+	case "POST /zones/:zone_id/logpush":
+		return "ZONE_LOGPUSH_ENABLE"
+	case "DELETE /zones/:zone_id/logpush":
+		return "ZONE_LOGPUSH_DISABLE"
+	case "PUT /zones/:zone_id/datasets/:dataset":
+		return "ZONE_DATASET_UPDATE"
+	case "DELETE /zones/:zone_id/datasets/:dataset":
+		return "ZONE_DATASET_DELETE"
 	case "DELETE /customers/:id":
 		return "CUSTOMER_ERASE"
 	case "POST /api-keys":
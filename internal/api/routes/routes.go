@@ -6,17 +6,22 @@ import (
 
 	"github.com/fabriziosalmi/rainlogs/internal/api/handlers"
 	"github.com/fabriziosalmi/rainlogs/internal/api/middleware"
+	"github.com/fabriziosalmi/rainlogs/internal/breaker"
+	"github.com/fabriziosalmi/rainlogs/internal/config"
 	"github.com/fabriziosalmi/rainlogs/internal/db"
 	"github.com/fabriziosalmi/rainlogs/internal/kms"
 	"github.com/fabriziosalmi/rainlogs/internal/storage"
 )
 
-func Register(e *echo.Echo, database *db.DB, kms *kms.Encryptor, jwtSecret string, queue *asynq.Client, store *storage.MultiStore) {
-	h := handlers.NewHandlers(database, kms, queue, store)
+func Register(e *echo.Echo, database *db.DB, kms *kms.Encryptor, jwtSecret string, queue *asynq.Client, store *storage.MultiStore, cfCfg config.CloudflareConfig, breakers *breaker.Breakers, creds handlers.Credentials, limits handlers.RateLimits, plans handlers.PlanSwitcher) {
+	h := handlers.NewHandlers(database, kms, queue, store, cfCfg, breakers, creds, limits, plans)
 
 	// Public — self-registration only; profile reads require auth (own-record only).
 	e.POST("/customers", h.CreateCustomer)
 
+	// Logpush HTTP destination — authenticated by the zone's Logpush secret.
+	e.POST("/ingest/logpush/:zone_id", h.IngestLogpush)
+
 	// ── API-key protected ────────────────────────────────────────────────────
 	api := e.Group("/api/v1")
 	api.Use(middleware.APIKeyAuth(database))
@@ -28,10 +33,15 @@ func Register(e *echo.Echo, database *db.DB, kms *kms.Encryptor, jwtSecret strin
 
 	api.GET("/zones", h.ListZones)
 	api.GET("/zones/:zone_id/logs", h.GetZoneLogs)
+	api.GET("/zones/:zone_id/backfills/:backfill_id", h.GetBackfill)
+	api.GET("/zones/:zone_id/coverage", h.GetZoneCoverage)
+	api.GET("/zones/:zone_id/datasets", h.ListZoneDatasets)
+	api.GET("/cloudflare/zones", h.ListCloudflareZones)
 	api.GET("/api-keys", h.ListAPIKeys)
 	api.GET("/logs/jobs", h.ListLogJobs)
 	api.GET("/logs/jobs/:job_id", h.GetLogJob)
 	api.GET("/logs/jobs/:job_id/download", h.DownloadLogs)
+	api.GET("/rays/:ray_id", h.GetRay)
 	api.GET("/exports/:id", h.Export.Get)
 	api.GET("/export", h.ExportCustomerData) // GDPR Art. 20 – data portability
 	api.GET("/audit-log", h.ListAuditLog)    // GDPR Art. 30 / NIS2 Art. 21
@@ -40,12 +50,19 @@ func Register(e *echo.Echo, database *db.DB, kms *kms.Encryptor, jwtSecret strin
 	admin := api.Group("")
 	admin.Use(middleware.RequireAdmin())
 
+	admin.PATCH("/customers/:id", h.UpdateCustomer)
 	admin.DELETE("/customers/:id", h.DeleteCustomer) // GDPR Art. 17 – right to erasure
 
 	admin.POST("/zones", h.CreateZone)
 	admin.PATCH("/zones/:zone_id", h.UpdateZone)
 	admin.DELETE("/zones/:zone_id", h.DeleteZone)
 	admin.POST("/zones/:zone_id/pull", h.TriggerPull)
+	admin.POST("/zones/:zone_id/backfill", h.CreateBackfill) // Logpull 7-day horizon
+	admin.POST("/zones/:zone_id/logpush", h.EnableLogpush)
+	admin.DELETE("/zones/:zone_id/logpush", h.DisableLogpush)
+	admin.PUT("/zones/:zone_id/datasets/:dataset", h.PutZoneDataset)
+	admin.DELETE("/zones/:zone_id/datasets/:dataset", h.DeleteZoneDataset)
+	admin.POST("/cloudflare/zones/discover", h.DiscoverCloudflareZones)
 
 	admin.POST("/api-keys", h.CreateAPIKey)
 	admin.DELETE("/api-keys/:key_id", h.RevokeAPIKey)
@@ -59,6 +76,7 @@ func Register(e *echo.Echo, database *db.DB, kms *kms.Encryptor, jwtSecret strin
 	dash.Use(middleware.AuditLog(database.AuditEvents))
 
 	dash.GET("/customers/:id", h.GetCustomer) // own record only
+	dash.PATCH("/customers/:id", h.UpdateCustomer)
 	dash.DELETE("/customers/:id", h.DeleteCustomer)
 
 	dash.POST("/zones", h.CreateZone)
@@ -67,6 +85,16 @@ func Register(e *echo.Echo, database *db.DB, kms *kms.Encryptor, jwtSecret strin
 	dash.DELETE("/zones/:zone_id", h.DeleteZone)
 	dash.POST("/zones/:zone_id/pull", h.TriggerPull)
 	dash.GET("/zones/:zone_id/logs", h.GetZoneLogs)
+	dash.POST("/zones/:zone_id/backfill", h.CreateBackfill)
+	dash.GET("/zones/:zone_id/backfills/:backfill_id", h.GetBackfill)
+	dash.GET("/zones/:zone_id/coverage", h.GetZoneCoverage)
+	dash.POST("/zones/:zone_id/logpush", h.EnableLogpush)
+	dash.DELETE("/zones/:zone_id/logpush", h.DisableLogpush)
+	dash.GET("/zones/:zone_id/datasets", h.ListZoneDatasets)
+	dash.PUT("/zones/:zone_id/datasets/:dataset", h.PutZoneDataset)
+	dash.DELETE("/zones/:zone_id/datasets/:dataset", h.DeleteZoneDataset)
+	dash.GET("/cloudflare/zones", h.ListCloudflareZones)
+	dash.POST("/cloudflare/zones/discover", h.DiscoverCloudflareZones)
 
 	dash.POST("/api-keys", h.CreateAPIKey)
 	dash.GET("/api-keys", h.ListAPIKeys)
@@ -75,6 +103,7 @@ func Register(e *echo.Echo, database *db.DB, kms *kms.Encryptor, jwtSecret strin
 	dash.GET("/logs/jobs", h.ListLogJobs)
 	dash.GET("/logs/jobs/:job_id", h.GetLogJob)
 	dash.GET("/logs/jobs/:job_id/download", h.DownloadLogs)
+	dash.GET("/rays/:ray_id", h.GetRay)
 
 	dash.GET("/export", h.ExportCustomerData)
 	dash.GET("/audit-log", h.ListAuditLog)
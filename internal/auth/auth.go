@@ -2,7 +2,10 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"strings"
 	"time"
@@ -57,6 +60,31 @@ func PrefixOf(plaintext string) (string, error) {
 	return body[:prefixLen], nil
 }
 
+// GenerateSecret returns a random shared secret for machine callers, such as
+// Logpush jobs, and its SHA-256 hash. The secret carries 256 bits of entropy,
+// so a fast hash is enough and keeps per-request checks cheap.
+func GenerateSecret() (plaintext, hash string, err error) {
+	b := make([]byte, tokenBytes)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("auth: rand: %w", err)
+	}
+	plaintext = base64.RawURLEncoding.EncodeToString(b)
+	return plaintext, hashSecret(plaintext), nil
+}
+
+// ValidateSecret compares a plaintext secret against its hash in constant time.
+func ValidateSecret(plaintext, hash string) bool {
+	if hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashSecret(plaintext)), []byte(hash)) == 1
+}
+
+func hashSecret(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
 // Claims is the JWT payload for internal service-to-service auth.
 type Claims struct {
 	CustomerID string `json:"cid"`
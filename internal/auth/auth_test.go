@@ -51,6 +51,15 @@ func TestValidateAPIKey_Invalid(t *testing.T) {
 	assert.False(t, auth.ValidateAPIKey("rl_wrongkey", hash))
 }
 
+func TestValidateSecret(t *testing.T) {
+	plaintext, hash, err := auth.GenerateSecret()
+	require.NoError(t, err)
+
+	assert.True(t, auth.ValidateSecret(plaintext, hash))
+	assert.False(t, auth.ValidateSecret(plaintext+"x", hash))
+	assert.False(t, auth.ValidateSecret("", ""), "an unset hash matches nothing")
+}
+
 func TestPrefixOf_Valid(t *testing.T) {
 	plaintext, _, expectedPrefix, err := auth.GenerateAPIKey()
 	require.NoError(t, err)
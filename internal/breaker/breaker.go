@@ -0,0 +1,185 @@
+// Package breaker implements circuit breakers around Cloudflare API calls,
+// one per customer account and API family, shared by every replica through
+// Redis.
+//
+// A breaker starts closed. Consecutive failures up to the threshold open it,
+// and an open breaker refuses calls for the cooldown. After that it goes
+// half-open and lets a single probe through: a success closes it, a failure
+// opens it for another cooldown. A customer's zones share one Cloudflare
+// account and token, so breakers are keyed by customer.
+package breaker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+const keyPrefix = "rainlogs:cf:breaker:"
+
+// keyTTL bounds how long an untouched breaker is kept. Open breakers are
+// touched every scheduler tick, so only abandoned ones expire.
+const keyTTL = 24 * time.Hour
+
+// Family is a Cloudflare API family. Each fails independently, e.g. Logpull
+// can be down while GraphQL answers.
+type Family string
+
+const (
+	Logpull     Family = "logpull"
+	GraphQL     Family = "graphql"
+	InstantLogs Family = "instant_logs"
+)
+
+// FamilyFor returns the API family that collects logs for zones on plan.
+func FamilyFor(plan models.PlanType) Family {
+	switch plan {
+	case models.PlanFreePro:
+		return GraphQL
+	case models.PlanBusiness:
+		return InstantLogs
+	default:
+		return Logpull
+	}
+}
+
+// State is a breaker state.
+type State string
+
+const (
+	Closed   State = "closed"
+	Open     State = "open"
+	HalfOpen State = "half_open"
+)
+
+// allowScript lets a call through a breaker. A breaker whose cooldown has
+// passed goes half-open and gives its probe ARGV[1] milliseconds to report
+// back before another probe is let through. It returns {allowed, state}.
+var allowScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local b = redis.call("HMGET", KEYS[1], "state", "until")
+local state = b[1] or "closed"
+if state == "closed" then
+	return {1, state}
+end
+if now < (tonumber(b[2]) or 0) then
+	return {0, state}
+end
+redis.call("HSET", KEYS[1], "state", "half_open", "until", now + tonumber(ARGV[1]))
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return {1, "half_open"}`)
+
+// failureScript counts a failure. It opens the breaker for ARGV[2]
+// milliseconds when the count reaches ARGV[1] or a half-open probe failed,
+// and returns {state, tripped}.
+var failureScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call("HGET", KEYS[1], "state") or "closed"
+local failures = redis.call("HINCRBY", KEYS[1], "failures", 1)
+local tripped = 0
+if state == "half_open" or (state == "closed" and failures >= tonumber(ARGV[1])) then
+	state = "open"
+	tripped = 1
+	redis.call("HSET", KEYS[1], "state", state, "until", now + tonumber(ARGV[2]))
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return {state, tripped}`)
+
+// Breakers holds the circuit breakers of all accounts. A nil *Breakers is
+// always closed.
+type Breakers struct {
+	rdb       redis.UniversalClient
+	threshold int
+	cooldown  time.Duration
+}
+
+// New returns breakers that open after threshold consecutive failures and
+// stay open for cooldown.
+func New(rdb redis.UniversalClient, threshold int, cooldown time.Duration) *Breakers {
+	return &Breakers{rdb: rdb, threshold: threshold, cooldown: cooldown}
+}
+
+func key(account uuid.UUID, f Family) string {
+	return keyPrefix + account.String() + ":" + string(f)
+}
+
+// Allow reports whether a call to family may be made for account. Callers
+// that are allowed must report the outcome with Success or Failure.
+func (b *Breakers) Allow(ctx context.Context, account uuid.UUID, f Family) (bool, error) {
+	if b == nil {
+		return true, nil
+	}
+	res, err := allowScript.Run(ctx, b.rdb, []string{key(account, f)}, b.cooldown.Milliseconds(), keyTTL.Milliseconds()).Slice()
+	if err != nil {
+		return false, fmt.Errorf("breaker: allow %s: %w", f, err)
+	}
+	allowed, _ := res[0].(int64)
+	state, _ := res[1].(string)
+	observe(account, f, State(state))
+	return allowed == 1, nil
+}
+
+// Success records a successful call, closing the breaker.
+func (b *Breakers) Success(ctx context.Context, account uuid.UUID, f Family) error {
+	if b == nil {
+		return nil
+	}
+	if err := b.rdb.Del(ctx, key(account, f)).Err(); err != nil {
+		return fmt.Errorf("breaker: record success %s: %w", f, err)
+	}
+	observe(account, f, Closed)
+	return nil
+}
+
+// Failure records a failed call. It reports whether the failure opened the
+// breaker.
+func (b *Breakers) Failure(ctx context.Context, account uuid.UUID, f Family) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+	res, err := failureScript.Run(ctx, b.rdb, []string{key(account, f)}, b.threshold, b.cooldown.Milliseconds(), keyTTL.Milliseconds()).Slice()
+	if err != nil {
+		return false, fmt.Errorf("breaker: record failure %s: %w", f, err)
+	}
+	state, _ := res[0].(string)
+	tripped, _ := res[1].(int64)
+	observe(account, f, State(state))
+	if tripped == 1 {
+		breakerTrips.WithLabelValues(string(f)).Inc()
+	}
+	return tripped == 1, nil
+}
+
+// State returns the state of account's breaker for family without changing
+// it. An open breaker whose cooldown has passed is reported half-open, as the
+// next call will probe it.
+func (b *Breakers) State(ctx context.Context, account uuid.UUID, f Family) (State, error) {
+	if b == nil {
+		return Closed, nil
+	}
+	vals, err := b.rdb.HMGet(ctx, key(account, f), "state", "until").Result()
+	if err != nil {
+		return "", fmt.Errorf("breaker: state %s: %w", f, err)
+	}
+	state, _ := vals[0].(string)
+	switch State(state) {
+	case Open:
+		until, _ := vals[1].(string)
+		if ms, err := strconv.ParseInt(until, 10, 64); err == nil && time.Now().UnixMilli() >= ms {
+			return HalfOpen, nil
+		}
+		return Open, nil
+	case HalfOpen:
+		return HalfOpen, nil
+	default:
+		return Closed, nil
+	}
+}
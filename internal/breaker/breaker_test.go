@@ -0,0 +1,181 @@
+package breaker
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/redistest"
+)
+
+func TestFamilyFor(t *testing.T) {
+	assert.Equal(t, Logpull, FamilyFor(models.PlanEnterprise))
+	assert.Equal(t, GraphQL, FamilyFor(models.PlanFreePro))
+	assert.Equal(t, InstantLogs, FamilyFor(models.PlanBusiness))
+	assert.Equal(t, Logpull, FamilyFor(""), "zones without a plan are pulled like Enterprise")
+}
+
+func TestNilBreakersStayClosed(t *testing.T) {
+	var b *Breakers
+	ctx := context.Background()
+	id := uuid.New()
+
+	allowed, err := b.Allow(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	tripped, err := b.Failure(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.False(t, tripped)
+	assert.NoError(t, b.Success(ctx, id, Logpull))
+
+	state, err := b.State(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.Equal(t, Closed, state)
+}
+
+// newFakeBreakers returns breakers over a fake Redis running Go equivalents of
+// allowScript and failureScript.
+func newFakeBreakers(t *testing.T, threshold int, cooldown time.Duration) (*Breakers, *redistest.Server) {
+	f := redistest.NewServer(t)
+	f.Script(allowScript, func(db *redistest.DB, keys, args []string) (any, error) {
+		state, ok := db.HGet(keys[0], "state")
+		if !ok || state == string(Closed) {
+			return []any{int64(1), string(Closed)}, nil
+		}
+		until, _ := db.HGet(keys[0], "until")
+		if ms, _ := strconv.ParseInt(until, 10, 64); db.Now().UnixMilli() < ms {
+			return []any{int64(0), state}, nil
+		}
+		probe, _ := strconv.ParseInt(args[0], 10, 64)
+		db.HSet(keys[0], "state", string(HalfOpen))
+		db.HSet(keys[0], "until", strconv.FormatInt(db.Now().UnixMilli()+probe, 10))
+		return []any{int64(1), string(HalfOpen)}, nil
+	})
+	f.Script(failureScript, func(db *redistest.DB, keys, args []string) (any, error) {
+		state, ok := db.HGet(keys[0], "state")
+		if !ok {
+			state = string(Closed)
+		}
+		n, _ := db.HGet(keys[0], "failures")
+		failures, _ := strconv.Atoi(n)
+		failures++
+		db.HSet(keys[0], "failures", strconv.Itoa(failures))
+		threshold, _ := strconv.Atoi(args[0])
+		cooldown, _ := strconv.ParseInt(args[1], 10, 64)
+		var tripped int64
+		if state == string(HalfOpen) || (state == string(Closed) && failures >= threshold) {
+			state, tripped = string(Open), 1
+			db.HSet(keys[0], "state", state)
+			db.HSet(keys[0], "until", strconv.FormatInt(db.Now().UnixMilli()+cooldown, 10))
+		}
+		return []any{state, tripped}, nil
+	})
+	return New(f.Client, threshold, cooldown), f
+}
+
+func TestBreakers_TripAtThreshold(t *testing.T) {
+	b, _ := newFakeBreakers(t, 3, time.Minute)
+	ctx := context.Background()
+	id := uuid.New()
+
+	for i := 0; i < 2; i++ {
+		tripped, err := b.Failure(ctx, id, Logpull)
+		require.NoError(t, err)
+		assert.False(t, tripped)
+	}
+	allowed, err := b.Allow(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.True(t, allowed, "below the threshold the breaker stays closed")
+
+	tripped, err := b.Failure(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.True(t, tripped)
+	allowed, err = b.Allow(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	state, err := b.State(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.Equal(t, Open, state)
+
+	// Breakers are per customer and family.
+	allowed, err = b.Allow(ctx, id, GraphQL)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, err = b.Allow(ctx, uuid.New(), Logpull)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	// A success in between starts the count again.
+	other := uuid.New()
+	for _, fail := range []bool{true, true, false, true, true} {
+		if !fail {
+			require.NoError(t, b.Success(ctx, other, Logpull))
+			continue
+		}
+		tripped, err := b.Failure(ctx, other, Logpull)
+		require.NoError(t, err)
+		assert.False(t, tripped)
+	}
+}
+
+func TestBreakers_HalfOpenProbe(t *testing.T) {
+	b, f := newFakeBreakers(t, 1, time.Minute)
+	ctx := context.Background()
+	id := uuid.New()
+
+	tripped, err := b.Failure(ctx, id, Logpull)
+	require.NoError(t, err)
+	require.True(t, tripped)
+
+	// Within the cooldown every call is refused.
+	f.Advance(59 * time.Second)
+	allowed, err := b.Allow(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	// After it one probe goes through, and only one.
+	f.Advance(time.Second)
+	allowed, err = b.Allow(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.True(t, allowed, "the cooldown ended: probe")
+	allowed, err = b.Allow(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.False(t, allowed, "a probe is already out")
+	state, err := b.State(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.Equal(t, HalfOpen, state)
+
+	// A failed probe opens the breaker for another cooldown.
+	tripped, err = b.Failure(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.True(t, tripped)
+	allowed, err = b.Allow(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	// A probe that never reports back is replaced after a cooldown.
+	f.Advance(time.Minute)
+	allowed, err = b.Allow(ctx, id, Logpull)
+	require.NoError(t, err)
+	require.True(t, allowed)
+	f.Advance(time.Minute)
+	allowed, err = b.Allow(ctx, id, Logpull)
+	require.NoError(t, err)
+	require.True(t, allowed)
+
+	// A successful probe closes it.
+	require.NoError(t, b.Success(ctx, id, Logpull))
+	allowed, err = b.Allow(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	state, err = b.State(ctx, id, Logpull)
+	require.NoError(t, err)
+	assert.Equal(t, Closed, state)
+}
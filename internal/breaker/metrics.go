@@ -0,0 +1,35 @@
+package breaker
+
+import (
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Circuit breaker metrics, exposed on the worker's /metrics endpoint.
+var (
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "rainlogs",
+		Subsystem: "cloudflare_breaker",
+		Name:      "state",
+		Help:      "Circuit breaker state per customer and API family as last seen by this replica: 0 closed, 1 half-open, 2 open.",
+	}, []string{"customer", "family"})
+
+	breakerTrips = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rainlogs",
+		Subsystem: "cloudflare_breaker",
+		Name:      "trips_total",
+		Help:      "Circuit breakers opened, per API family.",
+	}, []string{"family"})
+)
+
+func observe(account uuid.UUID, f Family, s State) {
+	v := 0.0
+	switch s {
+	case HalfOpen:
+		v = 1
+	case Open:
+		v = 2
+	}
+	breakerState.WithLabelValues(account.String(), string(f)).Set(v)
+}
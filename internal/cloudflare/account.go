@@ -0,0 +1,114 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+)
+
+// zonesPageSize is the page size used when listing an account's zones (API max 50).
+const zonesPageSize = 50
+
+// AccountClient calls account-level Cloudflare APIs.
+type AccountClient struct {
+	baseURL    string
+	httpClient *http.Client
+	accountID  string
+	apiKey     string
+}
+
+// NewAccountClient creates an AccountClient for a Cloudflare account.
+func NewAccountClient(cfg config.CloudflareConfig, accountID, apiKey string) *AccountClient {
+	base := cfg.BaseURL
+	if base == "" {
+		base = defaultBaseURL
+	}
+	return &AccountClient{
+		baseURL:    base,
+		httpClient: newHTTPClient(cfg.RequestTimeout),
+		accountID:  accountID,
+		apiKey:     apiKey,
+	}
+}
+
+// AccountZone is a zone as returned by the zones API.
+type AccountZone struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"` // active, pending, initializing, moved, ...
+	Paused bool   `json:"paused"`
+	Plan   struct {
+		Name     string `json:"name"`
+		LegacyID string `json:"legacy_id"` // free, pro, business, enterprise
+	} `json:"plan"`
+}
+
+type zonesResponse struct {
+	Success    bool            `json:"success"`
+	Result     []AccountZone   `json:"result"`
+	Errors     []ResponseError `json:"errors"`
+	ResultInfo struct {
+		Page       int `json:"page"`
+		TotalPages int `json:"total_pages"`
+	} `json:"result_info"`
+}
+
+// ListZones returns every zone of the account visible to the token.
+func (c *AccountClient) ListZones(ctx context.Context) ([]AccountZone, error) {
+	var zones []AccountZone
+	for page := 1; ; page++ {
+		resp, err := c.zonesPage(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, resp.Result...)
+		if page >= resp.ResultInfo.TotalPages || len(resp.Result) == 0 {
+			return zones, nil
+		}
+	}
+}
+
+func (c *AccountClient) zonesPage(ctx context.Context, page int) (*zonesResponse, error) {
+	u, err := url.Parse(c.baseURL + "/zones")
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: parse url: %w", err)
+	}
+	q := u.Query()
+	q.Set("account.id", c.accountID)
+	q.Set("page", strconv.Itoa(page))
+	q.Set("per_page", strconv.Itoa(zonesPageSize))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: new request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, rateLimitError(resp)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError("list zones", resp)
+	}
+
+	var out zonesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("cloudflare: decode zones: %w", err)
+	}
+	if !out.Success {
+		return nil, responseError("list zones", out.Errors)
+	}
+	return &out, nil
+}
@@ -0,0 +1,63 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+)
+
+func TestAccountClient_ListZonesPaginates(t *testing.T) {
+	const total = 120
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("account.id"); got != "acc-1" {
+			t.Errorf("account.id = %q", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer tok" {
+			t.Errorf("Authorization = %q", got)
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		pages := (total + perPage - 1) / perPage
+
+		var result string
+		for i := (page - 1) * perPage; i < min(page*perPage, total); i++ {
+			if result != "" {
+				result += ","
+			}
+			result += fmt.Sprintf(`{"id":"z%d","name":"zone%d.example","status":"active","plan":{"legacy_id":"pro"}}`, i, i)
+		}
+		fmt.Fprintf(w, `{"success":true,"errors":[],"result":[%s],"result_info":{"page":%d,"total_pages":%d}}`, result, page, pages)
+	}))
+	defer srv.Close()
+
+	c := NewAccountClient(config.CloudflareConfig{BaseURL: srv.URL, RequestTimeout: 5 * time.Second}, "acc-1", "tok")
+	zones, err := c.ListZones(context.Background())
+	if err != nil {
+		t.Fatalf("ListZones: %v", err)
+	}
+	if len(zones) != total {
+		t.Fatalf("got %d zones, want %d", len(zones), total)
+	}
+	if zones[total-1].ID != fmt.Sprintf("z%d", total-1) || zones[0].Plan.LegacyID != "pro" {
+		t.Errorf("unexpected zones: first %+v, last %+v", zones[0], zones[total-1])
+	}
+}
+
+func TestAccountClient_ListZonesError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"success":false,"errors":[{"code":9109,"message":"Unauthorized to access requested resource"}]}`)
+	}))
+	defer srv.Close()
+
+	c := NewAccountClient(config.CloudflareConfig{BaseURL: srv.URL}, "acc-1", "tok")
+	if _, err := c.ListZones(context.Background()); err == nil {
+		t.Fatal("expected an error for HTTP 403")
+	}
+}
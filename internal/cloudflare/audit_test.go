@@ -0,0 +1,67 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+)
+
+// auditServer serves total audit log entries one second apart from base,
+// plus one entry on each side of the requested window.
+func auditServer(t *testing.T, base time.Time, total int) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/accounts/acc-1/audit_logs" || r.URL.Query().Get("direction") != "asc" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+
+		var result string
+		for i := (page-1)*perPage - 1; i < min(page*perPage-1, total+1); i++ {
+			if result != "" {
+				result += ","
+			}
+			when := base.Add(time.Duration(i) * time.Second).Format(time.RFC3339)
+			result += fmt.Sprintf(`{"id":"a%d","when":%q,"action":{"type":"rec_set"}}`, i, when)
+		}
+		fmt.Fprintf(w, `{"success":true,"errors":[],"result":[%s]}`, result)
+	}))
+}
+
+func TestAccountClient_GetAuditLogs(t *testing.T) {
+	base := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	srv := auditServer(t, base, 1500)
+	defer srv.Close()
+
+	c := NewAccountClient(config.CloudflareConfig{BaseURL: srv.URL, RequestTimeout: 5 * time.Second}, "acc-1", "tok")
+	end := base.Add(1500 * time.Second)
+	logs, drained, err := c.GetAuditLogs(context.Background(), base, end, 0)
+	if err != nil {
+		t.Fatalf("GetAuditLogs: %v", err)
+	}
+	if len(logs) != 1500 || !drained.Equal(end) {
+		t.Fatalf("got %d logs drained until %v, want 1500 until %v", len(logs), drained, end)
+	}
+	if logs[0].ID != "a0" || logs[1499].ID != "a1499" || string(logs[0].Raw) == "" {
+		t.Errorf("unexpected logs: first %+v, last %+v", logs[0], logs[1499])
+	}
+
+	// The bound cuts at a timestamp boundary and reports where to resume.
+	logs, drained, err = c.GetAuditLogs(context.Background(), base, end, 500)
+	if err != nil {
+		t.Fatalf("GetAuditLogs bounded: %v", err)
+	}
+	if want := logs[len(logs)-1].When.Add(time.Second); !drained.Equal(want) || drained.After(end) {
+		t.Errorf("drained until %v, want %v", drained, want)
+	}
+	if len(logs) >= 1500 {
+		t.Errorf("bounded fetch returned %d logs", len(logs))
+	}
+}
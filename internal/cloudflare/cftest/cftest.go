@@ -0,0 +1,614 @@
+// Package cftest is an in-process fake of the Cloudflare APIs RainLogs
+// collects from, for tests and local development: Logpull, the GraphQL
+// analytics endpoint, and Instant Logs jobs with their WebSocket stream.
+//
+// Point config.CloudflareConfig.BaseURL at Server.URL. Zones are filled with
+// AddLogs, AddSecurityEvents and SendInstantLog; rate limits, refusals and
+// outages are scripted with Inject.
+package cftest
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+)
+
+// Endpoint names a faked API, for Inject and Calls.
+type Endpoint string
+
+const (
+	Zone              Endpoint = "zone"                // GET /zones/{zone}
+	Logpull           Endpoint = "logpull"             // GET /zones/{zone}/logs/received
+	RetentionFlag     Endpoint = "retention_flag"      // GET /zones/{zone}/logs/control/retention/flag
+	RayLookup         Endpoint = "ray_lookup"          // GET /zones/{zone}/logs/rayids/{ray}
+	GraphQL           Endpoint = "graphql"             // POST /graphql
+	InstantLogsJobs   Endpoint = "instant_logs_jobs"   // GET /zones/{zone}/logpush/edge/jobs
+	InstantLogsJob    Endpoint = "instant_logs_job"    // POST /zones/{zone}/logpush/edge/jobs
+	InstantLogsStream Endpoint = "instant_logs_stream" // the job's WebSocket
+)
+
+// Fault is a scripted failure of an endpoint.
+type Fault struct {
+	// Status is the HTTP status answered. GraphQL faults with status 200 send
+	// Errors as GraphQL errors[], the way Cloudflare reports them.
+	Status int
+	// RetryAfter is sent as Retry-After, in whole seconds, when positive.
+	RetryAfter time.Duration
+	// Errors is the errors[] of the response body.
+	Errors []cloudflare.ResponseError
+	// Times is how many requests fail; 0 means one.
+	Times int
+}
+
+// RateLimited is the 429 Cloudflare answers once a token's budget is spent.
+func RateLimited(retryAfter time.Duration) Fault {
+	return Fault{
+		Status:     http.StatusTooManyRequests,
+		RetryAfter: retryAfter,
+		Errors:     []cloudflare.ResponseError{{Code: 10000, Message: "Rate limited"}},
+	}
+}
+
+// Forbidden is the 403 Cloudflare answers to a token lacking a permission.
+func Forbidden() Fault {
+	return Fault{
+		Status: http.StatusForbidden,
+		Errors: []cloudflare.ResponseError{{Code: 10000, Message: "Authentication error"}},
+	}
+}
+
+// NotEntitled is the 403 Cloudflare answers to an API the zone's plan does
+// not include, e.g. Logpull below Enterprise.
+func NotEntitled() Fault {
+	return Fault{Status: http.StatusForbidden, Errors: []cloudflare.ResponseError{notEntitled}}
+}
+
+var notEntitled = cloudflare.ResponseError{Code: 1004, Message: "zone is not entitled to this feature"}
+
+// Log is a Logpull log line, received by Cloudflare at Time.
+type Log struct {
+	Time time.Time
+	Line string
+}
+
+type zone struct {
+	plan         string // Cloudflare plan legacy ID
+	logs         []Log
+	events       []cloudflare.FirewallEvent
+	retentionOff bool
+	instantOff   bool
+	instant      chan string
+	streams      map[*stream]struct{}
+}
+
+type stream struct {
+	quit chan struct{}
+	once sync.Once
+}
+
+func (s *stream) stop() { s.once.Do(func() { close(s.quit) }) }
+
+// Server is a fake Cloudflare API. Its zones, faults and settings may be
+// changed while it serves.
+type Server struct {
+	// URL is the base URL of a server started by NewServer.
+	URL string
+	// Token, when set, is the only API token accepted.
+	Token string
+	// GzipLogs makes Logpull compress its responses, as Cloudflare does for
+	// clients accepting gzip.
+	GzipLogs bool
+
+	ts       *httptest.Server
+	mux      *http.ServeMux
+	upgrader websocket.Upgrader
+
+	mu       sync.Mutex
+	zones    map[string]*zone
+	faults   map[Endpoint][]*Fault
+	calls    map[Endpoint]int
+	sessions int
+}
+
+// New returns a fake that is not listening; serve it with ServeHTTP, e.g.
+// from http.ListenAndServe.
+func New() *Server {
+	s := &Server{
+		zones:  make(map[string]*zone),
+		faults: make(map[Endpoint][]*Fault),
+		calls:  make(map[Endpoint]int),
+	}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("GET /zones/{zone}", s.handle(Zone, s.serveZone))
+	s.mux.HandleFunc("GET /zones/{zone}/logs/received", s.handle(Logpull, s.serveLogpull))
+	s.mux.HandleFunc("GET /zones/{zone}/logs/control/retention/flag", s.handle(RetentionFlag, s.serveRetentionFlag))
+	s.mux.HandleFunc("GET /zones/{zone}/logs/rayids/{ray}", s.handle(RayLookup, s.serveRayLookup))
+	s.mux.HandleFunc("POST /graphql", s.handle(GraphQL, s.serveGraphQL))
+	s.mux.HandleFunc("GET /zones/{zone}/logpush/edge/jobs", s.handle(InstantLogsJobs, s.serveInstantLogsJobs))
+	s.mux.HandleFunc("POST /zones/{zone}/logpush/edge/jobs", s.handle(InstantLogsJob, s.serveInstantLogsJob))
+	s.mux.HandleFunc("GET /instant/{zone}/{session}", s.handle(InstantLogsStream, s.serveInstantLogsStream))
+	return s
+}
+
+// NewServer starts a fake on a local port. The caller must Close it.
+func NewServer() *Server {
+	s := New()
+	s.ts = httptest.NewServer(s)
+	s.URL = s.ts.URL
+	return s
+}
+
+// Close drops every Instant Logs stream and stops a server started by
+// NewServer.
+func (s *Server) Close() {
+	s.mu.Lock()
+	for _, z := range s.zones {
+		for st := range z.streams {
+			st.stop()
+		}
+	}
+	s.mu.Unlock()
+	if s.ts != nil {
+		s.ts.Close()
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// zone returns the zone with id, creating it. The caller holds s.mu.
+func (s *Server) zone(id string) *zone {
+	z, ok := s.zones[id]
+	if !ok {
+		z = &zone{plan: "enterprise", instant: make(chan string, 1024), streams: make(map[*stream]struct{})}
+		s.zones[id] = z
+	}
+	return z
+}
+
+// AddZone makes a zone known without data. Requests for unknown zones fail
+// the way Cloudflare fails them for a wrong zone ID.
+func (s *Server) AddZone(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zone(id)
+}
+
+// AddLogs adds Logpull log lines to a zone.
+func (s *Server) AddLogs(zoneID string, logs ...Log) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	z := s.zone(zoneID)
+	z.logs = append(z.logs, logs...)
+	sort.SliceStable(z.logs, func(i, j int) bool { return z.logs[i].Time.Before(z.logs[j].Time) })
+}
+
+// AddSecurityEvents adds firewall events to a zone's GraphQL dataset.
+func (s *Server) AddSecurityEvents(zoneID string, events ...cloudflare.FirewallEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	z := s.zone(zoneID)
+	z.events = append(z.events, events...)
+	sort.SliceStable(z.events, func(i, j int) bool {
+		a, b := z.events[i], z.events[j]
+		if !a.Datetime.Equal(b.Datetime) {
+			return a.Datetime.Before(b.Datetime)
+		}
+		return a.RayName < b.RayName
+	})
+}
+
+// SetRetention turns a zone's log retention flag on or off. Logpull refuses
+// zones with retention off. It is on by default.
+func (s *Server) SetRetention(zoneID string, on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zone(zoneID).retentionOff = !on
+}
+
+// SetPlan sets the Cloudflare plan a zone reports, by legacy ID ("free",
+// "pro", "business", "enterprise"). It is "enterprise" by default.
+func (s *Server) SetPlan(zoneID, legacyID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zone(zoneID).plan = legacyID
+}
+
+// SetInstantLogs turns a zone's Instant Logs entitlement on or off. Zones
+// without it are refused Instant Logs jobs. It is on by default.
+func (s *Server) SetInstantLogs(zoneID string, on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zone(zoneID).instantOff = !on
+}
+
+// SendInstantLog queues a line for the zone's Instant Logs stream. Lines are
+// delivered once a stream is connected, each to one stream.
+func (s *Server) SendInstantLog(zoneID, line string) {
+	s.mu.Lock()
+	ch := s.zone(zoneID).instant
+	s.mu.Unlock()
+	ch <- line
+}
+
+// DisconnectInstantLogs drops the zone's open Instant Logs streams without a
+// close handshake, like a lost connection.
+func (s *Server) DisconnectInstantLogs(zoneID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for st := range s.zone(zoneID).streams {
+		st.stop()
+	}
+}
+
+// Inject makes the next requests to e fail with f. Faults queue up in the
+// order injected.
+func (s *Server) Inject(e Endpoint, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f.Times <= 0 {
+		f.Times = 1
+	}
+	s.faults[e] = append(s.faults[e], &f)
+}
+
+// Calls returns how many requests e received, failed ones included.
+func (s *Server) Calls(e Endpoint) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[e]
+}
+
+// handle counts requests to e, checks the token and the zone, and answers
+// injected faults before calling next.
+func (s *Server) handle(e Endpoint, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.calls[e]++
+		var fault *Fault
+		if q := s.faults[e]; len(q) > 0 {
+			f := *q[0]
+			fault = &f
+			if q[0].Times--; q[0].Times == 0 {
+				s.faults[e] = q[1:]
+			}
+		}
+		_, known := s.zones[r.PathValue("zone")]
+		token := s.Token
+		s.mu.Unlock()
+
+		switch {
+		case token != "" && e != InstantLogsStream && r.Header.Get("Authorization") != "Bearer "+token:
+			writeErrors(w, http.StatusBadRequest, cloudflare.ResponseError{Code: 1000, Message: "Invalid API Token"})
+		case fault != nil:
+			writeFault(w, e, fault)
+		case r.PathValue("zone") != "" && !known:
+			writeErrors(w, http.StatusBadRequest, cloudflare.ResponseError{
+				Code:    7003,
+				Message: fmt.Sprintf("Could not route to %s, perhaps your object identifier is invalid?", r.URL.Path),
+			})
+		default:
+			next(w, r)
+		}
+	}
+}
+
+func writeFault(w http.ResponseWriter, e Endpoint, f *Fault) {
+	if f.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(f.RetryAfter.Seconds()))))
+	}
+	if e == GraphQL && f.Status == http.StatusOK {
+		errs := make([]map[string]string, len(f.Errors))
+		for i, re := range f.Errors {
+			errs[i] = map[string]string{"message": re.Message}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": nil, "errors": errs})
+		return
+	}
+	writeErrors(w, f.Status, f.Errors...)
+}
+
+func writeErrors(w http.ResponseWriter, status int, errs ...cloudflare.ResponseError) {
+	if errs == nil {
+		errs = []cloudflare.ResponseError{}
+	}
+	writeJSON(w, status, map[string]any{"success": false, "errors": errs, "messages": []any{}, "result": nil})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// serveLogpull answers with the zone's lines received in [start, end), as
+// NDJSON reduced to the requested fields.
+func (s *Server) serveLogpull(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, err1 := time.Parse(time.RFC3339, q.Get("start"))
+	end, err2 := time.Parse(time.RFC3339, q.Get("end"))
+	if err1 != nil || err2 != nil || !start.Before(end) || end.Sub(start) > cloudflare.MaxPullWindow {
+		writeErrors(w, http.StatusBadRequest, cloudflare.ResponseError{Code: 1002, Message: "bad query: invalid time range"})
+		return
+	}
+
+	s.mu.Lock()
+	z := s.zones[r.PathValue("zone")]
+	if z.retentionOff {
+		s.mu.Unlock()
+		writeErrors(w, http.StatusBadRequest, cloudflare.ResponseError{Code: 1002, Message: "log retention is not enabled for this zone"})
+		return
+	}
+	var lines []string
+	for _, l := range z.logs {
+		if !l.Time.Before(start) && l.Time.Before(end) {
+			lines = append(lines, l.Line)
+		}
+	}
+	s.mu.Unlock()
+
+	var fields []string
+	if f := q.Get("fields"); f != "" {
+		fields = strings.Split(f, ",")
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	var gz *gzip.Writer
+	if s.GzipLogs && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz = gzip.NewWriter(w)
+		defer gz.Close()
+	}
+	for _, line := range lines {
+		b := []byte(line)
+		if fields != nil {
+			b = project(b, fields)
+		}
+		b = append(b, '\n')
+		if gz != nil {
+			_, _ = gz.Write(b)
+		} else {
+			_, _ = w.Write(b)
+		}
+	}
+}
+
+// serveRayLookup answers with the zone's line whose RayID is the requested
+// one, reduced to the requested fields, or with an empty body when the zone
+// has none within Logpull's retention.
+func (s *Server) serveRayLookup(w http.ResponseWriter, r *http.Request) {
+	ray := r.PathValue("ray")
+	since := time.Now().Add(-cloudflare.LogRetention)
+
+	s.mu.Lock()
+	z := s.zones[r.PathValue("zone")]
+	if z.retentionOff {
+		s.mu.Unlock()
+		writeErrors(w, http.StatusBadRequest, cloudflare.ResponseError{Code: 1002, Message: "log retention is not enabled for this zone"})
+		return
+	}
+	var found []byte
+	for _, l := range z.logs {
+		var v struct {
+			RayID string `json:"RayID"`
+		}
+		if l.Time.After(since) && json.Unmarshal([]byte(l.Line), &v) == nil && strings.EqualFold(v.RayID, ray) {
+			found = []byte(l.Line)
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if found == nil {
+		return
+	}
+	if f := r.URL.Query().Get("fields"); f != "" {
+		found = project(found, strings.Split(f, ","))
+	}
+	_, _ = w.Write(append(found, '\n'))
+}
+
+// project keeps only fields of a JSON object line; lines that aren't objects
+// are returned unchanged.
+func project(line []byte, fields []string) []byte {
+	var obj map[string]json.RawMessage
+	if json.Unmarshal(line, &obj) != nil {
+		return line
+	}
+	kept := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		if v, ok := obj[f]; ok {
+			kept[f] = v
+		}
+	}
+	b, err := json.Marshal(kept)
+	if err != nil {
+		return line
+	}
+	return b
+}
+
+func (s *Server) serveRetentionFlag(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	on := !s.zones[r.PathValue("zone")].retentionOff
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"success": true, "errors": []any{}, "result": map[string]bool{"flag": on}})
+}
+
+// serveGraphQL answers viewer.zones queries with the zone's firewall events,
+// honouring the datetime range, the (datetime, rayName) cursor and the limit
+// sent by GraphQLClient. Unknown zones are answered with no zones, as
+// Cloudflare does.
+func (s *Server) serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Variables struct {
+			ZoneTag string `json:"zoneTag"`
+			Filter  struct {
+				Geq string `json:"datetime_geq"`
+				Lt  string `json:"datetime_lt"`
+				OR  []struct {
+					At      string `json:"datetime"`
+					RayName string `json:"rayName_gt"`
+				} `json:"OR"`
+			} `json:"filter"`
+			Limit int `json:"limit"`
+		} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusOK, map[string]any{"data": nil, "errors": []map[string]string{{"message": "failed to parse request: " + err.Error()}}})
+		return
+	}
+	v := req.Variables
+	geq, _ := time.Parse(time.RFC3339, v.Filter.Geq)
+	lt, _ := time.Parse(time.RFC3339, v.Filter.Lt)
+	var cursorAt time.Time
+	var cursorRay string
+	for _, or := range v.Filter.OR {
+		if or.At != "" {
+			cursorAt, _ = time.Parse(time.RFC3339, or.At)
+			cursorRay = or.RayName
+		}
+	}
+
+	s.mu.Lock()
+	z, ok := s.zones[v.ZoneTag]
+	page := []cloudflare.FirewallEvent{}
+	if ok {
+		for _, e := range z.events {
+			if e.Datetime.Before(geq) || !e.Datetime.Before(lt) {
+				continue
+			}
+			if !cursorAt.IsZero() && !e.Datetime.After(cursorAt) && !(e.Datetime.Equal(cursorAt) && e.RayName > cursorRay) {
+				continue
+			}
+			if v.Limit > 0 && len(page) == v.Limit {
+				break
+			}
+			page = append(page, e)
+		}
+	}
+	s.mu.Unlock()
+
+	zones := []map[string]any{}
+	if ok {
+		zones = append(zones, map[string]any{"zoneTag": v.ZoneTag, "firewallEventsAdaptive": page})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"viewer": map[string]any{"zones": zones}}, "errors": nil})
+}
+
+// serveZone answers with the zone and its plan.
+func (s *Server) serveZone(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("zone")
+	s.mu.Lock()
+	plan := s.zones[id].plan
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"success": true,
+		"errors":  []any{},
+		"result": map[string]any{
+			"id":     id,
+			"name":   id,
+			"status": "active",
+			"plan":   map[string]string{"name": plan, "legacy_id": plan},
+		},
+	})
+}
+
+// serveInstantLogsJobs lists no Instant Logs jobs, or refuses zones without
+// the entitlement.
+func (s *Server) serveInstantLogsJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	off := s.zones[r.PathValue("zone")].instantOff
+	s.mu.Unlock()
+	if off {
+		writeErrors(w, http.StatusForbidden, notEntitled)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"success": true, "errors": []any{}, "result": []any{}})
+}
+
+// serveInstantLogsJob creates an Instant Logs job whose destination is this
+// server's WebSocket for the zone.
+func (s *Server) serveInstantLogsJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if s.zones[r.PathValue("zone")].instantOff {
+		s.mu.Unlock()
+		writeErrors(w, http.StatusForbidden, notEntitled)
+		return
+	}
+	s.sessions++
+	id := s.sessions
+	s.mu.Unlock()
+
+	scheme := "ws"
+	if r.TLS != nil {
+		scheme = "wss"
+	}
+	dest := fmt.Sprintf("%s://%s/instant/%s/%d", scheme, r.Host, r.PathValue("zone"), id)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"success": true,
+		"errors":  []any{},
+		"result":  map[string]any{"id": id, "destination_conf": dest, "session_id": strconv.Itoa(id)},
+	})
+}
+
+// serveInstantLogsStream sends the zone's queued lines, one message each,
+// until the client leaves or the stream is dropped.
+func (s *Server) serveInstantLogsStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	st := &stream{quit: make(chan struct{})}
+	s.mu.Lock()
+	z := s.zones[r.PathValue("zone")]
+	z.streams[st] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(z.streams, st)
+		s.mu.Unlock()
+	}()
+
+	// The client sends nothing; reading notices it leaving.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-st.quit:
+			return
+		case <-gone:
+			return
+		case line := <-z.instant:
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(line)); err != nil {
+				// Put the line back for the next stream.
+				z.instant <- line
+				return
+			}
+		}
+	}
+}
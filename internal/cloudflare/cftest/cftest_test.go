@@ -0,0 +1,228 @@
+package cftest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare/cftest"
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+)
+
+func TestLogpull(t *testing.T) {
+	cf := cftest.NewServer()
+	defer cf.Close()
+	cf.GzipLogs = true
+
+	to := time.Now().UTC().Add(-10 * time.Minute).Truncate(time.Minute)
+	from := to.Add(-time.Hour)
+	cf.AddLogs("zone1",
+		cftest.Log{Time: from.Add(-time.Second), Line: `{"RayID":"before"}`},
+		cftest.Log{Time: from, Line: `{"RayID":"a","ClientIP":"192.0.2.1"}`},
+		cftest.Log{Time: to.Add(-time.Second), Line: `{"RayID":"b","ClientIP":"192.0.2.2"}`},
+		cftest.Log{Time: to, Line: `{"RayID":"after"}`},
+	)
+
+	client := cloudflare.NewClient(config.CloudflareConfig{BaseURL: cf.URL}, "zone1", "token")
+	got, err := client.PullLogs(context.Background(), from, to, []string{"RayID"})
+	if err != nil {
+		t.Fatalf("PullLogs: %v", err)
+	}
+	if want := "{\"RayID\":\"a\"}\n{\"RayID\":\"b\"}\n"; string(got) != want {
+		t.Errorf("PullLogs = %q, want %q", got, want)
+	}
+
+	ray, err := client.GetRay(context.Background(), "b", []string{"ClientIP"})
+	if err != nil || string(ray) != `{"ClientIP":"192.0.2.2"}` {
+		t.Errorf("GetRay = %s, %v; want the ClientIP of b", ray, err)
+	}
+	if ray, err := client.GetRay(context.Background(), "missing", nil); err != nil || ray != nil {
+		t.Errorf("GetRay of an unknown ray = %s, %v; want nil", ray, err)
+	}
+
+	enabled, err := client.LogpullEnabled(context.Background())
+	if err != nil || !enabled {
+		t.Errorf("LogpullEnabled = %v, %v; want true", enabled, err)
+	}
+	cf.SetRetention("zone1", false)
+	if _, err := client.PullLogs(context.Background(), from, to, nil); !errors.Is(err, cloudflare.ErrRetentionDisabled) {
+		t.Errorf("PullLogs with retention off: err = %v, want ErrRetentionDisabled", err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	cf := cftest.NewServer()
+	defer cf.Close()
+	cf.AddZone("zone1")
+	cf.Inject(cftest.Logpull, cftest.RateLimited(7*time.Second))
+	cf.Inject(cftest.Logpull, cftest.Forbidden())
+
+	to := time.Now().UTC().Add(-10 * time.Minute)
+	from := to.Add(-time.Hour)
+	client := cloudflare.NewClient(config.CloudflareConfig{BaseURL: cf.URL}, "zone1", "token")
+
+	_, err := client.PullLogs(context.Background(), from, to, nil)
+	var rlErr *cloudflare.RateLimitError
+	if !errors.As(err, &rlErr) || rlErr.RetryAfter != 7*time.Second {
+		t.Errorf("first pull: err = %v, want RateLimitError after 7s", err)
+	}
+	if _, err := client.PullLogs(context.Background(), from, to, nil); !errors.Is(err, cloudflare.ErrMissingPermission) {
+		t.Errorf("second pull: err = %v, want ErrMissingPermission", err)
+	}
+	if _, err := client.PullLogs(context.Background(), from, to, nil); err != nil {
+		t.Errorf("third pull: %v", err)
+	}
+	if n := cf.Calls(cftest.Logpull); n != 3 {
+		t.Errorf("Calls = %d, want 3", n)
+	}
+
+	other := cloudflare.NewClient(config.CloudflareConfig{BaseURL: cf.URL}, "unknown", "token")
+	if _, err := other.PullLogs(context.Background(), from, to, nil); !errors.Is(err, cloudflare.ErrZoneNotFound) {
+		t.Errorf("unknown zone: err = %v, want ErrZoneNotFound", err)
+	}
+}
+
+func TestPlanProbes(t *testing.T) {
+	cf := cftest.NewServer()
+	defer cf.Close()
+	cf.AddZone("zone1")
+	cf.SetPlan("zone1", "business")
+	cf.SetInstantLogs("zone1", false)
+
+	client := cloudflare.NewClient(config.CloudflareConfig{BaseURL: cf.URL}, "zone1", "token")
+	z, err := client.Zone(context.Background())
+	if err != nil || z.ID != "zone1" || z.Plan.LegacyID != "business" {
+		t.Errorf("Zone = %+v, %v; want zone1 on business", z, err)
+	}
+
+	instant := cloudflare.NewInstantLogsClient(config.CloudflareConfig{BaseURL: cf.URL}, "token", "zone1")
+	if ok, err := instant.Available(context.Background()); err != nil || ok {
+		t.Errorf("Available without the entitlement = %v, %v; want false", ok, err)
+	}
+	if _, err := instant.StartSession(context.Background(), cloudflare.InstantLogsOptions{}); !errors.Is(err, cloudflare.ErrNotEntitled) {
+		t.Errorf("StartSession without the entitlement: err = %v, want ErrNotEntitled", err)
+	}
+	cf.SetInstantLogs("zone1", true)
+	if ok, err := instant.Available(context.Background()); err != nil || !ok {
+		t.Errorf("Available = %v, %v; want true", ok, err)
+	}
+	cf.Inject(cftest.InstantLogsJobs, cftest.Forbidden())
+	if _, err := instant.Available(context.Background()); !errors.Is(err, cloudflare.ErrMissingPermission) {
+		t.Errorf("Available with a token lacking permission: err = %v, want ErrMissingPermission", err)
+	}
+
+	cf.Inject(cftest.RetentionFlag, cftest.NotEntitled())
+	if ok, err := client.LogpullEnabled(context.Background()); err != nil || ok {
+		t.Errorf("LogpullEnabled without the entitlement = %v, %v; want false", ok, err)
+	}
+}
+
+func TestToken(t *testing.T) {
+	cf := cftest.NewServer()
+	defer cf.Close()
+	cf.Token = "good"
+	cf.AddZone("zone1")
+
+	to := time.Now().UTC().Add(-10 * time.Minute)
+	client := cloudflare.NewClient(config.CloudflareConfig{BaseURL: cf.URL}, "zone1", "bad")
+	if _, err := client.PullLogs(context.Background(), to.Add(-time.Hour), to, nil); !errors.Is(err, cloudflare.ErrInvalidToken) {
+		t.Errorf("err = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestGraphQLPaging(t *testing.T) {
+	cf := cftest.NewServer()
+	defer cf.Close()
+
+	start := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Second)
+	end := start.Add(time.Hour)
+	const n = 2500
+	for i := range n {
+		// Pairs share a timestamp, so paging has to use the rayName cursor.
+		cf.AddSecurityEvents("zone1", cloudflare.FirewallEvent{
+			Datetime: start.Add(time.Duration(i/2) * time.Second),
+			RayName:  fmt.Sprintf("ray%05d", i),
+			Action:   "block",
+		})
+	}
+	cf.AddSecurityEvents("zone1", cloudflare.FirewallEvent{Datetime: end, RayName: "outside"})
+
+	client := cloudflare.NewGraphQLClient(config.CloudflareConfig{BaseURL: cf.URL}, "token")
+	events, _, err := client.GetSecurityEvents(context.Background(), "zone1", start, end, 0)
+	if err != nil {
+		t.Fatalf("GetSecurityEvents: %v", err)
+	}
+	if len(events) != n {
+		t.Fatalf("got %d events, want %d", len(events), n)
+	}
+	for i, e := range events {
+		if want := fmt.Sprintf("ray%05d", i); e.RayName != want {
+			t.Fatalf("event %d = %s, want %s", i, e.RayName, want)
+		}
+	}
+	if c := cf.Calls(cftest.GraphQL); c != 3 {
+		t.Errorf("Calls = %d, want 3 pages", c)
+	}
+
+	cf.Inject(cftest.GraphQL, cftest.Fault{
+		Status: 200,
+		Errors: []cloudflare.ResponseError{{Message: "zone does not have access to the path"}},
+	})
+	if _, _, err := client.GetSecurityEvents(context.Background(), "zone1", start, end, 0); !errors.Is(err, cloudflare.ErrNotEntitled) {
+		t.Errorf("err = %v, want ErrNotEntitled", err)
+	}
+}
+
+func TestInstantLogs(t *testing.T) {
+	cf := cftest.NewServer()
+	defer cf.Close()
+	cf.AddZone("zone1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := cloudflare.NewInstantLogsClient(config.CloudflareConfig{BaseURL: cf.URL}, "token", "zone1")
+	wsURL, err := client.StartSession(ctx, cloudflare.InstantLogsOptions{})
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	if !strings.HasPrefix(wsURL, "ws://") {
+		t.Fatalf("destination = %q, want a ws:// URL", wsURL)
+	}
+	msgs, err := client.Stream(ctx, wsURL)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+
+	cf.SendInstantLog("zone1", `{"RayID":"a"}`)
+	cf.SendInstantLog("zone1", `{"RayID":"b"}`)
+	for _, want := range []string{`{"RayID":"a"}`, `{"RayID":"b"}`} {
+		select {
+		case got := <-msgs:
+			if string(got) != want {
+				t.Errorf("message = %s, want %s", got, want)
+			}
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for message")
+		}
+	}
+
+	cf.DisconnectInstantLogs("zone1")
+	select {
+	case _, ok := <-msgs:
+		if ok {
+			t.Error("got a message after disconnect")
+		}
+	case <-ctx.Done():
+		t.Fatal("stream not closed after disconnect")
+	}
+
+	cf.Inject(cftest.InstantLogsJob, cftest.RateLimited(time.Second))
+	if _, err := client.StartSession(ctx, cloudflare.InstantLogsOptions{}); !errors.Is(err, cloudflare.ErrRateLimited) {
+		t.Errorf("StartSession: err = %v, want ErrRateLimited", err)
+	}
+}
@@ -9,26 +9,110 @@
 package cloudflare
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/fabriziosalmi/rainlogs/internal/config"
 )
 
-// ErrRateLimited is returned when Cloudflare responds with HTTP 429.
-// Workers should treat it as a retriable/transient error.
+// ErrRateLimited is matched by the RateLimitError returned when Cloudflare
+// responds with HTTP 429. Workers should treat it as a retriable/transient
+// error.
 var ErrRateLimited = errors.New("cloudflare: rate limited")
 
 const defaultBaseURL = "https://api.cloudflare.com/client/v4"
 
+const (
+	// MaxPullWindow is the longest [from, to) range accepted by a single Logpull request.
+	MaxPullWindow = time.Hour
+	// LogAvailabilityDelay is the minimum age of a window end before Logpull serves it.
+	LogAvailabilityDelay = time.Minute
+	// LogRetention is how far back Logpull still holds logs.
+	LogRetention = 7 * 24 * time.Hour
+)
+
+// PullRetention returns how far back logs of logType can still be pulled:
+// LogRetention for Logpull ("logs"), SecurityEventsRetention for GraphQL
+// security events ("security"), and zero for log types that cannot be pulled
+// again (Instant Logs).
+func PullRetention(logType string) time.Duration {
+	switch logType {
+	case "logs":
+		return LogRetention
+	case "security":
+		return SecurityEventsRetention
+	default:
+		return 0
+	}
+}
+
+// Window is a half-open [Start, End) time range.
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// SplitWindow cuts [from, to) into consecutive sub-windows no longer than size.
+// A size <= 0 or above MaxPullWindow is clamped to MaxPullWindow. Returns nil
+// when the range is empty.
+func SplitWindow(from, to time.Time, size time.Duration) []Window {
+	if size <= 0 || size > MaxPullWindow {
+		size = MaxPullWindow
+	}
+	var out []Window
+	for start := from; start.Before(to); start = start.Add(size) {
+		end := start.Add(size)
+		if end.After(to) {
+			end = to
+		}
+		out = append(out, Window{Start: start, End: end})
+	}
+	return out
+}
+
+// AlignedWindows splits [from, to) at multiples of size (clamped like
+// SplitWindow), so windows cut from overlapping ranges line up. Only the first
+// and last window may be shorter than size.
+func AlignedWindows(from, to time.Time, size time.Duration) []Window {
+	if size <= 0 || size > MaxPullWindow {
+		size = MaxPullWindow
+	}
+	var out []Window
+	for start := from; start.Before(to); {
+		end := start.Truncate(size).Add(size)
+		if end.After(to) {
+			end = to
+		}
+		out = append(out, Window{Start: start, End: end})
+		start = end
+	}
+	return out
+}
+
+// newHTTPClient returns the HTTP client Cloudflare clients share. timeout
+// bounds connecting and waiting for the response headers but not reading the
+// body: a Logpull window can take far longer to stream than to start, so
+// bodies are bounded by the caller's context instead.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if timeout > 0 {
+		t.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+		t.TLSHandshakeTimeout = timeout
+		t.ResponseHeaderTimeout = timeout
+	}
+	return &http.Client{Transport: t}
+}
+
 // Client is a Cloudflare Logpull API client for a single zone.
 type Client struct {
 	baseURL    string
@@ -45,19 +129,36 @@ func NewClient(cfg config.CloudflareConfig, zoneID, apiKey string) *Client {
 	}
 	return &Client{
 		baseURL:    base,
-		httpClient: &http.Client{Timeout: cfg.RequestTimeout},
+		httpClient: newHTTPClient(cfg.RequestTimeout),
 		zoneID:     zoneID,
 		apiKey:     apiKey,
 	}
 }
 
 // PullLogs fetches NDJSON log lines for [from, to) (max 1-hour window).
-// Returns raw decompressed NDJSON bytes.
+// Returns raw decompressed NDJSON bytes. Prefer StreamLogs for large zones.
 func (c *Client) PullLogs(ctx context.Context, from, to time.Time, fields []string) ([]byte, error) {
-	if to.Sub(from) > time.Hour {
+	body, err := c.StreamLogs(ctx, from, to, fields)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: read body: %w", err)
+	}
+	return data, nil
+}
+
+// StreamLogs requests NDJSON log lines for [from, to) (max 1-hour window) and
+// returns the decompressed response body without buffering it. The caller
+// must close the returned reader.
+func (c *Client) StreamLogs(ctx context.Context, from, to time.Time, fields []string) (io.ReadCloser, error) {
+	if to.Sub(from) > MaxPullWindow {
 		return nil, fmt.Errorf("cloudflare: window exceeds 1 hour")
 	}
-	if time.Since(to) < time.Minute {
+	if time.Since(to) < LogAvailabilityDelay {
 		return nil, fmt.Errorf("cloudflare: logs not yet available (min 1-min delay)")
 	}
 
@@ -85,37 +186,160 @@ func (c *Client) PullLogs(ctx context.Context, from, to time.Time, fields []stri
 	if err != nil {
 		return nil, fmt.Errorf("cloudflare: do request: %w", err)
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusTooManyRequests {
-		retryAfterStr := resp.Header.Get("Retry-After")
-		delay := 30 * time.Second
-		if val, err := strconv.Atoi(retryAfterStr); err == nil {
-			delay = time.Duration(val) * time.Second
-		}
-		return nil, &RateLimitError{
-			Message:    "Cloudflare 429",
-			RetryAfter: delay,
-		}
+		resp.Body.Close()
+		return nil, rateLimitError(resp)
 	}
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return nil, fmt.Errorf("cloudflare: HTTP %d: %s", resp.StatusCode, body)
+		defer resp.Body.Close()
+		return nil, apiError("", resp)
 	}
 
-	var reader io.Reader = resp.Body
 	if resp.Header.Get("Content-Encoding") == "gzip" {
 		gr, gzErr := gzip.NewReader(resp.Body)
 		if gzErr != nil {
+			resp.Body.Close()
 			return nil, fmt.Errorf("cloudflare: gzip reader: %w", gzErr)
 		}
-		defer gr.Close()
-		reader = gr
+		return &gzipBody{Reader: gr, body: resp.Body}, nil
 	}
+	return resp.Body, nil
+}
 
-	data, err := io.ReadAll(reader)
+// GetRay returns the log record of the request with rayID, with fields (or
+// Cloudflare's default set), or nil when Logpull holds none, e.g. once it is
+// past LogRetention.
+func (c *Client) GetRay(ctx context.Context, rayID string, fields []string) ([]byte, error) {
+	u, err := url.Parse(fmt.Sprintf("%s/zones/%s/logs/rayids/%s", c.baseURL, c.zoneID, url.PathEscape(rayID)))
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: parse url: %w", err)
+	}
+	q := u.Query()
+	q.Set("timestamps", "rfc3339")
+	if len(fields) > 0 {
+		q.Set("fields", strings.Join(fields, ","))
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: new request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	case http.StatusTooManyRequests:
+		return nil, rateLimitError(resp)
+	default:
+		return nil, apiError("ray lookup", resp)
+	}
+
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("cloudflare: read body: %w", err)
 	}
-	return data, nil
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || string(body) == "{}" || string(body) == "null" {
+		return nil, nil
+	}
+	return body, nil
+}
+
+// LogpullEnabled reports whether Logpull is available for the zone: its log
+// retention flag is on. Zones without the entitlement (anything below
+// Enterprise) are reported as false; any other failure, a token lacking
+// Logs:Read included, is an error, as it says nothing about the plan.
+func (c *Client) LogpullEnabled(ctx context.Context) (bool, error) {
+	u := fmt.Sprintf("%s/zones/%s/logs/control/retention/flag", c.baseURL, c.zoneID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return false, fmt.Errorf("cloudflare: new request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("cloudflare: do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusTooManyRequests:
+		return false, rateLimitError(resp)
+	default:
+		if apiErr := apiError("retention flag", resp); !errors.Is(apiErr, ErrNotEntitled) {
+			return false, apiErr
+		}
+		return false, nil
+	}
+
+	var out struct {
+		Result struct {
+			Flag bool `json:"flag"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, fmt.Errorf("cloudflare: decode retention flag: %w", err)
+	}
+	return out.Result.Flag, nil
+}
+
+// Zone returns the zone as the zones API reports it, with its plan.
+func (c *Client) Zone(ctx context.Context) (*AccountZone, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/zones/%s", c.baseURL, c.zoneID), http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: new request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, rateLimitError(resp)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError("get zone", resp)
+	}
+
+	var out struct {
+		Success bool            `json:"success"`
+		Result  AccountZone     `json:"result"`
+		Errors  []ResponseError `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("cloudflare: decode zone: %w", err)
+	}
+	if !out.Success {
+		return nil, responseError("get zone", out.Errors)
+	}
+	return &out.Result, nil
+}
+
+// gzipBody closes both the gzip reader and the underlying response body.
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (g *gzipBody) Close() error {
+	gzErr := g.Reader.Close()
+	if err := g.body.Close(); err != nil {
+		return err
+	}
+	return gzErr
 }
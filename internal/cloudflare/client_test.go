@@ -0,0 +1,143 @@
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+)
+
+func TestSplitWindow(t *testing.T) {
+	from := time.Date(2026, 1, 1, 10, 15, 0, 0, time.UTC)
+	to := from.Add(3*time.Hour + 20*time.Minute)
+
+	windows := SplitWindow(from, to, 0)
+	if len(windows) != 4 {
+		t.Fatalf("expected 4 windows, got %d", len(windows))
+	}
+	if !windows[0].Start.Equal(from) {
+		t.Errorf("first window starts at %v, want %v", windows[0].Start, from)
+	}
+	if !windows[3].End.Equal(to) {
+		t.Errorf("last window ends at %v, want %v", windows[3].End, to)
+	}
+	for i, w := range windows {
+		if w.End.Sub(w.Start) > MaxPullWindow {
+			t.Errorf("window %d exceeds %v: %v", i, MaxPullWindow, w.End.Sub(w.Start))
+		}
+		if i > 0 && !w.Start.Equal(windows[i-1].End) {
+			t.Errorf("window %d is not contiguous with window %d", i, i-1)
+		}
+	}
+}
+
+func TestSplitWindowClampsSize(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if got := len(SplitWindow(from, from.Add(2*time.Hour), 6*time.Hour)); got != 2 {
+		t.Errorf("oversized chunk not clamped: got %d windows, want 2", got)
+	}
+	if got := len(SplitWindow(from, from.Add(time.Hour), 15*time.Minute)); got != 4 {
+		t.Errorf("expected 4 quarter-hour windows, got %d", got)
+	}
+	if got := SplitWindow(from, from, time.Hour); got != nil {
+		t.Errorf("empty range should yield nil, got %v", got)
+	}
+}
+
+func TestAlignedWindows(t *testing.T) {
+	from := time.Date(2026, 1, 1, 10, 15, 0, 0, time.UTC)
+	to := time.Date(2026, 1, 1, 13, 20, 0, 0, time.UTC)
+
+	windows := AlignedWindows(from, to, time.Hour)
+	want := []Window{
+		{Start: from, End: time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{Start: time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC), End: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{Start: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC), End: time.Date(2026, 1, 1, 13, 0, 0, 0, time.UTC)},
+		{Start: time.Date(2026, 1, 1, 13, 0, 0, 0, time.UTC), End: to},
+	}
+	if len(windows) != len(want) {
+		t.Fatalf("got %d windows, want %d: %v", len(windows), len(want), windows)
+	}
+	for i := range want {
+		if !windows[i].Start.Equal(want[i].Start) || !windows[i].End.Equal(want[i].End) {
+			t.Errorf("window %d = %v, want %v", i, windows[i], want[i])
+		}
+	}
+}
+
+func TestLogpullEnabled(t *testing.T) {
+	for _, tc := range []struct {
+		status  int
+		body    string
+		want    bool
+		wantErr error
+	}{
+		{http.StatusOK, `{"success":true,"result":{"flag":true}}`, true, nil},
+		{http.StatusOK, `{"success":true,"result":{"flag":false}}`, false, nil},
+		{http.StatusForbidden, `{"success":false,"errors":[{"code":1004,"message":"This feature requires an Enterprise plan"}]}`, false, nil},
+		// A token without Logs:Read is not a plan signal.
+		{http.StatusForbidden, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`, false, ErrMissingPermission},
+		{http.StatusBadGateway, `bad gateway`, false, ErrServer},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/zones/zone-1/logs/control/retention/flag" {
+				t.Errorf("path = %q", r.URL.Path)
+			}
+			w.WriteHeader(tc.status)
+			fmt.Fprint(w, tc.body)
+		}))
+		got, err := NewClient(config.CloudflareConfig{BaseURL: srv.URL}, "zone-1", "tok").LogpullEnabled(context.Background())
+		srv.Close()
+		if tc.wantErr != nil {
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("HTTP %d %s: err = %v, want %v", tc.status, tc.body, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("HTTP %d: %v", tc.status, err)
+		}
+		if got != tc.want {
+			t.Errorf("HTTP %d %s: got %v, want %v", tc.status, tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestStreamLogsOutlastsRequestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// Headers come at once; the body takes several request timeouts.
+		w.WriteHeader(http.StatusOK)
+		for i := range 5 {
+			fmt.Fprintf(w, "{\"RayID\":\"%d\"}\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	client := NewClient(config.CloudflareConfig{BaseURL: srv.URL, RequestTimeout: 50 * time.Millisecond}, "zone-1", "tok")
+	to := time.Now().Add(-10 * time.Minute)
+	got, err := client.PullLogs(context.Background(), to.Add(-time.Hour), to, nil)
+	if err != nil {
+		t.Fatalf("PullLogs: %v", err)
+	}
+	if n := bytes.Count(got, []byte("\n")); n != 5 {
+		t.Errorf("got %d lines, want 5", n)
+	}
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	client = NewClient(config.CloudflareConfig{BaseURL: slow.URL, RequestTimeout: 50 * time.Millisecond}, "zone-1", "tok")
+	if _, err := client.PullLogs(context.Background(), to.Add(-time.Hour), to, nil); err == nil {
+		t.Error("PullLogs succeeded although the headers came after the timeout")
+	}
+}
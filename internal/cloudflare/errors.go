@@ -1,8 +1,13 @@
 package cloudflare
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,22 +21,215 @@ func (e *RateLimitError) Error() string {
 	return fmt.Sprintf("rate limited: %s (retry after %v)", e.Message, e.RetryAfter)
 }
 
+// defaultRetryAfter is the back-off assumed when a 429 carries no usable
+// Retry-After.
+const defaultRetryAfter = 30 * time.Second
+
 // ParseRetryAfter parses the Retry-After header.
-// It supports both seconds (integer) and HTTP date format.
+// It supports both seconds (integer) and HTTP date format; a date in the past
+// means retry now.
 func ParseRetryAfter(header string) time.Duration {
+	header = strings.TrimSpace(header)
 	if header == "" {
-		return 30 * time.Second // Default backoff
+		return defaultRetryAfter
 	}
 
 	// Try parsing as integer seconds
 	if seconds, err := strconv.Atoi(header); err == nil {
-		return time.Duration(seconds) * time.Second
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+
+	// Try parsing as HTTP date (IMF-fixdate, RFC 850 or asctime)
+	if t, err := http.ParseTime(header); err == nil {
+		return max(time.Until(t), 0)
+	}
+
+	return defaultRetryAfter
+}
+
+// rateLimitError builds the RateLimitError for a 429 response.
+func rateLimitError(resp *http.Response) *RateLimitError {
+	return &RateLimitError{
+		Message:    "Cloudflare 429",
+		RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// Kinds of Cloudflare API failures. An *APIError matches its kind with
+// errors.Is; ErrInvalidToken (auth failure) and ErrRateLimited (see
+// RateLimitError) complete the set.
+var (
+	// ErrMissingPermission means the token is valid but lacks a permission
+	// the call needs.
+	ErrMissingPermission = errors.New("cloudflare: token lacks permission")
+	// ErrNotEntitled means the zone's plan does not include the API, e.g.
+	// Logpull below Enterprise.
+	ErrNotEntitled = errors.New("cloudflare: not entitled on the zone's plan")
+	// ErrZoneNotFound means Cloudflare does not know the zone ID.
+	ErrZoneNotFound = errors.New("cloudflare: zone not found")
+	// ErrRetentionDisabled means Logpull has no logs because the zone's log
+	// retention flag is off.
+	ErrRetentionDisabled = errors.New("cloudflare: log retention not enabled")
+	// ErrServer is a Cloudflare-side failure (HTTP 5xx).
+	ErrServer = errors.New("cloudflare: server error")
+)
+
+// Is makes a RateLimitError match ErrRateLimited.
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// ResponseError is an entry of the errors[] array of a Cloudflare API
+// response.
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// APIError is returned when Cloudflare answers with an unexpected HTTP status,
+// or with errors[] in an otherwise successful response. Kind classifies it and
+// is what errors.Is matches.
+type APIError struct {
+	Op         string // the failed call, e.g. "list zones"; empty for Logpull
+	StatusCode int
+	Errors     []ResponseError
+	Body       string // the start of the body, when it has no errors[]
+	Kind       error  // one of the Err* kinds, nil when unclassified
+}
+
+func (e *APIError) Error() string {
+	msg := e.Body
+	if len(e.Errors) > 0 {
+		parts := make([]string, len(e.Errors))
+		for i, re := range e.Errors {
+			parts[i] = fmt.Sprintf("%d %s", re.Code, re.Message)
+		}
+		msg = strings.Join(parts, "; ")
+	}
+	prefix := "cloudflare: "
+	if e.Op != "" {
+		prefix += e.Op + ": "
+	}
+	if e.StatusCode != http.StatusOK {
+		prefix += fmt.Sprintf("HTTP %d: ", e.StatusCode)
+	}
+	return prefix + msg
+}
+
+func (e *APIError) Unwrap() error { return e.Kind }
+
+// Permanent reports whether sending the same request again cannot succeed:
+// failures the customer has to fix, and client errors other than timeouts and
+// rate limits.
+func (e *APIError) Permanent() bool {
+	switch e.Kind {
+	case ErrInvalidToken, ErrMissingPermission, ErrNotEntitled, ErrZoneNotFound, ErrRetentionDisabled:
+		return true
+	case ErrServer:
+		return false
 	}
+	return e.StatusCode >= 400 && e.StatusCode < 500 &&
+		e.StatusCode != http.StatusRequestTimeout && e.StatusCode != http.StatusTooManyRequests
+}
+
+// errorCodeKinds maps Cloudflare v4 API error codes to kinds. Code 10000
+// ("Authentication error") is missing: it is sent both for unknown tokens
+// and for tokens lacking permissions, so its status decides.
+var errorCodeKinds = map[int]error{
+	1000: ErrInvalidToken,      // Invalid API Token
+	6003: ErrInvalidToken,      // Invalid request headers
+	6111: ErrInvalidToken,      // Invalid format for Authorization header
+	9103: ErrInvalidToken,      // Unknown X-Auth-Key or X-Auth-Email
+	9106: ErrInvalidToken,      // Missing authentication headers
+	9109: ErrMissingPermission, // Unauthorized to access requested resource
+	1001: ErrZoneNotFound,      // Invalid zone identifier
+	7003: ErrZoneNotFound,      // Could not route to /zones/..., invalid object identifier
+}
 
-	// Try parsing as HTTP date
-	if t, err := time.Parse(time.RFC1123, header); err == nil {
-		return time.Until(t)
+// classify returns the kind of a failure with status and errors[]. Codes
+// win, then messages Cloudflare words consistently, then the status.
+func classify(status int, errs []ResponseError) error {
+	for _, re := range errs {
+		if kind, ok := errorCodeKinds[re.Code]; ok {
+			return kind
+		}
+		msg := strings.ToLower(re.Message)
+		switch {
+		case strings.Contains(msg, "retention"):
+			return ErrRetentionDisabled
+		case strings.Contains(msg, "entitle"), strings.Contains(msg, "enterprise"), strings.Contains(msg, "not available on your plan"):
+			return ErrNotEntitled
+		}
 	}
+	switch {
+	case status == http.StatusUnauthorized:
+		return ErrInvalidToken
+	case status == http.StatusForbidden:
+		return ErrMissingPermission
+	case status >= 500:
+		return ErrServer
+	}
+	return nil
+}
 
-	return 30 * time.Second
+// apiError builds the APIError for resp, parsing errors[] from its body.
+func apiError(op string, resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var out struct {
+		Errors []ResponseError `json:"errors"`
+	}
+	e := &APIError{Op: op, StatusCode: resp.StatusCode}
+	if json.Unmarshal(body, &out) == nil && len(out.Errors) > 0 {
+		e.Errors = out.Errors
+	} else {
+		e.Body = string(body)
+	}
+	e.Kind = classify(resp.StatusCode, e.Errors)
+	return e
+}
+
+// responseError builds the APIError for a 200 response with success false.
+func responseError(op string, errs []ResponseError) *APIError {
+	e := &APIError{Op: op, StatusCode: http.StatusOK, Errors: errs, Kind: classify(http.StatusOK, errs)}
+	if len(errs) == 0 {
+		e.Body = "request failed"
+	}
+	return e
+}
+
+// graphQLError is an entry of the errors[] array of a GraphQL response.
+type graphQLError struct {
+	Message    string `json:"message"`
+	Extensions struct {
+		Code string `json:"code"`
+	} `json:"extensions"`
+}
+
+// graphQLAPIError builds the APIError for GraphQL errors[]. GraphQL answers
+// 200 and words authorization and entitlement failures in the message.
+func graphQLAPIError(op string, errs []graphQLError) *APIError {
+	e := &APIError{Op: op, StatusCode: http.StatusOK}
+	for _, ge := range errs {
+		e.Errors = append(e.Errors, ResponseError{Message: ge.Message})
+		msg := strings.ToLower(ge.Message)
+		switch {
+		case e.Kind != nil:
+		case ge.Extensions.Code == "authz", strings.Contains(msg, "authz"), strings.Contains(msg, "not authorized"), strings.Contains(msg, "permission"):
+			e.Kind = ErrMissingPermission
+		case strings.Contains(msg, "does not have access"):
+			e.Kind = ErrNotEntitled
+		}
+	}
+	return e
+}
+
+// IsPermanent reports whether err is a Cloudflare failure that retrying will
+// not fix: a rejected token, a failure the customer has to fix, or a client
+// error status.
+func IsPermanent(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Permanent()
+	}
+	return errors.Is(err, ErrInvalidToken)
 }
@@ -0,0 +1,138 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	cases := []struct {
+		header string
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"", defaultRetryAfter, defaultRetryAfter},
+		{"120", 2 * time.Minute, 2 * time.Minute},
+		{"-5", 0, 0},
+		{time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat), 85 * time.Second, 90 * time.Second},
+		{time.Now().Add(90 * time.Second).UTC().Format(time.RFC850), 85 * time.Second, 90 * time.Second},
+		{"Sun, 06 Nov 1994 08:49:37 GMT", 0, 0},
+		{"soon", defaultRetryAfter, defaultRetryAfter},
+	}
+	for _, tc := range cases {
+		if got := ParseRetryAfter(tc.header); got < tc.min || got > tc.max {
+			t.Errorf("ParseRetryAfter(%q) = %v, want within [%v, %v]", tc.header, got, tc.min, tc.max)
+		}
+	}
+}
+
+func TestStreamLogsRetryAfterDate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := NewClient(config.CloudflareConfig{BaseURL: srv.URL, RequestTimeout: 5 * time.Second}, "zone-1", "tok")
+	end := time.Now().Add(-time.Hour)
+	_, err := c.StreamLogs(context.Background(), end.Add(-time.Hour), end, nil)
+	var rlErr *RateLimitError
+	if !errors.As(err, &rlErr) {
+		t.Fatalf("StreamLogs: got %v, want RateLimitError", err)
+	}
+	if rlErr.RetryAfter < 55*time.Second || rlErr.RetryAfter > time.Minute {
+		t.Errorf("RetryAfter = %v, want about 1m", rlErr.RetryAfter)
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&APIError{StatusCode: http.StatusForbidden}, true},
+		{fmt.Errorf("pull logs: %w", &APIError{StatusCode: http.StatusBadRequest}), true},
+		{&APIError{StatusCode: http.StatusRequestTimeout}, false},
+		{&APIError{StatusCode: http.StatusTooManyRequests}, false},
+		{&APIError{StatusCode: http.StatusBadGateway}, false},
+		{&RateLimitError{RetryAfter: time.Second}, false},
+		{fmt.Errorf("verify: %w", ErrInvalidToken), true},
+		{&APIError{StatusCode: http.StatusOK, Kind: ErrNotEntitled}, true},
+		{&APIError{StatusCode: http.StatusOK}, false},
+		{errors.New("connection reset"), false},
+	}
+	for _, tc := range cases {
+		if got := IsPermanent(tc.err); got != tc.want {
+			t.Errorf("IsPermanent(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestAPIErrorKinds(t *testing.T) {
+	cases := []struct {
+		status int
+		body   string
+		want   error
+	}{
+		{http.StatusBadRequest, `{"success":false,"errors":[{"code":1000,"message":"Invalid API Token"}]}`, ErrInvalidToken},
+		{http.StatusUnauthorized, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`, ErrInvalidToken},
+		{http.StatusForbidden, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`, ErrMissingPermission},
+		{http.StatusForbidden, `{"success":false,"errors":[{"code":9109,"message":"Unauthorized to access requested resource"}]}`, ErrMissingPermission},
+		{http.StatusForbidden, `{"success":false,"errors":[{"code":1234,"message":"This feature requires an Enterprise plan"}]}`, ErrNotEntitled},
+		{http.StatusBadRequest, `{"success":false,"errors":[{"code":1010,"message":"log retention is not enabled for this zone"}]}`, ErrRetentionDisabled},
+		{http.StatusBadRequest, `{"success":false,"errors":[{"code":7003,"message":"Could not route to /zones/x/logs/received, perhaps your object identifier is invalid?"}]}`, ErrZoneNotFound},
+		{http.StatusBadGateway, `<html>bad gateway</html>`, ErrServer},
+	}
+	for _, tc := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+			_, _ = w.Write([]byte(tc.body))
+		}))
+		c := NewClient(config.CloudflareConfig{BaseURL: srv.URL, RequestTimeout: 5 * time.Second}, "zone-1", "tok")
+		end := time.Now().Add(-time.Hour)
+		_, err := c.StreamLogs(context.Background(), end.Add(-time.Hour), end, nil)
+		srv.Close()
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != tc.status {
+			t.Fatalf("HTTP %d: got %v, want APIError", tc.status, err)
+		}
+		if !errors.Is(err, tc.want) {
+			t.Errorf("HTTP %d %s: kind %v, want %v", tc.status, tc.body, apiErr.Kind, tc.want)
+		}
+	}
+}
+
+func TestGraphQLErrorKinds(t *testing.T) {
+	cases := []struct {
+		body string
+		want error
+	}{
+		{`{"errors":[{"message":"not authorized for that account","extensions":{"code":"authz"}}]}`, ErrMissingPermission},
+		{`{"errors":[{"message":"zone 'abc' does not have access to the path"}]}`, ErrNotEntitled},
+		{`{"errors":[{"message":"internal error"}]}`, nil},
+	}
+	for _, tc := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(tc.body))
+		}))
+		c := NewGraphQLClient(config.CloudflareConfig{BaseURL: srv.URL}, "tok")
+		_, _, err := c.GetSecurityEvents(context.Background(), "zone-1", time.Now().Add(-time.Hour), time.Now(), 0)
+		srv.Close()
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("%s: got %v, want APIError", tc.body, err)
+		}
+		if apiErr.Kind != tc.want {
+			t.Errorf("%s: kind %v, want %v", tc.body, apiErr.Kind, tc.want)
+		}
+	}
+}
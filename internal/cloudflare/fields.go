@@ -0,0 +1,155 @@
+package cloudflare
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Logpull field profiles selectable per zone.
+const (
+	ProfileMinimal       = "minimal"
+	ProfileForensicFull  = "forensic-full"
+	ProfileGDPRMinimized = "gdpr-minimized"
+)
+
+// httpRequestsFields is the Cloudflare catalogue of fields for the
+// http_requests dataset served by Logpull.
+var httpRequestsFields = newFieldSet(
+	"BotDetectionIDs", "BotDetectionTags", "BotScore", "BotScoreSrc", "BotTags",
+	"CacheCacheStatus", "CacheReserveUsed", "CacheResponseBytes", "CacheResponseStatus", "CacheTieredFill",
+	"ClientASN", "ClientCity", "ClientCountry", "ClientDeviceType", "ClientIP", "ClientIPClass",
+	"ClientLatitude", "ClientLongitude", "ClientMTLSAuthCertFingerprint", "ClientMTLSAuthStatus",
+	"ClientRegionCode", "ClientRequestBytes", "ClientRequestHost", "ClientRequestMethod",
+	"ClientRequestPath", "ClientRequestProtocol", "ClientRequestReferer", "ClientRequestScheme",
+	"ClientRequestSource", "ClientRequestURI", "ClientRequestUserAgent", "ClientSSLCipher",
+	"ClientSSLProtocol", "ClientSrcPort", "ClientTCPRTTMs", "ClientXRequestedWith",
+	"ContentScanObjResults", "ContentScanObjTypes", "Cookies",
+	"EdgeCFConnectingO2O", "EdgeColoCode", "EdgeColoID", "EdgeEndTimestamp", "EdgePathingOp",
+	"EdgePathingSrc", "EdgePathingStatus", "EdgeRequestHost", "EdgeResponseBodyBytes",
+	"EdgeResponseBytes", "EdgeResponseCompressionRatio", "EdgeResponseContentType",
+	"EdgeResponseStatus", "EdgeServerIP", "EdgeStartTimestamp", "EdgeTimeToFirstByteMs",
+	"FirewallMatchesActions", "FirewallMatchesRuleIDs", "FirewallMatchesSources",
+	"JA3Hash", "JA4", "JSDetectionPassed", "LeakedCredentialCheckResult",
+	"OriginDNSResponseTimeMs", "OriginIP", "OriginRequestHeaderSendDurationMs", "OriginResponseBytes",
+	"OriginResponseDurationMs", "OriginResponseHTTPExpires", "OriginResponseHTTPLastModified",
+	"OriginResponseHeaderReceiveDurationMs", "OriginResponseStatus", "OriginResponseTime",
+	"OriginSSLProtocol", "OriginTCPHandshakeDurationMs", "OriginTLSHandshakeDurationMs",
+	"ParentRayID", "RayID", "RequestHeaders", "ResponseHeaders",
+	"SecurityAction", "SecurityActions", "SecurityLevel", "SecurityRuleDescription",
+	"SecurityRuleID", "SecurityRuleIDs", "SecuritySources", "SmartRouteColoID", "UpperTierColoID",
+	"WAFAction", "WAFAttackScore", "WAFFlags", "WAFMatchedVar", "WAFProfile", "WAFRCEAttackScore",
+	"WAFRuleID", "WAFRuleMessage", "WAFSQLiAttackScore", "WAFXSSAttackScore",
+	"WorkerCPUTime", "WorkerStatus", "WorkerSubrequest", "WorkerSubrequestCount", "WorkerWallTimeUs",
+	"ZoneName",
+)
+
+func newFieldSet(fields ...string) map[string]struct{} {
+	set := make(map[string]struct{}, len(fields))
+	for _, f := range fields {
+		set[f] = struct{}{}
+	}
+	return set
+}
+
+// fieldProfiles maps profile names to their Logpull field lists.
+var fieldProfiles = map[string][]string{
+	// Cloudflare's own default set, requested explicitly.
+	ProfileMinimal: {
+		"RayID", "EdgeStartTimestamp", "EdgeEndTimestamp", "ClientIP", "ClientRequestHost",
+		"ClientRequestMethod", "ClientRequestURI", "EdgeResponseStatus", "EdgeResponseBytes",
+	},
+	// Everything auditors ask for during incident reconstruction: client
+	// identity, TLS fingerprints, security decisions and origin behavior.
+	ProfileForensicFull: {
+		"RayID", "ParentRayID", "EdgeStartTimestamp", "EdgeEndTimestamp", "ZoneName",
+		"ClientIP", "ClientSrcPort", "ClientASN", "ClientCountry", "ClientCity", "ClientRegionCode",
+		"ClientDeviceType", "ClientIPClass", "ClientRequestHost", "ClientRequestMethod",
+		"ClientRequestURI", "ClientRequestProtocol", "ClientRequestScheme", "ClientRequestReferer",
+		"ClientRequestUserAgent", "ClientRequestBytes", "ClientSSLProtocol", "ClientSSLCipher",
+		"ClientMTLSAuthStatus", "ClientMTLSAuthCertFingerprint", "JA3Hash", "JA4",
+		"EdgeColoCode", "EdgeServerIP", "EdgeRequestHost", "EdgeResponseStatus", "EdgeResponseBytes",
+		"EdgePathingOp", "EdgePathingSrc", "EdgePathingStatus", "CacheCacheStatus",
+		"SecurityAction", "SecurityActions", "SecurityRuleID", "SecurityRuleIDs",
+		"SecurityRuleDescription", "SecuritySources", "SecurityLevel",
+		"WAFAction", "WAFRuleID", "WAFRuleMessage", "WAFAttackScore", "WAFSQLiAttackScore",
+		"WAFXSSAttackScore", "WAFRCEAttackScore", "WAFFlags", "WAFMatchedVar",
+		"FirewallMatchesActions", "FirewallMatchesRuleIDs", "FirewallMatchesSources",
+		"BotScore", "BotScoreSrc", "BotTags", "BotDetectionIDs", "JSDetectionPassed",
+		"LeakedCredentialCheckResult", "OriginIP", "OriginResponseStatus", "OriginSSLProtocol",
+	},
+	// Security-relevant fields without direct personal data (no client IP,
+	// user agent, referer or query string) for GDPR Art. 5(1)(c) minimization.
+	ProfileGDPRMinimized: {
+		"RayID", "EdgeStartTimestamp", "EdgeEndTimestamp", "ClientCountry", "ClientASN",
+		"ClientRequestHost", "ClientRequestMethod", "ClientRequestPath", "ClientRequestProtocol",
+		"ClientSSLProtocol", "EdgeResponseStatus", "EdgeResponseBytes", "EdgeColoCode",
+		"SecurityAction", "SecurityRuleID", "SecuritySources", "WAFAction", "WAFRuleID",
+		"WAFAttackScore", "BotScore",
+	},
+}
+
+// FieldProfiles returns the names of the available field profiles, sorted.
+func FieldProfiles() []string {
+	names := make([]string, 0, len(fieldProfiles))
+	for name := range fieldProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateFields checks every field against the Logpull http_requests catalogue.
+func ValidateFields(fields []string) error {
+	var unknown []string
+	for _, f := range fields {
+		if _, ok := httpRequestsFields[f]; !ok {
+			unknown = append(unknown, f)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("cloudflare: unknown log fields: %s", strings.Join(unknown, ","))
+	}
+	return nil
+}
+
+// ResolveFields returns the Logpull field list for a zone: the profile's
+// fields (if any) followed by the extra fields not already included, with
+// RayID always among them so archived lines can be found by Ray ID. A nil
+// result means Cloudflare's default field set, which has RayID.
+func ResolveFields(profile string, fields []string) ([]string, error) {
+	var out []string
+	if profile != "" {
+		base, ok := fieldProfiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("cloudflare: unknown field profile %q (available: %s)", profile, strings.Join(FieldProfiles(), ", "))
+		}
+		out = append(out, base...)
+	}
+	if err := ValidateFields(fields); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(out)+len(fields))
+	for _, f := range out {
+		seen[f] = struct{}{}
+	}
+	for _, f := range fields {
+		if _, dup := seen[f]; dup {
+			continue
+		}
+		seen[f] = struct{}{}
+		out = append(out, f)
+	}
+	return withRayID(out), nil
+}
+
+// withRayID returns fields with RayID first unless it is already included.
+// An empty selection, meaning a default set, is returned as is.
+func withRayID(fields []string) []string {
+	if len(fields) == 0 || slices.Contains(fields, "RayID") {
+		return fields
+	}
+	return append([]string{"RayID"}, fields...)
+}
@@ -0,0 +1,53 @@
+package cloudflare
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResolveFields(t *testing.T) {
+	fields, err := ResolveFields("", nil)
+	if err != nil || fields != nil {
+		t.Fatalf("empty selection should mean CF defaults, got %v, %v", fields, err)
+	}
+
+	fields, err = ResolveFields(ProfileMinimal, []string{"RayID", "SecurityAction", "WAFRuleID"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(fields), len(fieldProfiles[ProfileMinimal])+2; got != want {
+		t.Errorf("expected %d fields (duplicates dropped), got %d: %v", want, got, fields)
+	}
+	if fields[len(fields)-1] != "WAFRuleID" {
+		t.Errorf("extra fields should follow the profile, got %v", fields)
+	}
+
+	// Custom fields always bring RayID along, for Ray lookups in archives.
+	fields, err = ResolveFields("", []string{"ClientIP", "EdgeStartTimestamp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"RayID", "ClientIP", "EdgeStartTimestamp"}; !slices.Equal(fields, want) {
+		t.Errorf("got %v, want %v", fields, want)
+	}
+
+	if _, err := ResolveFields("everything", nil); err == nil {
+		t.Error("expected error for unknown profile")
+	}
+	if _, err := ResolveFields("", []string{"ClientIP", "NotAField"}); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
+
+func TestFieldProfilesUseCatalogueFields(t *testing.T) {
+	for _, name := range FieldProfiles() {
+		if err := ValidateFields(fieldProfiles[name]); err != nil {
+			t.Errorf("profile %s: %v", name, err)
+		}
+	}
+	for _, f := range fieldProfiles[ProfileGDPRMinimized] {
+		if f == "ClientIP" || f == "ClientRequestUserAgent" {
+			t.Errorf("gdpr-minimized profile must not include %s", f)
+		}
+	}
+}
@@ -5,21 +5,29 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"sort"
 	"time"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
 )
 
 type GraphQLClient struct {
-	baseURL    string // default "https://api.cloudflare.com/client/v4/graphql"
+	baseURL    string // the GraphQL endpoint, e.g. "https://api.cloudflare.com/client/v4/graphql"
 	httpClient *http.Client
 	apiToken   string
 }
 
-func NewGraphQLClient(apiToken string) *GraphQLClient {
+// NewGraphQLClient creates a GraphQLClient for the endpoint under
+// cfg.BaseURL.
+func NewGraphQLClient(cfg config.CloudflareConfig, apiToken string) *GraphQLClient {
+	base := cfg.BaseURL
+	if base == "" {
+		base = defaultBaseURL
+	}
 	return &GraphQLClient{
-		baseURL:    "https://api.cloudflare.com/client/v4/graphql",
-		httpClient: &http.Client{Timeout: 30 * time.Second},
+		baseURL:    base + "/graphql",
+		httpClient: newHTTPClient(cfg.RequestTimeout),
 		apiToken:   apiToken,
 	}
 }
@@ -44,19 +52,24 @@ type graphQLResponse struct {
 			} `json:"zones"`
 		} `json:"viewer"`
 	} `json:"data"`
-	Errors []struct {
-		Message string `json:"message"`
-	} `json:"errors"`
+	Errors []graphQLError `json:"errors"`
 }
 
-func (c *GraphQLClient) GetSecurityEvents(ctx context.Context, zoneID string, start, end time.Time) ([]FirewallEvent, error) {
-	query := `query GetSecurityEvents($zoneTag: string, $start: Time!, $end: Time!) {
+// SecurityEventsRetention is how far back firewallEventsAdaptive can still be
+// queried. Retention grows with the plan; this is the Free plan's, the
+// shortest.
+const SecurityEventsRetention = 24 * time.Hour
+
+// securityEventsPageSize is the firewallEventsAdaptive row cap per request.
+const securityEventsPageSize = 1000
+
+const securityEventsQuery = `query GetSecurityEvents($zoneTag: string, $filter: ZoneFirewallEventsAdaptiveFilter_InputObject!, $limit: uint64!) {
 		viewer {
 			zones(filter: { zoneTag: $zoneTag }) {
 				firewallEventsAdaptive(
-					filter: { datetime_geq: $start, datetime_leq: $end },
-					limit: 1000,
-					orderBy: [datetime_DESC]
+					filter: $filter,
+					limit: $limit,
+					orderBy: [datetime_ASC, rayName_ASC]
 				) {
 					action
 					clientIP
@@ -72,12 +85,59 @@ func (c *GraphQLClient) GetSecurityEvents(ctx context.Context, zoneID string, st
 		}
 	}`
 
+// GetSecurityEvents drains firewallEventsAdaptive for [start, end), paging
+// past the per-request row cap with a (datetime, rayName) cursor.
+//
+// maxEvents bounds how many events are collected (<= 0 means unlimited). When
+// the bound is reached the result is cut at a datetime boundary: every event in
+// [start, drainedUntil) is returned and none after it, so the caller can resume
+// from drainedUntil. A fully drained window returns drainedUntil == end.
+func (c *GraphQLClient) GetSecurityEvents(ctx context.Context, zoneID string, start, end time.Time, maxEvents int) (events []FirewallEvent, drainedUntil time.Time, err error) {
+	var cursor *FirewallEvent
+	for {
+		page, err := c.securityEventsPage(ctx, zoneID, start, end, cursor)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		events = append(events, page...)
+		if len(page) < securityEventsPageSize {
+			return events, end, nil
+		}
+		cursor = &page[len(page)-1]
+
+		if maxEvents > 0 && len(events) >= maxEvents {
+			cut := cursor.Datetime
+			n := sort.Search(len(events), func(i int) bool { return !events[i].Datetime.Before(cut) })
+			// Only stop if the cut keeps something; a single datetime holding
+			// more than maxEvents rows has to be drained in one go.
+			if n > 0 && cut.After(start) {
+				return events[:n], cut, nil
+			}
+		}
+	}
+}
+
+// securityEventsPage fetches one page of events ordered by (datetime, rayName),
+// starting strictly after cursor when it is non-nil.
+func (c *GraphQLClient) securityEventsPage(ctx context.Context, zoneID string, start, end time.Time, cursor *FirewallEvent) ([]FirewallEvent, error) {
+	filter := map[string]interface{}{
+		"datetime_geq": start.UTC().Format(time.RFC3339),
+		"datetime_lt":  end.UTC().Format(time.RFC3339),
+	}
+	if cursor != nil {
+		at := cursor.Datetime.UTC().Format(time.RFC3339)
+		filter["OR"] = []map[string]interface{}{
+			{"datetime_gt": at},
+			{"datetime": at, "rayName_gt": cursor.RayName},
+		}
+	}
+
 	reqBody := map[string]interface{}{
-		"query": query,
+		"query": securityEventsQuery,
 		"variables": map[string]interface{}{
 			"zoneTag": zoneID,
-			"start":   start.Format(time.RFC3339),
-			"end":     end.Format(time.RFC3339),
+			"filter":  filter,
+			"limit":   securityEventsPageSize,
 		},
 	}
 
@@ -100,9 +160,11 @@ func (c *GraphQLClient) GetSecurityEvents(ctx context.Context, zoneID string, st
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, rateLimitError(resp)
+	}
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-		return nil, fmt.Errorf("graphql api error: %s", body)
+		return nil, apiError("graphql", resp)
 	}
 
 	var result graphQLResponse
@@ -111,7 +173,7 @@ func (c *GraphQLClient) GetSecurityEvents(ctx context.Context, zoneID string, st
 	}
 
 	if len(result.Errors) > 0 {
-		return nil, fmt.Errorf("graphql error: %s", result.Errors[0].Message)
+		return nil, graphQLAPIError("graphql", result.Errors)
 	}
 
 	if len(result.Data.Viewer.Zones) == 0 {
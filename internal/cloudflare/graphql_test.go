@@ -0,0 +1,144 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+)
+
+// fakeSecurityEvents serves events through the (datetime, rayName) cursor
+// filter sent by securityEventsPage.
+func fakeSecurityEvents(t *testing.T, events []FirewallEvent) (*httptest.Server, *int) {
+	t.Helper()
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req struct {
+			Variables struct {
+				Filter struct {
+					Geq string `json:"datetime_geq"`
+					Lt  string `json:"datetime_lt"`
+					OR  []struct {
+						Gt      string `json:"datetime_gt"`
+						At      string `json:"datetime"`
+						RayName string `json:"rayName_gt"`
+					} `json:"OR"`
+				} `json:"filter"`
+				Limit int `json:"limit"`
+			} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+			return
+		}
+		f := req.Variables.Filter
+		geq, _ := time.Parse(time.RFC3339, f.Geq)
+		lt, _ := time.Parse(time.RFC3339, f.Lt)
+
+		var page []FirewallEvent
+		for _, e := range events {
+			if e.Datetime.Before(geq) || !e.Datetime.Before(lt) {
+				continue
+			}
+			if len(f.OR) == 2 {
+				at, _ := time.Parse(time.RFC3339, f.OR[1].At)
+				if !e.Datetime.After(at) && !(e.Datetime.Equal(at) && e.RayName > f.OR[1].RayName) {
+					continue
+				}
+			}
+			page = append(page, e)
+			if len(page) == req.Variables.Limit {
+				break
+			}
+		}
+
+		var resp graphQLResponse
+		resp.Data.Viewer.Zones = append(resp.Data.Viewer.Zones, struct {
+			FirewallEventsAdaptive []FirewallEvent `json:"firewallEventsAdaptive"`
+		}{page})
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+// makeEvents returns n events, perSecond of them sharing each datetime.
+func makeEvents(start time.Time, n, perSecond int) []FirewallEvent {
+	events := make([]FirewallEvent, n)
+	for i := range events {
+		events[i] = FirewallEvent{
+			Datetime: start.Add(time.Duration(i/perSecond) * time.Second),
+			RayName:  fmt.Sprintf("ray%06d", i),
+		}
+	}
+	return events
+}
+
+func TestGetSecurityEventsPagesPastRowCap(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	all := makeEvents(start, 2500, 3)
+
+	srv, calls := fakeSecurityEvents(t, all)
+	c := NewGraphQLClient(config.CloudflareConfig{BaseURL: srv.URL}, "token")
+
+	events, drainedUntil, err := c.GetSecurityEvents(context.Background(), "zone", start, end, 0)
+	if err != nil {
+		t.Fatalf("GetSecurityEvents: %v", err)
+	}
+	if len(events) != len(all) {
+		t.Fatalf("got %d events, want %d", len(events), len(all))
+	}
+	if !drainedUntil.Equal(end) {
+		t.Errorf("drainedUntil = %v, want %v", drainedUntil, end)
+	}
+	if *calls != 3 {
+		t.Errorf("expected 3 pages, got %d", *calls)
+	}
+	seen := make(map[string]bool, len(events))
+	for _, e := range events {
+		if seen[e.RayName] {
+			t.Fatalf("duplicate event %s", e.RayName)
+		}
+		seen[e.RayName] = true
+	}
+}
+
+func TestGetSecurityEventsBoundCutsAtDatetime(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	all := makeEvents(start, 2500, 3)
+
+	srv, _ := fakeSecurityEvents(t, all)
+	c := NewGraphQLClient(config.CloudflareConfig{BaseURL: srv.URL}, "token")
+
+	events, drainedUntil, err := c.GetSecurityEvents(context.Background(), "zone", start, end, 1000)
+	if err != nil {
+		t.Fatalf("GetSecurityEvents: %v", err)
+	}
+	if !drainedUntil.Before(end) {
+		t.Fatalf("expected a partial drain, got drainedUntil = %v", drainedUntil)
+	}
+	for _, e := range events {
+		if !e.Datetime.Before(drainedUntil) {
+			t.Fatalf("event at %v is not before cut %v", e.Datetime, drainedUntil)
+		}
+	}
+	// Every event before the cut must be present, so resuming at drainedUntil
+	// loses nothing.
+	var want int
+	for _, e := range all {
+		if e.Datetime.Before(drainedUntil) {
+			want++
+		}
+	}
+	if len(events) != want {
+		t.Errorf("got %d events before cut, want %d", len(events), want)
+	}
+}
@@ -1,52 +1,186 @@
 package cloudflare
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
 )
 
 type InstantLogsClient struct {
-	apiToken string
-	zoneID   string
-	baseURL  string
+	apiToken   string
+	zoneID     string
+	baseURL    string
+	httpClient *http.Client
 }
 
-func NewInstantLogsClient(apiToken, zoneID string) *InstantLogsClient {
+// NewInstantLogsClient creates an InstantLogsClient for a zone. Jobs are
+// created under cfg.BaseURL; the stream's WebSocket URL comes from the job.
+func NewInstantLogsClient(cfg config.CloudflareConfig, apiToken, zoneID string) *InstantLogsClient {
+	base := cfg.BaseURL
+	if base == "" {
+		base = defaultBaseURL
+	}
 	return &InstantLogsClient{
-		apiToken: apiToken,
-		zoneID:   zoneID,
-		baseURL:  "https://api.cloudflare.com/client/v4",
+		apiToken:   apiToken,
+		zoneID:     zoneID,
+		baseURL:    base,
+		httpClient: newHTTPClient(cfg.RequestTimeout),
+	}
+}
+
+// DefaultInstantLogsFields are streamed when a zone doesn't select its own.
+var DefaultInstantLogsFields = []string{
+	"ClientIP", "EdgeStartTimestamp", "ClientRequestURI", "ClientRequestMethod",
+	"EdgeResponseStatus", "ClientRequestUserAgent", "RayID",
+}
+
+// MaxInstantLogsSample is the largest accepted sample divisor (1 in 1000).
+const MaxInstantLogsSample = 1000
+
+// InstantLogsOptions are the per-zone session parameters.
+type InstantLogsOptions struct {
+	// Fields to stream; empty means DefaultInstantLogsFields.
+	Fields []string
+	// Sample keeps one request in Sample; 0 and 1 keep every request.
+	Sample int
+	// Filter is a Logpush filter, e.g. {"where":{"key":"ClientRequestPath","operator":"!endsWith","value":".css"}}.
+	Filter json.RawMessage
+}
+
+// Validate checks the options against the field catalogue and Cloudflare's
+// filter grammar.
+func (o InstantLogsOptions) Validate() error {
+	if err := ValidateFields(o.Fields); err != nil {
+		return err
+	}
+	if o.Sample < 0 || o.Sample > MaxInstantLogsSample {
+		return fmt.Errorf("cloudflare: instant logs sample must be between 0 and %d (0 and 1 keep every request)", MaxInstantLogsSample)
+	}
+	if len(o.Filter) == 0 {
+		return nil
+	}
+	var f struct {
+		Where json.RawMessage `json:"where"`
+	}
+	if err := json.Unmarshal(o.Filter, &f); err != nil {
+		return fmt.Errorf("cloudflare: instant logs filter: %w", err)
+	}
+	if len(f.Where) == 0 {
+		return fmt.Errorf(`cloudflare: instant logs filter: missing "where"`)
+	}
+	return validateFilterNode(f.Where, 0)
+}
+
+// filterOperators are the comparison operators accepted in Logpush filters.
+var filterOperators = map[string]bool{
+	"eq": true, "!eq": true, "lt": true, "leq": true, "gt": true, "geq": true,
+	"startsWith": true, "endsWith": true, "!startsWith": true, "!endsWith": true,
+	"contains": true, "!contains": true, "in": true, "!in": true,
+}
+
+const maxFilterDepth = 4
+
+// validateFilterNode checks one node of a filter: either an and/or group of
+// nodes or a single key/operator/value condition.
+func validateFilterNode(raw json.RawMessage, depth int) error {
+	if depth > maxFilterDepth {
+		return fmt.Errorf("cloudflare: instant logs filter nested deeper than %d", maxFilterDepth)
+	}
+	var n struct {
+		And      []json.RawMessage `json:"and"`
+		Or       []json.RawMessage `json:"or"`
+		Key      string            `json:"key"`
+		Operator string            `json:"operator"`
+		Value    json.RawMessage   `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return fmt.Errorf("cloudflare: instant logs filter: %w", err)
+	}
+
+	group := n.And
+	if n.Or != nil {
+		if n.And != nil {
+			return fmt.Errorf(`cloudflare: instant logs filter: "and" and "or" in the same node`)
+		}
+		group = n.Or
 	}
+	if group != nil {
+		if n.Key != "" || len(group) == 0 {
+			return fmt.Errorf("cloudflare: instant logs filter: malformed and/or group")
+		}
+		for _, child := range group {
+			if err := validateFilterNode(child, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, ok := httpRequestsFields[n.Key]; !ok {
+		return fmt.Errorf("cloudflare: instant logs filter: unknown key %q", n.Key)
+	}
+	if !filterOperators[n.Operator] {
+		return fmt.Errorf("cloudflare: instant logs filter: unknown operator %q", n.Operator)
+	}
+	if len(n.Value) == 0 {
+		return fmt.Errorf("cloudflare: instant logs filter: missing value for %q", n.Key)
+	}
+	return nil
 }
 
 // StartSession creates a job and returns the WebSocket URL.
-func (c *InstantLogsClient) StartSession(ctx context.Context) (string, error) {
+func (c *InstantLogsClient) StartSession(ctx context.Context, opts InstantLogsOptions) (string, error) {
 	// 1. Create Job
 	url := fmt.Sprintf("%s/zones/%s/logpush/edge/jobs", c.baseURL, c.zoneID)
-	payload := `{"kind":"instant-logs","fields":"ClientIP,EdgeStartTimestamp,ClientRequestURI,ClientRequestMethod,EdgeResponseStatus,ClientRequestUserAgent,RayID"}`
+	fields := withRayID(opts.Fields)
+	if len(fields) == 0 {
+		fields = DefaultInstantLogsFields
+	}
+	job := struct {
+		Kind   string `json:"kind"`
+		Fields string `json:"fields"`
+		Sample int    `json:"sample,omitempty"`
+		// Cloudflare expects the filter as a JSON-encoded string.
+		Filter string `json:"filter,omitempty"`
+	}{
+		Kind:   "instant-logs",
+		Fields: strings.Join(fields, ","),
+		Filter: string(opts.Filter),
+	}
+	if opts.Sample > 1 {
+		job.Sample = opts.Sample
+	}
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return "", fmt.Errorf("marshal intent request: %w", err)
+	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
 	if err != nil {
 		return "", fmt.Errorf("create intent request: %w", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+c.apiToken)
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("do intent request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return "", rateLimitError(resp)
+	}
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-		return "", fmt.Errorf("create job failed: HTTP %d: %s", resp.StatusCode, body)
+		return "", apiError("create instant logs job", resp)
 	}
 
 	var result struct {
@@ -61,6 +195,35 @@ func (c *InstantLogsClient) StartSession(ctx context.Context) (string, error) {
 	return result.Result.DestinationConf, nil
 }
 
+// Available reports whether the zone's plan includes Instant Logs (Business
+// and Enterprise), by listing its Instant Logs jobs. Zones without the
+// entitlement are reported as false; any other failure is an error.
+func (c *InstantLogsClient) Available(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/zones/%s/logpush/edge/jobs", c.baseURL, c.zoneID), http.NoBody)
+	if err != nil {
+		return false, fmt.Errorf("cloudflare: new request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("cloudflare: do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusTooManyRequests:
+		return false, rateLimitError(resp)
+	default:
+		if apiErr := apiError("list instant logs jobs", resp); !errors.Is(apiErr, ErrNotEntitled) {
+			return false, apiErr
+		}
+		return false, nil
+	}
+}
+
 // Stream logs from WebSocket. Returns a channel of raw JSON bytes.
 func (c *InstantLogsClient) Stream(ctx context.Context, wsURL string) (<-chan []byte, error) {
 	dialer := websocket.DefaultDialer
@@ -0,0 +1,51 @@
+package cloudflare
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestInstantLogsOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    InstantLogsOptions
+		wantErr bool
+	}{
+		{name: "defaults", opts: InstantLogsOptions{}},
+		{name: "fields and sample", opts: InstantLogsOptions{Fields: []string{"RayID", "ClientIP"}, Sample: 10}},
+		{name: "unknown field", opts: InstantLogsOptions{Fields: []string{"Nope"}}, wantErr: true},
+		{name: "negative sample", opts: InstantLogsOptions{Sample: -1}, wantErr: true},
+		{name: "sample too large", opts: InstantLogsOptions{Sample: MaxInstantLogsSample + 1}, wantErr: true},
+		{
+			name: "exclude static assets",
+			opts: InstantLogsOptions{Filter: json.RawMessage(`{"where":{"and":[
+				{"key":"ClientRequestPath","operator":"!endsWith","value":".css"},
+				{"key":"ClientRequestPath","operator":"!endsWith","value":".js"}]}}`)},
+		},
+		{name: "not json", opts: InstantLogsOptions{Filter: json.RawMessage(`{where`)}, wantErr: true},
+		{name: "missing where", opts: InstantLogsOptions{Filter: json.RawMessage(`{}`)}, wantErr: true},
+		{
+			name:    "unknown operator",
+			opts:    InstantLogsOptions{Filter: json.RawMessage(`{"where":{"key":"ClientIP","operator":"like","value":"1"}}`)},
+			wantErr: true,
+		},
+		{
+			name:    "unknown key",
+			opts:    InstantLogsOptions{Filter: json.RawMessage(`{"where":{"key":"Bogus","operator":"eq","value":"1"}}`)},
+			wantErr: true,
+		},
+		{
+			name:    "mixed and/or",
+			opts:    InstantLogsOptions{Filter: json.RawMessage(`{"where":{"and":[],"or":[]}}`)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package cloudflare
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logpushTimestampFields names the timestamp field of each zone-scoped
+// Logpush dataset accepted by the receiver.
+var logpushTimestampFields = map[string]string{
+	"http_requests":        "EdgeStartTimestamp",
+	"firewall_events":      "Datetime",
+	"dns_logs":             "Timestamp",
+	"workers_trace_events": "EventTimestampMs",
+	"spectrum_events":      "Timestamp",
+}
+
+// LogpushDatasets returns the Logpush datasets the receiver accepts.
+func LogpushDatasets() []string {
+	return []string{"http_requests", "firewall_events", "dns_logs", "workers_trace_events", "spectrum_events"}
+}
+
+// IsLogpushDataset reports whether the receiver accepts dataset.
+func IsLogpushDataset(dataset string) bool {
+	_, ok := logpushTimestampFields[dataset]
+	return ok
+}
+
+// LogpushControl is a file Cloudflare delivers to an HTTP destination instead
+// of a log batch: the test file written when a job is created or the
+// destination validated, or an ownership challenge.
+type LogpushControl struct {
+	Filename string `json:"filename"`
+	Content  string `json:"content"`
+}
+
+// IsOwnershipChallenge reports whether the file carries an ownership
+// challenge token.
+func (f LogpushControl) IsOwnershipChallenge() bool {
+	return strings.HasPrefix(f.Filename, "ownership-challenge")
+}
+
+// ParseLogpushControl recognises a control file in a decompressed request
+// body. Log batches never parse as one: their lines carry dataset fields.
+func ParseLogpushControl(body []byte) (LogpushControl, bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{' || bytes.IndexByte(body, '\n') >= 0 {
+		return LogpushControl{}, false
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || len(fields) > 2 {
+		return LogpushControl{}, false
+	}
+	if _, ok := fields["content"]; !ok {
+		return LogpushControl{}, false
+	}
+	var f LogpushControl
+	if err := json.Unmarshal(body, &f); err != nil {
+		return LogpushControl{}, false
+	}
+	return f, true
+}
+
+// LogpushWindow returns the period an NDJSON batch of dataset, read from r,
+// covers, from its earliest timestamp to just past its latest, at second
+// resolution. ok is false when no line carries a readable timestamp.
+func LogpushWindow(r io.Reader, dataset string) (from, to time.Time, ok bool) {
+	field := logpushTimestampFields[dataset]
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var line map[string]json.RawMessage
+		if json.Unmarshal(sc.Bytes(), &line) != nil {
+			continue
+		}
+		ts, valid := parseLogpushTimestamp(line[field])
+		if !valid {
+			continue
+		}
+		if !ok || ts.Before(from) {
+			from = ts
+		}
+		if !ok || ts.After(to) {
+			to = ts
+		}
+		ok = true
+	}
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	return from.Truncate(time.Second), to.Truncate(time.Second).Add(time.Second), true
+}
+
+// parseLogpushTimestamp reads a timestamp in any Logpush timestamp_format:
+// RFC 3339 strings, or Unix seconds or nanoseconds. Integers are told apart by
+// magnitude, which also covers the milliseconds of Workers trace events.
+func parseLogpushTimestamp(raw json.RawMessage) (time.Time, bool) {
+	if len(raw) == 0 {
+		return time.Time{}, false
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		return t.UTC(), err == nil
+	}
+	n, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch {
+	case n > 1e17:
+		return time.Unix(0, n).UTC(), true
+	case n > 1e14:
+		return time.UnixMicro(n).UTC(), true
+	case n > 1e11:
+		return time.UnixMilli(n).UTC(), true
+	default:
+		return time.Unix(n, 0).UTC(), true
+	}
+}
@@ -0,0 +1,65 @@
+package cloudflare
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogpushWindow(t *testing.T) {
+	batch := []byte(`{"EdgeStartTimestamp":"2026-03-10T12:00:05Z","RayID":"a"}
+{"EdgeStartTimestamp":"2026-03-10T11:59:58.250Z","RayID":"b"}
+not json
+{"RayID":"c"}
+{"EdgeStartTimestamp":"2026-03-10T12:00:01Z","RayID":"d"}
+`)
+	from, to, ok := LogpushWindow(bytes.NewReader(batch), "http_requests")
+	if !ok {
+		t.Fatal("expected a window")
+	}
+	if want := time.Date(2026, 3, 10, 11, 59, 58, 0, time.UTC); !from.Equal(want) {
+		t.Errorf("from = %v, want %v", from, want)
+	}
+	if want := time.Date(2026, 3, 10, 12, 0, 6, 0, time.UTC); !to.Equal(want) {
+		t.Errorf("to = %v, want %v", to, want)
+	}
+
+	// unixnano timestamps, DNS dataset field
+	from, to, ok = LogpushWindow(strings.NewReader(`{"Timestamp":1773144000000000000}`), "dns_logs")
+	if !ok || from.Unix() != 1773144000 || to.Unix() != 1773144001 {
+		t.Errorf("unixnano: got %v %v %v", from, to, ok)
+	}
+
+	// Workers trace events carry milliseconds
+	from, _, ok = LogpushWindow(strings.NewReader(`{"EventTimestampMs":1773144000123}`), "workers_trace_events")
+	if !ok || from.Unix() != 1773144000 {
+		t.Errorf("unix ms: got %v %v", from, ok)
+	}
+
+	if _, _, ok := LogpushWindow(strings.NewReader(`{"RayID":"a"}`), "http_requests"); ok {
+		t.Error("expected no window without timestamps")
+	}
+}
+
+func TestParseLogpushControl(t *testing.T) {
+	f, ok := ParseLogpushControl([]byte(`{"content":"tests","filename":"test.txt"}`))
+	if !ok || f.IsOwnershipChallenge() {
+		t.Errorf("test file: got %+v, %v", f, ok)
+	}
+
+	f, ok = ParseLogpushControl([]byte(`{"filename":"ownership-challenge-6e1a3c.txt","content":"eyJhbGciOi..."}` + "\n"))
+	if !ok || !f.IsOwnershipChallenge() || f.Content != "eyJhbGciOi..." {
+		t.Errorf("challenge: got %+v, %v", f, ok)
+	}
+
+	for _, body := range []string{
+		`{"EdgeStartTimestamp":"2026-03-10T12:00:05Z","RayID":"a"}`,
+		`{"content":"x"}` + "\n" + `{"content":"y"}`,
+		`{"content":"x","filename":"f","RayID":"a"}`,
+	} {
+		if _, ok := ParseLogpushControl([]byte(body)); ok {
+			t.Errorf("%q parsed as a control file", body)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package cloudflare
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Limiter paces the requests of a Cloudflare client, typically against a
+// budget shared by every client using the same API token. Wait blocks until a
+// request may be sent; Throttled reports a 429 and the back-off Cloudflare
+// asked for.
+type Limiter interface {
+	Wait(ctx context.Context) error
+	Throttled(ctx context.Context, retryAfter time.Duration)
+}
+
+// limitedTransport passes every request through a Limiter. The wait happens
+// before the request is sent, so it counts against the request's context but
+// not against cloudflare.request_timeout, which only bounds connecting and the
+// response headers.
+type limitedTransport struct {
+	base    http.RoundTripper
+	limiter Limiter
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		t.limiter.Throttled(req.Context(), ParseRetryAfter(resp.Header.Get("Retry-After")))
+	}
+	return resp, err
+}
+
+// limitClient routes c's requests through l; a nil l leaves c unlimited.
+func limitClient(c *http.Client, l Limiter) {
+	if l == nil {
+		return
+	}
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c.Transport = &limitedTransport{base: base, limiter: l}
+}
+
+// WithLimiter paces the client's requests through l and returns the client.
+func (c *Client) WithLimiter(l Limiter) *Client {
+	limitClient(c.httpClient, l)
+	return c
+}
+
+// WithLimiter paces the client's requests through l and returns the client.
+func (c *AccountClient) WithLimiter(l Limiter) *AccountClient {
+	limitClient(c.httpClient, l)
+	return c
+}
+
+// WithLimiter paces the client's requests through l and returns the client.
+func (c *GraphQLClient) WithLimiter(l Limiter) *GraphQLClient {
+	limitClient(c.httpClient, l)
+	return c
+}
+
+// WithLimiter paces the client's session requests through l and returns the
+// client. The log stream itself is a single WebSocket and is not paced.
+func (c *InstantLogsClient) WithLimiter(l Limiter) *InstantLogsClient {
+	limitClient(c.httpClient, l)
+	return c
+}
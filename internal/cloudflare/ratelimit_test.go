@@ -0,0 +1,83 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+)
+
+type countingLimiter struct {
+	waits     int
+	delay     time.Duration
+	throttled []time.Duration
+	err       error
+}
+
+func (l *countingLimiter) Wait(context.Context) error {
+	l.waits++
+	time.Sleep(l.delay)
+	return l.err
+}
+
+func (l *countingLimiter) Throttled(_ context.Context, retryAfter time.Duration) {
+	l.throttled = append(l.throttled, retryAfter)
+}
+
+func TestWithLimiter(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 2 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"result":{"enabled":true}}`))
+	}))
+	defer srv.Close()
+
+	l := &countingLimiter{}
+	c := NewClient(config.CloudflareConfig{BaseURL: srv.URL, RequestTimeout: 5 * time.Second}, "zone-1", "tok").WithLimiter(l)
+
+	if _, err := c.LogpullEnabled(context.Background()); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	var rlErr *RateLimitError
+	if _, err := c.LogpullEnabled(context.Background()); !errors.As(err, &rlErr) {
+		t.Fatalf("second request: got %v, want RateLimitError", err)
+	}
+	if l.waits != 2 {
+		t.Errorf("waits = %d, want 2", l.waits)
+	}
+	if len(l.throttled) != 1 || l.throttled[0] != 7*time.Second {
+		t.Errorf("throttled = %v, want [7s]", l.throttled)
+	}
+
+	// A limiter that refuses keeps the request from being sent.
+	l.err = context.DeadlineExceeded
+	if _, err := c.LogpullEnabled(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("refused request: got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("server saw %d calls, want 2", calls)
+	}
+}
+
+func TestLimiterWaitOutlastsRequestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"success":true,"result":{"enabled":true}}`))
+	}))
+	defer srv.Close()
+
+	// Waiting for the budget is not the request being slow.
+	l := &countingLimiter{delay: 200 * time.Millisecond}
+	c := NewClient(config.CloudflareConfig{BaseURL: srv.URL, RequestTimeout: 50 * time.Millisecond}, "zone-1", "tok").WithLimiter(l)
+	if _, err := c.LogpullEnabled(context.Background()); err != nil {
+		t.Fatalf("request after a long wait: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Permission is a Cloudflare API token permission RainLogs depends on.
+type Permission string
+
+const (
+	PermissionZoneRead      Permission = "Zone Read"
+	PermissionLogsRead      Permission = "Logs Read"
+	PermissionAnalyticsRead Permission = "Analytics Read"
+)
+
+// RequiredPermissions returns the permissions the collector of logType needs
+// on a zone: Logpull and Instant Logs read logs, the security events poller
+// reads GraphQL analytics.
+func RequiredPermissions(logType string) []Permission {
+	if logType == "security" {
+		return []Permission{PermissionZoneRead, PermissionAnalyticsRead}
+	}
+	return []Permission{PermissionZoneRead, PermissionLogsRead}
+}
+
+// ErrInvalidToken is returned by VerifyToken when Cloudflare does not
+// recognise the token. It is also the kind of any call's APIError failing
+// authentication.
+var ErrInvalidToken = errors.New("cloudflare: invalid API token")
+
+// TokenStatus is an API token as reported by the token verify endpoint.
+type TokenStatus struct {
+	ID        string     `json:"id"`
+	Status    string     `json:"status"` // active, disabled, expired
+	ExpiresOn *time.Time `json:"expires_on"`
+	NotBefore *time.Time `json:"not_before"`
+}
+
+// Active reports whether the token can be used at now.
+func (t *TokenStatus) Active(now time.Time) bool {
+	return t.Status == "active" &&
+		(t.ExpiresOn == nil || now.Before(*t.ExpiresOn)) &&
+		(t.NotBefore == nil || !now.Before(*t.NotBefore))
+}
+
+// VerifyToken verifies the client's token. User tokens verify at
+// /user/tokens/verify, account-owned tokens at /accounts/{id}/tokens/verify;
+// the account endpoint is tried when the user one rejects the token.
+func (c *AccountClient) VerifyToken(ctx context.Context) (*TokenStatus, error) {
+	status, err := c.verifyToken(ctx, c.baseURL+"/user/tokens/verify")
+	if errors.Is(err, ErrInvalidToken) && c.accountID != "" {
+		status, err = c.verifyToken(ctx, fmt.Sprintf("%s/accounts/%s/tokens/verify", c.baseURL, c.accountID))
+	}
+	return status, err
+}
+
+func (c *AccountClient) verifyToken(ctx context.Context, u string) (*TokenStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: new request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrInvalidToken
+	case http.StatusTooManyRequests:
+		return nil, rateLimitError(resp)
+	default:
+		return nil, apiError("verify token", resp)
+	}
+
+	var out struct {
+		Success bool        `json:"success"`
+		Result  TokenStatus `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("cloudflare: decode token: %w", err)
+	}
+	if !out.Success {
+		return nil, ErrInvalidToken
+	}
+	return &out.Result, nil
+}
+
+// HasPermission probes an endpoint that requires perm on the client's zone.
+// It reports false when Cloudflare refuses the token.
+//
+// Logs Read is probed where logType's collector reads logs: the Logpull
+// retention flag, or the zone's Instant Logs jobs. Logpush endpoints also
+// need the Logpush entitlement, which Business zones lack.
+func (c *Client) HasPermission(ctx context.Context, perm Permission, logType string) (bool, error) {
+	var req *http.Request
+	var err error
+	switch perm {
+	case PermissionZoneRead:
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet,
+			fmt.Sprintf("%s/zones/%s", c.baseURL, c.zoneID), http.NoBody)
+	case PermissionLogsRead:
+		path := "logs/control/retention/flag"
+		if logType == "instant" {
+			path = "logpush/edge/jobs"
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet,
+			fmt.Sprintf("%s/zones/%s/%s", c.baseURL, c.zoneID, path), http.NoBody)
+	case PermissionAnalyticsRead:
+		body, _ := json.Marshal(map[string]any{
+			"query":     `query Preflight($zoneTag: string) { viewer { zones(filter: { zoneTag: $zoneTag }) { zoneTag } } }`,
+			"variables": map[string]any{"zoneTag": c.zoneID},
+		})
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/graphql", bytes.NewReader(body))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	default:
+		return false, fmt.Errorf("cloudflare: unknown permission %q", perm)
+	}
+	if err != nil {
+		return false, fmt.Errorf("cloudflare: new request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("cloudflare: do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, nil
+	case http.StatusTooManyRequests:
+		return false, rateLimitError(resp)
+	default:
+		return false, apiError("probe "+string(perm), resp)
+	}
+	if perm != PermissionAnalyticsRead {
+		return true, nil
+	}
+
+	// GraphQL answers 200 and reports authorization failures in errors[].
+	var out struct {
+		Data struct {
+			Viewer struct {
+				Zones []struct {
+					ZoneTag string `json:"zoneTag"`
+				} `json:"zones"`
+			} `json:"viewer"`
+		} `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, fmt.Errorf("cloudflare: decode graphql: %w", err)
+	}
+	if len(out.Errors) > 0 {
+		apiErr := graphQLAPIError("probe "+string(perm), out.Errors)
+		if errors.Is(apiErr, ErrMissingPermission) || errors.Is(apiErr, ErrNotEntitled) {
+			return false, nil
+		}
+		return false, apiErr
+	}
+	return len(out.Data.Viewer.Zones) > 0, nil
+}
@@ -0,0 +1,105 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+)
+
+func TestVerifyToken_AccountOwned(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user/tokens/verify":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"success":false,"errors":[{"code":1000,"message":"Invalid API Token"}]}`)
+		case "/accounts/acc-1/tokens/verify":
+			fmt.Fprint(w, `{"success":true,"result":{"id":"tok-1","status":"active","expires_on":"2030-01-01T00:00:00Z"}}`)
+		default:
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	status, err := NewAccountClient(config.CloudflareConfig{BaseURL: srv.URL}, "acc-1", "tok").VerifyToken(context.Background())
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	if status.ID != "tok-1" || status.ExpiresOn == nil || status.ExpiresOn.Year() != 2030 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if !status.Active(time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)) || status.Active(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("token should be active until it expires")
+	}
+}
+
+func TestVerifyToken_Invalid(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"success":false,"errors":[{"code":1000,"message":"Invalid API Token"}]}`)
+	}))
+	defer srv.Close()
+
+	_, err := NewAccountClient(config.CloudflareConfig{BaseURL: srv.URL}, "acc-1", "tok").VerifyToken(context.Background())
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/zones/zone-1":
+			fmt.Fprint(w, `{"success":true,"result":{"id":"zone-1"}}`)
+		case "/zones/zone-1/logs/control/retention/flag":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`)
+		case "/graphql":
+			fmt.Fprint(w, `{"data":null,"errors":[{"message":"not authorized for that account","extensions":{"code":"authz"}}]}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(config.CloudflareConfig{BaseURL: srv.URL}, "zone-1", "tok")
+	for perm, want := range map[Permission]bool{
+		PermissionZoneRead:      true,
+		PermissionLogsRead:      false,
+		PermissionAnalyticsRead: false,
+	} {
+		got, err := c.HasPermission(context.Background(), perm, "logs")
+		if err != nil {
+			t.Fatalf("%s: %v", perm, err)
+		}
+		if got != want {
+			t.Errorf("%s: got %v, want %v", perm, got, want)
+		}
+	}
+}
+
+func TestHasPermission_LogsReadByCollector(t *testing.T) {
+	// A Business zone has Instant Logs but no Logpush: the Logpush
+	// endpoints refuse its token whatever its permissions.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/zones/zone-1/logpush/edge/jobs":
+			fmt.Fprint(w, `{"success":true,"result":[]}`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(config.CloudflareConfig{BaseURL: srv.URL}, "zone-1", "tok")
+	if ok, err := c.HasPermission(context.Background(), PermissionLogsRead, "instant"); err != nil || !ok {
+		t.Errorf("instant: got %v, %v; want true", ok, err)
+	}
+	if ok, err := c.HasPermission(context.Background(), PermissionLogsRead, "logs"); err != nil || ok {
+		t.Errorf("logs: got %v, %v; want false", ok, err)
+	}
+}
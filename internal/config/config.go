@@ -59,6 +59,11 @@ type RedisConfig struct {
 type StorageConfig struct {
 	Backend string `mapstructure:"backend"` // "s3", "fs", "multi"
 	FSRoot  string `mapstructure:"fs_root"` // Root directory for filesystem
+	// Where streamed uploads are spooled so a secondary provider can take
+	// them over, and how much of one is spooled; larger uploads go to the
+	// primary only
+	SpoolDir      string `mapstructure:"spool_dir"`
+	SpoolMaxBytes int64  `mapstructure:"spool_max_bytes"`
 }
 
 // S3Config holds credentials for an S3-compatible provider.
@@ -83,12 +88,17 @@ type JWTConfig struct {
 
 type CloudflareConfig struct {
 	// Base API URL – override for testing
-	BaseURL        string        `mapstructure:"base_url"`
+	BaseURL string `mapstructure:"base_url"`
+	// Connect and response header timeout of Cloudflare requests; response
+	// bodies are streamed for as long as the task allows
 	RequestTimeout time.Duration `mapstructure:"request_timeout"`
 	// Max log pull window per request (CF limit: 1h)
 	MaxWindowSize time.Duration `mapstructure:"max_window_size"`
 	// Rate limit for Cloudflare API requests
 	RateLimit float64 `mapstructure:"rate_limit"`
+	// Max GraphQL security events held per poll; larger windows are archived
+	// in several jobs (0 = unlimited)
+	MaxSecurityEvents int `mapstructure:"max_security_events"`
 }
 
 type WorkerConfig struct {
@@ -98,6 +108,45 @@ type WorkerConfig struct {
 	Concurrency int `mapstructure:"concurrency"`
 	// Retention period for log objects in S3 (e.g. 395 days for NIS2 ~13 months)
 	LogRetentionDays int `mapstructure:"log_retention_days"`
+	// Directory for the Instant Logs write-ahead spool (one subdirectory per zone)
+	SpoolDir string `mapstructure:"spool_dir"`
+	// Max bytes spooled per zone; lines are dropped beyond it (0 = unlimited)
+	SpoolMaxBytes int64 `mapstructure:"spool_max_bytes"`
+	// How often spooled lines are fsynced to disk: the lines a host crash
+	// (not a process crash) may lose
+	SpoolSyncInterval time.Duration `mapstructure:"spool_sync_interval"`
+	// Spool usage (fraction of SpoolMaxBytes) that forces an early flush and
+	// counts as backpressure
+	SpoolHighWatermark float64 `mapstructure:"spool_high_watermark"`
+	// Identity of this replica for Instant Logs stream leases (default: hostname + random suffix)
+	ReplicaID string `mapstructure:"replica_id"`
+	// Lifetime of an Instant Logs stream lease; renewed every third of it
+	LeaseTTL time.Duration `mapstructure:"lease_ttl"`
+	// How often each zone's log jobs are scanned for coverage gaps
+	GapScanInterval time.Duration `mapstructure:"gap_scan_interval"`
+	// Age below which missing coverage is not yet a gap (jobs may still be queued)
+	GapScanGrace time.Duration `mapstructure:"gap_scan_grace"`
+	// How often each customer's Cloudflare account is listed for new and deleted zones
+	ZoneDiscoveryInterval time.Duration `mapstructure:"zone_discovery_interval"`
+	// How often each customer's Cloudflare API token and zone permissions are checked
+	TokenCheckInterval time.Duration `mapstructure:"token_check_interval"`
+	// How long before a token expires its customer is alerted (daily)
+	TokenExpiryWarning time.Duration `mapstructure:"token_expiry_warning"`
+	// How often each customer's Cloudflare account audit logs are archived
+	AuditLogInterval time.Duration `mapstructure:"audit_log_interval"`
+	// Consecutive Cloudflare failures that open a customer's circuit breaker
+	// for an API family
+	BreakerThreshold int `mapstructure:"breaker_threshold"`
+	// How long an open circuit breaker refuses calls before letting a probe through
+	BreakerCooldown time.Duration `mapstructure:"breaker_cooldown"`
+	// Compressed object size adaptive Logpull windows are sized to reach
+	AdaptiveWindowTargetBytes int64 `mapstructure:"adaptive_window_target_bytes"`
+	// Log lines per object adaptive windows are sized not to exceed (0 = no limit)
+	AdaptiveWindowTargetLines int64 `mapstructure:"adaptive_window_target_lines"`
+	// Bounds of adaptive Logpull windows; at most 1h, the longest window a
+	// Logpull request (and so an object) covers
+	AdaptiveWindowMin time.Duration `mapstructure:"adaptive_window_min"`
+	AdaptiveWindowMax time.Duration `mapstructure:"adaptive_window_max"`
 }
 type KMSConfig struct {
 	Key       string            `mapstructure:"key"`        // Legacy single key (mapped to "v1")
@@ -128,6 +177,8 @@ func Load() (*Config, error) {
 
 	v.SetDefault("storage.backend", "s3")
 	v.SetDefault("storage.fs_root", "./data/logs")
+	v.SetDefault("storage.spool_dir", "./data/upload-spool")
+	v.SetDefault("storage.spool_max_bytes", 1<<30)
 
 	v.SetDefault("s3.region", "us-east-1")
 	v.SetDefault("s3.endpoint", "")
@@ -146,11 +197,29 @@ func Load() (*Config, error) {
 	v.SetDefault("cloudflare.base_url", "https://api.cloudflare.com/client/v4")
 	v.SetDefault("cloudflare.request_timeout", "30s")
 	v.SetDefault("cloudflare.max_window_size", "1h")
+	v.SetDefault("cloudflare.max_security_events", 100000)
 	v.SetDefault("kms.key", "")
 
 	v.SetDefault("worker.scheduler_interval", "1m")
 	v.SetDefault("worker.concurrency", 10)
 	v.SetDefault("worker.log_retention_days", 395) // ~13 months – beyond NIS2 minimum
+	v.SetDefault("worker.spool_dir", "./data/spool")
+	v.SetDefault("worker.spool_max_bytes", 256<<20)
+	v.SetDefault("worker.spool_sync_interval", "1s")
+	v.SetDefault("worker.spool_high_watermark", 0.8)
+	v.SetDefault("worker.lease_ttl", "90s")
+	v.SetDefault("worker.gap_scan_interval", "1h")
+	v.SetDefault("worker.gap_scan_grace", "1h")
+	v.SetDefault("worker.zone_discovery_interval", "6h")
+	v.SetDefault("worker.token_check_interval", "6h")
+	v.SetDefault("worker.token_expiry_warning", "336h") // 14 days
+	v.SetDefault("worker.audit_log_interval", "1h")
+	v.SetDefault("worker.breaker_threshold", 5)
+	v.SetDefault("worker.breaker_cooldown", "10m")
+	v.SetDefault("worker.adaptive_window_target_bytes", 64<<20)
+	v.SetDefault("worker.adaptive_window_target_lines", 0)
+	v.SetDefault("worker.adaptive_window_min", "5m")
+	v.SetDefault("worker.adaptive_window_max", "1h")
 
 	v.SetDefault("rate_limits.enterprise", 1200) // 1200 reqs/5min (standard Ent)
 	v.SetDefault("rate_limits.business", 600)    // Safe guess
@@ -197,5 +266,37 @@ func Load() (*Config, error) {
 	if _, ok := cfg.KMS.Keys[cfg.KMS.ActiveKey]; !ok {
 		return nil, fmt.Errorf("active key %s not defined in kms.keys", cfg.KMS.ActiveKey)
 	}
+	// 5. Validate worker spool settings
+	if cfg.Worker.SpoolSyncInterval <= 0 {
+		return nil, fmt.Errorf("config: worker.spool_sync_interval must be positive")
+	}
+	if cfg.Worker.SpoolHighWatermark < 0 || cfg.Worker.SpoolHighWatermark > 1 {
+		return nil, fmt.Errorf("config: worker.spool_high_watermark must be between 0 and 1")
+	}
+	if cfg.Worker.LeaseTTL < 3*time.Second {
+		return nil, fmt.Errorf("config: worker.lease_ttl must be at least 3s")
+	}
+	if cfg.Worker.GapScanInterval <= 0 || cfg.Worker.GapScanGrace < 0 {
+		return nil, fmt.Errorf("config: worker.gap_scan_interval must be positive and worker.gap_scan_grace not negative")
+	}
+	if cfg.Worker.ZoneDiscoveryInterval <= 0 {
+		return nil, fmt.Errorf("config: worker.zone_discovery_interval must be positive")
+	}
+	if cfg.Worker.TokenCheckInterval <= 0 || cfg.Worker.TokenExpiryWarning < 0 {
+		return nil, fmt.Errorf("config: worker.token_check_interval must be positive and worker.token_expiry_warning not negative")
+	}
+	if cfg.Worker.AuditLogInterval <= 0 {
+		return nil, fmt.Errorf("config: worker.audit_log_interval must be positive")
+	}
+	if cfg.Worker.BreakerThreshold < 1 || cfg.Worker.BreakerCooldown <= 0 {
+		return nil, fmt.Errorf("config: worker.breaker_threshold must be at least 1 and worker.breaker_cooldown positive")
+	}
+	if cfg.Worker.AdaptiveWindowTargetBytes <= 0 || cfg.Worker.AdaptiveWindowTargetLines < 0 {
+		return nil, fmt.Errorf("config: worker.adaptive_window_target_bytes must be positive and worker.adaptive_window_target_lines not negative")
+	}
+	// An adaptive window is pulled as one Logpull request, which covers at most 1h.
+	if cfg.Worker.AdaptiveWindowMin < time.Minute || cfg.Worker.AdaptiveWindowMax < cfg.Worker.AdaptiveWindowMin || cfg.Worker.AdaptiveWindowMax > time.Hour {
+		return nil, fmt.Errorf("config: worker.adaptive_window_min must be at least 1m and worker.adaptive_window_max between it and 1h")
+	}
 	return &cfg, nil
 }
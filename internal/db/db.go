@@ -15,10 +15,13 @@ type DB struct {
 	Customers   *CustomerRepository
 	APIKeys     *APIKeyRepository
 	Zones       *ZoneRepository
+	Datasets    *ZoneDatasetRepository
 	LogJobs     *LogJobRepository
 	LogObjects  *LogObjectRepository
 	AuditEvents *AuditEventRepository
 	LogExports  *LogExportRepository
+	Backfills   *BackfillRepository
+	CFZones     *CloudflareZoneRepository
 }
 
 // Connect returns a pgxpool.Pool configured from cfg.
@@ -45,10 +48,13 @@ func Connect(ctx context.Context, cfg config.DatabaseConfig) (*DB, error) {
 		Customers:   NewCustomerRepository(pool),
 		APIKeys:     NewAPIKeyRepository(pool),
 		Zones:       NewZoneRepository(pool),
+		Datasets:    NewZoneDatasetRepository(pool),
 		LogJobs:     NewLogJobRepository(pool),
 		LogObjects:  NewLogObjectRepository(pool),
 		AuditEvents: NewAuditEventRepository(pool),
 		LogExports:  NewLogExportRepository(pool),
+		Backfills:   NewBackfillRepository(pool),
+		CFZones:     NewCloudflareZoneRepository(pool),
 	}, nil
 }
 
@@ -2,10 +2,12 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/fabriziosalmi/rainlogs/internal/models"
@@ -19,24 +21,35 @@ func NewCustomerRepository(db *pgxpool.Pool) *CustomerRepository {
 	return &CustomerRepository{db: db}
 }
 
+// customerColumns is the column list shared by every customer SELECT;
+// scanCustomer reads it back.
+const customerColumns = `id,name,email,cf_account_id,cf_api_key_enc,retention_days,quota_bytes,auto_enroll_zones,
+	cf_token_status,cf_token_error,cf_token_expires_at,cf_token_checked_at,cf_token_expiry_alerted_at,
+	audit_logs_archived_until,created_at,updated_at`
+
+func scanCustomer(row rowScanner) (*models.Customer, error) {
+	c := &models.Customer{}
+	err := row.Scan(&c.ID, &c.Name, &c.Email, &c.CFAccountID, &c.CFAPIKeyEnc, &c.RetentionDays, &c.QuotaBytes,
+		&c.AutoEnrollZones,
+		&c.CFTokenStatus, &c.CFTokenError, &c.CFTokenExpiresAt, &c.CFTokenCheckedAt, &c.CFTokenExpiryAlertedAt,
+		&c.AuditLogsArchivedUntil, &c.CreatedAt, &c.UpdatedAt)
+	return c, err
+}
+
 func (r *CustomerRepository) Create(ctx context.Context, c *models.Customer) error {
 	const q = `INSERT INTO customers
-		(id,name,email,cf_account_id,cf_api_key_enc,retention_days,quota_bytes,created_at,updated_at)
-		VALUES($1,$2,$3,$4,$5,$6,$7,now(),now())
+		(id,name,email,cf_account_id,cf_api_key_enc,retention_days,quota_bytes,auto_enroll_zones,created_at,updated_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,now(),now())
 		RETURNING created_at,updated_at`
 	return r.db.QueryRow(ctx, q,
-		c.ID, c.Name, c.Email, c.CFAccountID, c.CFAPIKeyEnc, c.RetentionDays, c.QuotaBytes,
+		c.ID, c.Name, c.Email, c.CFAccountID, c.CFAPIKeyEnc, c.RetentionDays, c.QuotaBytes, c.AutoEnrollZones,
 	).Scan(&c.CreatedAt, &c.UpdatedAt)
 }
 
 func (r *CustomerRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Customer, error) {
-	const q = `SELECT id,name,email,cf_account_id,cf_api_key_enc,retention_days,quota_bytes,created_at,updated_at
+	const q = `SELECT ` + customerColumns + `
 		FROM customers WHERE id=$1 AND deleted_at IS NULL`
-	c := &models.Customer{}
-	err := r.db.QueryRow(ctx, q, id).Scan(
-		&c.ID, &c.Name, &c.Email, &c.CFAccountID, &c.CFAPIKeyEnc, &c.RetentionDays, &c.QuotaBytes,
-		&c.CreatedAt, &c.UpdatedAt,
-	)
+	c, err := scanCustomer(r.db.QueryRow(ctx, q, id))
 	if err != nil {
 		return nil, fmt.Errorf("customer get: %w", err)
 	}
@@ -44,7 +57,7 @@ func (r *CustomerRepository) GetByID(ctx context.Context, id uuid.UUID) (*models
 }
 
 func (r *CustomerRepository) List(ctx context.Context) ([]*models.Customer, error) {
-	const q = `SELECT id,name,email,cf_account_id,cf_api_key_enc,retention_days,quota_bytes,created_at,updated_at
+	const q = `SELECT ` + customerColumns + `
 		FROM customers WHERE deleted_at IS NULL ORDER BY created_at DESC`
 	rows, err := r.db.Query(ctx, q)
 	if err != nil {
@@ -53,9 +66,8 @@ func (r *CustomerRepository) List(ctx context.Context) ([]*models.Customer, erro
 	defer rows.Close()
 	var out []*models.Customer
 	for rows.Next() {
-		c := &models.Customer{}
-		if err := rows.Scan(&c.ID, &c.Name, &c.Email, &c.CFAccountID, &c.CFAPIKeyEnc,
-			&c.RetentionDays, &c.QuotaBytes, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		c, err := scanCustomer(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, c)
@@ -63,6 +75,48 @@ func (r *CustomerRepository) List(ctx context.Context) ([]*models.Customer, erro
 	return out, rows.Err()
 }
 
+// SetAutoEnrollZones turns automatic registration of discovered zones on or off.
+func (r *CustomerRepository) SetAutoEnrollZones(ctx context.Context, id uuid.UUID, enabled bool) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE customers SET auto_enroll_zones=$2, updated_at=now() WHERE id=$1 AND deleted_at IS NULL`,
+		id, enabled,
+	)
+	return err
+}
+
+// UpdateAPIKey replaces the customer's encrypted Cloudflare API token.
+func (r *CustomerRepository) UpdateAPIKey(ctx context.Context, id uuid.UUID, enc string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE customers SET cf_api_key_enc=$2, cf_token_expiry_alerted_at=NULL, updated_at=now()
+		 WHERE id=$1 AND deleted_at IS NULL`,
+		id, enc,
+	)
+	return err
+}
+
+// RecordTokenCheck stores the result of a token preflight.
+func (r *CustomerRepository) RecordTokenCheck(ctx context.Context, id uuid.UUID, status, errMsg string, expiresAt *time.Time) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE customers SET cf_token_status=$2, cf_token_error=$3, cf_token_expires_at=$4, cf_token_checked_at=now()
+		 WHERE id=$1 AND deleted_at IS NULL`,
+		id, status, errMsg, expiresAt,
+	)
+	return err
+}
+
+// MarkTokenExpiryAlerted records that the customer was warned about the
+// token's upcoming expiry.
+func (r *CustomerRepository) MarkTokenExpiryAlerted(ctx context.Context, id uuid.UUID, t time.Time) error {
+	_, err := r.db.Exec(ctx, `UPDATE customers SET cf_token_expiry_alerted_at=$2 WHERE id=$1`, id, t)
+	return err
+}
+
+// SetAuditLogsArchivedUntil advances the customer's audit log high-watermark.
+func (r *CustomerRepository) SetAuditLogsArchivedUntil(ctx context.Context, id uuid.UUID, t time.Time) error {
+	_, err := r.db.Exec(ctx, `UPDATE customers SET audit_logs_archived_until=$2 WHERE id=$1`, id, t)
+	return err
+}
+
 // SoftDelete marks a customer as deleted (GDPR Art. 17 – right to erasure).
 func (r *CustomerRepository) SoftDelete(ctx context.Context, id uuid.UUID) error {
 	_, err := r.db.Exec(ctx,
@@ -174,23 +228,87 @@ func NewZoneRepository(db *pgxpool.Pool) *ZoneRepository {
 	return &ZoneRepository{db: db}
 }
 
+// zoneColumns is the column list shared by every zone SELECT; scanZone reads it back.
+const zoneColumns = `id,customer_id,zone_id,name,plan,pull_interval_secs,last_pulled_at,active,
+	log_fields,field_profile,instant_fields,instant_sample,instant_filter,cf_deleted_at,
+	previous_plan,plan_changed_at,missing_permissions,permissions_checked_at,
+	logpush_secret_hash,logpush_challenge,logpush_challenge_at,cf_api_key_enc,cf_api_key_rotated_at,
+	settle_delay_secs,adaptive_window,window_secs,created_at,
+	cf_token_status,cf_token_error,cf_token_expires_at,cf_token_checked_at,cf_token_expiry_alerted_at`
+
+// tokenUsable is the condition that the last preflight didn't find the
+// Cloudflare token of the zones row zone unusable: the zone's own token, or
+// its customer's when it has none. Collection waits for the token to be
+// replaced rather than failing on every tick.
+func tokenUsable(zone string) string {
+	return `(CASE WHEN ` + zone + `.cf_api_key_enc <> '' THEN ` + zone + `.cf_token_error
+		ELSE (SELECT cf_token_error FROM customers WHERE id=` + zone + `.customer_id) END) = ''`
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanZone(row rowScanner) (*models.Zone, error) {
+	z := &models.Zone{}
+	err := row.Scan(&z.ID, &z.CustomerID, &z.ZoneID, &z.Name, &z.Plan,
+		&z.PullIntervalSecs, &z.LastPulledAt, &z.Active,
+		&z.LogFields, &z.FieldProfile,
+		&z.InstantFields, &z.InstantSample, &z.InstantFilter, &z.CFDeletedAt,
+		&z.PreviousPlan, &z.PlanChangedAt, &z.MissingPermissions, &z.PermissionsCheckedAt,
+		&z.LogpushSecretHash, &z.LogpushChallenge, &z.LogpushChallengeAt,
+		&z.CFAPIKeyEnc, &z.CFAPIKeyRotatedAt, &z.SettleDelaySecs,
+		&z.AdaptiveWindow, &z.WindowSecs, &z.CreatedAt,
+		&z.CFTokenStatus, &z.CFTokenError, &z.CFTokenExpiresAt, &z.CFTokenCheckedAt, &z.CFTokenExpiryAlertedAt)
+	return z, err
+}
+
+// textArray maps a nil slice to an empty array so NOT NULL TEXT[] columns accept it.
+func textArray(v []string) []string {
+	if v == nil {
+		return []string{}
+	}
+	return v
+}
+
 func (r *ZoneRepository) Create(ctx context.Context, z *models.Zone) error {
-	const q = `INSERT INTO zones(id,customer_id,zone_id,name,plan,pull_interval_secs,last_pulled_at,active,created_at)
-		VALUES($1,$2,$3,$4,$5,$6,$7,$8,now()) RETURNING created_at`
+	const q = `INSERT INTO zones(id,customer_id,zone_id,name,plan,pull_interval_secs,last_pulled_at,active,
+			log_fields,field_profile,instant_fields,instant_sample,instant_filter,
+			cf_api_key_enc,cf_api_key_rotated_at,settle_delay_secs,adaptive_window,created_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,CASE WHEN $14 <> '' THEN now() END,$15,$16,now())
+		RETURNING cf_api_key_rotated_at,created_at`
 	if z.Plan == "" {
 		z.Plan = models.PlanEnterprise
 	}
 	return r.db.QueryRow(ctx, q,
 		z.ID, z.CustomerID, z.ZoneID, z.Name, z.Plan, z.PullIntervalSecs, z.LastPulledAt, z.Active,
+		textArray(z.LogFields), z.FieldProfile,
+		textArray(z.InstantFields), z.InstantSample, z.InstantFilter,
+		z.CFAPIKeyEnc, z.SettleDelaySecs, z.AdaptiveWindow,
+	).Scan(&z.CFAPIKeyRotatedAt, &z.CreatedAt)
+}
+
+// CreateIfAbsent inserts z unless the customer already has a row for its
+// Cloudflare zone ID, including a soft-deleted one. It reports whether z was
+// inserted.
+func (r *ZoneRepository) CreateIfAbsent(ctx context.Context, z *models.Zone) (bool, error) {
+	const q = `INSERT INTO zones(id,customer_id,zone_id,name,plan,pull_interval_secs,active,created_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,now())
+		ON CONFLICT (customer_id, zone_id) DO NOTHING
+		RETURNING created_at`
+	err := r.db.QueryRow(ctx, q,
+		z.ID, z.CustomerID, z.ZoneID, z.Name, z.Plan, z.PullIntervalSecs, z.Active,
 	).Scan(&z.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
 }
 
 func (r *ZoneRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Zone, error) {
-	const q = `SELECT id,customer_id,zone_id,name,plan,pull_interval_secs,last_pulled_at,active,created_at
+	const q = `SELECT ` + zoneColumns + `
 		FROM zones WHERE id=$1 AND deleted_at IS NULL`
-	z := &models.Zone{}
-	err := r.db.QueryRow(ctx, q, id).Scan(&z.ID, &z.CustomerID, &z.ZoneID, &z.Name, &z.Plan,
-		&z.PullIntervalSecs, &z.LastPulledAt, &z.Active, &z.CreatedAt)
+	z, err := scanZone(r.db.QueryRow(ctx, q, id))
 	if err != nil {
 		return nil, fmt.Errorf("zone get: %w", err)
 	}
@@ -198,18 +316,22 @@ func (r *ZoneRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Zon
 }
 
 func (r *ZoneRepository) ListByCustomer(ctx context.Context, customerID uuid.UUID) ([]*models.Zone, error) {
-	const q = `SELECT id,customer_id,zone_id,name,plan,pull_interval_secs,last_pulled_at,active,created_at
+	const q = `SELECT ` + zoneColumns + `
 		FROM zones WHERE customer_id=$1 AND deleted_at IS NULL`
 	return r.scanZones(ctx, q, customerID)
 }
 
+// ListDue returns the active zones with a usable token whose pull interval,
+// or adaptive window once sized, has passed since their last pull.
 func (r *ZoneRepository) ListDue(ctx context.Context) ([]*models.Zone, error) {
-	const q = `SELECT id,customer_id,zone_id,name,plan,pull_interval_secs,last_pulled_at,active,created_at
+	q := `SELECT ` + zoneColumns + `
 		FROM zones
 		WHERE active=true
 		  AND deleted_at IS NULL
+		  AND ` + tokenUsable("zones") + `
 		  AND (last_pulled_at IS NULL OR
-		       last_pulled_at < now() - (pull_interval_secs || ' seconds')::interval)`
+		       last_pulled_at < now() - make_interval(secs =>
+		           CASE WHEN adaptive_window AND window_secs > 0 THEN window_secs ELSE pull_interval_secs END))`
 	return r.scanZones(ctx, q)
 }
 
@@ -218,6 +340,12 @@ func (r *ZoneRepository) UpdateLastPulled(ctx context.Context, id uuid.UUID, t t
 	return err
 }
 
+// UpdateWindow stores the adaptive window size the scheduler chose for a zone.
+func (r *ZoneRepository) UpdateWindow(ctx context.Context, id uuid.UUID, secs int) error {
+	_, err := r.db.Exec(ctx, `UPDATE zones SET window_secs=$2 WHERE id=$1`, id, secs)
+	return err
+}
+
 // Delete soft-deletes a zone (GDPR Art. 17 – schema already has deleted_at column).
 func (r *ZoneRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	_, err := r.db.Exec(ctx,
@@ -227,16 +355,118 @@ func (r *ZoneRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
-// Update patches mutable zone fields. Only supply the new values for name, plan, intervalSecs, active.
-func (r *ZoneRepository) Update(ctx context.Context, zoneID, customerID uuid.UUID, name string, plan models.PlanType, intervalSecs int, active bool) error {
+// Update persists the mutable fields of z, scoped to its owning customer.
+// The plan is not one of them: it changes through SwitchPlan.
+func (r *ZoneRepository) Update(ctx context.Context, z *models.Zone) error {
 	_, err := r.db.Exec(ctx,
-		`UPDATE zones SET name=$3, plan=$4, pull_interval_secs=$5, active=$6, updated_at=now()
+		`UPDATE zones SET name=$3, pull_interval_secs=$4, active=$5,
+			log_fields=$6, field_profile=$7,
+			instant_fields=$8, instant_sample=$9, instant_filter=$10, settle_delay_secs=$11,
+			adaptive_window=$12, updated_at=now()
 		 WHERE id=$1 AND customer_id=$2 AND deleted_at IS NULL`,
-		zoneID, customerID, name, plan, intervalSecs, active,
+		z.ID, z.CustomerID, z.Name, z.PullIntervalSecs, z.Active,
+		textArray(z.LogFields), z.FieldProfile,
+		textArray(z.InstantFields), z.InstantSample, z.InstantFilter, z.SettleDelaySecs,
+		z.AdaptiveWindow,
+	)
+	return err
+}
+
+// SyncCFDeleted flags the customer's zones missing from present, the Cloudflare
+// zone IDs currently in the account, and clears the flag on zones that are
+// back. It returns the zones flagged by this call.
+func (r *ZoneRepository) SyncCFDeleted(ctx context.Context, customerID uuid.UUID, present []string) ([]*models.Zone, error) {
+	if _, err := r.db.Exec(ctx,
+		`UPDATE zones SET cf_deleted_at=NULL, updated_at=now()
+		 WHERE customer_id=$1 AND zone_id = ANY($2) AND cf_deleted_at IS NOT NULL`,
+		customerID, textArray(present),
+	); err != nil {
+		return nil, err
+	}
+	const q = `UPDATE zones SET cf_deleted_at=now(), updated_at=now()
+		WHERE customer_id=$1 AND deleted_at IS NULL AND cf_deleted_at IS NULL
+		  AND NOT (zone_id = ANY($2))
+		RETURNING ` + zoneColumns
+	return r.scanZones(ctx, q, customerID, textArray(present))
+}
+
+// SetMissingPermissions stores the permissions a preflight found missing for
+// the zone's collector.
+func (r *ZoneRepository) SetMissingPermissions(ctx context.Context, id uuid.UUID, missing []string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE zones SET missing_permissions=$2, permissions_checked_at=now() WHERE id=$1`,
+		id, textArray(missing),
 	)
 	return err
 }
 
+// SetCFAPIKey stores the zone's encrypted Cloudflare API token; an empty
+// token makes the zone fall back to its customer's. The previous token's
+// check results are cleared.
+func (r *ZoneRepository) SetCFAPIKey(ctx context.Context, id uuid.UUID, enc string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE zones SET cf_api_key_enc=$2, cf_api_key_rotated_at=CASE WHEN $2 <> '' THEN now() END,
+			cf_token_status='', cf_token_error='', cf_token_expires_at=NULL, cf_token_checked_at=NULL,
+			cf_token_expiry_alerted_at=NULL, updated_at=now()
+		 WHERE id=$1 AND deleted_at IS NULL`,
+		id, enc,
+	)
+	return err
+}
+
+// RecordTokenCheck stores the result of a preflight of the zone's own token.
+func (r *ZoneRepository) RecordTokenCheck(ctx context.Context, id uuid.UUID, status, errMsg string, expiresAt *time.Time) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE zones SET cf_token_status=$2, cf_token_error=$3, cf_token_expires_at=$4, cf_token_checked_at=now()
+		 WHERE id=$1 AND deleted_at IS NULL`,
+		id, status, errMsg, expiresAt,
+	)
+	return err
+}
+
+// MarkTokenExpiryAlerted records that the customer was warned about the
+// upcoming expiry of the zone's token.
+func (r *ZoneRepository) MarkTokenExpiryAlerted(ctx context.Context, id uuid.UUID, t time.Time) error {
+	_, err := r.db.Exec(ctx, `UPDATE zones SET cf_token_expiry_alerted_at=$2 WHERE id=$1`, id, t)
+	return err
+}
+
+// SetLogpushSecret stores the hash of the zone's Logpush secret; an empty
+// hash turns the receiver off for the zone.
+func (r *ZoneRepository) SetLogpushSecret(ctx context.Context, id uuid.UUID, hash string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE zones SET logpush_secret_hash=$2, updated_at=now() WHERE id=$1 AND deleted_at IS NULL`,
+		id, hash,
+	)
+	return err
+}
+
+// SetLogpushChallenge stores an ownership challenge delivered for the zone.
+func (r *ZoneRepository) SetLogpushChallenge(ctx context.Context, id uuid.UUID, challenge string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE zones SET logpush_challenge=$2, logpush_challenge_at=now() WHERE id=$1`,
+		id, challenge,
+	)
+	return err
+}
+
+// SwitchPlan moves a zone from plan `from` to `to`, with the new collector
+// taking over at handover. lastPulled, when non-nil, replaces last_pulled_at so
+// the scheduler resumes at the handover. It reports false when the zone's plan
+// is no longer `from`.
+func (r *ZoneRepository) SwitchPlan(ctx context.Context, id uuid.UUID, from, to models.PlanType, handover time.Time, lastPulled *time.Time) (bool, error) {
+	tag, err := r.db.Exec(ctx,
+		`UPDATE zones SET plan=$3, previous_plan=$2, plan_changed_at=$4,
+			last_pulled_at=COALESCE($5, last_pulled_at), updated_at=now()
+		 WHERE id=$1 AND plan=$2 AND deleted_at IS NULL`,
+		id, from, to, handover, lastPulled,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
 // SoftDeleteByCustomer soft-deletes all non-deleted zones owned by a customer.
 func (r *ZoneRepository) SoftDeleteByCustomer(ctx context.Context, customerID uuid.UUID) error {
 	_, err := r.db.Exec(ctx,
@@ -255,9 +485,63 @@ func (r *ZoneRepository) scanZones(ctx context.Context, q string, args ...interf
 	defer rows.Close()
 	var out []*models.Zone
 	for rows.Next() {
-		z := &models.Zone{}
-		if err := rows.Scan(&z.ID, &z.CustomerID, &z.ZoneID, &z.Name, &z.Plan,
-			&z.PullIntervalSecs, &z.LastPulledAt, &z.Active, &z.CreatedAt); err != nil {
+		z, err := scanZone(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, z)
+	}
+	return out, rows.Err()
+}
+
+// ── CloudflareZoneRepository ──────────────────────────────────────────────────
+
+type CloudflareZoneRepository struct{ db *pgxpool.Pool }
+
+func NewCloudflareZoneRepository(db *pgxpool.Pool) *CloudflareZoneRepository {
+	return &CloudflareZoneRepository{db: db}
+}
+
+// Upsert records z as seen now, clearing any earlier deletion.
+func (r *CloudflareZoneRepository) Upsert(ctx context.Context, z *models.CloudflareZone) error {
+	const q = `INSERT INTO cloudflare_zones
+		(customer_id,cf_zone_id,name,status,cf_plan,plan,first_seen_at,last_seen_at)
+		VALUES($1,$2,$3,$4,$5,$6,now(),now())
+		ON CONFLICT (customer_id, cf_zone_id) DO UPDATE SET
+			name=EXCLUDED.name, status=EXCLUDED.status, cf_plan=EXCLUDED.cf_plan,
+			plan=EXCLUDED.plan, last_seen_at=now(), deleted_at=NULL
+		RETURNING first_seen_at,last_seen_at`
+	z.DeletedAt = nil
+	return r.db.QueryRow(ctx, q,
+		z.CustomerID, z.CFZoneID, z.Name, z.Status, z.CFPlan, z.Plan,
+	).Scan(&z.FirstSeenAt, &z.LastSeenAt)
+}
+
+// MarkDeleted marks the customer's discovered zones missing from present as
+// deleted in Cloudflare.
+func (r *CloudflareZoneRepository) MarkDeleted(ctx context.Context, customerID uuid.UUID, present []string) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE cloudflare_zones SET deleted_at=now()
+		 WHERE customer_id=$1 AND deleted_at IS NULL AND NOT (cf_zone_id = ANY($2))`,
+		customerID, textArray(present),
+	)
+	return err
+}
+
+// ListByCustomer returns the customer's discovered zones by name.
+func (r *CloudflareZoneRepository) ListByCustomer(ctx context.Context, customerID uuid.UUID) ([]*models.CloudflareZone, error) {
+	const q = `SELECT customer_id,cf_zone_id,name,status,cf_plan,plan,first_seen_at,last_seen_at,deleted_at
+		FROM cloudflare_zones WHERE customer_id=$1 ORDER BY name`
+	rows, err := r.db.Query(ctx, q, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*models.CloudflareZone
+	for rows.Next() {
+		z := &models.CloudflareZone{}
+		if err := rows.Scan(&z.CustomerID, &z.CFZoneID, &z.Name, &z.Status, &z.CFPlan, &z.Plan,
+			&z.FirstSeenAt, &z.LastSeenAt, &z.DeletedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, z)
@@ -273,69 +557,232 @@ func NewLogJobRepository(db *pgxpool.Pool) *LogJobRepository {
 	return &LogJobRepository{db: db}
 }
 
+// logJobColumns is the column list shared by every job SELECT; scanJob reads
+// it back.
+const logJobColumns = `id,zone_id,customer_id,period_start,period_end,log_type,dataset,status,
+	s3_key,s3_provider,sha256,chain_hash,byte_count,log_count,attempts,err_msg,verified_at,
+	settles_job_id,settled_at,window_secs,created_at,updated_at`
+
+func scanJob(row rowScanner) (*models.LogJob, error) {
+	j := &models.LogJob{}
+	err := row.Scan(&j.ID, &j.ZoneID, &j.CustomerID, &j.PeriodStart, &j.PeriodEnd,
+		&j.LogType, &j.Dataset, &j.Status, &j.S3Key, &j.S3Provider, &j.SHA256, &j.ChainHash, &j.ByteCount,
+		&j.LogCount, &j.Attempts, &j.ErrMsg, &j.VerifiedAt,
+		&j.SettlesJobID, &j.SettledAt, &j.WindowSecs, &j.CreatedAt, &j.UpdatedAt)
+	return j, err
+}
+
 func (r *LogJobRepository) Create(ctx context.Context, j *models.LogJob) error {
 	const q = `INSERT INTO log_jobs
-		(id,zone_id,customer_id,period_start,period_end,status,created_at,updated_at)
-		VALUES($1,$2,$3,$4,$5,$6,now(),now())
+		(id,zone_id,customer_id,period_start,period_end,log_type,dataset,status,settles_job_id,window_secs,created_at,updated_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,now(),now())
 		RETURNING created_at,updated_at`
 	return r.db.QueryRow(ctx, q,
-		j.ID, j.ZoneID, j.CustomerID, j.PeriodStart, j.PeriodEnd, j.Status,
+		j.ID, nullZone(j.ZoneID), j.CustomerID, j.PeriodStart, j.PeriodEnd, j.LogType, j.Dataset, j.Status,
+		j.SettlesJobID, j.WindowSecs,
+	).Scan(&j.CreatedAt, &j.UpdatedAt)
+}
+
+// CreateIfAbsent inserts j unless a job with its ID exists. It reports whether
+// j was inserted.
+func (r *LogJobRepository) CreateIfAbsent(ctx context.Context, j *models.LogJob) (bool, error) {
+	const q = `INSERT INTO log_jobs
+		(id,zone_id,customer_id,period_start,period_end,log_type,dataset,status,settles_job_id,window_secs,created_at,updated_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,now(),now())
+		ON CONFLICT (id) DO NOTHING
+		RETURNING created_at,updated_at`
+	err := r.db.QueryRow(ctx, q,
+		j.ID, nullZone(j.ZoneID), j.CustomerID, j.PeriodStart, j.PeriodEnd, j.LogType, j.Dataset, j.Status,
+		j.SettlesJobID, j.WindowSecs,
 	).Scan(&j.CreatedAt, &j.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
 }
 
 func (r *LogJobRepository) Update(ctx context.Context, j *models.LogJob) error {
 	const q = `UPDATE log_jobs SET
 		status=$2, s3_key=$3, s3_provider=$4, sha256=$5,
 		chain_hash=$6, byte_count=$7, log_count=$8, err_msg=$9,
-		attempts=$10, verified_at=$11, updated_at=now()
+		attempts=$10, verified_at=$11, period_start=$12, period_end=$13, updated_at=now()
 		WHERE id=$1`
 	_, err := r.db.Exec(ctx, q,
 		j.ID, j.Status, j.S3Key, j.S3Provider, j.SHA256,
 		j.ChainHash, j.ByteCount, j.LogCount, j.ErrMsg, j.Attempts, j.VerifiedAt,
+		j.PeriodStart, j.PeriodEnd,
 	)
 	return err
 }
 
+// FinishChained marks j done and links it into the chain of its zone and
+// dataset, or of its customer and dataset for account-level jobs. link maps
+// the chain hash of the job linked last (empty for the first) to j's. Jobs of
+// a chain finish one at a time under an advisory lock, so concurrent producers
+// never link two jobs to the same predecessor.
+func (r *LogJobRepository) FinishChained(ctx context.Context, j *models.LogJob, link func(prevChainHash string) string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck // no-op after commit
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended(COALESCE($1::uuid, $3::uuid)::text || '/' || $2, 0))`,
+		nullZone(j.ZoneID), j.Dataset, j.CustomerID); err != nil {
+		return err
+	}
+	var prev string
+	err = tx.QueryRow(ctx,
+		`SELECT chain_hash FROM log_jobs
+		 WHERE zone_id IS NOT DISTINCT FROM $1 AND customer_id=$3 AND dataset=$2 AND status='done'
+		 ORDER BY chained_at DESC NULLS LAST, created_at DESC, id DESC LIMIT 1`, nullZone(j.ZoneID), j.Dataset, j.CustomerID,
+	).Scan(&prev)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	chainHash := link(prev)
+	if _, err := tx.Exec(ctx,
+		`UPDATE log_jobs SET status=$2, s3_key=$3, s3_provider=$4, sha256=$5,
+			chain_hash=$6, byte_count=$7, log_count=$8, chained_at=clock_timestamp(), updated_at=now()
+		 WHERE id=$1`,
+		j.ID, models.JobStatusDone, j.S3Key, j.S3Provider, j.SHA256, chainHash, j.ByteCount, j.LogCount,
+	); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	j.Status, j.ChainHash = models.JobStatusDone, chainHash
+	return nil
+}
+
 func (r *LogJobRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.LogJob, error) {
-	const q = `SELECT id,zone_id,customer_id,period_start,period_end,status,
-			s3_key,s3_provider,sha256,chain_hash,byte_count,log_count,attempts,err_msg,verified_at,created_at,updated_at
-		FROM log_jobs WHERE id=$1`
-	j := &models.LogJob{}
-	err := r.db.QueryRow(ctx, q, id).Scan(
-		&j.ID, &j.ZoneID, &j.CustomerID, &j.PeriodStart, &j.PeriodEnd, &j.Status,
-		&j.S3Key, &j.S3Provider, &j.SHA256, &j.ChainHash, &j.ByteCount, &j.LogCount,
-		&j.Attempts, &j.ErrMsg, &j.VerifiedAt, &j.CreatedAt, &j.UpdatedAt,
-	)
+	const q = `SELECT ` + logJobColumns + ` FROM log_jobs WHERE id=$1`
+	j, err := scanJob(r.db.QueryRow(ctx, q, id))
 	if err != nil {
 		return nil, fmt.Errorf("log_job get: %w", err)
 	}
 	return j, nil
 }
 
-func (r *LogJobRepository) ListByCustomer(ctx context.Context, customerID uuid.UUID, limit, offset int) ([]*models.LogJob, error) {
-	const q = `SELECT id,zone_id,customer_id,period_start,period_end,status,
-			s3_key,s3_provider,sha256,chain_hash,byte_count,log_count,attempts,err_msg,verified_at,created_at,updated_at
-		FROM log_jobs WHERE customer_id=$1 ORDER BY created_at DESC LIMIT $2 OFFSET $3`
-	return r.scanJobs(ctx, q, customerID, limit, offset)
+// ListByCustomer returns the customer's jobs, newest first, of dataset when it
+// is not empty.
+func (r *LogJobRepository) ListByCustomer(ctx context.Context, customerID uuid.UUID, dataset string, limit, offset int) ([]*models.LogJob, error) {
+	const q = `SELECT ` + logJobColumns + `
+		FROM log_jobs WHERE customer_id=$1 AND ($2='' OR dataset=$2)
+		ORDER BY created_at DESC LIMIT $3 OFFSET $4`
+	return r.scanJobs(ctx, q, customerID, dataset, limit, offset)
 }
 
-// ListExpired returns done jobs older than retentionDays (GDPR art.17).
+// ListExpired returns done jobs older than retentionDays, or than the
+// retention configured for their zone's dataset (GDPR art.17).
 func (r *LogJobRepository) ListExpired(ctx context.Context, customerID uuid.UUID, retentionDays int) ([]*models.LogJob, error) {
-	const q = `SELECT id,zone_id,customer_id,period_start,period_end,status,
-			s3_key,s3_provider,sha256,chain_hash,byte_count,log_count,attempts,err_msg,verified_at,created_at,updated_at
-		FROM log_jobs
-		WHERE customer_id=$1
-		  AND status=$2
-		  AND period_end < now() - ($3 || ' days')::interval`
+	const q = `SELECT ` + logJobColumns + `
+		FROM log_jobs j
+		WHERE j.customer_id=$1
+		  AND j.status=$2
+		  AND j.period_end < now() - (COALESCE(
+			(SELECT NULLIF(zd.retention_days, 0) FROM zone_datasets zd WHERE zd.zone_id=j.zone_id AND zd.dataset=j.dataset),
+			$3) || ' days')::interval`
 	return r.scanJobs(ctx, q, customerID, models.JobStatusDone, retentionDays)
 }
 
-// ListByZone returns jobs for a specific zone owned by customerID.
-func (r *LogJobRepository) ListByZone(ctx context.Context, customerID, zoneID uuid.UUID, limit, offset int) ([]*models.LogJob, error) {
-	const q = `SELECT id,zone_id,customer_id,period_start,period_end,status,
-			s3_key,s3_provider,sha256,chain_hash,byte_count,log_count,attempts,err_msg,verified_at,created_at,updated_at
-		FROM log_jobs WHERE customer_id=$1 AND zone_id=$2 ORDER BY created_at DESC LIMIT $3 OFFSET $4`
-	return r.scanJobs(ctx, q, customerID, zoneID, limit, offset)
+// ListSettleDue returns up to limit done Logpull jobs of zones in settle mode
+// whose window is due a re-pull: the zone's settle delay has passed since the
+// window ended. Windows starting before since, which Logpull no longer holds,
+// are left out, as are zones whose token is unusable.
+func (r *LogJobRepository) ListSettleDue(ctx context.Context, since time.Time, limit int) ([]*models.LogJob, error) {
+	q := `SELECT ` + logJobColumns + `
+		FROM log_jobs j
+		WHERE j.log_type=$1 AND j.status='done' AND j.settled_at IS NULL AND j.settles_job_id IS NULL
+		  AND j.period_start >= $2
+		  AND EXISTS (
+			SELECT 1 FROM zones z
+			WHERE z.id=j.zone_id AND z.active AND z.deleted_at IS NULL AND z.settle_delay_secs > 0
+			  AND ` + tokenUsable("z") + `
+			  AND j.period_end <= now() - make_interval(secs => z.settle_delay_secs))
+		ORDER BY j.period_end LIMIT $3`
+	return r.scanJobs(ctx, q, models.LogTypeLogpull, since, limit)
+}
+
+// ListRecentDone returns the zone's last limit done jobs of logType, newest
+// first, leaving out the supplementary jobs of settle re-pulls.
+func (r *LogJobRepository) ListRecentDone(ctx context.Context, zoneID uuid.UUID, logType string, limit int) ([]*models.LogJob, error) {
+	const q = `SELECT ` + logJobColumns + `
+		FROM log_jobs WHERE zone_id=$1 AND log_type=$2 AND status='done' AND settles_job_id IS NULL
+		ORDER BY period_end DESC LIMIT $3`
+	return r.scanJobs(ctx, q, zoneID, logType, limit)
+}
+
+// GetSettlement returns the done supplementary job that settled jobID, or nil
+// when there is none.
+func (r *LogJobRepository) GetSettlement(ctx context.Context, jobID uuid.UUID) (*models.LogJob, error) {
+	const q = `SELECT ` + logJobColumns + `
+		FROM log_jobs WHERE settles_job_id=$1 AND status='done' LIMIT 1`
+	j, err := scanJob(r.db.QueryRow(ctx, q, jobID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return j, err
+}
+
+// MarkSettled records that a job's window was re-pulled and its late lines,
+// if any, archived.
+func (r *LogJobRepository) MarkSettled(ctx context.Context, id uuid.UUID) error {
+	_, err := r.db.Exec(ctx, `UPDATE log_jobs SET settled_at=now(), updated_at=now() WHERE id=$1`, id)
+	return err
+}
+
+// ListByZone returns jobs for a specific zone owned by customerID, of dataset
+// when it is not empty.
+func (r *LogJobRepository) ListByZone(ctx context.Context, customerID, zoneID uuid.UUID, dataset string, limit, offset int) ([]*models.LogJob, error) {
+	const q = `SELECT ` + logJobColumns + `
+		FROM log_jobs WHERE customer_id=$1 AND zone_id=$2 AND ($3='' OR dataset=$3)
+		ORDER BY created_at DESC LIMIT $4 OFFSET $5`
+	return r.scanJobs(ctx, q, customerID, zoneID, dataset, limit, offset)
+}
+
+// HasDoneWindow reports whether the zone already has a done job of logType for
+// exactly [start, end).
+func (r *LogJobRepository) HasDoneWindow(ctx context.Context, zoneID uuid.UUID, logType string, start, end time.Time) (bool, error) {
+	const q = `SELECT EXISTS(
+		SELECT 1 FROM log_jobs
+		WHERE zone_id=$1 AND log_type=$2 AND period_start=$3 AND period_end=$4 AND status='done')`
+	var exists bool
+	err := r.db.QueryRow(ctx, q, zoneID, logType, start, end).Scan(&exists)
+	return exists, err
+}
+
+// ListPeriods returns the period and status of the zone's logType jobs
+// overlapping [from, to), ordered by period start.
+func (r *LogJobRepository) ListPeriods(ctx context.Context, zoneID uuid.UUID, logType string, from, to time.Time) ([]models.JobPeriod, error) {
+	const q = `SELECT period_start, period_end, status, updated_at FROM log_jobs
+		WHERE zone_id=$1 AND log_type=$2 AND period_start < $4 AND period_end > $3
+		ORDER BY period_start`
+	rows, err := r.db.Query(ctx, q, zoneID, logType, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []models.JobPeriod
+	for rows.Next() {
+		var p models.JobPeriod
+		if err := rows.Scan(&p.Start, &p.End, &p.Status, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// FirstPeriodStart returns the start of the zone's earliest logType job, or nil
+// when it has none.
+func (r *LogJobRepository) FirstPeriodStart(ctx context.Context, zoneID uuid.UUID, logType string) (*time.Time, error) {
+	const q = `SELECT MIN(period_start) FROM log_jobs WHERE zone_id=$1 AND log_type=$2`
+	var t *time.Time
+	err := r.db.QueryRow(ctx, q, zoneID, logType).Scan(&t)
+	return t, err
 }
 
 // MarkExpired sets a job's status to expired after S3 object deletion.
@@ -370,14 +817,38 @@ func (r *LogJobRepository) MarkVerified(ctx context.Context, id uuid.UUID) error
 	return err
 }
 
-func (r *LogJobRepository) ListForExport(ctx context.Context, customerID uuid.UUID, start, end time.Time) ([]*models.LogJob, error) {
-	const q = `SELECT id,zone_id,customer_id,period_start,period_end,status,
-			s3_key,s3_provider,sha256,chain_hash,byte_count,log_count,attempts,err_msg,verified_at,created_at,updated_at
+// ListForExport returns the customer's done jobs within [start, end), of the
+// given datasets when there are any.
+func (r *LogJobRepository) ListForExport(ctx context.Context, customerID uuid.UUID, start, end time.Time, datasets []string) ([]*models.LogJob, error) {
+	const q = `SELECT ` + logJobColumns + `
 		FROM log_jobs
 		WHERE customer_id=$1
 		  AND status='done'
-		  AND period_start >= $2 AND period_end <= $3`
-	return r.scanJobs(ctx, q, customerID, start, end)
+		  AND period_start >= $2 AND period_end <= $3
+		  AND (cardinality($4::text[]) = 0 OR dataset = ANY($4))`
+	return r.scanJobs(ctx, q, customerID, start, end, textArray(datasets))
+}
+
+// ListArchived returns up to limit done jobs of the customer's dataset with
+// an archived object overlapping [from, to), newest first, of zoneID unless it
+// is uuid.Nil.
+func (r *LogJobRepository) ListArchived(ctx context.Context, customerID, zoneID uuid.UUID, dataset string, from, to time.Time, limit int) ([]*models.LogJob, error) {
+	const q = `SELECT ` + logJobColumns + `
+		FROM log_jobs
+		WHERE customer_id=$1 AND ($2::uuid = '00000000-0000-0000-0000-000000000000' OR zone_id=$2)
+		  AND dataset=$3 AND status='done' AND s3_key <> ''
+		  AND period_end > $4 AND period_start < $5
+		ORDER BY period_end DESC LIMIT $6`
+	return r.scanJobs(ctx, q, customerID, zoneID, dataset, from, to, limit)
+}
+
+// nullZone maps the zone of an account-level job to NULL. NULL zone_ids scan
+// back as uuid.Nil.
+func nullZone(id uuid.UUID) *uuid.UUID {
+	if id == uuid.Nil {
+		return nil
+	}
+	return &id
 }
 
 func (r *LogJobRepository) scanJobs(ctx context.Context, q string, args ...interface{}) ([]*models.LogJob, error) {
@@ -388,10 +859,8 @@ func (r *LogJobRepository) scanJobs(ctx context.Context, q string, args ...inter
 	defer rows.Close()
 	var out []*models.LogJob
 	for rows.Next() {
-		j := &models.LogJob{}
-		if err := rows.Scan(&j.ID, &j.ZoneID, &j.CustomerID, &j.PeriodStart, &j.PeriodEnd,
-			&j.Status, &j.S3Key, &j.S3Provider, &j.SHA256, &j.ChainHash, &j.ByteCount,
-			&j.LogCount, &j.Attempts, &j.ErrMsg, &j.VerifiedAt, &j.CreatedAt, &j.UpdatedAt); err != nil {
+		j, err := scanJob(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, j)
@@ -413,22 +882,6 @@ func (r *LogObjectRepository) Create(ctx context.Context, o *models.LogObject) e
 	return r.db.QueryRow(ctx, q, o.ID, o.JobID, o.S3Key, o.SHA256, o.ByteCount).Scan(&o.CreatedAt)
 }
 
-func (r *LogJobRepository) GetLastJob(ctx context.Context, zoneID uuid.UUID) (*models.LogJob, error) {
-	const q = `SELECT id,zone_id,customer_id,period_start,period_end,status,
-			s3_key,s3_provider,sha256,chain_hash,byte_count,log_count,attempts,err_msg,verified_at,created_at,updated_at
-		FROM log_jobs WHERE zone_id=$1 AND status='done' ORDER BY created_at DESC, id DESC LIMIT 1`
-	j := &models.LogJob{}
-	err := r.db.QueryRow(ctx, q, zoneID).Scan(
-		&j.ID, &j.ZoneID, &j.CustomerID, &j.PeriodStart, &j.PeriodEnd, &j.Status,
-		&j.S3Key, &j.S3Provider, &j.SHA256, &j.ChainHash, &j.ByteCount, &j.LogCount,
-		&j.Attempts, &j.ErrMsg, &j.VerifiedAt, &j.CreatedAt, &j.UpdatedAt,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return j, nil
-}
-
 // ── AuditEventRepository ─────────────────────────────────────────────────────
 
 type AuditEventRepository struct{ db *pgxpool.Pool }
@@ -472,11 +925,11 @@ func (r *AuditEventRepository) ListByCustomer(ctx context.Context, customerID uu
 	return out, rows.Err()
 }
 
-// ListActive returns all active zones.
-func (r *ZoneRepository) ListActive(ctx context.Context) ([]*models.Zone, error) {
-	const q = `SELECT id,customer_id,zone_id,name,plan,pull_interval_secs,last_pulled_at,active,created_at
+// ListCollectable returns the active zones whose token is usable.
+func (r *ZoneRepository) ListCollectable(ctx context.Context) ([]*models.Zone, error) {
+	q := `SELECT ` + zoneColumns + `
 		FROM zones
-		WHERE active = true AND deleted_at IS NULL`
+		WHERE active = true AND deleted_at IS NULL AND ` + tokenUsable("zones")
 	return r.scanZones(ctx, q)
 }
 
@@ -489,10 +942,12 @@ func NewLogExportRepository(db *pgxpool.Pool) *LogExportRepository {
 }
 
 func (r *LogExportRepository) Create(ctx context.Context, e *models.LogExport) error {
-	const q = `INSERT INTO log_exports(id,customer_id,s3_config_enc,filter_start,filter_end,status,created_at,updated_at)
-		VALUES($1,$2,$3,$4,$5,$6,now(),now())
+	const q = `INSERT INTO log_exports(id,customer_id,s3_config_enc,filter_start,filter_end,filter_datasets,status,created_at,updated_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,now(),now())
 		RETURNING created_at,updated_at`
-	return r.db.QueryRow(ctx, q, e.ID, e.CustomerID, e.S3ConfigEnc, e.FilterStart, e.FilterEnd, e.Status).Scan(&e.CreatedAt, &e.UpdatedAt)
+	return r.db.QueryRow(ctx, q,
+		e.ID, e.CustomerID, e.S3ConfigEnc, e.FilterStart, e.FilterEnd, textArray(e.FilterDatasets), e.Status,
+	).Scan(&e.CreatedAt, &e.UpdatedAt)
 }
 
 func (r *LogExportRepository) Update(ctx context.Context, e *models.LogExport) error {
@@ -502,11 +957,11 @@ func (r *LogExportRepository) Update(ctx context.Context, e *models.LogExport) e
 }
 
 func (r *LogExportRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.LogExport, error) {
-	const q = `SELECT id,customer_id,s3_config_enc,filter_start,filter_end,status,log_count,byte_count,error_msg,created_at,updated_at
+	const q = `SELECT id,customer_id,s3_config_enc,filter_start,filter_end,filter_datasets,status,log_count,byte_count,error_msg,created_at,updated_at
 		FROM log_exports WHERE id=$1`
 	e := &models.LogExport{}
 	err := r.db.QueryRow(ctx, q, id).Scan(
-		&e.ID, &e.CustomerID, &e.S3ConfigEnc, &e.FilterStart, &e.FilterEnd,
+		&e.ID, &e.CustomerID, &e.S3ConfigEnc, &e.FilterStart, &e.FilterEnd, &e.FilterDatasets,
 		&e.Status, &e.LogCount, &e.ByteCount, &e.ErrorMsg, &e.CreatedAt, &e.UpdatedAt,
 	)
 	if err != nil {
@@ -514,3 +969,108 @@ func (r *LogExportRepository) GetByID(ctx context.Context, id uuid.UUID) (*model
 	}
 	return e, nil
 }
+
+// ── ZoneDatasetRepository ─────────────────────────────────────────────────────
+
+type ZoneDatasetRepository struct{ db *pgxpool.Pool }
+
+func NewZoneDatasetRepository(db *pgxpool.Pool) *ZoneDatasetRepository {
+	return &ZoneDatasetRepository{db: db}
+}
+
+const zoneDatasetColumns = `zone_id,dataset,pull_interval_secs,retention_days,last_pulled_at,created_at,updated_at`
+
+// Upsert creates or reconfigures a zone dataset; last_pulled_at is kept.
+func (r *ZoneDatasetRepository) Upsert(ctx context.Context, d *models.ZoneDataset) error {
+	const q = `INSERT INTO zone_datasets(zone_id,dataset,pull_interval_secs,retention_days,created_at,updated_at)
+		VALUES($1,$2,$3,$4,now(),now())
+		ON CONFLICT (zone_id,dataset) DO UPDATE
+		SET pull_interval_secs=EXCLUDED.pull_interval_secs, retention_days=EXCLUDED.retention_days, updated_at=now()
+		RETURNING last_pulled_at,created_at,updated_at`
+	return r.db.QueryRow(ctx, q, d.ZoneID, d.Dataset, d.PullIntervalSecs, d.RetentionDays).
+		Scan(&d.LastPulledAt, &d.CreatedAt, &d.UpdatedAt)
+}
+
+// Delete removes a zone dataset; its archived jobs are kept.
+func (r *ZoneDatasetRepository) Delete(ctx context.Context, zoneID uuid.UUID, dataset string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM zone_datasets WHERE zone_id=$1 AND dataset=$2`, zoneID, dataset)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *ZoneDatasetRepository) ListByZone(ctx context.Context, zoneID uuid.UUID) ([]*models.ZoneDataset, error) {
+	const q = `SELECT ` + zoneDatasetColumns + ` FROM zone_datasets WHERE zone_id=$1 ORDER BY dataset`
+	return r.scanZoneDatasets(ctx, q, zoneID)
+}
+
+// ListDue returns pulled datasets of active zones with a usable token whose
+// interval has elapsed.
+func (r *ZoneDatasetRepository) ListDue(ctx context.Context) ([]*models.ZoneDataset, error) {
+	q := `SELECT d.zone_id,d.dataset,d.pull_interval_secs,d.retention_days,d.last_pulled_at,d.created_at,d.updated_at
+		FROM zone_datasets d JOIN zones z ON z.id=d.zone_id
+		WHERE z.active=true
+		  AND z.deleted_at IS NULL
+		  AND ` + tokenUsable("z") + `
+		  AND d.pull_interval_secs > 0
+		  AND (d.last_pulled_at IS NULL OR
+		       d.last_pulled_at < now() - (d.pull_interval_secs || ' seconds')::interval)`
+	return r.scanZoneDatasets(ctx, q)
+}
+
+func (r *ZoneDatasetRepository) UpdateLastPulled(ctx context.Context, zoneID uuid.UUID, dataset string, t time.Time) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE zone_datasets SET last_pulled_at=$3 WHERE zone_id=$1 AND dataset=$2`,
+		zoneID, dataset, t,
+	)
+	return err
+}
+
+func (r *ZoneDatasetRepository) scanZoneDatasets(ctx context.Context, q string, args ...interface{}) ([]*models.ZoneDataset, error) {
+	rows, err := r.db.Query(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*models.ZoneDataset
+	for rows.Next() {
+		d := &models.ZoneDataset{}
+		if err := rows.Scan(&d.ZoneID, &d.Dataset, &d.PullIntervalSecs, &d.RetentionDays,
+			&d.LastPulledAt, &d.CreatedAt, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// ── BackfillRepository ────────────────────────────────────────────────────────
+
+type BackfillRepository struct{ db *pgxpool.Pool }
+
+func NewBackfillRepository(db *pgxpool.Pool) *BackfillRepository {
+	return &BackfillRepository{db: db}
+}
+
+func (r *BackfillRepository) Create(ctx context.Context, b *models.Backfill) error {
+	const q = `INSERT INTO backfills(id,customer_id,zone_id,period_start,period_end,windows_total,windows_skipped,created_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,now()) RETURNING created_at`
+	return r.db.QueryRow(ctx, q,
+		b.ID, b.CustomerID, b.ZoneID, b.PeriodStart, b.PeriodEnd, b.WindowsTotal, b.WindowsSkipped,
+	).Scan(&b.CreatedAt)
+}
+
+func (r *BackfillRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Backfill, error) {
+	const q = `SELECT id,customer_id,zone_id,period_start,period_end,windows_total,windows_skipped,created_at
+		FROM backfills WHERE id=$1`
+	b := &models.Backfill{}
+	err := r.db.QueryRow(ctx, q, id).Scan(
+		&b.ID, &b.CustomerID, &b.ZoneID, &b.PeriodStart, &b.PeriodEnd,
+		&b.WindowsTotal, &b.WindowsSkipped, &b.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("backfill get: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Backfill is a historical Logpull request over part of Cloudflare's
+// retention horizon, fanned out into hour-sized pull tasks.
+type Backfill struct {
+	ID          uuid.UUID `db:"id"           json:"id"`
+	CustomerID  uuid.UUID `db:"customer_id"  json:"customer_id"`
+	ZoneID      uuid.UUID `db:"zone_id"      json:"zone_id"`
+	PeriodStart time.Time `db:"period_start" json:"period_start"`
+	PeriodEnd   time.Time `db:"period_end"   json:"period_end"`
+	// WindowsTotal is the number of hour windows in the range; WindowsSkipped
+	// of them were already archived when the backfill was requested.
+	WindowsTotal   int       `db:"windows_total"   json:"windows_total"`
+	WindowsSkipped int       `db:"windows_skipped" json:"windows_skipped"`
+	CreatedAt      time.Time `db:"created_at"      json:"created_at"`
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// JobPeriod is the period and status of a log job, used to compute coverage.
+type JobPeriod struct {
+	Start     time.Time
+	End       time.Time
+	Status    JobStatus
+	UpdatedAt time.Time
+}
+
+// Interval is the half-open time range [Start, End).
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Duration returns the length of the interval.
+func (i Interval) Duration() time.Duration { return i.End.Sub(i.Start) }
+
+// Coverage splits [from, to) into the intervals covered by the union of the
+// periods in one of the given statuses and the intervals that are not. Both
+// results are sorted and non-overlapping. periods must be sorted by Start.
+func Coverage(from, to time.Time, periods []JobPeriod, statuses ...JobStatus) (covered, missing []Interval) {
+	at := from
+	for _, p := range periods {
+		if !at.Before(to) {
+			break
+		}
+		if !slices.Contains(statuses, p.Status) || !p.End.After(at) {
+			continue
+		}
+		start := p.Start
+		if start.Before(at) {
+			start = at
+		}
+		if !start.Before(to) {
+			break
+		}
+		if start.After(at) {
+			missing = append(missing, Interval{Start: at, End: start})
+		}
+		end := p.End
+		if end.After(to) {
+			end = to
+		}
+		if n := len(covered); n > 0 && covered[n-1].End.Equal(start) {
+			covered[n-1].End = end
+		} else {
+			covered = append(covered, Interval{Start: start, End: end})
+		}
+		at = end
+	}
+	if at.Before(to) {
+		missing = append(missing, Interval{Start: at, End: to})
+	}
+	return covered, missing
+}
+
+// Covered reports whether [start, end) is fully covered by the union of the
+// periods with the given status. periods must be sorted by Start.
+func Covered(start, end time.Time, periods []JobPeriod, status JobStatus) bool {
+	_, missing := Coverage(start, end, periods, status)
+	return len(missing) == 0
+}
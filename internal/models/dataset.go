@@ -0,0 +1,81 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Datasets name the Cloudflare log dataset a job's lines belong to. A zone
+// keeps one WORM chain per dataset.
+const (
+	DatasetHTTPRequests   = "http_requests"
+	DatasetFirewallEvents = "firewall_events"
+	DatasetDNSLogs        = "dns_logs"
+	DatasetWorkersTrace   = "workers_trace_events"
+	DatasetSpectrum       = "spectrum_events"
+)
+
+var datasets = []string{
+	DatasetHTTPRequests,
+	DatasetFirewallEvents,
+	DatasetDNSLogs,
+	DatasetWorkersTrace,
+	DatasetSpectrum,
+}
+
+// Datasets returns every dataset a zone can archive.
+func Datasets() []string {
+	return slices.Clone(datasets)
+}
+
+// IsDataset reports whether ds is a dataset a zone can archive.
+func IsDataset(ds string) bool {
+	return slices.Contains(datasets, ds)
+}
+
+// DatasetAuditLogs is the account audit log dataset. It is archived per
+// customer rather than per zone, in one chain of jobs without a zone.
+const DatasetAuditLogs = "audit_logs"
+
+// IsJobDataset reports whether jobs can be of dataset ds: a zone dataset or
+// the account audit logs.
+func IsJobDataset(ds string) bool {
+	return ds == DatasetAuditLogs || IsDataset(ds)
+}
+
+// DatasetForPlan returns the dataset the plan collector of zones on plan
+// pulls: HTTP requests through Logpull or Instant Logs, firewall events
+// through the security events poller.
+func DatasetForPlan(plan PlanType) string {
+	if plan == PlanFreePro {
+		return DatasetFirewallEvents
+	}
+	return DatasetHTTPRequests
+}
+
+// DatasetPullable reports whether RainLogs can pull ds for a zone on plan on
+// its own schedule, besides the plan collector. Only firewall events have a
+// pull API outside Logpull and Instant Logs; other datasets arrive through
+// Logpush.
+func DatasetPullable(plan PlanType, ds string) bool {
+	return ds == DatasetFirewallEvents && plan != PlanFreePro
+}
+
+// ZoneDataset configures a dataset a zone archives besides, or on top of,
+// the one its plan collector pulls.
+type ZoneDataset struct {
+	ZoneID  uuid.UUID `db:"zone_id" json:"zone_id"`
+	Dataset string    `db:"dataset" json:"dataset"`
+	// PullIntervalSecs schedules pulls of a pullable dataset; 0 means the
+	// dataset is pushed through Logpush, or is the plan's and follows the
+	// zone's own interval.
+	PullIntervalSecs int `db:"pull_interval_secs" json:"pull_interval_secs"`
+	// RetentionDays overrides the customer's retention for the dataset; 0
+	// keeps the customer's.
+	RetentionDays int        `db:"retention_days" json:"retention_days"`
+	LastPulledAt  *time.Time `db:"last_pulled_at" json:"last_pulled_at,omitempty"`
+	CreatedAt     time.Time  `db:"created_at"     json:"created_at"`
+	UpdatedAt     time.Time  `db:"updated_at"     json:"updated_at"`
+}
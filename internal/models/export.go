@@ -16,17 +16,18 @@ const (
 )
 
 type LogExport struct {
-	ID          uuid.UUID    `db:"id"             json:"id"`
-	CustomerID  uuid.UUID    `db:"customer_id"    json:"customer_id"`
-	S3ConfigEnc string       `db:"s3_config_enc"  json:"-"`
-	FilterStart time.Time    `db:"filter_start"   json:"filter_start"`
-	FilterEnd   time.Time    `db:"filter_end"     json:"filter_end"`
-	Status      ExportStatus `db:"status"         json:"status"`
-	LogCount    int64        `db:"log_count"      json:"log_count"`
-	ByteCount   int64        `db:"byte_count"     json:"byte_count"`
-	ErrorMsg    *string      `db:"error_msg"      json:"error_msg,omitempty"`
-	CreatedAt   time.Time    `db:"created_at"     json:"created_at"`
-	UpdatedAt   time.Time    `db:"updated_at"     json:"updated_at"`
+	ID             uuid.UUID    `db:"id"             json:"id"`
+	CustomerID     uuid.UUID    `db:"customer_id"    json:"customer_id"`
+	S3ConfigEnc    string       `db:"s3_config_enc"  json:"-"`
+	FilterStart    time.Time    `db:"filter_start"   json:"filter_start"`
+	FilterEnd      time.Time    `db:"filter_end"     json:"filter_end"`
+	FilterDatasets []string     `db:"filter_datasets" json:"filter_datasets"` // empty: all datasets
+	Status         ExportStatus `db:"status"         json:"status"`
+	LogCount       int64        `db:"log_count"      json:"log_count"`
+	ByteCount      int64        `db:"byte_count"     json:"byte_count"`
+	ErrorMsg       *string      `db:"error_msg"      json:"error_msg,omitempty"`
+	CreatedAt      time.Time    `db:"created_at"     json:"created_at"`
+	UpdatedAt      time.Time    `db:"updated_at"     json:"updated_at"`
 }
 
 type ExportS3Config struct {
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -28,8 +29,28 @@ type Customer struct {
 	UpdatedAt     time.Time  `db:"updated_at"     json:"updated_at"`
 	DeletedAt     *time.Time `db:"deleted_at"     json:"deleted_at,omitempty"`
 	QuotaBytes    int64      `db:"quota_bytes"    json:"quota_bytes"` // -1 for unlimited
+	// AutoEnrollZones registers zones found by account discovery automatically.
+	AutoEnrollZones bool `db:"auto_enroll_zones" json:"auto_enroll_zones"`
+	// Result of the last Cloudflare API token preflight. CFTokenStatus is
+	// Cloudflare's token status (active, disabled, expired), "invalid" when
+	// the token is not recognised, or empty before the first check.
+	CFTokenStatus          string     `db:"cf_token_status"            json:"cf_token_status,omitempty"`
+	CFTokenError           string     `db:"cf_token_error"             json:"cf_token_error,omitempty"`
+	CFTokenExpiresAt       *time.Time `db:"cf_token_expires_at"        json:"cf_token_expires_at,omitempty"`
+	CFTokenCheckedAt       *time.Time `db:"cf_token_checked_at"        json:"cf_token_checked_at,omitempty"`
+	CFTokenExpiryAlertedAt *time.Time `db:"cf_token_expiry_alerted_at" json:"-"`
+	// AuditLogsArchivedUntil is the high-watermark of account audit log
+	// archival: every entry before it is archived.
+	AuditLogsArchivedUntil *time.Time `db:"audit_logs_archived_until" json:"audit_logs_archived_until,omitempty"`
 }
 
+// Cloudflare API token statuses recorded by the preflight besides the ones
+// Cloudflare reports.
+const (
+	TokenStatusActive  = "active"
+	TokenStatusInvalid = "invalid"
+)
+
 type UserRole string
 
 const (
@@ -59,6 +80,41 @@ const (
 	PlanFreePro    PlanType = "free_pro"
 )
 
+// Log types name the source of a job's lines; the dataset says what the lines
+// are. The log type is also the first segment of the job's object key.
+const (
+	LogTypeLogpull  = "logs"
+	LogTypeSecurity = "security"
+	LogTypeInstant  = "instant"
+	LogTypeLogpush  = "logpush"
+	LogTypeAudit    = "audit"
+)
+
+// PlanFromCloudflare maps a Cloudflare plan legacy ID ("free", "pro",
+// "business", "enterprise") to the plan that selects the zone's collector.
+func PlanFromCloudflare(legacyID string) PlanType {
+	switch legacyID {
+	case "enterprise":
+		return PlanEnterprise
+	case "business":
+		return PlanBusiness
+	default:
+		return PlanFreePro
+	}
+}
+
+// LogTypeForPlan returns the log type collected for zones on plan.
+func LogTypeForPlan(plan PlanType) string {
+	switch plan {
+	case PlanFreePro:
+		return LogTypeSecurity
+	case PlanBusiness:
+		return LogTypeInstant
+	default:
+		return LogTypeLogpull
+	}
+}
+
 // Zone is a Cloudflare zone registered for a customer.
 type Zone struct {
 	ID               uuid.UUID  `db:"id"                 json:"id"`
@@ -69,17 +125,79 @@ type Zone struct {
 	PullIntervalSecs int        `db:"pull_interval_secs" json:"pull_interval_secs"`
 	LastPulledAt     *time.Time `db:"last_pulled_at"     json:"last_pulled_at,omitempty"`
 	Active           bool       `db:"active"             json:"active"`
-	CreatedAt        time.Time  `db:"created_at"         json:"created_at"`
-	DeletedAt        *time.Time `db:"deleted_at"         json:"deleted_at,omitempty"`
+	// LogFields are extra Logpull fields requested on top of FieldProfile.
+	// Both empty means Cloudflare's default field set.
+	LogFields    []string `db:"log_fields"    json:"log_fields"`
+	FieldProfile string   `db:"field_profile" json:"field_profile,omitempty"`
+	// Instant Logs (Business) session settings: fields to stream (empty means
+	// the default set), keep one request in InstantSample, and a Cloudflare
+	// Logpush filter expression.
+	InstantFields []string        `db:"instant_fields" json:"instant_fields"`
+	InstantSample int             `db:"instant_sample" json:"instant_sample,omitempty"`
+	InstantFilter json.RawMessage `db:"instant_filter" json:"instant_filter,omitempty"`
+	// CFDeletedAt is set when account discovery no longer finds the zone in
+	// Cloudflare.
+	CFDeletedAt *time.Time `db:"cf_deleted_at" json:"cf_deleted_at,omitempty"`
+	// PreviousPlan and PlanChangedAt record the last detected plan switch:
+	// the collector of PreviousPlan covers up to PlanChangedAt, the one of
+	// Plan from then on.
+	PreviousPlan  PlanType   `db:"previous_plan"   json:"previous_plan,omitempty"`
+	PlanChangedAt *time.Time `db:"plan_changed_at" json:"plan_changed_at,omitempty"`
+	// MissingPermissions lists the token permissions the zone's collector
+	// needs that the last preflight found missing.
+	MissingPermissions   []string   `db:"missing_permissions"    json:"missing_permissions"`
+	PermissionsCheckedAt *time.Time `db:"permissions_checked_at" json:"permissions_checked_at,omitempty"`
+	// Logpush receiver: hash of the secret Logpush jobs send, and the last
+	// ownership challenge Cloudflare delivered.
+	LogpushSecretHash  string     `db:"logpush_secret_hash"  json:"-"`
+	LogpushChallenge   string     `db:"logpush_challenge"    json:"logpush_ownership_challenge,omitempty"`
+	LogpushChallengeAt *time.Time `db:"logpush_challenge_at" json:"logpush_challenge_at,omitempty"`
+	// CFAPIKeyEnc is the zone's own KMS-encrypted Cloudflare API token; empty
+	// means the customer's token is used.
+	CFAPIKeyEnc       string     `db:"cf_api_key_enc"        json:"-"`
+	CFAPIKeyRotatedAt *time.Time `db:"cf_api_key_rotated_at" json:"cf_api_key_rotated_at,omitempty"`
+	// SettleDelaySecs, when positive, has each Logpull window pulled again
+	// this long after it ended, archiving the lines that arrived late.
+	SettleDelaySecs int        `db:"settle_delay_secs" json:"settle_delay_secs"`
+	CreatedAt       time.Time  `db:"created_at"            json:"created_at"`
+	DeletedAt       *time.Time `db:"deleted_at"            json:"deleted_at,omitempty"`
+	// AdaptiveWindow has the scheduler size the zone's Logpull windows from
+	// the volume of its recent jobs; WindowSecs is the size in use (0 until
+	// first sized), which replaces PullIntervalSecs.
+	AdaptiveWindow bool `db:"adaptive_window" json:"adaptive_window"`
+	WindowSecs     int  `db:"window_secs"     json:"window_secs"`
+	// Result of the last preflight of the zone's own token, as on Customer.
+	CFTokenStatus          string     `db:"cf_token_status"            json:"cf_token_status,omitempty"`
+	CFTokenError           string     `db:"cf_token_error"             json:"cf_token_error,omitempty"`
+	CFTokenExpiresAt       *time.Time `db:"cf_token_expires_at"        json:"cf_token_expires_at,omitempty"`
+	CFTokenCheckedAt       *time.Time `db:"cf_token_checked_at"        json:"cf_token_checked_at,omitempty"`
+	CFTokenExpiryAlertedAt *time.Time `db:"cf_token_expiry_alerted_at" json:"-"`
+}
+
+// CloudflareZone is a zone found in the customer's Cloudflare account by
+// discovery, whether or not it is registered.
+type CloudflareZone struct {
+	CustomerID  uuid.UUID  `db:"customer_id"   json:"-"`
+	CFZoneID    string     `db:"cf_zone_id"    json:"cf_zone_id"`
+	Name        string     `db:"name"          json:"name"`
+	Status      string     `db:"status"        json:"status"`
+	CFPlan      string     `db:"cf_plan"       json:"cf_plan"`
+	Plan        PlanType   `db:"plan"          json:"plan"`
+	FirstSeenAt time.Time  `db:"first_seen_at" json:"first_seen_at"`
+	LastSeenAt  time.Time  `db:"last_seen_at"  json:"last_seen_at"`
+	DeletedAt   *time.Time `db:"deleted_at"    json:"deleted_at,omitempty"`
 }
 
-// LogJob tracks a single Logpull fetch window.
+// LogJob tracks a single Logpull fetch window. Account-level jobs, such as
+// audit logs, have no zone: their ZoneID is uuid.Nil.
 type LogJob struct {
 	ID          uuid.UUID  `db:"id"           json:"id"`
 	ZoneID      uuid.UUID  `db:"zone_id"      json:"zone_id"`
 	CustomerID  uuid.UUID  `db:"customer_id"  json:"customer_id"`
 	PeriodStart time.Time  `db:"period_start" json:"period_start"`
 	PeriodEnd   time.Time  `db:"period_end"   json:"period_end"`
+	LogType     string     `db:"log_type"     json:"log_type"`
+	Dataset     string     `db:"dataset"      json:"dataset"`
 	Status      JobStatus  `db:"status"       json:"status"`
 	S3Key       string     `db:"s3_key"       json:"s3_key,omitempty"`
 	S3Provider  string     `db:"s3_provider"  json:"s3_provider,omitempty"`
@@ -90,8 +208,16 @@ type LogJob struct {
 	Attempts    int        `db:"attempts"    json:"attempts"`
 	ErrMsg      string     `db:"err_msg"     json:"err_msg,omitempty"`
 	VerifiedAt  *time.Time `db:"verified_at" json:"verified_at,omitempty"`
-	CreatedAt   time.Time  `db:"created_at"  json:"created_at"`
-	UpdatedAt   time.Time  `db:"updated_at"  json:"updated_at"`
+	// SettlesJobID links a supplementary job, holding the lines that reached
+	// Logpull after the window was first pulled, to the job it completes.
+	// SettledAt is set on the original job once its window was re-pulled.
+	SettlesJobID *uuid.UUID `db:"settles_job_id" json:"settles_job_id,omitempty"`
+	SettledAt    *time.Time `db:"settled_at"     json:"settled_at,omitempty"`
+	CreatedAt    time.Time  `db:"created_at"  json:"created_at"`
+	UpdatedAt    time.Time  `db:"updated_at"  json:"updated_at"`
+	// WindowSecs is the adaptive window the job's pull was cut with; 0 for
+	// pulls of zones on a fixed interval.
+	WindowSecs int `db:"window_secs" json:"window_secs,omitempty"`
 }
 
 // LogObject represents a stored S3 object.
@@ -144,6 +270,7 @@ type AuditEvent struct {
 type SearchFilter struct {
 	CustomerID uuid.UUID
 	ZoneID     *uuid.UUID
+	Dataset    string
 	IP         string
 	RayID      string
 	From       time.Time
@@ -14,8 +14,13 @@ const (
 	TypeSecurityPoll = "security:poll"
 	TypeInstantLogs  = "log:instant" // Streaming job (Business)
 	TypeLogVerify    = "log:verify"
+	TypeLogSettle    = "log:settle"
 	TypeLogExpire    = "log:expire"
 	TypeLogExport    = "log:export"
+	TypeGapScan      = "coverage:scan"
+	TypeZoneDiscover = "cloudflare:discover"
+	TypePreflight    = "cloudflare:preflight"
+	TypeAuditLogPull = "cloudflare:audit_logs"
 
 	QueueCritical = "critical"
 	QueueDefault  = "default"
@@ -28,6 +33,10 @@ type LogPullPayload struct {
 	CustomerID  uuid.UUID `json:"customer_id"`
 	PeriodStart time.Time `json:"period_start"`
 	PeriodEnd   time.Time `json:"period_end"`
+	// WindowSecs, when set, is the adaptive window chosen by the scheduler:
+	// the period is cut into windows of that size rather than the Logpull
+	// maximum.
+	WindowSecs int `json:"window_secs,omitempty"`
 }
 
 // SecurityPollPayload is the task payload for TypeSecurityPoll.
@@ -43,12 +52,37 @@ type LogVerifyPayload struct {
 	JobID uuid.UUID `json:"job_id"`
 }
 
+// LogSettlePayload is the task payload for TypeLogSettle.
+type LogSettlePayload struct {
+	JobID uuid.UUID `json:"job_id"`
+}
+
 // LogExpirePayload is the task payload for TypeLogExpire.
 type LogExpirePayload struct {
 	CustomerID    uuid.UUID `json:"customer_id"`
 	RetentionDays int       `json:"retention_days"`
 }
 
+// GapScanPayload is the task payload for TypeGapScan.
+type GapScanPayload struct {
+	ZoneID uuid.UUID `json:"zone_id"`
+}
+
+// ZoneDiscoverPayload is the task payload for TypeZoneDiscover.
+type ZoneDiscoverPayload struct {
+	CustomerID uuid.UUID `json:"customer_id"`
+}
+
+// PreflightPayload is the task payload for TypePreflight.
+type PreflightPayload struct {
+	CustomerID uuid.UUID `json:"customer_id"`
+}
+
+// AuditLogPullPayload is the task payload for TypeAuditLogPull.
+type AuditLogPullPayload struct {
+	CustomerID uuid.UUID `json:"customer_id"`
+}
+
 // InstantLogsPayload is the task payload for TypeInstantLogs.
 type InstantLogsPayload struct {
 	ZoneID     uuid.UUID `json:"zone_id"`
@@ -56,21 +90,39 @@ type InstantLogsPayload struct {
 	Duration   string    `json:"duration"` // e.g. "5m"
 }
 
-func NewLogPullTask(p LogPullPayload) (*asynq.Task, error) {
+// NewLogPullTask creates a Logpull task. The worker splits windows longer than
+// one hour into sequential chunks, so the task timeout grows with the window.
+// opts override the defaults (e.g. asynq.Queue(QueueLow) for backfills).
+func NewLogPullTask(p LogPullPayload, opts ...asynq.Option) (*asynq.Task, error) {
 	b, err := json.Marshal(p)
 	if err != nil {
 		return nil, fmt.Errorf("marshal LogPull: %w", err)
 	}
-	return asynq.NewTask(TypeLogPull, b, asynq.Queue(QueueDefault)), nil
+	opts = append([]asynq.Option{asynq.Queue(QueueDefault), asynq.Timeout(logPullTimeout(p))}, opts...)
+	return asynq.NewTask(TypeLogPull, b, opts...), nil
 }
 
-func NewSecurityPollTask(p SecurityPollPayload) (*asynq.Task, error) {
+// logPullTimeout allows pullChunkTimeout per started hour of the window, with
+// the asynq default of 30 minutes as the floor.
+func logPullTimeout(p LogPullPayload) time.Duration {
+	const pullChunkTimeout = 10 * time.Minute
+	chunks := int64(p.PeriodEnd.Sub(p.PeriodStart)/time.Hour) + 1
+	if timeout := time.Duration(chunks) * pullChunkTimeout; timeout > 30*time.Minute {
+		return timeout
+	}
+	return 30 * time.Minute
+}
+
+// NewSecurityPollTask creates a security events poll task. opts override the
+// defaults.
+func NewSecurityPollTask(p SecurityPollPayload, opts ...asynq.Option) (*asynq.Task, error) {
 	b, err := json.Marshal(p)
 	if err != nil {
 		return nil, fmt.Errorf("marshal SecurityPoll: %w", err)
 	}
 	// Use QueueDefault or separate queue? Default is fine.
-	return asynq.NewTask(TypeSecurityPoll, b, asynq.Queue(QueueDefault)), nil
+	opts = append([]asynq.Option{asynq.Queue(QueueDefault)}, opts...)
+	return asynq.NewTask(TypeSecurityPoll, b, opts...), nil
 }
 
 // NewInstantLogsTask creates an InstantLogs streaming task.
@@ -90,6 +142,16 @@ func NewLogVerifyTask(p LogVerifyPayload) (*asynq.Task, error) {
 	return asynq.NewTask(TypeLogVerify, b, asynq.Queue(QueueLow)), nil
 }
 
+// NewLogSettleTask creates the late-arrival re-pull of a Logpull job's
+// window.
+func NewLogSettleTask(p LogSettlePayload) (*asynq.Task, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("queue: marshal LogSettle: %w", err)
+	}
+	return asynq.NewTask(TypeLogSettle, b, asynq.Queue(QueueLow), asynq.Timeout(10*time.Minute)), nil
+}
+
 func NewLogExpireTask(p LogExpirePayload) (*asynq.Task, error) {
 	b, err := json.Marshal(p)
 	if err != nil {
@@ -98,6 +160,42 @@ func NewLogExpireTask(p LogExpirePayload) (*asynq.Task, error) {
 	return asynq.NewTask(TypeLogExpire, b, asynq.Queue(QueueLow)), nil
 }
 
+// NewGapScanTask creates a coverage gap scan task for one zone.
+func NewGapScanTask(p GapScanPayload) (*asynq.Task, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("queue: marshal GapScan: %w", err)
+	}
+	return asynq.NewTask(TypeGapScan, b, asynq.Queue(QueueLow)), nil
+}
+
+// NewZoneDiscoverTask creates a Cloudflare account zone discovery task.
+func NewZoneDiscoverTask(p ZoneDiscoverPayload) (*asynq.Task, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("queue: marshal ZoneDiscover: %w", err)
+	}
+	return asynq.NewTask(TypeZoneDiscover, b, asynq.Queue(QueueLow)), nil
+}
+
+func NewPreflightTask(p PreflightPayload) (*asynq.Task, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("queue: marshal Preflight: %w", err)
+	}
+	return asynq.NewTask(TypePreflight, b, asynq.Queue(QueueLow)), nil
+}
+
+// NewAuditLogPullTask creates an account audit log pull task. The window is
+// not part of the payload: the worker resumes from the customer's watermark.
+func NewAuditLogPullTask(p AuditLogPullPayload) (*asynq.Task, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("queue: marshal AuditLogPull: %w", err)
+	}
+	return asynq.NewTask(TypeAuditLogPull, b, asynq.Queue(QueueLow)), nil
+}
+
 func ParseLogPullPayload(t *asynq.Task) (LogPullPayload, error) {
 	var p LogPullPayload
 	err := json.Unmarshal(t.Payload(), &p)
@@ -123,8 +221,42 @@ func ParseLogVerifyPayload(t *asynq.Task) (LogVerifyPayload, error) {
 	return p, err
 }
 
+func ParseLogSettlePayload(t *asynq.Task) (LogSettlePayload, error) {
+	var p LogSettlePayload
+	err := json.Unmarshal(t.Payload(), &p)
+	return p, err
+}
+
 func ParseLogExpirePayload(t *asynq.Task) (LogExpirePayload, error) {
 	var p LogExpirePayload
 	err := json.Unmarshal(t.Payload(), &p)
 	return p, err
 }
+
+// ParseGapScanPayload decodes the payload.
+func ParseGapScanPayload(t *asynq.Task) (GapScanPayload, error) {
+	var p GapScanPayload
+	err := json.Unmarshal(t.Payload(), &p)
+	return p, err
+}
+
+// ParseZoneDiscoverPayload decodes the payload.
+func ParseZoneDiscoverPayload(t *asynq.Task) (ZoneDiscoverPayload, error) {
+	var p ZoneDiscoverPayload
+	err := json.Unmarshal(t.Payload(), &p)
+	return p, err
+}
+
+// ParsePreflightPayload decodes the payload.
+func ParsePreflightPayload(t *asynq.Task) (PreflightPayload, error) {
+	var p PreflightPayload
+	err := json.Unmarshal(t.Payload(), &p)
+	return p, err
+}
+
+// ParseAuditLogPullPayload decodes the payload.
+func ParseAuditLogPullPayload(t *asynq.Task) (AuditLogPullPayload, error) {
+	var p AuditLogPullPayload
+	err := json.Unmarshal(t.Payload(), &p)
+	return p, err
+}
@@ -0,0 +1,312 @@
+// Package redistest is an in-memory fake of the Redis commands RainLogs sends,
+// for tests: leases, replica heartbeats and circuit breakers.
+//
+// The fake is a go-redis hook, so Server.Client never opens a connection.
+// Keys expire on a clock the test moves with Advance. Lua can't run here, so
+// a test registers a Go equivalent of each script it sends with Script.
+package redistest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ScriptFunc stands in for a Lua script. It runs with the server locked, as
+// scripts run atomically, and returns the script's reply: int64, string, nil
+// or a []any of those.
+type ScriptFunc func(db *DB, keys, args []string) (any, error)
+
+// Server serves Redis commands from memory.
+type Server struct {
+	// Client is a client of the server.
+	Client *redis.Client
+
+	mu      sync.Mutex
+	db      DB
+	scripts map[string]ScriptFunc
+}
+
+// DB is the data of a Server, for ScriptFuncs.
+type DB struct {
+	now   time.Time
+	keys  map[string]*entry
+	zsets map[string]map[string]float64
+}
+
+// entry is a string or a hash key.
+type entry struct {
+	val     string
+	hash    map[string]string
+	expires time.Time // zero = never
+}
+
+// NewServer returns a server whose clock starts at the current time. Its
+// client is closed when the test ends.
+func NewServer(t interface{ Cleanup(func()) }) *Server {
+	s := &Server{
+		db: DB{
+			now:   time.Now(),
+			keys:  make(map[string]*entry),
+			zsets: make(map[string]map[string]float64),
+		},
+		scripts: make(map[string]ScriptFunc),
+	}
+	s.Client = redis.NewClient(&redis.Options{Addr: "redistest:6379"})
+	s.Client.AddHook(s)
+	t.Cleanup(func() { _ = s.Client.Close() })
+	return s
+}
+
+// Script registers fn to run in place of script.
+func (s *Server) Script(script *redis.Script, fn ScriptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[script.Hash()] = fn
+}
+
+// Advance moves the server's clock forward by d.
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.db.now = s.db.now.Add(d)
+}
+
+// ZAdd sets the score of member in the sorted set key, e.g. to plant a stale
+// heartbeat.
+func (s *Server) ZAdd(key, member string, score float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.db.ZAdd(key, member, score)
+}
+
+func (s *Server) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, fmt.Errorf("redistest: no connections")
+	}
+}
+
+func (s *Server) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		return s.process(cmd)
+	}
+}
+
+func (s *Server) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			if name := cmd.Name(); name == "multi" || name == "exec" {
+				continue
+			}
+			if err := s.process(cmd); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (s *Server) process(cmd redis.Cmder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	args := make([]string, len(cmd.Args()))
+	for i, a := range cmd.Args() {
+		args[i] = fmt.Sprint(a)
+	}
+	reply, err := s.run(args)
+	if err != nil {
+		cmd.SetErr(err)
+		return err
+	}
+	return setReply(cmd, reply)
+}
+
+func (s *Server) run(args []string) (any, error) {
+	db := &s.db
+	switch name := strings.ToLower(args[0]); name {
+	case "evalsha": // EVALSHA sha numkeys key... arg...
+		fn, ok := s.scripts[args[1]]
+		if !ok {
+			return nil, fmt.Errorf("redistest: no script registered for %s", args[1])
+		}
+		n, _ := strconv.Atoi(args[2])
+		return fn(db, args[3:3+n], args[3+n:])
+	case "set": // SET key value [EX s|PX ms] [NX]
+		var ttl time.Duration
+		nx := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "ex", "px":
+				n, _ := strconv.ParseInt(args[i+1], 10, 64)
+				ttl = time.Duration(n) * time.Millisecond
+				if strings.EqualFold(args[i], "ex") {
+					ttl = time.Duration(n) * time.Second
+				}
+				i++
+			case "nx":
+				nx = true
+			}
+		}
+		if _, ok := db.Get(args[1]); ok && nx {
+			return false, nil
+		}
+		db.Set(args[1], args[2], ttl)
+		return true, nil
+	case "get":
+		if v, ok := db.Get(args[1]); ok {
+			return v, nil
+		}
+		return nil, redis.Nil
+	case "del":
+		var n int64
+		for _, k := range args[1:] {
+			n += db.Del(k)
+		}
+		return n, nil
+	case "hmget":
+		vals := make([]any, 0, len(args)-2)
+		for _, f := range args[2:] {
+			if v, ok := db.HGet(args[1], f); ok {
+				vals = append(vals, v)
+			} else {
+				vals = append(vals, nil)
+			}
+		}
+		return vals, nil
+	case "zadd": // ZADD key score member
+		score, _ := strconv.ParseFloat(args[2], 64)
+		db.ZAdd(args[1], args[3], score)
+		return int64(1), nil
+	case "zremrangebyscore": // ZREMRANGEBYSCORE key -inf (max
+		limit, _ := strconv.ParseFloat(strings.TrimPrefix(args[3], "("), 64)
+		var n int64
+		for m, score := range db.zsets[args[1]] {
+			if score < limit {
+				delete(db.zsets[args[1]], m)
+				n++
+			}
+		}
+		return n, nil
+	case "zcard":
+		return int64(len(db.zsets[args[1]])), nil
+	case "zrem":
+		var n int64
+		for _, m := range args[2:] {
+			if _, ok := db.zsets[args[1]][m]; ok {
+				delete(db.zsets[args[1]], m)
+				n++
+			}
+		}
+		return n, nil
+	default:
+		return nil, fmt.Errorf("redistest: unsupported command %s", name)
+	}
+}
+
+func setReply(cmd redis.Cmder, reply any) error {
+	switch c := cmd.(type) {
+	case *redis.Cmd:
+		c.SetVal(reply)
+	case *redis.BoolCmd:
+		c.SetVal(reply.(bool))
+	case *redis.IntCmd:
+		c.SetVal(reply.(int64))
+	case *redis.StringCmd:
+		c.SetVal(reply.(string))
+	case *redis.SliceCmd:
+		c.SetVal(reply.([]any))
+	default:
+		return fmt.Errorf("redistest: unsupported reply type %T", cmd)
+	}
+	return nil
+}
+
+// Now returns the server's clock.
+func (db *DB) Now() time.Time { return db.now }
+
+// lookup returns key unless it is missing or expired.
+func (db *DB) lookup(key string) *entry {
+	e, ok := db.keys[key]
+	if !ok {
+		return nil
+	}
+	if !e.expires.IsZero() && !db.now.Before(e.expires) {
+		delete(db.keys, key)
+		return nil
+	}
+	return e
+}
+
+// Get returns the string value of key.
+func (db *DB) Get(key string) (string, bool) {
+	e := db.lookup(key)
+	if e == nil || e.hash != nil {
+		return "", false
+	}
+	return e.val, true
+}
+
+// Set sets key to val, expiring after ttl unless it is 0.
+func (db *DB) Set(key, val string, ttl time.Duration) {
+	e := &entry{val: val}
+	if ttl > 0 {
+		e.expires = db.now.Add(ttl)
+	}
+	db.keys[key] = e
+}
+
+// Expire makes key expire after ttl. It reports whether key exists.
+func (db *DB) Expire(key string, ttl time.Duration) bool {
+	e := db.lookup(key)
+	if e == nil {
+		return false
+	}
+	e.expires = db.now.Add(ttl)
+	return true
+}
+
+// Del deletes key, returning the number of keys deleted.
+func (db *DB) Del(key string) int64 {
+	if db.lookup(key) == nil {
+		return 0
+	}
+	delete(db.keys, key)
+	return 1
+}
+
+// HGet returns field of the hash key.
+func (db *DB) HGet(key, field string) (string, bool) {
+	e := db.lookup(key)
+	if e == nil {
+		return "", false
+	}
+	v, ok := e.hash[field]
+	return v, ok
+}
+
+// HSet sets field of the hash key, creating it if needed.
+func (db *DB) HSet(key, field, val string) {
+	e := db.lookup(key)
+	if e == nil {
+		e = &entry{}
+		db.keys[key] = e
+	}
+	if e.hash == nil {
+		e.hash = make(map[string]string)
+	}
+	e.hash[field] = val
+}
+
+// ZAdd sets the score of member in the sorted set key.
+func (db *DB) ZAdd(key, member string, score float64) {
+	if db.zsets[key] == nil {
+		db.zsets[key] = make(map[string]float64)
+	}
+	db.zsets[key][member] = score
+}
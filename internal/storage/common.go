@@ -20,7 +20,7 @@ type BlobMetadata struct {
 }
 
 // PrepareBlob compresses, hashes, and generates a key for raw log data.
-func PrepareBlob(raw []byte, customerID, zoneID uuid.UUID, from, to time.Time, logType string) ([]byte, BlobMetadata, error) {
+func PrepareBlob(raw []byte, customerID, zoneID uuid.UUID, from, to time.Time, logType, dataset string) ([]byte, BlobMetadata, error) {
 	lines := int64(countLines(raw))
 
 	// Compress
@@ -40,27 +40,73 @@ func PrepareBlob(raw []byte, customerID, zoneID uuid.UUID, from, to time.Time, l
 	sum := sha256.Sum256(compressed)
 	sha256hex := hex.EncodeToString(sum[:])
 
+	return compressed, BlobMetadata{
+		Key:    ObjectKey(logType, dataset, customerID, zoneID, from, to, sha256hex[:8]),
+		SHA256: sha256hex,
+		Size:   size,
+		Lines:  lines,
+	}, nil
+}
+
+// ObjectKey builds the key layout shared by all backends:
+// <type>/<customer>/<zone>/<dataset>/<YYYY>/<MM>/<DD>/<from>_<to>_<suffix>.ndjson.gz.
+// Objects written before datasets existed have no <dataset> segment.
+// Account-level logs (zoneID == uuid.Nil) use "account" as <zone>.
+func ObjectKey(logType, dataset string, customerID, zoneID uuid.UUID, from, to time.Time, suffix string) string {
 	if logType == "" {
 		logType = "logs"
 	}
-
-	// Key: <type>/<customer>/<zone>/<YYYY>/<MM>/<DD>/<from>_<to>_<sha[:8]>.ndjson.gz
-	key := fmt.Sprintf("%s/%s/%s/%s/%s_%s_%s.ndjson.gz",
+	if dataset == "" {
+		dataset = "http_requests"
+	}
+	zone := zoneID.String()
+	if zoneID == uuid.Nil {
+		zone = "account"
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%s/%s_%s_%s.ndjson.gz",
 		logType,
 		customerID,
-		zoneID,
+		zone,
+		dataset,
 		from.UTC().Format("2006/01/02"),
 		from.UTC().Format("20060102T150405Z"),
 		to.UTC().Format("20060102T150405Z"),
-		sha256hex[:8],
+		suffix,
 	)
+}
 
-	return compressed, BlobMetadata{
-		Key:    key,
-		SHA256: sha256hex,
-		Size:   size,
-		Lines:  lines,
-	}, nil
+// CompressStream gzips r into dst in a single pass, hashing and measuring the
+// compressed output and counting raw NDJSON lines on the way. Memory use is
+// bounded by the gzip window and copy buffer, independent of the input size.
+func CompressStream(dst io.Writer, r io.Reader) (sha256hex string, compressedBytes, logLines int64, err error) {
+	h := sha256.New()
+	var size byteCounter
+	gw := gzip.NewWriter(io.MultiWriter(dst, h, &size))
+
+	var lines lineCounter
+	if _, err := io.Copy(io.MultiWriter(gw, &lines), r); err != nil {
+		return "", 0, 0, fmt.Errorf("storage: gzip write: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return "", 0, 0, fmt.Errorf("storage: gzip close: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), int64(size), int64(lines), nil
+}
+
+// byteCounter counts the bytes written through it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// lineCounter counts the newlines written through it.
+type lineCounter int64
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	*c += lineCounter(bytes.Count(p, []byte{'\n'}))
+	return len(p), nil
 }
 
 // DecompressBlob reads gzip compressed data from a reader.
@@ -74,6 +120,28 @@ func DecompressBlob(r io.Reader) ([]byte, error) {
 	return io.ReadAll(gr)
 }
 
+// gzipStream decompresses an object body, closing both on Close.
+type gzipStream struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (s gzipStream) Close() error {
+	s.Reader.Close()
+	return s.body.Close()
+}
+
+// DecompressStream returns a reader of body's decompressed content. body is
+// closed when the reader is, or when it isn't gzipped.
+func DecompressStream(body io.ReadCloser) (io.ReadCloser, error) {
+	gr, err := gzip.NewReader(body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("storage: gzip reader: %w", err)
+	}
+	return gzipStream{Reader: gr, body: body}, nil
+}
+
 func countLines(b []byte) int {
 	count := 0
 	for _, x := range b {
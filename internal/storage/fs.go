@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -37,7 +38,7 @@ func (s *FSStore) Provider() string {
 	return s.provider
 }
 
-func (s *FSStore) PutLogs(_ context.Context, customerID, zoneID uuid.UUID, from, to time.Time, raw []byte, logType string) (key, sha256hex string, compressedBytes, logLines int64, err error) {
+func (s *FSStore) PutLogs(_ context.Context, customerID, zoneID uuid.UUID, from, to time.Time, raw []byte, logType, dataset string) (key, sha256hex string, compressedBytes, logLines int64, err error) {
 	// Re-use logic for compression/hashing/key generation from common helpers?
 	// For now, let's duplicate the non-AWS logic to keep it independent,
 	// or ideally refactor S3 logic to share "blob preparation".
@@ -49,7 +50,7 @@ func (s *FSStore) PutLogs(_ context.Context, customerID, zoneID uuid.UUID, from,
 
 	// Actually, let's call the helper to compress and hash.
 	// We'll define `PrepareBlob` in `common.go` next.
-	blob, meta, err := PrepareBlob(raw, customerID, zoneID, from, to, logType)
+	blob, meta, err := PrepareBlob(raw, customerID, zoneID, from, to, logType, dataset)
 	if err != nil {
 		return "", "", 0, 0, err
 	}
@@ -89,6 +90,43 @@ func (s *FSStore) PutLogs(_ context.Context, customerID, zoneID uuid.UUID, from,
 	return meta.Key, meta.SHA256, meta.Size, meta.Lines, nil
 }
 
+// PutLogsStream gzips r into a temp file next to its final location and renames
+// it into place once complete.
+func (s *FSStore) PutLogsStream(_ context.Context, customerID, zoneID uuid.UUID, from, to time.Time, r io.Reader, logType, dataset string, jobID uuid.UUID) (key, sha256hex string, compressedBytes, logLines int64, err error) {
+	key = ObjectKey(logType, dataset, customerID, zoneID, from, to, jobID.String())
+	dir := filepath.Dir(filepath.Join(s.root, key))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", 0, 0, fmt.Errorf("storage: mkdir: %w", err)
+	}
+
+	tmpFile, err := os.CreateTemp(dir, "rainlog-*.tmp")
+	if err != nil {
+		return "", "", 0, 0, fmt.Errorf("storage: create temp: %w", err)
+	}
+	tmpName := tmpFile.Name()
+	defer os.Remove(tmpName) // Cleanup (ignored if renamed successfully)
+
+	if err := tmpFile.Chmod(0o644); err != nil {
+		tmpFile.Close()
+		return "", "", 0, 0, fmt.Errorf("storage: chmod: %w", err)
+	}
+
+	sha256hex, compressedBytes, logLines, err = CompressStream(tmpFile, r)
+	if err != nil {
+		tmpFile.Close()
+		return "", "", 0, 0, err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return "", "", 0, 0, fmt.Errorf("storage: close temp: %w", err)
+	}
+
+	if err := os.Rename(tmpName, filepath.Join(s.root, key)); err != nil {
+		return "", "", 0, 0, fmt.Errorf("storage: rename: %w", err)
+	}
+
+	return key, sha256hex, compressedBytes, logLines, nil
+}
+
 func (s *FSStore) GetLogs(_ context.Context, key string) ([]byte, error) {
 	fullPath := filepath.Join(s.root, key)
 	f, err := os.Open(fullPath)
@@ -108,6 +146,25 @@ func (s *FSStore) GetLogs(_ context.Context, key string) ([]byte, error) {
 	return DecompressBlob(f)
 }
 
+func (s *FSStore) GetLogsStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := s.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return DecompressStream(f)
+}
+
+func (s *FSStore) GetObject(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.root, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("storage: key not found: %s", key)
+		}
+		return nil, fmt.Errorf("storage: open file: %w", err)
+	}
+	return f, nil
+}
+
 func (s *FSStore) DeleteObject(_ context.Context, key string) error {
 	fullPath := filepath.Join(s.root, key)
 	if err := os.Remove(fullPath); err != nil {
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"io"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,11 +12,25 @@ import (
 type Backend interface {
 	// PutLogs stores compressed logs and returns metadata.
 	// logType distinguishes the bucket path prefix (e.g. "logs" vs "security").
-	PutLogs(ctx context.Context, customerID, zoneID uuid.UUID, from, to time.Time, raw []byte, logType string) (key, sha256hex string, compressedBytes, logLines int64, err error)
+	PutLogs(ctx context.Context, customerID, zoneID uuid.UUID, from, to time.Time, raw []byte, logType, dataset string) (key, sha256hex string, compressedBytes, logLines int64, err error)
 
-	// GetLogs retrieves the raw compressed content of a log object.
+	// PutLogsStream compresses and stores NDJSON read from r in a single pass
+	// with constant memory, returning the same metadata as PutLogs. The key
+	// ends in jobID, so a retried job overwrites its object rather than
+	// leaving another behind.
+	PutLogsStream(ctx context.Context, customerID, zoneID uuid.UUID, from, to time.Time, r io.Reader, logType, dataset string, jobID uuid.UUID) (key, sha256hex string, compressedBytes, logLines int64, err error)
+
+	// GetLogs retrieves the decompressed content of a log object.
 	GetLogs(ctx context.Context, key string) ([]byte, error)
 
+	// GetObject opens a log object for reading its stored, compressed bytes,
+	// the bytes its SHA-256 is taken over. The caller closes it.
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// GetLogsStream opens a log object for reading its decompressed content
+	// without buffering it. The caller closes it.
+	GetLogsStream(ctx context.Context, key string) (io.ReadCloser, error)
+
 	// DeleteObject removes a log object (used for retention/expiry).
 	DeleteObject(ctx context.Context, key string) error
 
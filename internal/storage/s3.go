@@ -9,11 +9,14 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
 
 	"github.com/fabriziosalmi/rainlogs/internal/config"
@@ -81,8 +84,8 @@ func (s *Store) Provider() string { return s.provider }
 // PutLogs compresses raw NDJSON bytes and uploads to S3.
 // Returns: S3 key, SHA-256 hex of compressed bytes, compressed byte count, log line count.
 // Uses a deterministic key so duplicate uploads are idempotent.
-func (s *Store) PutLogs(ctx context.Context, customerID, zoneID uuid.UUID, from, to time.Time, raw []byte, logType string) (key, sha256hex string, compressedBytes, logLines int64, err error) {
-	compressed, meta, err := PrepareBlob(raw, customerID, zoneID, from, to, logType)
+func (s *Store) PutLogs(ctx context.Context, customerID, zoneID uuid.UUID, from, to time.Time, raw []byte, logType, dataset string) (key, sha256hex string, compressedBytes, logLines int64, err error) {
+	compressed, meta, err := PrepareBlob(raw, customerID, zoneID, from, to, logType, dataset)
 	if err != nil {
 		return "", "", 0, 0, err
 	}
@@ -101,6 +104,107 @@ func (s *Store) PutLogs(ctx context.Context, customerID, zoneID uuid.UUID, from,
 	return meta.Key, meta.SHA256, meta.Size, meta.Lines, nil
 }
 
+// multipartPartSize is the buffered part size for streamed uploads. It bounds
+// per-upload memory and must stay above the S3 minimum of 5 MiB.
+const multipartPartSize = 8 << 20
+
+// PutLogsStream gzips r and uploads it with an S3 multipart upload, holding at
+// most one part in memory. Because the content hash is only known once the
+// upload has finished, the key suffix is the job ID rather than the SHA-256
+// prefix used by PutLogs, and the hash is returned (for the LogJob) but not
+// stored as object metadata.
+func (s *Store) PutLogsStream(ctx context.Context, customerID, zoneID uuid.UUID, from, to time.Time, r io.Reader, logType, dataset string, jobID uuid.UUID) (key, sha256hex string, compressedBytes, logLines int64, err error) {
+	key = ObjectKey(logType, dataset, customerID, zoneID, from, to, jobID.String())
+
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String("application/x-ndjson+gzip"),
+	})
+	if err != nil {
+		return "", "", 0, 0, fmt.Errorf("storage: create multipart upload: %w", err)
+	}
+	uploadID := created.UploadId
+
+	abort := func() {
+		// Use a non-cancelled context so a cancelled pull still releases the parts.
+		_, _ = s.client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.bucket),
+			Key:      aws.String(key),
+			UploadId: uploadID,
+		})
+	}
+
+	type compressResult struct {
+		sha256hex string
+		size      int64
+		lines     int64
+		err       error
+	}
+	pr, pw := io.Pipe()
+	done := make(chan compressResult, 1)
+	go func() {
+		var res compressResult
+		res.sha256hex, res.size, res.lines, res.err = CompressStream(pw, r)
+		pw.CloseWithError(res.err)
+		done <- res
+	}()
+
+	parts, err := s.uploadParts(ctx, key, uploadID, pr)
+	if err != nil {
+		pr.CloseWithError(err) // unblock the compressor
+		<-done
+		abort()
+		return "", "", 0, 0, err
+	}
+	res := <-done
+	if res.err != nil {
+		abort()
+		return "", "", 0, 0, res.err
+	}
+
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		abort()
+		return "", "", 0, 0, fmt.Errorf("storage: complete multipart upload: %w", err)
+	}
+	return key, res.sha256hex, res.size, res.lines, nil
+}
+
+// uploadParts reads r in multipartPartSize chunks and uploads each as a part.
+func (s *Store) uploadParts(ctx context.Context, key string, uploadID *string, r io.Reader) ([]types.CompletedPart, error) {
+	buf := make([]byte, multipartPartSize)
+	var parts []types.CompletedPart
+	for partNumber := int32(1); ; partNumber++ {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			out, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:        aws.String(s.bucket),
+				Key:           aws.String(key),
+				UploadId:      uploadID,
+				PartNumber:    aws.Int32(partNumber),
+				Body:          bytes.NewReader(buf[:n]),
+				ContentLength: aws.Int64(int64(n)),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("storage: upload part %d: %w", partNumber, err)
+			}
+			parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(partNumber)})
+		}
+		switch {
+		case readErr == io.EOF || readErr == io.ErrUnexpectedEOF:
+			return parts, nil
+		case readErr != nil:
+			return nil, fmt.Errorf("storage: read stream: %w", readErr)
+		}
+	}
+}
+
 // GetLogs downloads and decompresses a stored log object.
 func (s *Store) GetLogs(ctx context.Context, key string) ([]byte, error) {
 	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
@@ -115,6 +219,27 @@ func (s *Store) GetLogs(ctx context.Context, key string) ([]byte, error) {
 	return DecompressBlob(out.Body)
 }
 
+// GetLogsStream opens a stored log object for streaming decompression.
+func (s *Store) GetLogsStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, err := s.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return DecompressStream(body)
+}
+
+// GetObject opens a stored log object as stored, compressed.
+func (s *Store) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("storage: get object: %w", err)
+	}
+	return out.Body, nil
+}
+
 // DeleteObject removes an object (used by GDPR art.17 expiry worker).
 func (s *Store) DeleteObject(ctx context.Context, key string) error {
 	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
@@ -131,7 +256,9 @@ func (s *Store) DeleteObject(ctx context.Context, key string) error {
 
 // MultiStore tries providers in order and returns on first success.
 type MultiStore struct {
-	providers []Backend
+	providers     []Backend
+	spoolDir      string // "" = the system temp directory
+	spoolMaxBytes int64  // 0 = unlimited
 }
 
 // NewMultiStore creates a MultiStore from a list of Stores (primary first).
@@ -139,13 +266,20 @@ func NewMultiStore(providers ...Backend) *MultiStore {
 	return &MultiStore{providers: providers}
 }
 
+// WithSpool sets where streams are spooled for failover, and how much of a
+// stream is spooled at most, and returns the store.
+func (m *MultiStore) WithSpool(dir string, maxBytes int64) *MultiStore {
+	m.spoolDir, m.spoolMaxBytes = dir, maxBytes
+	return m
+}
+
 // PutLogs uploads to the first available provider.
 // Returns the winning provider label alongside the object metadata.
-func (m *MultiStore) PutLogs(ctx context.Context, customerID, zoneID uuid.UUID, from, to time.Time, raw []byte, logType string) (key, sha256hex, provider string, compressedBytes, logLines int64, err error) {
+func (m *MultiStore) PutLogs(ctx context.Context, customerID, zoneID uuid.UUID, from, to time.Time, raw []byte, logType, dataset string) (key, sha256hex, provider string, compressedBytes, logLines int64, err error) {
 	for _, p := range m.providers {
 		var k, h string
 		var cb, ll int64
-		k, h, cb, ll, err = p.PutLogs(ctx, customerID, zoneID, from, to, raw, logType)
+		k, h, cb, ll, err = p.PutLogs(ctx, customerID, zoneID, from, to, raw, logType, dataset)
 		if err == nil {
 			return k, h, p.Provider(), cb, ll, nil
 		}
@@ -154,6 +288,84 @@ func (m *MultiStore) PutLogs(ctx context.Context, customerID, zoneID uuid.UUID,
 	return "", "", "", 0, 0, fmt.Errorf("storage: all providers failed, last error: %w", err)
 }
 
+// PutLogsStream streams to the first available provider. A stream can only be
+// consumed once, so unless r can be rewound it is spooled to a temporary file
+// first, from which each provider in turn reads it. A stream larger than the
+// spool limit goes to the first provider only, without failover.
+func (m *MultiStore) PutLogsStream(ctx context.Context, customerID, zoneID uuid.UUID, from, to time.Time, r io.Reader, logType, dataset string, jobID uuid.UUID) (key, sha256hex, provider string, compressedBytes, logLines int64, err error) {
+	providers := m.providers
+	seeker, seekable := r.(io.ReadSeeker)
+	if !seekable && len(providers) > 1 {
+		f, complete, err := m.spoolStream(r)
+		if err != nil {
+			return "", "", "", 0, 0, err
+		}
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+		if complete {
+			seeker, r = f, f
+		} else {
+			r, providers = io.MultiReader(f, r), providers[:1]
+		}
+	}
+	for i, p := range providers {
+		// Past the first provider seeker is set: there are several.
+		if i > 0 {
+			if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+				break
+			}
+		}
+		var k, h string
+		var cb, ll int64
+		k, h, cb, ll, err = p.PutLogsStream(ctx, customerID, zoneID, from, to, r, logType, dataset, jobID)
+		if err == nil {
+			return k, h, p.Provider(), cb, ll, nil
+		}
+	}
+	return "", "", "", 0, 0, fmt.Errorf("storage: all providers failed, last error: %w", err)
+}
+
+// Spool creates a temporary file in the spool directory, for a stream that
+// has to be read more than once. The caller closes and removes it.
+func (m *MultiStore) Spool() (*os.File, error) {
+	if m.spoolDir != "" {
+		if err := os.MkdirAll(m.spoolDir, 0o700); err != nil {
+			return nil, fmt.Errorf("storage: spool: %w", err)
+		}
+	}
+	f, err := os.CreateTemp(m.spoolDir, "rainlogs-upload-*.ndjson")
+	if err != nil {
+		return nil, fmt.Errorf("storage: spool: %w", err)
+	}
+	return f, nil
+}
+
+// spoolStream copies r, up to the spool limit, to a temporary file and
+// returns it rewound. complete is false when r holds more than the limit.
+func (m *MultiStore) spoolStream(r io.Reader) (f *os.File, complete bool, err error) {
+	f, err = m.Spool()
+	if err != nil {
+		return nil, false, err
+	}
+	src, complete := r, true
+	if m.spoolMaxBytes > 0 {
+		src = io.LimitReader(r, m.spoolMaxBytes+1)
+	}
+	n, err := io.Copy(f, src)
+	if err == nil {
+		complete = m.spoolMaxBytes <= 0 || n <= m.spoolMaxBytes
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, false, fmt.Errorf("storage: spool stream: %w", err)
+	}
+	return f, complete, nil
+}
+
 // GetLogs fetches from the first provider that has the object.
 // It iterates providers in order.
 func (m *MultiStore) GetLogs(ctx context.Context, key string) ([]byte, error) {
@@ -170,6 +382,32 @@ func (m *MultiStore) GetLogs(ctx context.Context, key string) ([]byte, error) {
 	return nil, fmt.Errorf("storage: all providers failed or object not found: %w", lastErr)
 }
 
+// GetLogsStream opens the object on the first provider that has it.
+func (m *MultiStore) GetLogsStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	var lastErr error
+	for _, p := range m.providers {
+		rc, err := p.GetLogsStream(ctx, key)
+		if err == nil {
+			return rc, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("storage: all providers failed or object not found: %w", lastErr)
+}
+
+// GetObject opens the stored object on the first provider that has it.
+func (m *MultiStore) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	var lastErr error
+	for _, p := range m.providers {
+		rc, err := p.GetObject(ctx, key)
+		if err == nil {
+			return rc, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("storage: all providers failed or object not found: %w", lastErr)
+}
+
 // DeleteObject deletes from all providers (best-effort/consistency).
 // We must try to delete from all configured backends to ensure no data residue.
 func (m *MultiStore) DeleteObject(ctx context.Context, key string) error {
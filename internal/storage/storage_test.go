@@ -3,7 +3,12 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -29,7 +34,7 @@ func TestFSStore(t *testing.T) {
 	rawLogs := []byte("{\"event\":\"test1\"}\n{\"event\":\"test2\"}\n")
 
 	// Test PutLogs
-	key, sha256hex, size, lines, err := store.PutLogs(ctx, customerID, zoneID, now, now.Add(time.Second), rawLogs, "logs")
+	key, sha256hex, size, lines, err := store.PutLogs(ctx, customerID, zoneID, now, now.Add(time.Second), rawLogs, "logs", "http_requests")
 	if err != nil {
 		t.Fatalf("PutLogs failed: %v", err)
 	}
@@ -76,7 +81,7 @@ func TestPrepareBlob(t *testing.T) {
 	start := time.Now()
 	end := start.Add(time.Minute)
 
-	compressed, meta, err := PrepareBlob(raw, cid, zid, start, end, "logs")
+	compressed, meta, err := PrepareBlob(raw, cid, zid, start, end, "logs", "http_requests")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -96,3 +101,176 @@ func TestPrepareBlob(t *testing.T) {
 		t.Errorf("roundtrip mismatch: want %q, got %q", raw, decompressed)
 	}
 }
+
+func TestFSStorePutLogsStream(t *testing.T) {
+	store, err := NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	ctx := context.Background()
+	cid := uuid.New()
+	zid := uuid.New()
+	start := time.Now()
+	end := start.Add(time.Hour)
+	raw := bytes.Repeat([]byte("{\"RayID\":\"abc\"}\n"), 10000)
+
+	jobID := uuid.New()
+	key, sha256hex, size, lines, err := store.PutLogsStream(ctx, cid, zid, start, end, bytes.NewReader(raw), "logs", "http_requests", jobID)
+	if err != nil {
+		t.Fatalf("PutLogsStream failed: %v", err)
+	}
+	if lines != 10000 {
+		t.Errorf("expected 10000 lines, got %d", lines)
+	}
+	if size == 0 {
+		t.Error("expected non-zero size")
+	}
+
+	// The streamed object must be byte-identical to the buffered path, and
+	// keyed by its job.
+	_, meta, err := PrepareBlob(raw, cid, zid, start, end, "logs", "http_requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha256hex != meta.SHA256 || size != meta.Size {
+		t.Errorf("stream metadata %s/%d differs from buffered %s/%d", sha256hex, size, meta.SHA256, meta.Size)
+	}
+	if want := ObjectKey("logs", "http_requests", cid, zid, start, end, jobID.String()); key != want {
+		t.Errorf("key = %q, want %q", key, want)
+	}
+
+	// A retried job replaces its object instead of adding one.
+	retryKey, _, _, _, err := store.PutLogsStream(ctx, cid, zid, start, end, bytes.NewReader(raw), "logs", "http_requests", jobID)
+	if err != nil || retryKey != key {
+		t.Errorf("retry stored %q, %v; want %q", retryKey, err, key)
+	}
+	if files, _ := os.ReadDir(filepath.Dir(filepath.Join(store.root, key))); len(files) != 1 {
+		t.Errorf("%d objects after a retry, want 1", len(files))
+	}
+
+	obj, err := store.GetObject(ctx, key)
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, obj)
+	obj.Close()
+	if err != nil || hex.EncodeToString(h.Sum(nil)) != sha256hex {
+		t.Errorf("stored object does not hash to %s: %v", sha256hex, err)
+	}
+
+	readBack, err := store.GetLogs(ctx, key)
+	if err != nil {
+		t.Fatalf("GetLogs failed: %v", err)
+	}
+	if !bytes.Equal(readBack, raw) {
+		t.Error("roundtrip mismatch")
+	}
+
+	rc, err := store.GetLogsStream(ctx, key)
+	if err != nil {
+		t.Fatalf("GetLogsStream failed: %v", err)
+	}
+	defer rc.Close()
+	if streamed, err := io.ReadAll(rc); err != nil || !bytes.Equal(streamed, raw) {
+		t.Errorf("stream roundtrip mismatch: %v", err)
+	}
+}
+
+func TestObjectKeyDataset(t *testing.T) {
+	cid := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+	zid := uuid.MustParse("22222222-2222-2222-2222-222222222222")
+	from := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	got := ObjectKey("logpush", "dns_logs", cid, zid, from, from.Add(time.Minute), "abcd1234")
+	want := "logpush/" + cid.String() + "/" + zid.String() + "/dns_logs/2024/01/15/20240115T100000Z_20240115T100100Z_abcd1234.ndjson.gz"
+	if got != want {
+		t.Errorf("ObjectKey = %q, want %q", got, want)
+	}
+
+	got = ObjectKey("audit", "audit_logs", cid, uuid.Nil, from, from.Add(time.Hour), "abcd1234")
+	want = "audit/" + cid.String() + "/account/audit_logs/2024/01/15/20240115T100000Z_20240115T110000Z_abcd1234.ndjson.gz"
+	if got != want {
+		t.Errorf("ObjectKey = %q, want %q", got, want)
+	}
+}
+
+// failingStore is a Backend whose uploads fail after reading part of the
+// stream, as an S3 provider does when it drops mid-upload.
+type failingStore struct{ *FSStore }
+
+func (failingStore) Provider() string { return "failing" }
+
+func (failingStore) PutLogsStream(_ context.Context, _, _ uuid.UUID, _, _ time.Time, r io.Reader, _, _ string, _ uuid.UUID) (string, string, int64, int64, error) {
+	_, _ = io.CopyN(io.Discard, r, 100)
+	return "", "", 0, 0, errors.New("provider unavailable")
+}
+
+func TestMultiStorePutLogsStreamFailover(t *testing.T) {
+	fallback, err := NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMultiStore(failingStore{fallback}, fallback)
+
+	ctx := context.Background()
+	start := time.Now()
+	raw := bytes.Repeat([]byte("{\"RayID\":\"abc\"}\n"), 1000)
+
+	// The stream is neither seekable nor readable twice, like a Cloudflare
+	// response hashed on the way through.
+	h := sha256.New()
+	key, _, provider, _, lines, err := m.PutLogsStream(ctx, uuid.New(), uuid.New(), start, start.Add(time.Hour), io.TeeReader(bytes.NewReader(raw), h), "logs", "http_requests", uuid.New())
+	if err != nil {
+		t.Fatalf("PutLogsStream: %v", err)
+	}
+	if provider != "filesystem" || lines != 1000 {
+		t.Errorf("provider %s with %d lines, want filesystem with 1000", provider, lines)
+	}
+	if want := sha256.Sum256(raw); !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Error("stream hash differs from the content's")
+	}
+	readBack, err := fallback.GetLogs(ctx, key)
+	if err != nil || !bytes.Equal(readBack, raw) {
+		t.Errorf("GetLogs = %d bytes, %v; want the full stream", len(readBack), err)
+	}
+}
+
+func TestMultiStorePutLogsStreamSpoolLimit(t *testing.T) {
+	primary, err := NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback, err := NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool := t.TempDir()
+	ctx := context.Background()
+	start := time.Now()
+	raw := bytes.Repeat([]byte("{\"RayID\":\"abc\"}\n"), 1000)
+
+	// A stream above the limit goes to the primary only, whole.
+	m := NewMultiStore(primary, fallback).WithSpool(spool, 100)
+	key, _, provider, _, lines, err := m.PutLogsStream(ctx, uuid.New(), uuid.New(), start, start.Add(time.Hour), io.MultiReader(bytes.NewReader(raw)), "logs", "http_requests", uuid.New())
+	if err != nil {
+		t.Fatalf("PutLogsStream: %v", err)
+	}
+	if provider != "filesystem" || lines != 1000 {
+		t.Errorf("provider %s with %d lines, want filesystem with 1000", provider, lines)
+	}
+	if readBack, err := primary.GetLogs(ctx, key); err != nil || !bytes.Equal(readBack, raw) {
+		t.Errorf("GetLogs = %d bytes, %v; want the full stream", len(readBack), err)
+	}
+
+	// ...and without failover.
+	m = NewMultiStore(failingStore{fallback}, fallback).WithSpool(spool, 100)
+	if _, _, _, _, _, err := m.PutLogsStream(ctx, uuid.New(), uuid.New(), start, start.Add(time.Hour), io.MultiReader(bytes.NewReader(raw)), "logs", "http_requests", uuid.New()); err == nil {
+		t.Error("a stream above the spool limit failed over")
+	}
+
+	if files, _ := os.ReadDir(spool); len(files) != 0 {
+		t.Errorf("%d spool files left behind", len(files))
+	}
+}
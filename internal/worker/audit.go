@@ -0,0 +1,261 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+	"github.com/fabriziosalmi/rainlogs/internal/db"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/notifications"
+	"github.com/fabriziosalmi/rainlogs/internal/queue"
+	"github.com/fabriziosalmi/rainlogs/internal/storage"
+	"github.com/fabriziosalmi/rainlogs/pkg/worm"
+)
+
+// auditLogsPerJob bounds the audit log entries archived in one job. A pull
+// that reaches it archives what it drained and enqueues the next pull.
+const auditLogsPerJob = 50000
+
+const (
+	auditLeaseKeyPrefix = "rainlogs:audit:lease:"
+	// auditLeaseTTL outlasts a pull (asynq's default task timeout), so a
+	// crashed pull holds up its customer's next one at most this long.
+	auditLeaseTTL = 30 * time.Minute
+)
+
+// AuditLogProcessor archives a customer's Cloudflare account audit logs. Each
+// pull resumes from the customer's high-watermark, archives the entries up to
+// cloudflare.AuditLogDelay before now as one job of the customer's audit log
+// chain, then advances the watermark. A customer's pulls run one at a time
+// under a Redis lease: a scheduled pull and a follow-up may both be queued.
+type AuditLogProcessor struct {
+	db       *db.DB
+	creds    *Credentials
+	storage  *storage.MultiStore
+	queue    *asynq.Client
+	rdb      redis.UniversalClient
+	cfCfg    config.CloudflareConfig
+	limits   *RateLimiter
+	log      *zap.Logger
+	notifier notifications.NotificationService
+}
+
+func NewAuditLogProcessor(db *db.DB, creds *Credentials, storage *storage.MultiStore, queue *asynq.Client, rdb redis.UniversalClient, cfCfg config.CloudflareConfig, limits *RateLimiter, log *zap.Logger, notifier notifications.NotificationService) *AuditLogProcessor {
+	return &AuditLogProcessor{
+		db:       db,
+		creds:    creds,
+		storage:  storage,
+		queue:    queue,
+		rdb:      rdb,
+		cfCfg:    cfCfg,
+		limits:   limits,
+		log:      log,
+		notifier: notifier,
+	}
+}
+
+func (p *AuditLogProcessor) ProcessTask(ctx context.Context, t *asynq.Task) error {
+	payload, err := queue.ParseAuditLogPullPayload(t)
+	if err != nil {
+		return fmt.Errorf("parse payload: %w", err)
+	}
+
+	// The lease is this pull's own, even against pulls on the same replica.
+	// The customer is read under it, so the watermark is the last pull's.
+	key := payload.CustomerID.String()
+	lease := &LeaseStore{rdb: p.rdb, prefix: auditLeaseKeyPrefix, owner: uuid.NewString(), ttl: auditLeaseTTL}
+	held, err := lease.Acquire(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !held {
+		// The pull holding it resumes from the watermark and enqueues any
+		// follow-up.
+		return nil
+	}
+	next, err := p.pull(ctx, payload.CustomerID)
+	if relErr := lease.Release(ctx, key); relErr != nil {
+		p.log.Warn("release audit log lease", zap.String("customer_id", key), zap.Error(relErr))
+	}
+	if err == nil && next != nil {
+		p.enqueueNext(ctx, payload.CustomerID, *next)
+	}
+	return err
+}
+
+// pull archives the customer's audit logs from the watermark and advances it.
+// It returns where the next pull resumes when it stopped short of the window
+// because of auditLogsPerJob.
+func (p *AuditLogProcessor) pull(ctx context.Context, customerID uuid.UUID) (*time.Time, error) {
+	customer, err := p.db.Customers.GetByID(ctx, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("get customer: %w", err)
+	}
+	if customer.CFAccountID == "" {
+		return nil, nil
+	}
+	from, to := auditLogWindow(customer, time.Now().UTC())
+	if !from.Before(to) {
+		return nil, nil
+	}
+
+	if customer.QuotaBytes != -1 {
+		usage, err := p.db.LogJobs.GetCurrentUsage(ctx, customer.ID)
+		if err != nil {
+			return nil, fmt.Errorf("check quota: %w", err)
+		}
+		if usage >= customer.QuotaBytes {
+			// The watermark stays put, so the next pull after the quota is
+			// raised or reset catches up.
+			p.log.Warn("quota exceeded, audit logs not archived", zap.String("customer_id", customer.ID.String()))
+			return nil, nil
+		}
+	}
+
+	apiKey, err := p.creds.ForCustomer(customer)
+	if err != nil {
+		return nil, err
+	}
+	client := cloudflare.NewAccountClient(p.cfCfg, customer.CFAccountID, apiKey).WithLimiter(p.limits.ForAccount(apiKey))
+	logs, drainedUntil, err := client.GetAuditLogs(ctx, from, to, auditLogsPerJob)
+	if err != nil {
+		// No job exists yet; failures the customer has to fix are alerted,
+		// the rest retried.
+		if alert := cloudflareAlert(err); alert != "" {
+			msg := fmt.Sprintf("Audit log archival failed: %s (%v)", alert, err)
+			if alertErr := p.notifier.SendAlert(ctx, customer.ID.String(), "error", msg); alertErr != nil {
+				p.log.Warn("failed to send failure alert", zap.Error(alertErr))
+			}
+		}
+		return nil, fmt.Errorf("fetch audit logs: %w", err)
+	}
+
+	if len(logs) > 0 {
+		if err := p.archive(ctx, customer, from, drainedUntil, logs); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.db.Customers.SetAuditLogsArchivedUntil(ctx, customer.ID, drainedUntil); err != nil {
+		return nil, fmt.Errorf("advance audit log watermark: %w", err)
+	}
+	if drainedUntil.Before(to) {
+		return &drainedUntil, nil
+	}
+	return nil, nil
+}
+
+// archive stores logs as the audit log job for [from, to) and links it into
+// the customer's audit log chain.
+func (p *AuditLogProcessor) archive(ctx context.Context, customer *models.Customer, from, to time.Time, logs []cloudflare.AuditLog) error {
+	job := &models.LogJob{
+		ID:          uuid.New(),
+		CustomerID:  customer.ID,
+		PeriodStart: from,
+		PeriodEnd:   to,
+		LogType:     models.LogTypeAudit,
+		Dataset:     models.DatasetAuditLogs,
+		Status:      models.JobStatusPending,
+	}
+	if err := p.db.LogJobs.Create(ctx, job); err != nil {
+		return fmt.Errorf("create job: %w", err)
+	}
+
+	buffer, err := auditLogLines(logs)
+	if err != nil {
+		return p.failJob(ctx, job, err)
+	}
+	s3Key, s3HashStr, provider, byteCount, logCount, err := p.storage.PutLogs(ctx, customer.ID, uuid.Nil, from, to, buffer, job.LogType, job.Dataset)
+	if err != nil {
+		return p.failJob(ctx, job, fmt.Errorf("s3 upload: %w", err))
+	}
+	job.S3Key, job.S3Provider, job.SHA256 = s3Key, provider, s3HashStr
+	job.ByteCount, job.LogCount = byteCount, logCount
+
+	rawHash := sha256.Sum256(buffer)
+	err = p.db.LogJobs.FinishChained(ctx, job, func(prev string) string {
+		if prev == "" {
+			prev = worm.GenesisHash
+		}
+		return worm.ChainHash(prev, hex.EncodeToString(rawHash[:]), job.ID.String())
+	})
+	if err != nil {
+		return p.failJob(ctx, job, fmt.Errorf("chain job: %w", err))
+	}
+
+	verifyTask, err := queue.NewLogVerifyTask(queue.LogVerifyPayload{JobID: job.ID})
+	if err == nil {
+		_, err = p.queue.EnqueueContext(ctx, verifyTask)
+	}
+	if err != nil {
+		p.log.Error("enqueue verify task failed – WORM integrity check deferred",
+			zap.String("job_id", job.ID.String()),
+			zap.Error(err),
+		)
+	}
+	return nil
+}
+
+// enqueueNext schedules the pull that resumes after a bounded one, rather
+// than leaving the backlog to the next scheduled run.
+func (p *AuditLogProcessor) enqueueNext(ctx context.Context, customerID uuid.UUID, from time.Time) {
+	t, err := queue.NewAuditLogPullTask(queue.AuditLogPullPayload{CustomerID: customerID})
+	if err == nil {
+		_, err = p.queue.EnqueueContext(ctx, t, asynq.TaskID(fmt.Sprintf("audit-%s-%d", customerID, from.Unix())))
+		if errors.Is(err, asynq.ErrTaskIDConflict) {
+			err = nil
+		}
+	}
+	if err != nil {
+		// Not lost: the next scheduled pull resumes from the watermark.
+		p.log.Warn("enqueue audit log pull failed", zap.String("customer_id", customerID.String()), zap.Error(err))
+	}
+}
+
+func (p *AuditLogProcessor) failJob(ctx context.Context, job *models.LogJob, err error) error {
+	job.Attempts++
+	job.Status = models.JobStatusFailed
+	job.ErrMsg = err.Error()
+
+	if alertErr := p.notifier.SendAlert(ctx, job.CustomerID.String(), "error", fmt.Sprintf("Audit log archival failed: %v", err)); alertErr != nil {
+		p.log.Warn("failed to send failure alert", zap.Error(alertErr))
+	}
+
+	_ = p.db.LogJobs.Update(ctx, job)
+	return err
+}
+
+// auditLogWindow returns the window the customer's next audit log pull
+// covers: from the watermark, or the customer's retention back on the first
+// pull, to cloudflare.AuditLogDelay before now.
+func auditLogWindow(customer *models.Customer, now time.Time) (from, to time.Time) {
+	to = now.Add(-cloudflare.AuditLogDelay).Truncate(time.Second)
+	if customer.AuditLogsArchivedUntil != nil {
+		return customer.AuditLogsArchivedUntil.UTC(), to
+	}
+	return to.AddDate(0, 0, -customer.RetentionDays), to
+}
+
+// auditLogLines renders logs as NDJSON, one compacted entry per line.
+func auditLogLines(logs []cloudflare.AuditLog) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, l := range logs {
+		if err := json.Compact(&buf, l.Raw); err != nil {
+			return nil, fmt.Errorf("compact audit log %s: %w", l.ID, err)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
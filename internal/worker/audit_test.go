@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+func TestAuditLogWindow(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 30, 500, time.UTC)
+	to := time.Date(2026, 3, 10, 11, 55, 30, 0, time.UTC)
+
+	from, end := auditLogWindow(&models.Customer{RetentionDays: 30}, now)
+	assert.Equal(t, to, end)
+	assert.Equal(t, to.AddDate(0, 0, -30), from)
+
+	watermark := time.Date(2026, 3, 10, 11, 0, 0, 0, time.UTC)
+	from, end = auditLogWindow(&models.Customer{RetentionDays: 30, AuditLogsArchivedUntil: &watermark}, now)
+	assert.Equal(t, watermark, from)
+	assert.Equal(t, to, end)
+}
+
+func TestAuditLogLines(t *testing.T) {
+	logs := []cloudflare.AuditLog{
+		{ID: "a", Raw: json.RawMessage("{\n  \"id\": \"a\",\n  \"action\": {\"type\": \"login\"}\n}")},
+		{ID: "b", Raw: json.RawMessage(`{"id":"b"}`)},
+	}
+	out, err := auditLogLines(logs)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"id\":\"a\",\"action\":{\"type\":\"login\"}}\n{\"id\":\"b\"}\n", string(out))
+
+	_, err = auditLogLines([]cloudflare.AuditLog{{ID: "c", Raw: json.RawMessage(`{`)}})
+	assert.Error(t, err)
+}
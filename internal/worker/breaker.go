@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/breaker"
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/notifications"
+)
+
+// errBreakerOpen is returned instead of calling Cloudflare while the
+// customer's circuit breaker for the API family is open.
+var errBreakerOpen = errors.New("cloudflare circuit breaker open")
+
+// recordBreaker reports the outcome of a Cloudflare call to the customer's
+// breaker for family, alerting the customer when it opens. Cancellations and
+// failures countsForBreaker rejects aren't counted.
+func recordBreaker(ctx context.Context, b *breaker.Breakers, log *zap.Logger, notifier notifications.NotificationService, customerID uuid.UUID, f breaker.Family, err error) {
+	if err == nil {
+		if err := b.Success(ctx, customerID, f); err != nil {
+			log.Warn("record breaker success failed", zap.String("customer_id", customerID.String()), zap.Error(err))
+		}
+		return
+	}
+	if ctx.Err() != nil || !countsForBreaker(err) {
+		return
+	}
+	tripped, bErr := b.Failure(ctx, customerID, f)
+	if bErr != nil {
+		log.Warn("record breaker failure failed", zap.String("customer_id", customerID.String()), zap.Error(bErr))
+		return
+	}
+	if !tripped {
+		return
+	}
+	log.Warn("cloudflare circuit breaker opened",
+		zap.String("customer_id", customerID.String()),
+		zap.String("family", string(f)),
+		zap.Error(err),
+	)
+	msg := fmt.Sprintf("Cloudflare %s calls keep failing; collection is paused until a probe succeeds: %v", f, err)
+	if alertErr := notifier.SendAlert(ctx, customerID.String(), "error", msg); alertErr != nil {
+		log.Warn("failed to send circuit breaker alert", zap.Error(alertErr))
+	}
+}
+
+// countsForBreaker reports whether err counts as a failure of the API for the
+// whole account. 429s are the rate limiter's business, rejected tokens the
+// preflight's (a zone's own token fails only that zone), and refusals tied to
+// one zone (its plan, permissions, existence or retention) are handled per
+// zone.
+func countsForBreaker(err error) bool {
+	switch {
+	case errors.Is(err, cloudflare.ErrRateLimited),
+		errors.Is(err, cloudflare.ErrInvalidToken),
+		errors.Is(err, cloudflare.ErrMissingPermission),
+		errors.Is(err, cloudflare.ErrNotEntitled),
+		errors.Is(err, cloudflare.ErrZoneNotFound),
+		errors.Is(err, cloudflare.ErrRetentionDisabled):
+		return false
+	}
+	return true
+}
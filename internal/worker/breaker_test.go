@@ -0,0 +1,20 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+)
+
+func TestCountsForBreaker(t *testing.T) {
+	assert.True(t, countsForBreaker(errors.New("connection reset")))
+	assert.True(t, countsForBreaker(&cloudflare.APIError{StatusCode: 502, Kind: cloudflare.ErrServer}))
+	assert.False(t, countsForBreaker(fmt.Errorf("pull logs: %w", &cloudflare.RateLimitError{})))
+	assert.False(t, countsForBreaker(&cloudflare.APIError{StatusCode: 401, Kind: cloudflare.ErrInvalidToken}))
+	assert.False(t, countsForBreaker(&cloudflare.APIError{StatusCode: 403, Kind: cloudflare.ErrNotEntitled}))
+	assert.False(t, countsForBreaker(&cloudflare.APIError{StatusCode: 400, Kind: cloudflare.ErrRetentionDisabled}))
+}
@@ -0,0 +1,256 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+	"github.com/fabriziosalmi/rainlogs/internal/db"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/notifications"
+	"github.com/fabriziosalmi/rainlogs/internal/queue"
+)
+
+// GapScanProcessor looks for intervals of a zone's log type that no job
+// covers. Gaps Cloudflare still holds are pulled again on the low queue; gaps
+// that aged out of Cloudflare's retention since the previous scan are
+// reported, once, as lost.
+type GapScanProcessor struct {
+	db       *db.DB
+	queue    *asynq.Client
+	interval time.Duration
+	grace    time.Duration
+	log      *zap.Logger
+	notifier notifications.NotificationService
+}
+
+func NewGapScanProcessor(db *db.DB, queue *asynq.Client, cfg config.WorkerConfig, log *zap.Logger, notifier notifications.NotificationService) *GapScanProcessor {
+	return &GapScanProcessor{
+		db:       db,
+		queue:    queue,
+		interval: cfg.GapScanInterval,
+		grace:    cfg.GapScanGrace,
+		log:      log,
+		notifier: notifier,
+	}
+}
+
+// maxInFlightAge is how long a pending or running job, since its last update,
+// still counts as coverage. Older ones belong to tasks that were lost.
+const maxInFlightAge = time.Hour
+
+// gapScan is the range one scan of a zone looks at.
+type gapScan struct {
+	logType string
+	// [from, to) is scanned; later data may still be in flight.
+	from, to time.Time
+	// horizon is the oldest instant Cloudflare still serves. Gaps in
+	// [recoverFrom, to) are pulled again; gaps in [lostFrom, horizon) crossed
+	// the horizon since the previous scan.
+	horizon     time.Time
+	recoverFrom time.Time
+	lostFrom    time.Time
+}
+
+// planGapScan returns the scan range for zone at now, or false when there is
+// nothing to scan yet. first is the start of the zone's earliest job of its
+// log type, if any: coverage is not expected before collection began.
+func planGapScan(zone *models.Zone, first *time.Time, now time.Time, interval, grace time.Duration) (gapScan, bool) {
+	s := gapScan{logType: models.LogTypeForPlan(zone.Plan)}
+
+	// Pulled log types are complete up to the scheduler's high-watermark;
+	// Instant Logs are streamed up to now.
+	s.to = now
+	if s.logType != models.LogTypeInstant {
+		if zone.LastPulledAt == nil {
+			return s, false
+		}
+		s.to = *zone.LastPulledAt
+	}
+	s.to = s.to.Add(-grace)
+
+	retention := cloudflare.PullRetention(s.logType)
+	s.horizon = now.Add(-retention)
+	if s.horizon.After(s.to) {
+		s.horizon = s.to
+	}
+	// Leave a re-pull of the oldest data one grace period to run before
+	// Cloudflare drops it.
+	s.recoverFrom = s.to
+	if retention > 0 {
+		s.recoverFrom = s.horizon.Add(grace)
+	}
+	s.lostFrom = s.horizon.Add(-interval)
+
+	s.from = s.lostFrom
+	if zone.CreatedAt.After(s.from) {
+		s.from = zone.CreatedAt
+	}
+	if first != nil && first.After(s.from) {
+		s.from = *first
+	}
+	return s, s.from.Before(s.to)
+}
+
+// coveringPeriods drops the pending and running periods not updated since
+// staleBefore. periods stay sorted.
+func coveringPeriods(periods []models.JobPeriod, staleBefore time.Time) []models.JobPeriod {
+	out := make([]models.JobPeriod, 0, len(periods))
+	for _, p := range periods {
+		inFlight := p.Status == models.JobStatusPending || p.Status == models.JobStatusRunning
+		if inFlight && p.UpdatedAt.Before(staleBefore) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// split sorts gaps into the intervals to pull again and the intervals lost
+// since the previous scan. Instant Logs gaps are never reported lost: a zone
+// streams nothing while idle, so a missing job is no sign of missing logs.
+func (s gapScan) split(gaps []models.Interval) (recollect, lost []models.Interval) {
+	for _, g := range gaps {
+		if r, ok := clipInterval(g, s.recoverFrom, s.to); ok {
+			recollect = append(recollect, r)
+		}
+		if s.logType == models.LogTypeInstant {
+			continue
+		}
+		if l, ok := clipInterval(g, s.lostFrom, s.horizon); ok {
+			lost = append(lost, l)
+		}
+	}
+	return recollect, lost
+}
+
+// clipInterval returns the part of i inside [from, to), if any.
+func clipInterval(i models.Interval, from, to time.Time) (models.Interval, bool) {
+	if i.Start.Before(from) {
+		i.Start = from
+	}
+	if i.End.After(to) {
+		i.End = to
+	}
+	return i, i.Start.Before(i.End)
+}
+
+func (p *GapScanProcessor) ProcessTask(ctx context.Context, t *asynq.Task) error {
+	payload, err := queue.ParseGapScanPayload(t)
+	if err != nil {
+		return fmt.Errorf("parse payload: %w", err)
+	}
+
+	zone, err := p.db.Zones.GetByID(ctx, payload.ZoneID)
+	if err != nil {
+		return fmt.Errorf("get zone: %w", err)
+	}
+	if !zone.Active || zone.DeletedAt != nil {
+		return nil
+	}
+
+	logType := models.LogTypeForPlan(zone.Plan)
+	first, err := p.db.LogJobs.FirstPeriodStart(ctx, zone.ID, logType)
+	if err != nil {
+		return fmt.Errorf("first job: %w", err)
+	}
+	scan, ok := planGapScan(zone, first, time.Now().UTC(), p.interval, p.grace)
+	if !ok {
+		return nil
+	}
+
+	periods, err := p.db.LogJobs.ListPeriods(ctx, zone.ID, scan.logType, scan.from, scan.to)
+	if err != nil {
+		return fmt.Errorf("list periods: %w", err)
+	}
+	// Jobs recently in flight are not gaps: their tasks may yet succeed.
+	_, gaps := models.Coverage(scan.from, scan.to, coveringPeriods(periods, time.Now().Add(-maxInFlightAge)),
+		models.JobStatusDone, models.JobStatusExpired, models.JobStatusPending, models.JobStatusRunning)
+	recollect, lost := scan.split(gaps)
+
+	for _, gap := range recollect {
+		if err := p.recollect(ctx, zone, scan.logType, gap); err != nil {
+			return err
+		}
+		coverageRecollected.WithLabelValues(scan.logType).Inc()
+	}
+	if len(lost) > 0 {
+		p.reportLost(ctx, zone, scan.logType, lost)
+	}
+
+	p.log.Info("coverage gap scan",
+		zap.String("zone_id", zone.ID.String()),
+		zap.String("log_type", scan.logType),
+		zap.Int("gaps", len(gaps)),
+		zap.Int("recollected", len(recollect)),
+		zap.Int("lost", len(lost)),
+	)
+	return nil
+}
+
+// recollect enqueues a low-priority pull for gap. The task ID keeps repeated
+// scans from queueing the same gap twice while a pull for it is pending.
+func (p *GapScanProcessor) recollect(ctx context.Context, zone *models.Zone, logType string, gap models.Interval) error {
+	var task *asynq.Task
+	var err error
+	switch logType {
+	case models.LogTypeLogpull:
+		task, err = queue.NewLogPullTask(queue.LogPullPayload{
+			ZoneID:      zone.ID,
+			CustomerID:  zone.CustomerID,
+			PeriodStart: gap.Start,
+			PeriodEnd:   gap.End,
+		}, asynq.Queue(queue.QueueLow))
+	case models.LogTypeSecurity:
+		task, err = queue.NewSecurityPollTask(queue.SecurityPollPayload{
+			ZoneID:      zone.ID,
+			CustomerID:  zone.CustomerID,
+			PeriodStart: gap.Start,
+			PeriodEnd:   gap.End,
+		}, asynq.Queue(queue.QueueLow))
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("create %s task: %w", logType, err)
+	}
+
+	taskID := fmt.Sprintf("gap-%s-%s-%d-%d", zone.ID, logType, gap.Start.Unix(), gap.End.Unix())
+	if _, err := p.queue.EnqueueContext(ctx, task, asynq.TaskID(taskID)); err != nil {
+		if errors.Is(err, asynq.ErrTaskIDConflict) || errors.Is(err, asynq.ErrDuplicateTask) {
+			return nil
+		}
+		return fmt.Errorf("enqueue gap %s-%s: %w", gap.Start.Format(time.RFC3339), gap.End.Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+// reportLost records and alerts on gaps that can no longer be collected.
+func (p *GapScanProcessor) reportLost(ctx context.Context, zone *models.Zone, logType string, lost []models.Interval) {
+	var total time.Duration
+	ranges := make([]string, 0, len(lost))
+	for _, l := range lost {
+		total += l.Duration()
+		ranges = append(ranges, l.Start.Format(time.RFC3339)+" – "+l.End.Format(time.RFC3339))
+	}
+	coverageLostSeconds.WithLabelValues(zone.ID.String(), logType).Add(total.Seconds())
+
+	p.log.Warn("coverage gap unrecoverable",
+		zap.String("zone_id", zone.ID.String()),
+		zap.String("log_type", logType),
+		zap.Duration("missing", total),
+		zap.Strings("ranges", ranges),
+	)
+	msg := fmt.Sprintf("Zone %s has %s of %s logs that were never archived and are no longer held by Cloudflare: %s",
+		zone.Name, total, logType, strings.Join(ranges, ", "))
+	if err := p.notifier.SendAlert(ctx, zone.CustomerID.String(), "error", msg); err != nil {
+		p.log.Error("failed to send coverage alert", zap.Error(err))
+	}
+}
@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+func TestGapScan_Logpull(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	lastPulled := now.Add(-time.Minute)
+	zone := &models.Zone{
+		Plan:         models.PlanEnterprise,
+		LastPulledAt: &lastPulled,
+		CreatedAt:    now.Add(-30 * 24 * time.Hour),
+	}
+
+	scan, ok := planGapScan(zone, nil, now, time.Hour, time.Hour)
+	require.True(t, ok)
+	assert.Equal(t, models.LogTypeLogpull, scan.logType)
+	assert.True(t, scan.to.Equal(lastPulled.Add(-time.Hour)))
+	horizon := now.Add(-7 * 24 * time.Hour)
+	assert.True(t, scan.horizon.Equal(horizon))
+	assert.True(t, scan.from.Equal(horizon.Add(-time.Hour)))
+
+	// One failed hour a day ago, one hour that just aged out, and a hole
+	// straddling the re-pull margin.
+	h := func(d time.Duration) time.Time { return horizon.Add(d) }
+	periods := []models.JobPeriod{
+		{Start: scan.from, End: h(-30 * time.Minute), Status: models.JobStatusDone},
+		{Start: h(0), End: h(30 * time.Minute), Status: models.JobStatusDone},
+		{Start: h(90 * time.Minute), End: now.Add(-25 * time.Hour), Status: models.JobStatusDone},
+		{Start: now.Add(-25 * time.Hour), End: now.Add(-24 * time.Hour), Status: models.JobStatusFailed},
+		{Start: now.Add(-24 * time.Hour), End: now.Add(-3 * time.Hour), Status: models.JobStatusDone},
+		{Start: now.Add(-3 * time.Hour), End: scan.to, Status: models.JobStatusRunning},
+	}
+	_, gaps := models.Coverage(scan.from, scan.to, periods,
+		models.JobStatusDone, models.JobStatusExpired, models.JobStatusPending, models.JobStatusRunning)
+	recollect, lost := scan.split(gaps)
+
+	assert.Equal(t, []models.Interval{
+		{Start: h(time.Hour), End: h(90 * time.Minute)},
+		{Start: now.Add(-25 * time.Hour), End: now.Add(-24 * time.Hour)},
+	}, recollect)
+	assert.Equal(t, []models.Interval{{Start: h(-30 * time.Minute), End: h(0)}}, lost)
+}
+
+func TestGapScan_InstantIsNeverRecollectedNorLost(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	first := now.Add(-70 * time.Minute)
+	zone := &models.Zone{Plan: models.PlanBusiness, CreatedAt: now.Add(-24 * time.Hour)}
+
+	scan, ok := planGapScan(zone, &first, now, time.Hour, 15*time.Minute)
+	require.True(t, ok)
+	assert.Equal(t, models.LogTypeInstant, scan.logType)
+	assert.True(t, scan.from.Equal(first))
+	assert.True(t, scan.to.Equal(now.Add(-15*time.Minute)))
+
+	gap := models.Interval{Start: now.Add(-40 * time.Minute), End: now.Add(-30 * time.Minute)}
+	recollect, lost := scan.split([]models.Interval{gap})
+	assert.Empty(t, recollect)
+	assert.Empty(t, lost)
+}
+
+func TestCoveringPeriods(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	fresh, stale := now.Add(-10*time.Minute), now.Add(-3*time.Hour)
+	periods := []models.JobPeriod{
+		{Status: models.JobStatusDone, UpdatedAt: stale},
+		{Status: models.JobStatusExpired, UpdatedAt: stale},
+		{Status: models.JobStatusPending, UpdatedAt: stale},
+		{Status: models.JobStatusRunning, UpdatedAt: fresh},
+		{Status: models.JobStatusRunning, UpdatedAt: stale},
+		{Status: models.JobStatusFailed, UpdatedAt: fresh},
+	}
+	got := coveringPeriods(periods, now.Add(-maxInFlightAge))
+	assert.Equal(t, []models.JobPeriod{periods[0], periods[1], periods[3], periods[5]}, got)
+}
+
+func TestGapScan_NothingPulledYet(t *testing.T) {
+	zone := &models.Zone{Plan: models.PlanFreePro, CreatedAt: time.Now()}
+	_, ok := planGapScan(zone, nil, time.Now(), time.Hour, time.Hour)
+	assert.False(t, ok)
+}
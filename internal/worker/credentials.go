@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"fmt"
+
+	"github.com/fabriziosalmi/rainlogs/internal/kms"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+// Credentials resolves the Cloudflare API token a collector calls Cloudflare
+// with. Zones may carry their own zone-scoped token; zones without one use
+// their customer's account-wide token.
+type Credentials struct {
+	kms *kms.Encryptor
+}
+
+func NewCredentials(kms *kms.Encryptor) *Credentials {
+	return &Credentials{kms: kms}
+}
+
+// ForZone returns the token for zone's Cloudflare calls.
+func (c *Credentials) ForZone(customer *models.Customer, zone *models.Zone) (string, error) {
+	if zone.CFAPIKeyEnc == "" {
+		return c.ForCustomer(customer)
+	}
+	key, err := c.kms.Decrypt(zone.CFAPIKeyEnc)
+	if err != nil {
+		return "", fmt.Errorf("decrypt zone cf key: %w", err)
+	}
+	return key, nil
+}
+
+// ForCustomer returns the customer's account-wide token, used for account
+// calls and by zones without their own.
+func (c *Credentials) ForCustomer(customer *models.Customer) (string, error) {
+	key, err := c.kms.Decrypt(customer.CFAPIKeyEnc)
+	if err != nil {
+		return "", fmt.Errorf("decrypt cf key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,34 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fabriziosalmi/rainlogs/internal/kms"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+func TestCredentialsForZone(t *testing.T) {
+	enc, err := kms.New("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	require.NoError(t, err)
+	customerKey, err := enc.Encrypt("customer-token")
+	require.NoError(t, err)
+	zoneKey, err := enc.Encrypt("zone-token")
+	require.NoError(t, err)
+
+	creds := NewCredentials(enc)
+	customer := &models.Customer{CFAPIKeyEnc: customerKey}
+
+	key, err := creds.ForZone(customer, &models.Zone{})
+	require.NoError(t, err)
+	assert.Equal(t, "customer-token", key, "zones without a token use the customer's")
+
+	key, err = creds.ForZone(customer, &models.Zone{CFAPIKeyEnc: zoneKey})
+	require.NoError(t, err)
+	assert.Equal(t, "zone-token", key)
+
+	_, err = creds.ForZone(customer, &models.Zone{CFAPIKeyEnc: "not-encrypted"})
+	assert.Error(t, err, "a broken zone token must not fall back to the customer's")
+}
@@ -0,0 +1,265 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+	"github.com/fabriziosalmi/rainlogs/internal/db"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/notifications"
+	"github.com/fabriziosalmi/rainlogs/internal/queue"
+)
+
+// autoEnrollPullIntervalSecs is the pull interval of auto-enrolled zones, the
+// shortest the API accepts.
+const autoEnrollPullIntervalSecs = 300
+
+// ZoneDiscoveryProcessor lists the zones of a customer's Cloudflare account,
+// records them with the plan Cloudflare reports, registers new active zones
+// when the customer opted into auto-enrollment, and flags registered zones
+// that no longer exist in Cloudflare. It then switches every registered zone
+// to the plan detected for it, whether or not the customer gave an account.
+type ZoneDiscoveryProcessor struct {
+	db       *db.DB
+	creds    *Credentials
+	plans    *PlanSwitcher
+	cfCfg    config.CloudflareConfig
+	limits   *RateLimiter
+	log      *zap.Logger
+	notifier notifications.NotificationService
+}
+
+func NewZoneDiscoveryProcessor(db *db.DB, creds *Credentials, plans *PlanSwitcher, cfCfg config.CloudflareConfig, limits *RateLimiter, log *zap.Logger, notifier notifications.NotificationService) *ZoneDiscoveryProcessor {
+	return &ZoneDiscoveryProcessor{
+		db:       db,
+		creds:    creds,
+		plans:    plans,
+		cfCfg:    cfCfg,
+		limits:   limits,
+		log:      log,
+		notifier: notifier,
+	}
+}
+
+// zonePlan is the plan detected for a zone and why. detected is false when
+// a probe failed, so the plan is a guess from the Cloudflare plan alone.
+type zonePlan struct {
+	plan     models.PlanType
+	reason   string
+	detected bool
+	status   string // the zone's Cloudflare status
+}
+
+func (p *ZoneDiscoveryProcessor) ProcessTask(ctx context.Context, t *asynq.Task) error {
+	payload, err := queue.ParseZoneDiscoverPayload(t)
+	if err != nil {
+		return fmt.Errorf("parse payload: %w", err)
+	}
+
+	customer, err := p.db.Customers.GetByID(ctx, payload.CustomerID)
+	if err != nil {
+		return fmt.Errorf("get customer: %w", err)
+	}
+	var listed map[string]zonePlan
+	if customer.CFAccountID != "" {
+		if listed, err = p.discoverAccount(ctx, customer); err != nil {
+			return err
+		}
+	}
+	return p.detectPlans(ctx, customer, listed)
+}
+
+// discoverAccount records the zones of the customer's account, enrolls new
+// ones and flags deleted ones. It returns the plans detected for the zones
+// listed, by Cloudflare zone ID.
+func (p *ZoneDiscoveryProcessor) discoverAccount(ctx context.Context, customer *models.Customer) (map[string]zonePlan, error) {
+	apiKey, err := p.creds.ForCustomer(customer)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := cloudflare.NewAccountClient(p.cfCfg, customer.CFAccountID, apiKey).WithLimiter(p.limits.ForAccount(apiKey)).ListZones(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list account zones: %w", err)
+	}
+
+	zones, err := p.db.Zones.ListByCustomer(ctx, customer.ID)
+	if err != nil {
+		return nil, fmt.Errorf("list zones: %w", err)
+	}
+	registered := make(map[string]*models.Zone, len(zones))
+	for _, z := range zones {
+		registered[z.ZoneID] = z
+	}
+
+	present := make([]string, 0, len(found))
+	listed := make(map[string]zonePlan, len(found))
+	var enrolled []string
+	for _, az := range found {
+		present = append(present, az.ID)
+		zp := p.detectZonePlan(ctx, apiKey, az)
+		listed[az.ID] = zp
+		cz := &models.CloudflareZone{
+			CustomerID: customer.ID,
+			CFZoneID:   az.ID,
+			Name:       az.Name,
+			Status:     az.Status,
+			CFPlan:     az.Plan.LegacyID,
+			Plan:       zp.plan,
+		}
+		if err := p.db.CFZones.Upsert(ctx, cz); err != nil {
+			return nil, fmt.Errorf("record zone %s: %w", az.ID, err)
+		}
+
+		if _, ok := registered[az.ID]; ok || !customer.AutoEnrollZones || az.Status != "active" {
+			continue
+		}
+		// Zones the customer registered before, including deleted ones, are
+		// left alone.
+		zone := &models.Zone{
+			ID:               uuid.New(),
+			CustomerID:       customer.ID,
+			ZoneID:           az.ID,
+			Name:             az.Name,
+			Plan:             cz.Plan,
+			PullIntervalSecs: autoEnrollPullIntervalSecs,
+			Active:           true,
+		}
+		created, err := p.db.Zones.CreateIfAbsent(ctx, zone)
+		if err != nil {
+			return nil, fmt.Errorf("enroll zone %s: %w", az.ID, err)
+		}
+		if created {
+			enrolled = append(enrolled, fmt.Sprintf("%s (%s)", az.Name, zone.Plan))
+			p.log.Info("zone auto-enrolled",
+				zap.String("customer_id", customer.ID.String()),
+				zap.String("zone", az.Name),
+				zap.String("plan", string(zone.Plan)),
+			)
+		}
+	}
+	if len(enrolled) > 0 {
+		msg := fmt.Sprintf("Auto-enrolled %d Cloudflare zone(s): %s", len(enrolled), strings.Join(enrolled, ", "))
+		if err := p.notifier.SendAlert(ctx, customer.ID.String(), "info", msg); err != nil {
+			p.log.Error("failed to send enrollment notice", zap.Error(err))
+		}
+	}
+
+	// An empty listing more likely means the token lost Zone:Read than that
+	// every zone was deleted, so nothing is flagged from it.
+	if len(found) == 0 {
+		p.log.Warn("zone discovery found no zones; skipping deletion check",
+			zap.String("customer_id", customer.ID.String()))
+		return listed, nil
+	}
+	if err := p.db.CFZones.MarkDeleted(ctx, customer.ID, present); err != nil {
+		return nil, fmt.Errorf("mark deleted zones: %w", err)
+	}
+	gone, err := p.db.Zones.SyncCFDeleted(ctx, customer.ID, present)
+	if err != nil {
+		return nil, fmt.Errorf("flag deleted zones: %w", err)
+	}
+	for _, zone := range gone {
+		p.log.Warn("registered zone deleted in Cloudflare",
+			zap.String("zone_id", zone.ID.String()),
+			zap.String("zone", zone.Name),
+		)
+		msg := fmt.Sprintf("Zone %s (%s) no longer exists in Cloudflare account %s; its collection will fail until it is removed or restored",
+			zone.Name, zone.ZoneID, customer.CFAccountID)
+		if err := p.notifier.SendAlert(ctx, customer.ID.String(), "warning", msg); err != nil {
+			p.log.Error("failed to send zone deletion alert", zap.Error(err))
+		}
+	}
+	return listed, nil
+}
+
+// detectPlans switches the customer's registered zones to the plan detected
+// for them. Zones listed in the account reuse the detection made there;
+// others, e.g. zones registered by ID under a zone-scoped token, are looked up
+// with the zone's token. Zones Cloudflare can't tell about are left alone.
+func (p *ZoneDiscoveryProcessor) detectPlans(ctx context.Context, customer *models.Customer, listed map[string]zonePlan) error {
+	zones, err := p.db.Zones.ListByCustomer(ctx, customer.ID)
+	if err != nil {
+		return fmt.Errorf("list zones: %w", err)
+	}
+	for _, zone := range zones {
+		if !zone.Active || zone.CFDeletedAt != nil {
+			continue
+		}
+		zp, ok := listed[zone.ZoneID]
+		if !ok {
+			apiKey, err := p.creds.ForZone(customer, zone)
+			if err != nil {
+				return fmt.Errorf("zone %s: %w", zone.Name, err)
+			}
+			az, err := cloudflare.NewClient(p.cfCfg, zone.ZoneID, apiKey).WithLimiter(p.limits.ForZone(apiKey, zone.Plan)).Zone(ctx)
+			if err != nil {
+				p.log.Warn("zone discovery: zone lookup failed",
+					zap.String("zone", zone.Name),
+					zap.Error(err),
+				)
+				continue
+			}
+			zp = p.detectZonePlan(ctx, apiKey, *az)
+		}
+		if !zp.detected || zp.status != "active" {
+			continue
+		}
+		if err := p.reconcilePlan(ctx, zone, zp.plan, zp.reason); err != nil {
+			return fmt.Errorf("zone %s: %w", zone.Name, err)
+		}
+	}
+	return nil
+}
+
+// detectZonePlan probes what az can be collected with: Logpull on Enterprise
+// zones, then Instant Logs on Business and Enterprise ones. When a probe
+// fails, the plan is guessed from the Cloudflare plan and not detected, so
+// registered zones keep theirs.
+func (p *ZoneDiscoveryProcessor) detectZonePlan(ctx context.Context, apiKey string, az cloudflare.AccountZone) zonePlan {
+	zp := zonePlan{reason: "Cloudflare plan " + az.Plan.LegacyID, status: az.Status}
+	cfPlan := models.PlanFromCloudflare(az.Plan.LegacyID)
+	if cfPlan == models.PlanFreePro {
+		zp.plan, zp.detected = models.PlanFreePro, true
+		return zp
+	}
+	var logpull bool
+	if cfPlan == models.PlanEnterprise {
+		var err error
+		logpull, err = cloudflare.NewClient(p.cfCfg, az.ID, apiKey).WithLimiter(p.limits.ForZone(apiKey, models.PlanEnterprise)).LogpullEnabled(ctx)
+		if err != nil {
+			p.log.Warn("zone discovery: logpull probe failed",
+				zap.String("zone", az.Name),
+				zap.Error(err),
+			)
+			zp.plan = models.PlanEnterprise
+			return zp
+		}
+		if logpull {
+			zp.plan, zp.detected = models.PlanEnterprise, true
+			return zp
+		}
+		zp.reason += ", Logpull retention disabled"
+	}
+	instant, err := cloudflare.NewInstantLogsClient(p.cfCfg, apiKey, az.ID).WithLimiter(p.limits.ForZone(apiKey, models.PlanBusiness)).Available(ctx)
+	if err != nil {
+		p.log.Warn("zone discovery: instant logs probe failed",
+			zap.String("zone", az.Name),
+			zap.Error(err),
+		)
+		zp.plan = models.PlanBusiness
+		return zp
+	}
+	if !instant {
+		zp.reason += ", Instant Logs not available"
+	}
+	zp.plan, zp.detected = detectPlan(az.Plan.LegacyID, logpull, instant), true
+	return zp
+}
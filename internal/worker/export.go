@@ -77,7 +77,7 @@ func (p *LogExportProcessor) ProcessTask(ctx context.Context, t *asynq.Task) err
 	})
 
 	// 4. List Log Jobs to Export
-	logs, err := p.db.LogJobs.ListForExport(ctx, exportJob.CustomerID, exportJob.FilterStart, exportJob.FilterEnd)
+	logs, err := p.db.LogJobs.ListForExport(ctx, exportJob.CustomerID, exportJob.FilterStart, exportJob.FilterEnd, exportJob.FilterDatasets)
 	if err != nil {
 		return p.failJob(ctx, exportJob, fmt.Errorf("list logs: %w", err))
 	}
@@ -1,54 +1,168 @@
 package worker
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
 	"go.uber.org/zap"
 
+	"github.com/fabriziosalmi/rainlogs/internal/breaker"
 	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
 	"github.com/fabriziosalmi/rainlogs/internal/config"
 	"github.com/fabriziosalmi/rainlogs/internal/db"
-	"github.com/fabriziosalmi/rainlogs/internal/kms"
 	"github.com/fabriziosalmi/rainlogs/internal/models"
 	"github.com/fabriziosalmi/rainlogs/internal/notifications"
+	"github.com/fabriziosalmi/rainlogs/internal/queue"
 	"github.com/fabriziosalmi/rainlogs/internal/storage"
+	"github.com/fabriziosalmi/rainlogs/pkg/worm"
 )
 
+const (
+	// instantBatchLines flushes a batch early once it holds this many lines.
+	instantBatchLines     = 2000
+	instantMinUploadRetry = 5 * time.Second
+	instantMaxUploadRetry = 5 * time.Minute
+)
+
+// instantBatch is a zone's spool plus its upload retry state. It outlives a
+// single stream session so a reconnect doesn't reset the backoff.
+type instantBatch struct {
+	spool      *zoneSpool
+	retryAt    time.Time
+	retryDelay time.Duration
+	dropping   bool // lines are being dropped; alerted once per episode
+	window     *atomic.Pointer[instantWindow]
+}
+
+// segmentEnd returns the end of a segment sealed at now. The last segment
+// before a handover ends where the pulled collector starts.
+func (b *instantBatch) segmentEnd(now time.Time) time.Time {
+	if w := b.window.Load(); w != nil && w.ended(now) {
+		return w.until
+	}
+	return now
+}
+
+// instantWindow bounds the lines a stream archives while a zone's plan hands
+// over between Instant Logs and a pulled collector; zero bounds are open.
+// Lines are admitted by receipt time, the timestamp spool segments carry.
+type instantWindow struct {
+	from, until time.Time
+}
+
+func (w *instantWindow) admits(t time.Time) bool {
+	return !t.Before(w.from) && (w.until.IsZero() || t.Before(w.until))
+}
+
+// ended reports whether the zone no longer needs a stream at t.
+func (w *instantWindow) ended(t time.Time) bool {
+	return !w.until.IsZero() && !t.Before(w.until)
+}
+
+// instantLogsWindow returns the window z is collected through Instant Logs,
+// and false when it isn't. A zone switched to Business streams from the
+// handover on; one switched away keeps streaming until the handover.
+func instantLogsWindow(z *models.Zone) (instantWindow, bool) {
+	switch {
+	case z.Plan == models.PlanBusiness:
+		if z.PlanChangedAt != nil && z.PreviousPlan != "" && z.PreviousPlan != models.PlanBusiness {
+			return instantWindow{from: *z.PlanChangedAt}, true
+		}
+		return instantWindow{}, true
+	case z.PreviousPlan == models.PlanBusiness && z.PlanChangedAt != nil:
+		return instantWindow{until: *z.PlanChangedAt}, true
+	}
+	return instantWindow{}, false
+}
+
+// backoff schedules the next upload of a batch that failed at now, doubling
+// the delay within [instantMinUploadRetry, instantMaxUploadRetry].
+func (b *instantBatch) backoff(now time.Time) {
+	b.retryDelay *= 2
+	if b.retryDelay < instantMinUploadRetry {
+		b.retryDelay = instantMinUploadRetry
+	}
+	if b.retryDelay > instantMaxUploadRetry {
+		b.retryDelay = instantMaxUploadRetry
+	}
+	b.retryAt = now.Add(b.retryDelay)
+}
+
+// zoneStream is a zone stream owned and run by this replica.
+type zoneStream struct {
+	zone      *models.Zone
+	cancel    context.CancelFunc
+	done      chan struct{}
+	renewedAt time.Time
+	window    atomic.Pointer[instantWindow] // refreshed on every sync
+}
+
 type InstantLogsManager struct {
 	db       *db.DB
-	kms      *kms.Encryptor
+	creds    *Credentials
 	storage  *storage.MultiStore
+	queue    *asynq.Client
+	leases   *LeaseStore
 	cfCfg    config.CloudflareConfig
+	spoolCfg config.WorkerConfig
+	limits   *RateLimiter
+	breakers *breaker.Breakers
 	log      *zap.Logger
 	notifier notifications.NotificationService
 	wg       sync.WaitGroup
 	mu       sync.Mutex
-	// streams tracks active stream cancellations by ZoneID
-	streams map[string]context.CancelFunc
+	// streams tracks the streams this replica holds a lease for, by zone ID
+	streams map[string]*zoneStream
+	// draining holds the done channel of the last stopped stream per zone
+	draining map[string]chan struct{}
+	archiver *segmentArchiver
 }
 
-func NewInstantLogsManager(db *db.DB, kms *kms.Encryptor, storage *storage.MultiStore, cfCfg config.CloudflareConfig, log *zap.Logger, notifier notifications.NotificationService) *InstantLogsManager {
+func NewInstantLogsManager(db *db.DB, creds *Credentials, storage *storage.MultiStore, queue *asynq.Client, leases *LeaseStore, cfCfg config.CloudflareConfig, workerCfg config.WorkerConfig, limits *RateLimiter, breakers *breaker.Breakers, log *zap.Logger, notifier notifications.NotificationService) *InstantLogsManager {
 	return &InstantLogsManager{
 		db:       db,
-		kms:      kms,
+		creds:    creds,
 		storage:  storage,
+		queue:    queue,
+		leases:   leases,
 		cfCfg:    cfCfg,
+		spoolCfg: workerCfg,
+		limits:   limits,
+		breakers: breakers,
 		log:      log,
 		notifier: notifier,
-		streams:  make(map[string]context.CancelFunc),
+		streams:  make(map[string]*zoneStream),
+		draining: make(map[string]chan struct{}),
+		archiver: &segmentArchiver{jobs: db.LogJobs, storage: storage, queue: queue, log: log},
 	}
 }
 
-// Start watches for Business zones and manages their log streams.
-// It handles dynamic addition/removal of zones and ensures robust reconnection.
+// Start watches for Business zones, and zones handing over to or from
+// Business, and manages their log streams.
+// Streams are spread across worker replicas with Redis leases: each replica
+// takes at most its fair share of zones, renews its leases on every sync and
+// hands them back on shutdown. It handles dynamic addition/removal of zones
+// and replicas and ensures robust reconnection.
 func (m *InstantLogsManager) Start(ctx context.Context) {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(m.leases.TTL() / 3)
 	defer ticker.Stop()
 
-	// Initial sync
+	// Archive whatever a previous process left in the spool, then sync
+	m.replaySpools(ctx)
 	m.syncStreams(ctx)
 
 	for {
@@ -62,104 +176,314 @@ func (m *InstantLogsManager) Start(ctx context.Context) {
 	}
 }
 
+// stopAll stops every stream, waits for their final flush and releases the
+// leases so other replicas take over without waiting for them to expire.
 func (m *InstantLogsManager) stopAll() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	for id, cancel := range m.streams {
-		cancel()
-		delete(m.streams, id)
+	streams := m.streams
+	m.streams = make(map[string]*zoneStream)
+	m.mu.Unlock()
+
+	for _, s := range streams {
+		s.cancel()
 	}
 	m.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for id := range streams {
+		if err := m.leases.Release(ctx, id); err != nil {
+			m.log.Warn("instant logs: release lease failed", zap.String("zone_id", id), zap.Error(err))
+		}
+	}
+	if err := m.leases.Leave(ctx); err != nil {
+		m.log.Warn("instant logs: leave replica set failed", zap.Error(err))
+	}
+}
+
+// Leases returns this replica's identity and the zones it streams, sorted by
+// zone ID.
+func (m *InstantLogsManager) Leases() (replica string, zones []*models.Zone) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.streams {
+		zones = append(zones, s.zone)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].ID.String() < zones[j].ID.String() })
+	return m.leases.Owner(), zones
 }
 
 func (m *InstantLogsManager) syncStreams(ctx context.Context) {
-	zones, err := m.db.Zones.ListActive(ctx)
+	zones, err := m.db.Zones.ListCollectable(ctx)
 	if err != nil {
 		m.log.Error("instant logs: list zones failed", zap.Error(err))
 		return
 	}
 
-	// Identify active Business zones
+	// Identify active Business zones with a usable token, including those
+	// still streaming up to a plan handover
+	now := time.Now()
 	businessZones := make(map[string]*models.Zone)
+	var ids []string
 	for _, z := range zones {
-		if z.Plan == models.PlanBusiness {
-			// Ensure we store a pointer to the zone, assuming zones is []*models.Zone
-			// Or if it's []models.Zone, Go 1.22 loop variable creates distinct address per iteration
+		if w, ok := instantLogsWindow(z); ok && !w.ended(now) {
 			businessZones[z.ID.String()] = z
+			ids = append(ids, z.ID.String())
 		}
 	}
+	sort.Strings(ids)
+
+	replicas, hbErr := m.leases.Heartbeat(ctx)
+	if hbErr != nil {
+		m.log.Error("instant logs: replica heartbeat failed", zap.Error(hbErr))
+	}
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// 1. Remove stopped/downgraded streams
-	for id, cancel := range m.streams {
+	// 1. Remove stopped/downgraded streams and renew the rest. A stream whose
+	// lease can't be confirmed for a full TTL may already be owned elsewhere.
+	for id, s := range m.streams {
 		if _, ok := businessZones[id]; !ok {
-			m.log.Info("stopping instant logs stream (zone removed or downgraded)", zap.String("zone_id", id))
-			cancel()
-			delete(m.streams, id)
+			m.log.Info("stopping instant logs stream (zone removed, downgraded or token unusable)", zap.String("zone_id", id))
+			m.stopStream(id, s, true)
+			continue
+		}
+		held, err := m.leases.Renew(ctx, id)
+		switch {
+		case err == nil && held:
+			s.renewedAt = time.Now()
+			z := businessZones[id]
+			w, _ := instantLogsWindow(z)
+			s.window.Store(&w)
+			if instantSettingsChanged(s.zone, z) {
+				m.log.Info("restarting instant logs stream (settings changed)", zap.String("zone", z.Name))
+				m.stopStream(id, s, false)
+				m.startStream(ctx, z)
+			}
+		case err == nil:
+			m.log.Warn("stopping instant logs stream (lease lost)", zap.String("zone", s.zone.Name))
+			m.stopStream(id, s, false)
+		case time.Since(s.renewedAt) >= m.leases.TTL():
+			m.log.Error("stopping instant logs stream (lease not renewable)", zap.String("zone", s.zone.Name), zap.Error(err))
+			m.stopStream(id, s, false)
+		default:
+			m.log.Warn("instant logs: lease renewal failed", zap.String("zone", s.zone.Name), zap.Error(err))
+		}
+	}
+	if hbErr != nil {
+		return
+	}
+
+	// 2. Hand over streams above our fair share, e.g. after a replica joined
+	share := fairShare(len(ids), replicas)
+	if excess := len(m.streams) - share; excess > 0 {
+		owned := make([]string, 0, len(m.streams))
+		for id := range m.streams {
+			owned = append(owned, id)
+		}
+		sort.Strings(owned)
+		for _, id := range owned[len(owned)-excess:] {
+			m.log.Info("handing over instant logs stream (rebalance)",
+				zap.String("zone", m.streams[id].zone.Name),
+				zap.Int("replicas", replicas),
+				zap.Int("share", share),
+			)
+			m.stopStream(id, m.streams[id], true)
 		}
 	}
 
-	// 2. Start new streams
-	for id, zone := range businessZones {
+	// 3. Start new streams for free zones, up to our fair share
+	for _, id := range ids {
+		if len(m.streams) >= share {
+			break
+		}
 		if _, exists := m.streams[id]; exists {
 			continue
 		}
+		held, err := m.leases.Acquire(ctx, id)
+		if err != nil {
+			m.log.Error("instant logs: acquire lease failed", zap.String("zone_id", id), zap.Error(err))
+			return
+		}
+		if !held {
+			continue
+		}
+
+		m.startStream(ctx, businessZones[id])
+	}
+}
+
+// startStream runs a stream for zone, whose lease the caller holds. If a
+// previous stream of the zone is still flushing, the new one waits for it so
+// only one goroutine writes the zone's spool. The caller holds m.mu.
+func (m *InstantLogsManager) startStream(ctx context.Context, zone *models.Zone) {
+	id := zone.ID.String()
+	m.log.Info("starting instant logs stream", zap.String("zone", zone.Name), zap.String("replica", m.leases.Owner()))
+
+	// Create a child context for this stream
+	ctxZone, cancel := context.WithCancel(ctx)
+	s := &zoneStream{zone: zone, cancel: cancel, done: make(chan struct{}), renewedAt: time.Now()}
+	w, _ := instantLogsWindow(zone)
+	s.window.Store(&w)
+	prev := m.draining[id]
+	m.streams[id] = s
+	m.wg.Add(1)
+
+	go func() {
+		defer m.wg.Done()
+		defer close(s.done)
+		if prev != nil {
+			select {
+			case <-prev:
+			case <-ctxZone.Done():
+				return
+			}
+		}
+		// Run the stream manager for this zone until ctxZone is cancelled
+		m.runZoneStream(ctxZone, s.zone, &s.window)
+	}()
+}
 
-		m.log.Info("starting instant logs stream", zap.String("zone", zone.Name))
+// instantLogsOptions maps a zone's stored settings to session parameters.
+func instantLogsOptions(z *models.Zone) cloudflare.InstantLogsOptions {
+	return cloudflare.InstantLogsOptions{
+		Fields: z.InstantFields,
+		Sample: z.InstantSample,
+		Filter: z.InstantFilter,
+	}
+}
 
-		// Create a child context for this stream
-		ctxZone, cancel := context.WithCancel(ctx)
-		m.streams[id] = cancel
-		m.wg.Add(1)
+// instantSettingsChanged reports whether a running stream must be restarted
+// to pick up new session parameters.
+func instantSettingsChanged(running, current *models.Zone) bool {
+	return !slices.Equal(running.InstantFields, current.InstantFields) ||
+		running.InstantSample != current.InstantSample ||
+		!bytes.Equal(running.InstantFilter, current.InstantFilter)
+}
 
-		go func(z *models.Zone) {
-			defer m.wg.Done()
-			// Run the stream manager for this zone until ctxZone is cancelled
-			m.runZoneStream(ctxZone, z)
+// stopStream cancels a stream; with release its lease is handed back once the
+// stream has flushed. The caller holds m.mu.
+func (m *InstantLogsManager) stopStream(id string, s *zoneStream, release bool) {
+	s.cancel()
+	delete(m.streams, id)
+	m.draining[id] = s.done
+	if !release {
+		return
+	}
+	go func() {
+		<-s.done
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := m.leases.Release(ctx, id); err != nil {
+			m.log.Warn("instant logs: release lease failed", zap.String("zone_id", id), zap.Error(err))
+		}
+	}()
+}
 
-			// Cleanup on exit (if it wasn't cancelled by syncStreams)
-			m.mu.Lock()
-			if _, ok := m.streams[z.ID.String()]; ok {
-				// Only delete if we are still in the map (avoid race with syncStreams removal)
-				// Actually, we shouldn't delete here if we want to restart on error?
-				// runZoneStream loops forever until context cancel, so if we are here, context is done.
-				// We can just let the map be cleaned up by syncStreams or stopAll.
+// replaySpools archives spools left on disk by a previous process, including
+// zones that are no longer streamed. Spools that can't be drained now stay on
+// disk and are picked up again when their zone's stream opens them.
+func (m *InstantLogsManager) replaySpools(ctx context.Context) {
+	entries, err := os.ReadDir(m.spoolCfg.SpoolDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			m.log.Error("instant logs: read spool dir failed", zap.Error(err))
+		}
+		return
+	}
+	for _, e := range entries {
+		zoneID, err := uuid.Parse(e.Name())
+		if !e.IsDir() || err != nil {
+			continue
+		}
+		zone, err := m.db.Zones.GetByID(ctx, zoneID)
+		if err != nil {
+			m.log.Error("instant logs: spool for unknown zone left on disk", zap.String("zone_id", e.Name()), zap.Error(err))
+			continue
+		}
+		spool, err := openZoneSpool(m.spoolDir(zone), m.spoolCfg.SpoolMaxBytes)
+		if err != nil {
+			m.log.Error("instant logs: open spool failed", zap.String("zone", zone.Name), zap.Error(err))
+			continue
+		}
+		if spool.Size() > 0 {
+			m.log.Info("replaying instant logs spool", zap.String("zone", zone.Name), zap.Int64("bytes", spool.Size()))
+			if err := m.drainSpool(ctx, zone.CustomerID, zone, spool); err != nil {
+				m.log.Error("instant logs: spool replay failed, kept on disk", zap.String("zone", zone.Name), zap.Error(err))
 			}
-			m.mu.Unlock()
-		}(zone)
+		}
+		m.observeSpool(zone, spool)
+		_ = spool.Close()
 	}
 }
 
+func (m *InstantLogsManager) spoolDir(zone *models.Zone) string {
+	return filepath.Join(m.spoolCfg.SpoolDir, zone.ID.String())
+}
+
 // runZoneStream manages the persistent connection life-cycle for a single zone.
 // It handles retries, backoffs, and uploading.
-func (m *InstantLogsManager) runZoneStream(ctx context.Context, zone *models.Zone) {
+func (m *InstantLogsManager) runZoneStream(ctx context.Context, zone *models.Zone, window *atomic.Pointer[instantWindow]) {
 	// Exponential backoff for connection retries
 	minBackoff := 5 * time.Second
 	maxBackoff := 5 * time.Minute
 	backoff := minBackoff
+	batch := &instantBatch{window: window}
+	defer func() {
+		if batch.spool != nil {
+			if err := batch.spool.Close(); err != nil {
+				m.log.Error("instant logs: close spool failed", zap.String("zone", zone.Name), zap.Error(err))
+			}
+		}
+	}()
 
 	for {
 		if ctx.Err() != nil {
 			return
 		}
 
-		if err := m.streamSession(ctx, zone); err == nil {
-			// Clean exit (context done)
-			return
-		} else {
-			// Stream errored
-			m.log.Error("instant logs stream disconnected, retrying...",
-				zap.String("zone", zone.Name),
-				zap.Error(err),
-				zap.Duration("backoff", backoff),
-			)
-			// Only alert if we've been backing off for a while (e.g. > 1 minute), indicating persistent failure
-			if backoff > 1*time.Minute {
-				if notifyErr := m.notifier.SendAlert(ctx, zone.ID.String(), "error", fmt.Sprintf("Instant logs stream persistent failure for zone %s: %v", zone.Name, err)); notifyErr != nil {
-					m.log.Warn("failed to send persistent failure alert", zap.Error(notifyErr))
-				}
+		err := m.openSpool(zone, batch)
+		if err == nil {
+			if err = m.streamSession(ctx, zone, batch); err == nil {
+				// Clean exit (context done)
+				return
+			}
+		}
+
+		// Nothing is received until the stream reconnects.
+		if batch.spool != nil {
+			if err := batch.spool.Break(batch.segmentEnd(time.Now())); err != nil {
+				m.log.Error("instant logs: seal spool segment failed", zap.String("zone", zone.Name), zap.Error(err))
+			}
+		}
+
+		// Stream errored. A failure the customer has to fix is alerted right
+		// away and retried at the slowest pace.
+		permanent := cloudflare.IsPermanent(err)
+		if permanent {
+			backoff = maxBackoff
+		}
+		m.log.Error("instant logs stream disconnected, retrying...",
+			zap.String("zone", zone.Name),
+			zap.Error(err),
+			zap.Duration("backoff", backoff),
+		)
+		// Only alert if we've been backing off for a while (e.g. > 1 minute), indicating persistent failure
+		// The breaker alerts once for the whole account when it opens.
+		if backoff > 1*time.Minute && !errors.Is(err, errBreakerOpen) {
+			msg := fmt.Sprintf("Instant logs stream persistent failure for zone %s: %v", zone.Name, err)
+			if permanent {
+				msg = fmt.Sprintf("Instant logs stream failed for zone %s: %s (%v)", zone.Name, cloudflareAlert(err), err)
+			}
+			if notifyErr := m.notifier.SendAlert(ctx, zone.ID.String(), "error", msg); notifyErr != nil {
+				m.log.Warn("failed to send persistent failure alert", zap.Error(notifyErr))
+			}
+		}
+		// A zone Instant Logs isn't available to may have changed plan.
+		if errors.Is(err, cloudflare.ErrNotEntitled) {
+			if err := requestPlanCheck(ctx, m.queue, zone.CustomerID); err != nil {
+				m.log.Error("failed to request plan check", zap.String("customer_id", zone.CustomerID.String()), zap.Error(err))
 			}
 		}
 
@@ -176,21 +500,47 @@ func (m *InstantLogsManager) runZoneStream(ctx context.Context, zone *models.Zon
 	}
 }
 
-func (m *InstantLogsManager) streamSession(ctx context.Context, zone *models.Zone) error {
+// openSpool opens the zone's spool once; no lines are accepted without it.
+func (m *InstantLogsManager) openSpool(zone *models.Zone, batch *instantBatch) error {
+	if batch.spool != nil {
+		return nil
+	}
+	spool, err := openZoneSpool(m.spoolDir(zone), m.spoolCfg.SpoolMaxBytes)
+	if err != nil {
+		return fmt.Errorf("open spool: %w", err)
+	}
+	batch.spool = spool
+	m.observeSpool(zone, spool)
+	return nil
+}
+
+func (m *InstantLogsManager) streamSession(ctx context.Context, zone *models.Zone, batch *instantBatch) error {
 	// Get Customer & Key (Refresh from DB to get latest key if rotated)
 	customer, err := m.db.Customers.GetByID(ctx, zone.CustomerID)
 	if err != nil {
 		return fmt.Errorf("get customer: %w", err)
 	}
-	cfKey, err := m.kms.Decrypt(customer.CFAPIKeyEnc)
+	current, err := m.db.Zones.GetByID(ctx, zone.ID)
+	if err != nil {
+		return fmt.Errorf("get zone: %w", err)
+	}
+	cfKey, err := m.creds.ForZone(customer, current)
 	if err != nil {
-		return fmt.Errorf("decrypt key: %w", err)
+		return err
 	}
 
-	client := cloudflare.NewInstantLogsClient(cfKey, zone.ZoneID)
+	client := cloudflare.NewInstantLogsClient(m.cfCfg, cfKey, zone.ZoneID).WithLimiter(m.limits.ForZone(cfKey, zone.Plan))
 
-	// Create Session
-	wsURL, err := client.StartSession(ctx)
+	// Create Session, unless the account's breaker says Instant Logs keeps
+	// failing; the stream then backs off like after any other failure.
+	allowed, err := m.breakers.Allow(ctx, customer.ID, breaker.InstantLogs)
+	if err != nil {
+		m.log.Warn("instant logs: check circuit breaker failed", zap.String("zone", zone.Name), zap.Error(err))
+	} else if !allowed {
+		return errBreakerOpen
+	}
+	wsURL, err := client.StartSession(ctx, instantLogsOptions(zone))
+	recordBreaker(ctx, m.breakers, m.log, m.notifier, customer.ID, breaker.InstantLogs, err)
 	if err != nil {
 		return fmt.Errorf("start session: %w", err)
 	}
@@ -201,62 +551,245 @@ func (m *InstantLogsManager) streamSession(ctx context.Context, zone *models.Zon
 		return fmt.Errorf("connect stream: %w", err)
 	}
 
-	buffer := make([][]byte, 0, 5000)
-	lastUpload := time.Now()
-
-	// Helper to flush buffer
-	//nolint:contextcheck // We use a detached context intentionally to ensure upload completes even if session cancels
-	upload := func() {
-		if len(buffer) == 0 {
-			return
-		}
-
-		var raw []byte
-		for _, line := range buffer {
-			raw = append(raw, line...)
-			raw = append(raw, '\n')
-		}
-
-		start := lastUpload
-		end := time.Now()
-
-		// Use a detached context for upload to ensure data isn't lost if stream cancels mid-upload
-		uploadCtx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
-		defer cancel()
-
-		_, _, _, _, _, err := m.storage.PutLogs(uploadCtx, customer.ID, zone.ID, start, end, raw, "instant")
-		if err != nil {
-			m.log.Error("upload failed", zap.Error(err))
-		} else {
-			m.log.Info("uploaded instant logs batch",
-				zap.String("zone", zone.Name),
-				zap.Int("lines", len(buffer)),
-			)
-		}
-
-		buffer = buffer[:0]
-		lastUpload = end
-	}
-
 	uploadTicker := time.NewTicker(30 * time.Second) // Upload more frequently for instant feel
 	defer uploadTicker.Stop()
+	syncTicker := time.NewTicker(m.spoolCfg.SpoolSyncInterval)
+	defer syncTicker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			upload()
+			m.flush(ctx, customer.ID, zone, batch, true)
 			return nil
 		case <-uploadTicker.C:
-			upload()
+			m.flush(ctx, customer.ID, zone, batch, false)
+		case <-syncTicker.C:
+			if err := batch.spool.Sync(); err != nil {
+				m.log.Error("instant logs: spool sync failed", zap.String("zone", zone.Name), zap.Error(err))
+			}
 		case msg, ok := <-ch:
 			if !ok {
-				upload()
+				m.flush(ctx, customer.ID, zone, batch, false)
 				return fmt.Errorf("stream closed by remote")
 			}
-			buffer = append(buffer, msg)
-			if len(buffer) >= 2000 { // Batch size
-				upload()
+			m.spoolLine(ctx, customer.ID, zone, batch, msg)
+		}
+	}
+}
+
+// spoolLine appends a received line to the spool and flushes early when the
+// batch is complete or the spool crosses its high watermark. A full spool gets
+// one flush attempt before the line is dropped. Lines outside the zone's
+// handover window belong to the pulled collector and are skipped.
+func (m *InstantLogsManager) spoolLine(ctx context.Context, customerID uuid.UUID, zone *models.Zone, batch *instantBatch, msg []byte) {
+	if w := batch.window.Load(); w != nil && !w.admits(time.Now()) {
+		return
+	}
+	err := batch.spool.Append(msg, time.Now())
+	if errors.Is(err, errSpoolFull) {
+		m.flush(ctx, customerID, zone, batch, false)
+		err = batch.spool.Append(msg, time.Now())
+	}
+	if err != nil {
+		instantSpoolDropped.WithLabelValues(zone.ID.String()).Inc()
+		if !batch.dropping {
+			batch.dropping = true
+			m.log.Error("instant logs: spool rejected lines, dropping until it recovers",
+				zap.String("zone", zone.Name),
+				zap.Int64("spool_bytes", batch.spool.Size()),
+				zap.Error(err),
+			)
+			if alertErr := m.notifier.SendAlert(ctx, zone.ID.String(), "error", fmt.Sprintf("Instant logs for zone %s are being dropped: %v", zone.Name, err)); alertErr != nil {
+				m.log.Warn("failed to send dropped lines alert", zap.Error(alertErr))
 			}
 		}
+		return
+	}
+	if batch.dropping {
+		batch.dropping = false
+		m.log.Info("instant logs: spool accepting lines again", zap.String("zone", zone.Name))
+	}
+	m.observeSpool(zone, batch.spool)
+
+	switch {
+	case m.spoolCfg.SpoolHighWatermark > 0 && batch.spool.Usage() >= m.spoolCfg.SpoolHighWatermark:
+		instantSpoolBackpressure.WithLabelValues(zone.ID.String()).Inc()
+		m.flush(ctx, customerID, zone, batch, false)
+	case batch.spool.Lines() >= instantBatchLines:
+		m.flush(ctx, customerID, zone, batch, false)
+	}
+}
+
+func (m *InstantLogsManager) observeSpool(zone *models.Zone, spool *zoneSpool) {
+	instantSpoolBytes.WithLabelValues(zone.ID.String()).Set(float64(spool.Size()))
+	instantSpoolUsage.WithLabelValues(zone.ID.String()).Set(spool.Usage())
+}
+
+// flush seals the active spool segment and archives every sealed segment in
+// order. On failure the segments stay on disk and the upload is retried with
+// backoff on a later flush; force ignores the backoff (used on shutdown).
+func (m *InstantLogsManager) flush(ctx context.Context, customerID uuid.UUID, zone *models.Zone, batch *instantBatch, force bool) {
+	now := time.Now()
+	if !force && now.Before(batch.retryAt) {
+		return
+	}
+	if err := batch.spool.Seal(batch.segmentEnd(now)); err != nil {
+		m.log.Error("instant logs: seal spool segment failed", zap.String("zone", zone.Name), zap.Error(err))
+	}
+
+	// Use a detached context for upload to ensure data isn't lost if stream cancels mid-upload
+	uploadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 1*time.Minute)
+	defer cancel()
+
+	err := m.drainSpool(uploadCtx, customerID, zone, batch.spool)
+	m.observeSpool(zone, batch.spool)
+	if err != nil {
+		batch.backoff(now)
+		m.log.Error("instant logs upload failed, batch kept in spool for retry",
+			zap.String("zone", zone.Name),
+			zap.Int64("spool_bytes", batch.spool.Size()),
+			zap.Duration("retry_in", batch.retryDelay),
+			zap.Error(err),
+		)
+		return
+	}
+	batch.retryAt = time.Time{}
+	batch.retryDelay = 0
+}
+
+// drainSpool archives sealed segments oldest first, stopping at the first
+// failure so the zone's chain stays in order.
+func (m *InstantLogsManager) drainSpool(ctx context.Context, customerID uuid.UUID, zone *models.Zone, spool *zoneSpool) error {
+	segs, err := spool.Sealed()
+	if err != nil {
+		return err
+	}
+	for _, seg := range segs {
+		job, err := m.archiveSegment(ctx, customerID, zone, seg)
+		if err != nil {
+			return err
+		}
+		if err := spool.Remove(seg); err != nil {
+			return err
+		}
+		m.log.Info("uploaded instant logs batch",
+			zap.String("zone", zone.Name),
+			zap.String("job_id", job.ID.String()),
+			zap.Int64("lines", job.LogCount),
+		)
+	}
+	return nil
+}
+
+// segmentJobNamespace scopes the name-based UUIDs of Instant Logs jobs.
+var segmentJobNamespace = uuid.MustParse("0b7f5d2e-3c4a-4e1b-8f9d-6a2c1e5b7d30")
+
+// segmentJobID derives the job ID of a zone's spool segment from its bounds,
+// so every attempt at archiving the segment maps to the same job.
+func segmentJobID(zoneID uuid.UUID, seg spoolSegment) uuid.UUID {
+	return uuid.NewSHA1(segmentJobNamespace, []byte(fmt.Sprintf("%s/%d-%d", zoneID, seg.Start.UnixNano(), seg.End.UnixNano())))
+}
+
+// segmentArchiver archives sealed spool segments.
+type segmentArchiver struct {
+	jobs    SegmentJobs
+	storage ArchiveStorage
+	queue   TaskEnqueuer
+	log     *zap.Logger
+}
+
+// archiveSegment records one sealed segment as a LogJob the same way Logpull
+// windows are: upload, chain to the zone's previous job and enqueue
+// verification.
+func (m *InstantLogsManager) archiveSegment(ctx context.Context, customerID uuid.UUID, zone *models.Zone, seg spoolSegment) (*models.LogJob, error) {
+	return m.archiver.archive(ctx, customerID, zone, seg)
+}
+
+// archive archives seg under its own job. A retry resumes that job: a
+// segment already archived is not uploaded again, and one uploaded but not
+// chained is only chained.
+func (a *segmentArchiver) archive(ctx context.Context, customerID uuid.UUID, zone *models.Zone, seg spoolSegment) (*models.LogJob, error) {
+	job := &models.LogJob{
+		ID:          segmentJobID(zone.ID, seg),
+		ZoneID:      zone.ID,
+		CustomerID:  customerID,
+		PeriodStart: seg.Start,
+		PeriodEnd:   seg.End,
+		LogType:     models.LogTypeInstant,
+		Dataset:     models.DatasetHTTPRequests,
+		Status:      models.JobStatusPending,
+	}
+	created, err := a.jobs.CreateIfAbsent(ctx, job)
+	if err == nil && !created {
+		job, err = a.jobs.GetByID(ctx, job.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create job: %w", err)
+	}
+	if job.Status == models.JobStatusDone {
+		return job, nil
+	}
+
+	f, err := seg.open()
+	if err != nil {
+		return nil, fmt.Errorf("open segment: %w", err)
+	}
+	defer f.Close()
+
+	// The raw SHA-256 feeds the WORM chain; hash first, then rewind so the
+	// upload can fail over between providers.
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("hash segment: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("rewind segment: %w", err)
+	}
+
+	if job.S3Key == "" {
+		s3Key, s3HashStr, provider, byteCount, logCount, err := a.storage.PutLogsStream(ctx, customerID, zone.ID, seg.Start, seg.End, f, job.LogType, job.Dataset, job.ID)
+		if err != nil {
+			return nil, a.failJob(ctx, job, fmt.Errorf("s3 upload: %w", err))
+		}
+		job.S3Key = s3Key
+		job.S3Provider = provider
+		job.SHA256 = s3HashStr
+		job.ByteCount = byteCount
+		job.LogCount = logCount
+	}
+
+	rawHash := hex.EncodeToString(h.Sum(nil))
+	err = a.jobs.FinishChained(ctx, job, func(prev string) string {
+		if prev == "" {
+			prev = worm.GenesisHash
+		}
+		return worm.ChainHash(prev, rawHash, job.ID.String())
+	})
+	if err != nil {
+		return nil, a.failJob(ctx, job, fmt.Errorf("chain job: %w", err))
 	}
+
+	// The segment is archived at this point; a verify task that can't be
+	// enqueued must not make the caller upload it again.
+	verifyTask, err := queue.NewLogVerifyTask(queue.LogVerifyPayload{JobID: job.ID})
+	if err == nil {
+		_, err = a.queue.EnqueueContext(ctx, verifyTask)
+	}
+	if err != nil {
+		a.log.Error("enqueue verify task failed – WORM integrity check deferred",
+			zap.String("job_id", job.ID.String()),
+			zap.Error(err),
+		)
+	}
+	return job, nil
+}
+
+// failJob records err on job. The upload's fields are kept, so a retry that
+// finds them only chains the job.
+func (a *segmentArchiver) failJob(ctx context.Context, job *models.LogJob, err error) error {
+	job.Attempts++
+	job.Status = models.JobStatusFailed
+	job.ErrMsg = err.Error()
+	_ = a.jobs.Update(ctx, job)
+	return err
 }
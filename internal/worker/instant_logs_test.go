@@ -0,0 +1,213 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/pkg/worm"
+)
+
+// MockSegmentJobs simulates log job database access
+type MockSegmentJobs struct {
+	mock.Mock
+}
+
+func (m *MockSegmentJobs) CreateIfAbsent(ctx context.Context, j *models.LogJob) (bool, error) {
+	args := m.Called(ctx, j)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockSegmentJobs) GetByID(ctx context.Context, id uuid.UUID) (*models.LogJob, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.LogJob), args.Error(1)
+}
+
+func (m *MockSegmentJobs) Update(ctx context.Context, j *models.LogJob) error {
+	args := m.Called(ctx, j)
+	return args.Error(0)
+}
+
+func (m *MockSegmentJobs) FinishChained(ctx context.Context, j *models.LogJob, link func(prevChainHash string) string) error {
+	args := m.Called(ctx, j)
+	if err := args.Error(0); err != nil {
+		return err
+	}
+	j.Status, j.ChainHash = models.JobStatusDone, link("")
+	return nil
+}
+
+// MockArchiveStorage simulates object storage uploads
+type MockArchiveStorage struct {
+	mock.Mock
+}
+
+func (m *MockArchiveStorage) PutLogsStream(ctx context.Context, customerID, zoneID uuid.UUID, from, to time.Time, r io.Reader, logType, dataset string, jobID uuid.UUID) (string, string, string, int64, int64, error) {
+	data, _ := io.ReadAll(r)
+	args := m.Called(ctx, string(data))
+	return args.String(0), args.String(1), args.String(2), int64(args.Int(3)), int64(args.Int(4)), args.Error(5)
+}
+
+// MockTaskEnqueuer simulates the asynq client
+type MockTaskEnqueuer struct {
+	mock.Mock
+}
+
+func (m *MockTaskEnqueuer) EnqueueContext(ctx context.Context, task *asynq.Task, opts ...asynq.Option) (*asynq.TaskInfo, error) {
+	args := m.Called(ctx, task.Type())
+	return &asynq.TaskInfo{}, args.Error(0)
+}
+
+const segmentLines = "{\"RayID\":\"a\"}\n{\"RayID\":\"b\"}\n"
+
+func newTestSegment(t *testing.T) spoolSegment {
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(30 * time.Second)
+	path := filepath.Join(t.TempDir(), sealedName(start, end))
+	require.NoError(t, os.WriteFile(path, []byte(segmentLines), 0o600))
+	return spoolSegment{Path: path, Start: start, End: end, Size: int64(len(segmentLines))}
+}
+
+func newTestArchiver() (*segmentArchiver, *MockSegmentJobs, *MockArchiveStorage, *MockTaskEnqueuer) {
+	jobs, store, q := new(MockSegmentJobs), new(MockArchiveStorage), new(MockTaskEnqueuer)
+	return &segmentArchiver{jobs: jobs, storage: store, queue: q, log: zap.NewNop()}, jobs, store, q
+}
+
+func TestSegmentArchiver_Archive(t *testing.T) {
+	a, jobs, store, q := newTestArchiver()
+	zone := &models.Zone{ID: uuid.New()}
+	customerID := uuid.New()
+	seg := newTestSegment(t)
+	ctx := context.Background()
+
+	jobs.On("CreateIfAbsent", ctx, mock.Anything).Return(true, nil)
+	store.On("PutLogsStream", ctx, segmentLines).Return("instant/key", "s3hash", "primary", 42, 2, nil)
+	jobs.On("FinishChained", ctx, mock.Anything).Return(nil)
+	q.On("EnqueueContext", ctx, mock.Anything).Return(nil)
+
+	job, err := a.archive(ctx, customerID, zone, seg)
+	require.NoError(t, err)
+
+	assert.Equal(t, segmentJobID(zone.ID, seg), job.ID)
+	assert.Equal(t, models.JobStatusDone, job.Status)
+	assert.Equal(t, "instant/key", job.S3Key)
+	assert.Equal(t, int64(2), job.LogCount)
+	raw := sha256.Sum256([]byte(segmentLines))
+	assert.Equal(t, worm.ChainHash(worm.GenesisHash, hex.EncodeToString(raw[:]), job.ID.String()), job.ChainHash)
+	jobs.AssertExpectations(t)
+	store.AssertExpectations(t)
+	q.AssertExpectations(t)
+}
+
+func TestSegmentArchiver_RetryReusesJob(t *testing.T) {
+	a, jobs, store, q := newTestArchiver()
+	zone := &models.Zone{ID: uuid.New()}
+	seg := newTestSegment(t)
+	ctx := context.Background()
+
+	// The first attempt fails to upload: the job is recorded as failed.
+	jobs.On("CreateIfAbsent", ctx, mock.Anything).Return(true, nil).Once()
+	store.On("PutLogsStream", ctx, segmentLines).Return("", "", "", 0, 0, errors.New("unavailable")).Once()
+	var failed *models.LogJob
+	jobs.On("Update", ctx, mock.Anything).Run(func(args mock.Arguments) {
+		j := *args.Get(1).(*models.LogJob)
+		failed = &j
+	}).Return(nil).Once()
+
+	_, err := a.archive(ctx, uuid.New(), zone, seg)
+	require.Error(t, err)
+	require.NotNil(t, failed)
+	assert.Equal(t, models.JobStatusFailed, failed.Status)
+	assert.Equal(t, 1, failed.Attempts)
+
+	// The retry resumes the same job instead of creating another.
+	jobs.On("CreateIfAbsent", ctx, mock.Anything).Return(false, nil).Once()
+	jobs.On("GetByID", ctx, failed.ID).Return(failed, nil).Once()
+	store.On("PutLogsStream", ctx, segmentLines).Return("instant/key", "s3hash", "primary", 42, 2, nil).Once()
+	jobs.On("FinishChained", ctx, mock.Anything).Return(nil).Once()
+	q.On("EnqueueContext", ctx, mock.Anything).Return(nil).Once()
+
+	job, err := a.archive(ctx, uuid.New(), zone, seg)
+	require.NoError(t, err)
+	assert.Equal(t, failed.ID, job.ID)
+	assert.Equal(t, models.JobStatusDone, job.Status)
+	jobs.AssertExpectations(t)
+	store.AssertExpectations(t)
+}
+
+func TestSegmentArchiver_RetryAfterUploadOnlyChains(t *testing.T) {
+	a, jobs, store, q := newTestArchiver()
+	zone := &models.Zone{ID: uuid.New()}
+	seg := newTestSegment(t)
+	ctx := context.Background()
+
+	// A previous attempt uploaded the segment and failed to chain it.
+	uploaded := &models.LogJob{
+		ID: segmentJobID(zone.ID, seg), ZoneID: zone.ID, Status: models.JobStatusFailed,
+		S3Key: "instant/key", SHA256: "s3hash", LogCount: 2, Attempts: 1,
+	}
+	jobs.On("CreateIfAbsent", ctx, mock.Anything).Return(false, nil)
+	jobs.On("GetByID", ctx, uploaded.ID).Return(uploaded, nil)
+	jobs.On("FinishChained", ctx, uploaded).Return(nil)
+	q.On("EnqueueContext", ctx, mock.Anything).Return(nil)
+
+	job, err := a.archive(ctx, uuid.New(), zone, seg)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobStatusDone, job.Status)
+	assert.Equal(t, "instant/key", job.S3Key)
+	store.AssertNotCalled(t, "PutLogsStream", mock.Anything, mock.Anything)
+	jobs.AssertExpectations(t)
+}
+
+func TestSegmentArchiver_AlreadyArchived(t *testing.T) {
+	a, jobs, store, q := newTestArchiver()
+	zone := &models.Zone{ID: uuid.New()}
+	seg := newTestSegment(t)
+	ctx := context.Background()
+
+	done := &models.LogJob{ID: segmentJobID(zone.ID, seg), Status: models.JobStatusDone}
+	jobs.On("CreateIfAbsent", ctx, mock.Anything).Return(false, nil)
+	jobs.On("GetByID", ctx, done.ID).Return(done, nil)
+
+	job, err := a.archive(ctx, uuid.New(), zone, seg)
+	require.NoError(t, err)
+	assert.Same(t, done, job)
+	store.AssertNotCalled(t, "PutLogsStream", mock.Anything, mock.Anything)
+	jobs.AssertNotCalled(t, "FinishChained", mock.Anything, mock.Anything)
+	q.AssertNotCalled(t, "EnqueueContext", mock.Anything, mock.Anything)
+}
+
+func TestInstantBatch_Backoff(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	b := &instantBatch{}
+
+	b.backoff(now)
+	assert.Equal(t, instantMinUploadRetry, b.retryDelay)
+	assert.Equal(t, now.Add(instantMinUploadRetry), b.retryAt)
+
+	b.backoff(now)
+	assert.Equal(t, 2*instantMinUploadRetry, b.retryDelay, "each failure doubles the delay")
+
+	for i := 0; i < 10; i++ {
+		b.backoff(now)
+	}
+	assert.Equal(t, instantMaxUploadRetry, b.retryDelay, "the delay is capped")
+	assert.Equal(t, now.Add(instantMaxUploadRetry), b.retryAt)
+}
@@ -2,9 +2,12 @@ package worker
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"github.com/fabriziosalmi/rainlogs/internal/models"
 	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
 )
 
 // Interfaces for dependency injection to allow testing.
@@ -19,3 +22,41 @@ type LogRepository interface {
 type LogStorage interface {
 	DeleteObject(ctx context.Context, key string) error
 }
+
+// SegmentJobs defines database access for archiving Instant Logs segments.
+type SegmentJobs interface {
+	CreateIfAbsent(ctx context.Context, j *models.LogJob) (bool, error)
+	GetByID(ctx context.Context, id uuid.UUID) (*models.LogJob, error)
+	Update(ctx context.Context, j *models.LogJob) error
+	FinishChained(ctx context.Context, j *models.LogJob, link func(prevChainHash string) string) error
+}
+
+// PullJobs defines database access for Logpull jobs.
+type PullJobs interface {
+	HasDoneWindow(ctx context.Context, zoneID uuid.UUID, logType string, start, end time.Time) (bool, error)
+	CreateIfAbsent(ctx context.Context, j *models.LogJob) (bool, error)
+	GetByID(ctx context.Context, id uuid.UUID) (*models.LogJob, error)
+	Update(ctx context.Context, j *models.LogJob) error
+	GetCurrentUsage(ctx context.Context, customerID uuid.UUID) (int64, error)
+	FinishChained(ctx context.Context, j *models.LogJob, link func(prevChainHash string) string) error
+}
+
+// CustomerReader defines read access to customers.
+type CustomerReader interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Customer, error)
+}
+
+// ZoneReader defines read access to zones.
+type ZoneReader interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Zone, error)
+}
+
+// ArchiveStorage defines storage access for archiving collected logs.
+type ArchiveStorage interface {
+	PutLogsStream(ctx context.Context, customerID, zoneID uuid.UUID, from, to time.Time, r io.Reader, logType, dataset string, jobID uuid.UUID) (key, sha256hex, provider string, compressedBytes, logLines int64, err error)
+}
+
+// TaskEnqueuer defines the queue access of processors that enqueue tasks.
+type TaskEnqueuer interface {
+	EnqueueContext(ctx context.Context, task *asynq.Task, opts ...asynq.Option) (*asynq.TaskInfo, error)
+}
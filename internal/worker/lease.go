@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	leaseKeyPrefix   = "rainlogs:instant:lease:"
+	leaseReplicasKey = "rainlogs:instant:replicas"
+)
+
+// renewScript extends a lease only if it is still held by the caller.
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseScript deletes a lease only if it is still held by the caller.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// LeaseStore hands out Redis leases so that exactly one worker replica owns
+// a given key at a time. Leases expire unless renewed, so a crashed replica's
+// keys become available again after one TTL.
+type LeaseStore struct {
+	rdb    redis.UniversalClient
+	prefix string
+	owner  string
+	ttl    time.Duration
+}
+
+// NewLeaseStore returns a lease store for Instant Logs streams acting as
+// replica owner.
+func NewLeaseStore(rdb redis.UniversalClient, owner string, ttl time.Duration) *LeaseStore {
+	return &LeaseStore{rdb: rdb, prefix: leaseKeyPrefix, owner: owner, ttl: ttl}
+}
+
+// Owner returns the replica identity leases are held under.
+func (l *LeaseStore) Owner() string { return l.owner }
+
+// TTL returns the lease duration.
+func (l *LeaseStore) TTL() time.Duration { return l.ttl }
+
+// Acquire takes the lease on key if it is free. It reports true if the
+// caller holds the lease afterwards (including when it already did).
+func (l *LeaseStore) Acquire(ctx context.Context, key string) (bool, error) {
+	ok, err := l.rdb.SetNX(ctx, l.prefix+key, l.owner, l.ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("lease: acquire %s: %w", key, err)
+	}
+	if ok {
+		return true, nil
+	}
+	return l.Renew(ctx, key)
+}
+
+// Renew extends the lease on key. It reports false if the lease was lost.
+func (l *LeaseStore) Renew(ctx context.Context, key string) (bool, error) {
+	n, err := renewScript.Run(ctx, l.rdb, []string{l.prefix + key}, l.owner, l.ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("lease: renew %s: %w", key, err)
+	}
+	return n == 1, nil
+}
+
+// Release gives up the lease on key so another replica can take it.
+func (l *LeaseStore) Release(ctx context.Context, key string) error {
+	if err := releaseScript.Run(ctx, l.rdb, []string{l.prefix + key}, l.owner).Err(); err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("lease: release %s: %w", key, err)
+	}
+	return nil
+}
+
+// Heartbeat registers this replica as alive and returns the number of live
+// replicas (at least one, this replica).
+func (l *LeaseStore) Heartbeat(ctx context.Context) (int, error) {
+	now := time.Now()
+	cutoff := strconv.FormatInt(now.Add(-l.ttl).UnixMilli(), 10)
+
+	pipe := l.rdb.TxPipeline()
+	pipe.ZAdd(ctx, leaseReplicasKey, redis.Z{Score: float64(now.UnixMilli()), Member: l.owner})
+	pipe.ZRemRangeByScore(ctx, leaseReplicasKey, "-inf", "("+cutoff)
+	count := pipe.ZCard(ctx, leaseReplicasKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("lease: heartbeat: %w", err)
+	}
+	return max(int(count.Val()), 1), nil
+}
+
+// Leave deregisters this replica so the others rebalance without waiting for
+// its heartbeat to expire.
+func (l *LeaseStore) Leave(ctx context.Context) error {
+	if err := l.rdb.ZRem(ctx, leaseReplicasKey, l.owner).Err(); err != nil {
+		return fmt.Errorf("lease: leave: %w", err)
+	}
+	return nil
+}
+
+// fairShare is how many of n zones each of replicas should own.
+func fairShare(n, replicas int) int {
+	if replicas < 1 {
+		replicas = 1
+	}
+	return (n + replicas - 1) / replicas
+}
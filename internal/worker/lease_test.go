@@ -0,0 +1,170 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fabriziosalmi/rainlogs/internal/redistest"
+)
+
+// newFakeRedis returns a fake Redis running LeaseStore's scripts.
+func newFakeRedis(t *testing.T) *redistest.Server {
+	f := redistest.NewServer(t)
+	// renewScript and releaseScript act only on a lease the caller holds.
+	f.Script(renewScript, func(db *redistest.DB, keys, args []string) (any, error) {
+		if v, ok := db.Get(keys[0]); !ok || v != args[0] {
+			return int64(0), nil
+		}
+		ms, _ := strconv.ParseInt(args[1], 10, 64)
+		db.Expire(keys[0], time.Duration(ms)*time.Millisecond)
+		return int64(1), nil
+	})
+	f.Script(releaseScript, func(db *redistest.DB, keys, args []string) (any, error) {
+		if v, ok := db.Get(keys[0]); !ok || v != args[0] {
+			return int64(0), nil
+		}
+		return db.Del(keys[0]), nil
+	})
+	return f
+}
+
+func TestFairShare(t *testing.T) {
+	tests := []struct {
+		zones, replicas, want int
+	}{
+		{0, 1, 0},
+		{5, 1, 5},
+		{5, 2, 3},
+		{6, 3, 2},
+		{7, 3, 3},
+		{2, 5, 1},
+		{4, 0, 4},
+		{4, -1, 4},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.zones, tt.replicas), func(t *testing.T) {
+			assert.Equal(t, tt.want, fairShare(tt.zones, tt.replicas))
+		})
+	}
+}
+
+func TestLeaseStore_AcquireRenewRelease(t *testing.T) {
+	f := newFakeRedis(t)
+	ctx := context.Background()
+	a := NewLeaseStore(f.Client, "replica-a", time.Minute)
+	b := NewLeaseStore(f.Client, "replica-b", time.Minute)
+
+	held, err := a.Acquire(ctx, "zone1")
+	require.NoError(t, err)
+	assert.True(t, held)
+	// Acquiring a lease already held renews it.
+	held, err = a.Acquire(ctx, "zone1")
+	require.NoError(t, err)
+	assert.True(t, held)
+
+	held, err = b.Acquire(ctx, "zone1")
+	require.NoError(t, err)
+	assert.False(t, held, "a lease has one holder")
+	held, err = b.Renew(ctx, "zone1")
+	require.NoError(t, err)
+	assert.False(t, held, "only the holder renews")
+	require.NoError(t, b.Release(ctx, "zone1"))
+
+	// Renewing keeps the lease past its first TTL.
+	f.Advance(45 * time.Second)
+	held, err = a.Renew(ctx, "zone1")
+	require.NoError(t, err)
+	assert.True(t, held)
+	f.Advance(45 * time.Second)
+	held, err = b.Acquire(ctx, "zone1")
+	require.NoError(t, err)
+	assert.False(t, held, "a foreign release or an old TTL frees nothing")
+
+	// Handing back frees the lease at once.
+	require.NoError(t, a.Release(ctx, "zone1"))
+	held, err = b.Acquire(ctx, "zone1")
+	require.NoError(t, err)
+	assert.True(t, held)
+
+	// A replica that stops renewing loses the lease after one TTL.
+	f.Advance(time.Minute)
+	held, err = a.Acquire(ctx, "zone1")
+	require.NoError(t, err)
+	assert.True(t, held)
+	held, err = b.Renew(ctx, "zone1")
+	require.NoError(t, err)
+	assert.False(t, held)
+}
+
+func TestLeaseStore_Prefix(t *testing.T) {
+	f := newFakeRedis(t)
+	ctx := context.Background()
+	stream := NewLeaseStore(f.Client, "replica-a", time.Minute)
+	audit := &LeaseStore{rdb: f.Client, prefix: auditLeaseKeyPrefix, owner: "pull-1", ttl: time.Minute}
+
+	held, err := stream.Acquire(ctx, "id")
+	require.NoError(t, err)
+	require.True(t, held)
+	held, err = audit.Acquire(ctx, "id")
+	require.NoError(t, err)
+	assert.True(t, held, "leases under different prefixes don't collide")
+}
+
+func TestLeaseStore_Rebalance(t *testing.T) {
+	f := newFakeRedis(t)
+	ctx := context.Background()
+	a := NewLeaseStore(f.Client, "replica-a", time.Minute)
+	b := NewLeaseStore(f.Client, "replica-b", time.Minute)
+	zones := []string{"z1", "z2", "z3", "z4", "z5"}
+
+	// Alone, replica a owns every zone.
+	replicas, err := a.Heartbeat(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, replicas)
+	share := fairShare(len(zones), replicas)
+	for _, z := range zones[:share] {
+		held, err := a.Acquire(ctx, z)
+		require.NoError(t, err)
+		require.True(t, held)
+	}
+
+	// Replica b joins: a hands back what is above its share...
+	replicas, err = b.Heartbeat(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, replicas)
+	replicas, err = a.Heartbeat(ctx)
+	require.NoError(t, err)
+	share = fairShare(len(zones), replicas)
+	assert.Equal(t, 3, share)
+	for _, z := range zones[share:] {
+		require.NoError(t, a.Release(ctx, z))
+	}
+
+	// ...and b takes exactly the zones handed back.
+	var taken []string
+	for _, z := range zones {
+		held, err := b.Acquire(ctx, z)
+		require.NoError(t, err)
+		if held {
+			taken = append(taken, z)
+		}
+	}
+	assert.Equal(t, []string{"z4", "z5"}, taken)
+
+	// A replica that leaves, or stops beating, no longer counts.
+	require.NoError(t, b.Leave(ctx))
+	replicas, err = a.Heartbeat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, replicas)
+
+	f.ZAdd(leaseReplicasKey, "replica-c", float64(time.Now().Add(-2*time.Minute).UnixMilli()))
+	replicas, err = a.Heartbeat(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, replicas)
+}
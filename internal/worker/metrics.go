@@ -0,0 +1,112 @@
+package worker
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Instant Logs spool metrics, exposed on the worker's /metrics endpoint.
+var (
+	instantSpoolBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "rainlogs",
+		Subsystem: "instant_spool",
+		Name:      "bytes",
+		Help:      "Bytes held in the Instant Logs spool, per zone.",
+	}, []string{"zone"})
+
+	instantSpoolUsage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "rainlogs",
+		Subsystem: "instant_spool",
+		Name:      "usage_ratio",
+		Help:      "Instant Logs spool size as a fraction of worker.spool_max_bytes, per zone.",
+	}, []string{"zone"})
+
+	instantSpoolBackpressure = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rainlogs",
+		Subsystem: "instant_spool",
+		Name:      "backpressure_total",
+		Help:      "Early flushes forced by the spool crossing worker.spool_high_watermark.",
+	}, []string{"zone"})
+
+	instantSpoolDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rainlogs",
+		Subsystem: "instant_spool",
+		Name:      "dropped_lines_total",
+		Help:      "Instant Logs lines dropped because the spool was full or unwritable.",
+	}, []string{"zone"})
+)
+
+// Coverage gap scan metrics.
+var (
+	coverageRecollected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rainlogs",
+		Subsystem: "coverage",
+		Name:      "recollected_gaps_total",
+		Help:      "Coverage gaps re-enqueued for collection, per log type.",
+	}, []string{"log_type"})
+
+	coverageLostSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rainlogs",
+		Subsystem: "coverage",
+		Name:      "lost_seconds_total",
+		Help:      "Seconds of logs that aged out of Cloudflare's retention without being archived.",
+	}, []string{"zone", "log_type"})
+)
+
+// Late-arrival re-pull (settle mode) metrics.
+var (
+	settleRepulls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rainlogs",
+		Subsystem: "settle",
+		Name:      "repulls_total",
+		Help:      "Logpull windows pulled again after their zone's settle delay, by outcome: complete (nothing new) or late (late lines archived).",
+	}, []string{"outcome"})
+
+	settleLateLines = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rainlogs",
+		Subsystem: "settle",
+		Name:      "late_lines_total",
+		Help:      "Log lines missed by the first pull of their window and archived by its re-pull, per zone.",
+	}, []string{"zone"})
+
+	settleLateBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rainlogs",
+		Subsystem: "settle",
+		Name:      "late_bytes_total",
+		Help:      "Uncompressed bytes of late log lines archived by re-pulls, per zone.",
+	}, []string{"zone"})
+)
+
+// Adaptive window metrics.
+var adaptiveWindowSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "rainlogs",
+	Subsystem: "scheduler",
+	Name:      "adaptive_window_seconds",
+	Help:      "Logpull windows chosen for zones with adaptive windows.",
+	Buckets:   []float64{60, 300, 600, 900, 1200, 1800, 2700, 3600},
+})
+
+// Cloudflare API rate limit metrics, per token bucket (a hash of the API
+// token).
+var (
+	cfRateLimitRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "rainlogs",
+		Subsystem: "cloudflare_ratelimit",
+		Name:      "remaining",
+		Help:      "Requests left in the shared Cloudflare rate limit bucket.",
+	}, []string{"bucket"})
+
+	cfRateLimitWaitSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rainlogs",
+		Subsystem: "cloudflare_ratelimit",
+		Name:      "wait_seconds_total",
+		Help:      "Seconds requests waited for the shared Cloudflare rate limit bucket.",
+	}, []string{"bucket"})
+
+	cfRateLimitThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "rainlogs",
+		Subsystem: "cloudflare_ratelimit",
+		Name:      "throttled_total",
+		Help:      "Cloudflare 429 responses; each empties the bucket for its Retry-After.",
+	}, []string{"bucket"})
+)
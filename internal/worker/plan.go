@@ -0,0 +1,190 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+	"github.com/fabriziosalmi/rainlogs/internal/db"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/queue"
+)
+
+// auditActionPlanChange is the audit log action of a plan switch.
+const auditActionPlanChange = "ZONE_PLAN_CHANGE"
+
+// detectPlan picks the plan a zone is collected under from what Cloudflare
+// reports: its plan, whether Logpull retention is enabled and whether Instant
+// Logs is available. A zone falls back from Logpull to Instant Logs to the
+// security events every plan has.
+func detectPlan(cfPlan string, logpull, instant bool) models.PlanType {
+	switch plan := models.PlanFromCloudflare(cfPlan); {
+	case plan == models.PlanEnterprise && logpull:
+		return models.PlanEnterprise
+	case plan != models.PlanFreePro && instant:
+		return models.PlanBusiness
+	}
+	return models.PlanFreePro
+}
+
+// planChange is a switch of a zone's collector.
+type planChange struct {
+	from, to models.PlanType
+	// handover is when the collector of `to` takes over.
+	handover time.Time
+	// lastPulled, when set, replaces the zone's last_pulled_at.
+	lastPulled *time.Time
+	// final is the last window of an outgoing security events poller.
+	final *models.Interval
+}
+
+// planHandover works out how zone moves to plan `to` at now. Between the
+// pulled collectors (Logpull and security events) nothing needs handing over:
+// the scheduler continues from last_pulled_at with the new one. Moves to or
+// from Instant Logs take effect after delay, which leaves the Instant Logs
+// manager time to start the zone's stream or learn where it ends. Logpull
+// stops answering as soon as a zone loses it, so only the security events
+// poller is given a final window up to the handover.
+func planHandover(zone *models.Zone, to models.PlanType, now time.Time, delay time.Duration) planChange {
+	c := planChange{from: zone.Plan, to: to, handover: now}
+	if zone.Plan != models.PlanBusiness && to != models.PlanBusiness {
+		return c
+	}
+	c.handover = now.Add(delay)
+	c.lastPulled = &c.handover
+	if zone.Plan == models.PlanFreePro && zone.LastPulledAt != nil && zone.LastPulledAt.Before(c.handover) {
+		c.final = &models.Interval{Start: *zone.LastPulledAt, End: c.handover}
+	}
+	return c
+}
+
+// PlanSwitcher moves zones between collectors without a gap or an overlap,
+// and records each switch in the audit log. Zone discovery switches zones to
+// the plan detected for them, the API to the plan a customer sets.
+type PlanSwitcher struct {
+	db    *db.DB
+	queue *asynq.Client
+	log   *zap.Logger
+	// handoverDelay is how far ahead a switch to or from Instant Logs takes
+	// effect: one lease TTL spans several Instant Logs syncs.
+	handoverDelay time.Duration
+}
+
+func NewPlanSwitcher(db *db.DB, queue *asynq.Client, workerCfg config.WorkerConfig, log *zap.Logger) *PlanSwitcher {
+	return &PlanSwitcher{db: db, queue: queue, log: log, handoverDelay: workerCfg.LeaseTTL}
+}
+
+// Switch moves zone to plan `to`, auditing it under requestID with reason.
+// On success zone is updated to the new plan and handover. It reports false,
+// changing nothing, when zone is on `to` already, is still inside a handover
+// or changed plan meanwhile.
+func (s *PlanSwitcher) Switch(ctx context.Context, zone *models.Zone, to models.PlanType, reason, requestID string) (bool, error) {
+	now := time.Now().UTC()
+	if zone.Plan == to || (zone.PlanChangedAt != nil && zone.PlanChangedAt.After(now)) {
+		return false, nil
+	}
+
+	c := planHandover(zone, to, now, s.handoverDelay)
+	// The outgoing poller's last window is queued first: should the switch
+	// then fail, the window is polled twice rather than not at all.
+	if c.final != nil {
+		if err := s.enqueueFinalPoll(ctx, zone, *c.final); err != nil {
+			return false, fmt.Errorf("enqueue final security events window: %w", err)
+		}
+	}
+	switched, err := s.db.Zones.SwitchPlan(ctx, zone.ID, c.from, c.to, c.handover, c.lastPulled)
+	if err != nil {
+		return false, fmt.Errorf("switch plan: %w", err)
+	}
+	if !switched {
+		return false, nil
+	}
+	zone.Plan, zone.PreviousPlan, zone.PlanChangedAt = c.to, c.from, &c.handover
+	if c.lastPulled != nil {
+		zone.LastPulledAt = c.lastPulled
+	}
+
+	s.log.Info("zone plan changed",
+		zap.String("zone_id", zone.ID.String()),
+		zap.String("zone", zone.Name),
+		zap.String("from", string(c.from)),
+		zap.String("to", string(c.to)),
+		zap.Time("handover", c.handover),
+		zap.String("reason", reason),
+	)
+	customerID := zone.CustomerID
+	if err := s.db.AuditEvents.Create(ctx, &models.AuditEvent{
+		ID:          uuid.New(),
+		CustomerID:  &customerID,
+		RequestID:   requestID,
+		Action:      auditActionPlanChange,
+		ResourceID:  zone.ID.String(),
+		ErrorDetail: fmt.Sprintf("%s -> %s (%s), handover at %s", c.from, c.to, reason, c.handover.Format(time.RFC3339)),
+	}); err != nil {
+		s.log.Error("failed to audit plan change", zap.String("zone_id", zone.ID.String()), zap.Error(err))
+	}
+	return true, nil
+}
+
+// reconcilePlan switches zone to the detected plan when it differs, and
+// tells the customer.
+func (p *ZoneDiscoveryProcessor) reconcilePlan(ctx context.Context, zone *models.Zone, detected models.PlanType, reason string) error {
+	requestID, ok := asynq.GetTaskID(ctx)
+	if !ok {
+		requestID = queue.TypeZoneDiscover
+	}
+	from := zone.Plan
+	switched, err := p.plans.Switch(ctx, zone, detected, reason, requestID)
+	if err != nil || !switched {
+		return err
+	}
+
+	msg := fmt.Sprintf("Zone %s now collects %s logs instead of %s: %s -> %s (%s), handover at %s",
+		zone.Name, models.LogTypeForPlan(zone.Plan), models.LogTypeForPlan(from),
+		from, zone.Plan, reason, zone.PlanChangedAt.Format(time.RFC3339))
+	if err := p.notifier.SendAlert(ctx, zone.CustomerID.String(), "warning", msg); err != nil {
+		p.log.Error("failed to send plan change alert", zap.Error(err))
+	}
+	return nil
+}
+
+// enqueueFinalPoll queues the security events window up to the handover, to
+// run once the window is complete.
+func (s *PlanSwitcher) enqueueFinalPoll(ctx context.Context, zone *models.Zone, w models.Interval) error {
+	task, err := queue.NewSecurityPollTask(queue.SecurityPollPayload{
+		ZoneID:      zone.ID,
+		CustomerID:  zone.CustomerID,
+		PeriodStart: w.Start,
+		PeriodEnd:   w.End,
+	}, asynq.ProcessAt(w.End))
+	if err != nil {
+		return err
+	}
+	taskID := fmt.Sprintf("handover-%s-%d", zone.ID, w.End.Unix())
+	if _, err := s.queue.EnqueueContext(ctx, task, asynq.TaskID(taskID)); err != nil &&
+		!errors.Is(err, asynq.ErrTaskIDConflict) && !errors.Is(err, asynq.ErrDuplicateTask) {
+		return err
+	}
+	return nil
+}
+
+// requestPlanCheck queues a discovery run for the customer, so a zone whose
+// collector Cloudflare refuses is re-detected without waiting for the next
+// scheduled run. Requests within the same hour share one task.
+func requestPlanCheck(ctx context.Context, q TaskEnqueuer, customerID uuid.UUID) error {
+	task, err := queue.NewZoneDiscoverTask(queue.ZoneDiscoverPayload{CustomerID: customerID})
+	if err != nil {
+		return err
+	}
+	taskID := fmt.Sprintf("discover-%s-plan-%d", customerID, time.Now().Truncate(time.Hour).Unix())
+	if _, err := q.EnqueueContext(ctx, task, asynq.TaskID(taskID)); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return err
+	}
+	return nil
+}
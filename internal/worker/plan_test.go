@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare/cftest"
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+func TestDetectPlan(t *testing.T) {
+	assert.Equal(t, models.PlanEnterprise, detectPlan("enterprise", true, true))
+	assert.Equal(t, models.PlanBusiness, detectPlan("enterprise", false, true))
+	assert.Equal(t, models.PlanFreePro, detectPlan("enterprise", false, false))
+	assert.Equal(t, models.PlanBusiness, detectPlan("business", false, true))
+	assert.Equal(t, models.PlanFreePro, detectPlan("business", false, false))
+	assert.Equal(t, models.PlanFreePro, detectPlan("pro", false, true))
+	assert.Equal(t, models.PlanFreePro, detectPlan("free", true, true))
+}
+
+func TestDetectZonePlan(t *testing.T) {
+	cf := cftest.NewServer()
+	defer cf.Close()
+	p := &ZoneDiscoveryProcessor{
+		cfCfg:  config.CloudflareConfig{BaseURL: cf.URL},
+		limits: NewRateLimiter(nil, config.RateLimitConfig{}, config.CloudflareConfig{}, nil),
+		log:    zap.NewNop(),
+	}
+	detect := func(id string) zonePlan {
+		t.Helper()
+		az, err := cloudflare.NewClient(p.cfCfg, id, "tok").Zone(context.Background())
+		require.NoError(t, err)
+		return p.detectZonePlan(context.Background(), "tok", *az)
+	}
+
+	cf.AddZone("ent")
+	zp := detect("ent")
+	assert.True(t, zp.detected)
+	assert.Equal(t, models.PlanEnterprise, zp.plan)
+
+	// Without Logpull an Enterprise zone streams Instant Logs, and without
+	// both it falls back to security events.
+	cf.SetRetention("ent", false)
+	zp = detect("ent")
+	assert.True(t, zp.detected)
+	assert.Equal(t, models.PlanBusiness, zp.plan)
+	cf.SetInstantLogs("ent", false)
+	zp = detect("ent")
+	assert.True(t, zp.detected)
+	assert.Equal(t, models.PlanFreePro, zp.plan)
+	assert.Equal(t, "Cloudflare plan enterprise, Logpull retention disabled, Instant Logs not available", zp.reason)
+
+	cf.SetPlan("biz", "business")
+	zp = detect("biz")
+	assert.True(t, zp.detected)
+	assert.Equal(t, models.PlanBusiness, zp.plan)
+
+	// A token lacking a permission says nothing about the plan.
+	cf.Inject(cftest.InstantLogsJobs, cftest.Forbidden())
+	assert.False(t, detect("biz").detected)
+	cf.SetRetention("ent", true)
+	cf.Inject(cftest.RetentionFlag, cftest.Forbidden())
+	assert.False(t, detect("ent").detected)
+
+	// Free and Pro zones have nothing to probe.
+	probes := cf.Calls(cftest.RetentionFlag) + cf.Calls(cftest.InstantLogsJobs)
+	cf.SetPlan("free", "free")
+	zp = detect("free")
+	assert.True(t, zp.detected)
+	assert.Equal(t, models.PlanFreePro, zp.plan)
+	assert.Equal(t, probes, cf.Calls(cftest.RetentionFlag)+cf.Calls(cftest.InstantLogsJobs))
+}
+
+func TestPlanHandover_BetweenPulledCollectors(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	lastPulled := now.Add(-2 * time.Minute)
+	zone := &models.Zone{Plan: models.PlanFreePro, LastPulledAt: &lastPulled}
+
+	c := planHandover(zone, models.PlanEnterprise, now, 90*time.Second)
+	assert.True(t, c.handover.Equal(now))
+	assert.Nil(t, c.lastPulled)
+	assert.Nil(t, c.final)
+}
+
+func TestPlanHandover_ToInstantLogs(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	lastPulled := now.Add(-2 * time.Minute)
+	zone := &models.Zone{Plan: models.PlanFreePro, LastPulledAt: &lastPulled}
+
+	c := planHandover(zone, models.PlanBusiness, now, 90*time.Second)
+	handover := now.Add(90 * time.Second)
+	assert.True(t, c.handover.Equal(handover))
+	require.NotNil(t, c.lastPulled)
+	assert.True(t, c.lastPulled.Equal(handover))
+	require.NotNil(t, c.final)
+	assert.Equal(t, models.Interval{Start: lastPulled, End: handover}, *c.final)
+
+	// The stream started for the zone archives nothing before the handover.
+	zone.Plan, zone.PreviousPlan, zone.PlanChangedAt = models.PlanBusiness, models.PlanFreePro, &c.handover
+	w, ok := instantLogsWindow(zone)
+	require.True(t, ok)
+	assert.False(t, w.admits(handover.Add(-time.Millisecond)))
+	assert.True(t, w.admits(handover))
+	assert.False(t, w.ended(handover.Add(time.Hour)))
+
+	// Logpull is gone once a zone leaves Enterprise: no final window.
+	zone = &models.Zone{Plan: models.PlanEnterprise, LastPulledAt: &lastPulled}
+	assert.Nil(t, planHandover(zone, models.PlanBusiness, now, 90*time.Second).final)
+}
+
+func TestPlanHandover_FromInstantLogs(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	zone := &models.Zone{Plan: models.PlanBusiness}
+
+	c := planHandover(zone, models.PlanEnterprise, now, 90*time.Second)
+	handover := now.Add(90 * time.Second)
+	require.NotNil(t, c.lastPulled)
+	assert.True(t, c.lastPulled.Equal(handover))
+	assert.Nil(t, c.final)
+
+	// The stream keeps running up to the handover, where Logpull resumes.
+	zone.Plan, zone.PreviousPlan, zone.PlanChangedAt = models.PlanEnterprise, models.PlanBusiness, &c.handover
+	w, ok := instantLogsWindow(zone)
+	require.True(t, ok)
+	assert.True(t, w.admits(now))
+	assert.False(t, w.admits(handover))
+	assert.False(t, w.ended(now))
+	assert.True(t, w.ended(handover))
+
+	_, ok = instantLogsWindow(&models.Zone{Plan: models.PlanEnterprise})
+	assert.False(t, ok)
+}
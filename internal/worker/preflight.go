@@ -0,0 +1,256 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+	"github.com/fabriziosalmi/rainlogs/internal/db"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/notifications"
+	"github.com/fabriziosalmi/rainlogs/internal/queue"
+)
+
+// tokenExpiryAlertEvery is how often a customer is reminded of a token about
+// to expire.
+const tokenExpiryAlertEvery = 24 * time.Hour
+
+// PreflightProcessor verifies a customer's Cloudflare API token and each zone's
+// own token, records their status and expiry, and checks that each registered
+// zone's token grants the permissions its collector needs. It alerts on
+// unusable tokens, on newly missing permissions and ahead of a token's expiry.
+type PreflightProcessor struct {
+	db            *db.DB
+	creds         *Credentials
+	cfCfg         config.CloudflareConfig
+	limits        *RateLimiter
+	log           *zap.Logger
+	notifier      notifications.NotificationService
+	expiryWarning time.Duration
+}
+
+func NewPreflightProcessor(db *db.DB, creds *Credentials, cfCfg config.CloudflareConfig, workerCfg config.WorkerConfig, limits *RateLimiter, log *zap.Logger, notifier notifications.NotificationService) *PreflightProcessor {
+	return &PreflightProcessor{
+		db:            db,
+		creds:         creds,
+		cfCfg:         cfCfg,
+		limits:        limits,
+		log:           log,
+		notifier:      notifier,
+		expiryWarning: workerCfg.TokenExpiryWarning,
+	}
+}
+
+// tokenCheck is the outcome of verifying one token.
+type tokenCheck struct {
+	status, problem string
+	expiresAt       *time.Time
+}
+
+func (p *PreflightProcessor) ProcessTask(ctx context.Context, t *asynq.Task) error {
+	payload, err := queue.ParsePreflightPayload(t)
+	if err != nil {
+		return fmt.Errorf("parse payload: %w", err)
+	}
+
+	customer, err := p.db.Customers.GetByID(ctx, payload.CustomerID)
+	if err != nil {
+		return fmt.Errorf("get customer: %w", err)
+	}
+	apiKey, err := p.creds.ForCustomer(customer)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	check, err := p.verifyToken(ctx, customer.CFAccountID, apiKey, now)
+	if err != nil {
+		return err
+	}
+	if err := p.db.Customers.RecordTokenCheck(ctx, customer.ID, check.status, check.problem, check.expiresAt); err != nil {
+		return fmt.Errorf("record token check: %w", err)
+	}
+
+	// A bad customer token stops account-level collection and the zones
+	// without their own token; zones with one are still checked.
+	customerTokenOK := check.problem == ""
+	if !customerTokenOK {
+		p.log.Warn("cloudflare token unusable",
+			zap.String("customer_id", customer.ID.String()),
+			zap.String("status", check.status),
+			zap.String("problem", check.problem),
+		)
+		if customer.CFTokenStatus != check.status || customer.CFTokenError != check.problem {
+			msg := fmt.Sprintf("Cloudflare API token check failed: %s. Collection for the account and for zones without their own token stops until the token is replaced.", check.problem)
+			if err := p.notifier.SendAlert(ctx, customer.ID.String(), "error", msg); err != nil {
+				p.log.Error("failed to send token alert", zap.Error(err))
+			}
+		}
+	} else if tokenExpiryDue(check.expiresAt, customer.CFTokenExpiryAlertedAt, now, p.expiryWarning) {
+		msg := fmt.Sprintf("Cloudflare API token expires on %s (in %s); rotate it before then to keep collecting logs",
+			check.expiresAt.Format(time.RFC3339), check.expiresAt.Sub(now).Round(time.Hour))
+		if err := p.notifier.SendAlert(ctx, customer.ID.String(), "warning", msg); err != nil {
+			p.log.Error("failed to send token expiry alert", zap.Error(err))
+		} else if err := p.db.Customers.MarkTokenExpiryAlerted(ctx, customer.ID, now); err != nil {
+			p.log.Error("failed to record token expiry alert", zap.Error(err))
+		}
+	}
+
+	zones, err := p.db.Zones.ListByCustomer(ctx, customer.ID)
+	if err != nil {
+		return fmt.Errorf("list zones: %w", err)
+	}
+	for _, zone := range zones {
+		if zone.CFDeletedAt != nil {
+			continue
+		}
+		zoneKey, err := p.creds.ForZone(customer, zone)
+		if err != nil {
+			return fmt.Errorf("zone %s: %w", zone.Name, err)
+		}
+		if zone.CFAPIKeyEnc != "" {
+			ok, err := p.checkZoneToken(ctx, customer, zone, zoneKey, now)
+			if err != nil {
+				return fmt.Errorf("zone %s: %w", zone.Name, err)
+			}
+			if !ok {
+				continue
+			}
+		} else if !customerTokenOK {
+			continue
+		}
+
+		missing, err := p.missingPermissions(ctx, zoneKey, zone)
+		if err != nil {
+			return fmt.Errorf("zone %s: %w", zone.Name, err)
+		}
+		if err := p.db.Zones.SetMissingPermissions(ctx, zone.ID, missing); err != nil {
+			return fmt.Errorf("zone %s: record permissions: %w", zone.Name, err)
+		}
+		if len(missing) == 0 || slices.Equal(missing, zone.MissingPermissions) {
+			continue
+		}
+		p.log.Warn("cloudflare token lacks permissions for zone",
+			zap.String("zone_id", zone.ID.String()),
+			zap.String("zone", zone.Name),
+			zap.Strings("missing", missing),
+		)
+		token := "Cloudflare API token"
+		if zone.CFAPIKeyEnc != "" {
+			token = "The zone's Cloudflare API token"
+		}
+		msg := fmt.Sprintf("%s lacks %s on zone %s, needed to collect %s logs",
+			token, strings.Join(missing, ", "), zone.Name, models.LogTypeForPlan(zone.Plan))
+		if err := p.notifier.SendAlert(ctx, customer.ID.String(), "warning", msg); err != nil {
+			p.log.Error("failed to send permission alert", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// verifyToken asks Cloudflare for the status of apiKey. A token Cloudflare
+// rejects is a check result, not an error.
+func (p *PreflightProcessor) verifyToken(ctx context.Context, accountID, apiKey string, now time.Time) (tokenCheck, error) {
+	token, err := cloudflare.NewAccountClient(p.cfCfg, accountID, apiKey).WithLimiter(p.limits.ForAccount(apiKey)).VerifyToken(ctx)
+	if err != nil && !errors.Is(err, cloudflare.ErrInvalidToken) {
+		return tokenCheck{}, fmt.Errorf("verify token: %w", err)
+	}
+	var check tokenCheck
+	check.status, check.problem = tokenProblem(token, now)
+	if token != nil {
+		check.expiresAt = token.ExpiresOn
+	}
+	return check, nil
+}
+
+// checkZoneToken verifies zone's own token, records the result and alerts on
+// it like on the customer's. It reports whether the token is usable.
+func (p *PreflightProcessor) checkZoneToken(ctx context.Context, customer *models.Customer, zone *models.Zone, apiKey string, now time.Time) (bool, error) {
+	check, err := p.verifyToken(ctx, customer.CFAccountID, apiKey, now)
+	if err != nil {
+		return false, err
+	}
+	if err := p.db.Zones.RecordTokenCheck(ctx, zone.ID, check.status, check.problem, check.expiresAt); err != nil {
+		return false, fmt.Errorf("record token check: %w", err)
+	}
+
+	if check.problem != "" {
+		p.log.Warn("zone cloudflare token unusable",
+			zap.String("zone_id", zone.ID.String()),
+			zap.String("zone", zone.Name),
+			zap.String("status", check.status),
+			zap.String("problem", check.problem),
+		)
+		if zone.CFTokenStatus != check.status || zone.CFTokenError != check.problem {
+			msg := fmt.Sprintf("Cloudflare API token of zone %s failed its check: %s. Log collection for the zone stops until its token is replaced.", zone.Name, check.problem)
+			if err := p.notifier.SendAlert(ctx, customer.ID.String(), "error", msg); err != nil {
+				p.log.Error("failed to send token alert", zap.Error(err))
+			}
+		}
+		return false, nil
+	}
+
+	if tokenExpiryDue(check.expiresAt, zone.CFTokenExpiryAlertedAt, now, p.expiryWarning) {
+		msg := fmt.Sprintf("Cloudflare API token of zone %s expires on %s (in %s); rotate it before then to keep collecting the zone's logs",
+			zone.Name, check.expiresAt.Format(time.RFC3339), check.expiresAt.Sub(now).Round(time.Hour))
+		if err := p.notifier.SendAlert(ctx, customer.ID.String(), "warning", msg); err != nil {
+			p.log.Error("failed to send token expiry alert", zap.Error(err))
+		} else if err := p.db.Zones.MarkTokenExpiryAlerted(ctx, zone.ID, now); err != nil {
+			p.log.Error("failed to record token expiry alert", zap.Error(err))
+		}
+	}
+	return true, nil
+}
+
+// missingPermissions probes the permissions zone's collector needs and
+// returns those the token lacks.
+func (p *PreflightProcessor) missingPermissions(ctx context.Context, apiKey string, zone *models.Zone) ([]string, error) {
+	client := cloudflare.NewClient(p.cfCfg, zone.ZoneID, apiKey).WithLimiter(p.limits.ForZone(apiKey, zone.Plan))
+	logType := models.LogTypeForPlan(zone.Plan)
+	var missing []string
+	for _, perm := range cloudflare.RequiredPermissions(logType) {
+		ok, err := client.HasPermission(ctx, perm, logType)
+		if err != nil {
+			return nil, fmt.Errorf("probe %s: %w", perm, err)
+		}
+		if !ok {
+			missing = append(missing, string(perm))
+		}
+	}
+	return missing, nil
+}
+
+// tokenProblem maps a verify result to the status recorded on the customer
+// and, when the token can't be used, why. A nil token is one Cloudflare
+// rejected.
+func tokenProblem(token *cloudflare.TokenStatus, now time.Time) (status, problem string) {
+	switch {
+	case token == nil:
+		return models.TokenStatusInvalid, "Cloudflare does not recognise the token"
+	case token.Active(now):
+		return token.Status, ""
+	case token.Status != models.TokenStatusActive:
+		return token.Status, "token is " + token.Status
+	case token.ExpiresOn != nil && !now.Before(*token.ExpiresOn):
+		return "expired", "token expired on " + token.ExpiresOn.Format(time.RFC3339)
+	default:
+		return token.Status, "token is not valid before " + token.NotBefore.Format(time.RFC3339)
+	}
+}
+
+// tokenExpiryDue reports whether a token expiring at expiresAt is within the
+// warning period at now and its customer wasn't reminded in the last day.
+func tokenExpiryDue(expiresAt, alertedAt *time.Time, now time.Time, warning time.Duration) bool {
+	if expiresAt == nil || expiresAt.Sub(now) > warning {
+		return false
+	}
+	return alertedAt == nil || now.Sub(*alertedAt) >= tokenExpiryAlertEvery
+}
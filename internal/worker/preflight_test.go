@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+func TestTokenProblem(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+
+	status, problem := tokenProblem(nil, now)
+	assert.Equal(t, models.TokenStatusInvalid, status)
+	assert.NotEmpty(t, problem)
+
+	status, problem = tokenProblem(&cloudflare.TokenStatus{Status: "active", ExpiresOn: &future}, now)
+	assert.Equal(t, models.TokenStatusActive, status)
+	assert.Empty(t, problem)
+
+	status, problem = tokenProblem(&cloudflare.TokenStatus{Status: "disabled"}, now)
+	assert.Equal(t, "disabled", status)
+	assert.Equal(t, "token is disabled", problem)
+
+	status, _ = tokenProblem(&cloudflare.TokenStatus{Status: "active", ExpiresOn: &past}, now)
+	assert.Equal(t, "expired", status)
+
+	status, problem = tokenProblem(&cloudflare.TokenStatus{Status: "active", NotBefore: &future}, now)
+	assert.Equal(t, models.TokenStatusActive, status)
+	assert.Contains(t, problem, "not valid before")
+}
+
+func TestTokenExpiryDue(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	warning := 14 * 24 * time.Hour
+	soon, later := now.Add(3*24*time.Hour), now.Add(30*24*time.Hour)
+	yesterday, anHourAgo := now.Add(-25*time.Hour), now.Add(-time.Hour)
+
+	assert.False(t, tokenExpiryDue(nil, nil, now, warning), "tokens without expiry")
+	assert.False(t, tokenExpiryDue(&later, nil, now, warning), "outside the warning period")
+	assert.True(t, tokenExpiryDue(&soon, nil, now, warning))
+	assert.True(t, tokenExpiryDue(&soon, &yesterday, now, warning), "reminded daily")
+	assert.False(t, tokenExpiryDue(&soon, &anHourAgo, now, warning))
+}
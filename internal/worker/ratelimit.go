@@ -0,0 +1,209 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+const rateLimitKeyPrefix = "rainlogs:cf:ratelimit:"
+
+// rateLimitWindow is the period RateLimitConfig budgets are expressed over.
+const rateLimitWindow = 5 * time.Minute
+
+// takeScript takes one token from each of the buckets in KEYS, or from none.
+// Bucket i holds up to ARGV[2i-1] tokens and refills at ARGV[2i] tokens per
+// millisecond. It returns {taken, wait ms, tokens left in the emptiest
+// bucket}. Redis' clock is used so every replica sees the same buckets.
+var takeScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local tokens, wait, left = {}, 0, math.huge
+for i, key in ipairs(KEYS) do
+	local capacity = tonumber(ARGV[2 * i - 1])
+	local rate = tonumber(ARGV[2 * i])
+	local b = redis.call("HMGET", key, "tokens", "ts", "blocked")
+	local ts = tonumber(b[2]) or now
+	local blocked = tonumber(b[3]) or 0
+	if blocked > now then
+		return {0, blocked - now, 0}
+	end
+	tokens[i] = math.min(capacity, (tonumber(b[1]) or capacity) + math.max(0, now - ts) * rate)
+	if tokens[i] < 1 then
+		wait = math.max(wait, math.ceil((1 - tokens[i]) / rate))
+	end
+end
+local taken = 0
+if wait == 0 then
+	taken = 1
+end
+for i, key in ipairs(KEYS) do
+	local capacity = tonumber(ARGV[2 * i - 1])
+	local rate = tonumber(ARGV[2 * i])
+	tokens[i] = tokens[i] - taken
+	left = math.min(left, tokens[i])
+	redis.call("HSET", key, "tokens", tostring(tokens[i]), "ts", now)
+	redis.call("PEXPIRE", key, math.ceil(capacity / rate) + 60000)
+end
+return {taken, wait, math.floor(left)}`)
+
+// throttleScript empties a bucket and blocks it for ARGV[1] milliseconds.
+var throttleScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local blocked = now + tonumber(ARGV[1])
+local cur = tonumber(redis.call("HGET", KEYS[1], "blocked")) or 0
+if cur > blocked then
+	blocked = cur
+end
+redis.call("HSET", KEYS[1], "tokens", 0, "ts", now, "blocked", blocked)
+redis.call("PEXPIRE", KEYS[1], blocked - now + 60000)
+return 1`)
+
+// RateLimiter hands out Cloudflare request budgets shared by every worker
+// replica through Redis. Cloudflare limits requests per API token, so every
+// token has one bucket sized from the account-level (Enterprise) budget,
+// emptied for the Retry-After of any 429 it sees. Requests for a zone on a
+// smaller plan also draw on a bucket of that plan's budget for the token, so
+// the plan budget caps them without enlarging the token's overall budget.
+type RateLimiter struct {
+	rdb     redis.UniversalClient
+	cfg     config.RateLimitConfig
+	maxRate float64 // requests per second per token; 0 = no cap
+	log     *zap.Logger
+}
+
+// NewRateLimiter returns a limiter whose buckets are further capped at
+// cfCfg.RateLimit requests per second when it is set.
+func NewRateLimiter(rdb redis.UniversalClient, cfg config.RateLimitConfig, cfCfg config.CloudflareConfig, log *zap.Logger) *RateLimiter {
+	return &RateLimiter{rdb: rdb, cfg: cfg, maxRate: cfCfg.RateLimit, log: log}
+}
+
+// ForZone returns the limiter for requests made with apiKey on behalf of a
+// zone on plan. A nil RateLimiter, or a token without a budget, is unlimited.
+func (r *RateLimiter) ForZone(apiKey string, plan models.PlanType) cloudflare.Limiter {
+	if r == nil {
+		return nil
+	}
+	return r.bucket(apiKey, planBudget(r.cfg, plan))
+}
+
+// ForAccount returns the limiter for account-level requests made with apiKey.
+// These aren't tied to a zone's plan and draw on the token's full budget.
+func (r *RateLimiter) ForAccount(apiKey string) cloudflare.Limiter {
+	if r == nil {
+		return nil
+	}
+	return r.bucket(apiKey, 0)
+}
+
+// bucket returns the limiter of apiKey's bucket, further capped at plan
+// requests per window when that is below the token's budget.
+func (r *RateLimiter) bucket(apiKey string, plan int) cloudflare.Limiter {
+	budget := planBudget(r.cfg, models.PlanEnterprise)
+	if budget <= 0 {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	label := hex.EncodeToString(sum[:8])
+	// The hash tag keeps a token's buckets in one slot of a Redis Cluster,
+	// which a script touching several keys needs.
+	key := rateLimitKeyPrefix + "{" + label + "}"
+	b := &tokenBucket{rdb: r.rdb, log: r.log, label: label}
+	b.add(key, budget, r.perMs(budget))
+	if plan > 0 && plan < budget {
+		b.add(fmt.Sprintf("%s:%d", key, plan), plan, r.perMs(plan))
+	}
+	return b
+}
+
+// perMs returns the refill rate, in tokens per millisecond, of a bucket of
+// budget, capped at cloudflare.rate_limit requests per second.
+func (r *RateLimiter) perMs(budget int) float64 {
+	perSec := float64(budget) / rateLimitWindow.Seconds()
+	if r.maxRate > 0 && r.maxRate < perSec {
+		perSec = r.maxRate
+	}
+	return perSec / 1000
+}
+
+// planBudget returns the requests per five minutes budgeted for zones on
+// plan. Free and Pro zones share a plan, so they get the smaller budget.
+func planBudget(cfg config.RateLimitConfig, plan models.PlanType) int {
+	switch plan {
+	case models.PlanEnterprise:
+		return cfg.Enterprise
+	case models.PlanBusiness:
+		return cfg.Business
+	default:
+		if cfg.Pro > 0 && (cfg.Free <= 0 || cfg.Pro < cfg.Free) {
+			return cfg.Pro
+		}
+		return cfg.Free
+	}
+}
+
+// tokenBucket is one API token's budget, possibly capped by a plan's: a
+// request takes a token from each of its Redis buckets. The token's own bucket
+// comes first and is identified by a hash of the token, which is also the
+// metrics label.
+type tokenBucket struct {
+	rdb   redis.UniversalClient
+	log   *zap.Logger
+	label string
+	keys  []string
+	args  []any // capacity and refill rate of each key
+}
+
+func (b *tokenBucket) add(key string, capacity int, perMs float64) {
+	b.keys = append(b.keys, key)
+	b.args = append(b.args, capacity, perMs)
+}
+
+// Wait blocks until every bucket has a token. Like the circuit breakers it
+// fails open: without Redis requests go out unpaced, and any 429 is still
+// honoured through the job's retry delay.
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	for {
+		res, err := takeScript.Run(ctx, b.rdb, b.keys, b.args...).Int64Slice()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			b.log.Warn("ratelimit: take failed, not pacing", zap.String("bucket", b.label), zap.Error(err))
+			return nil
+		}
+		cfRateLimitRemaining.WithLabelValues(b.label).Set(float64(res[2]))
+		if res[0] == 1 {
+			return nil
+		}
+		wait := time.Duration(res[1]) * time.Millisecond
+		cfRateLimitWaitSeconds.WithLabelValues(b.label).Add(wait.Seconds())
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *tokenBucket) Throttled(ctx context.Context, retryAfter time.Duration) {
+	cfRateLimitThrottled.WithLabelValues(b.label).Inc()
+	cfRateLimitRemaining.WithLabelValues(b.label).Set(0)
+	ms := int64(math.Max(float64(retryAfter.Milliseconds()), 1000))
+	// A 429 is for the whole token: blocking its own bucket blocks every
+	// plan bucket drawing on it.
+	if err := throttleScript.Run(ctx, b.rdb, b.keys[:1], ms).Err(); err != nil {
+		b.log.Warn("ratelimit: record 429", zap.String("bucket", b.label), zap.Error(err))
+	}
+}
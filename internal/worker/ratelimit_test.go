@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+func TestPlanBudget(t *testing.T) {
+	cfg := config.RateLimitConfig{Enterprise: 1200, Business: 600, Pro: 300, Free: 150}
+	assert.Equal(t, 1200, planBudget(cfg, models.PlanEnterprise))
+	assert.Equal(t, 600, planBudget(cfg, models.PlanBusiness))
+	assert.Equal(t, 150, planBudget(cfg, models.PlanFreePro))
+
+	cfg.Free = 0
+	assert.Equal(t, 300, planBudget(cfg, models.PlanFreePro))
+}
+
+func TestRateLimiterBuckets(t *testing.T) {
+	var nilLimits *RateLimiter
+	assert.Nil(t, nilLimits.ForZone("tok", models.PlanEnterprise))
+	assert.Nil(t, nilLimits.ForAccount("tok"))
+
+	unbudgeted := NewRateLimiter(nil, config.RateLimitConfig{Business: 600}, config.CloudflareConfig{}, nil)
+	assert.Nil(t, unbudgeted.ForZone("tok", models.PlanBusiness), "no token budget means unlimited")
+
+	limits := NewRateLimiter(nil, config.RateLimitConfig{Enterprise: 1200, Business: 600}, config.CloudflareConfig{RateLimit: 1}, nil)
+	acct, ok := limits.ForAccount("tok").(*tokenBucket)
+	require.True(t, ok)
+	require.Len(t, acct.keys, 1)
+	assert.NotContains(t, acct.keys[0], "tok")
+	assert.Equal(t, []any{1200, 0.001}, acct.args, "capped at cloudflare.rate_limit")
+
+	// Every plan draws on the token's one bucket, sized once; smaller plans
+	// are capped by a bucket of their own on top.
+	ent, ok := limits.ForZone("tok", models.PlanEnterprise).(*tokenBucket)
+	require.True(t, ok)
+	assert.Equal(t, acct.keys, ent.keys)
+	assert.Equal(t, acct.args, ent.args)
+
+	biz, ok := limits.ForZone("tok", models.PlanBusiness).(*tokenBucket)
+	require.True(t, ok)
+	require.Len(t, biz.keys, 2)
+	assert.Equal(t, acct.keys[0], biz.keys[0], "one bucket per token")
+	assert.Equal(t, []any{1200, 0.001, 600, 0.001}, biz.args)
+
+	free, ok := limits.ForZone("tok", models.PlanFreePro).(*tokenBucket)
+	require.True(t, ok)
+	assert.Equal(t, acct.keys, free.keys, "a plan without a budget has only the token's")
+
+	other, ok := limits.ForAccount("other").(*tokenBucket)
+	require.True(t, ok)
+	assert.NotEqual(t, acct.keys[0], other.keys[0])
+}
+
+func TestTokenBucketFailsOpen(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+	t.Cleanup(func() { _ = rdb.Close() })
+	limits := NewRateLimiter(rdb, config.RateLimitConfig{Enterprise: 1200}, config.CloudflareConfig{}, zap.NewNop())
+
+	// Without Redis the request goes out unpaced, as the breakers let it...
+	l := limits.ForAccount("tok")
+	require.NoError(t, l.Wait(context.Background()))
+
+	// ...unless the caller gave up.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, l.Wait(ctx), context.Canceled)
+}
@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/hibiken/asynq"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+)
+
+const (
+	// retryBaseDelay is the back-off before the first retry of a failed task;
+	// it doubles with every retry up to retryMaxDelay.
+	retryBaseDelay = 15 * time.Second
+	retryMaxDelay  = 30 * time.Minute
+	// minRetryAfter keeps a 429 with a zero or past Retry-After from being
+	// retried in a tight loop.
+	minRetryAfter = time.Second
+)
+
+// RetryDelay is the worker server's asynq RetryDelayFunc. A task that hit a
+// Cloudflare 429 is requeued for exactly the Retry-After Cloudflare asked
+// for. Other failures back off exponentially with jitter, so tasks failing
+// together don't retry together.
+func RetryDelay(n int, err error, _ *asynq.Task) time.Duration {
+	var rlErr *cloudflare.RateLimitError
+	if errors.As(err, &rlErr) {
+		return max(rlErr.RetryAfter, minRetryAfter)
+	}
+	d := retryMaxDelay
+	if n < 16 {
+		d = min(retryBaseDelay<<n, retryMaxDelay)
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// IsFailure is the worker server's asynq IsFailure func. Cloudflare 429s are
+// not failures: the requeue does not use up one of the task's retries.
+func IsFailure(err error) bool {
+	var rlErr *cloudflare.RateLimitError
+	return !errors.As(err, &rlErr)
+}
+
+// SkipPermanent is asynq middleware that stops retrying tasks that failed for
+// a reason retrying won't fix, such as a revoked token or a Cloudflare 4xx.
+// Those tasks are archived right away.
+func SkipPermanent(h asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, t *asynq.Task) error {
+		err := h.ProcessTask(ctx, t)
+		if err != nil && cloudflare.IsPermanent(err) && !errors.Is(err, asynq.SkipRetry) {
+			return fmt.Errorf("%w: %w", err, asynq.SkipRetry)
+		}
+		return err
+	})
+}
+
+// cloudflareAlert returns the alert for a Cloudflare failure the customer has
+// to fix, or "" when retrying may fix it.
+func cloudflareAlert(err error) string {
+	switch {
+	case errors.Is(err, cloudflare.ErrInvalidToken):
+		return "Cloudflare rejected the API token; rotate it with PATCH /api/v1/customers/:id"
+	case errors.Is(err, cloudflare.ErrMissingPermission):
+		return "the Cloudflare API token lacks a permission this zone's collector needs"
+	case errors.Is(err, cloudflare.ErrNotEntitled):
+		return "the zone's Cloudflare plan does not include this log source"
+	case errors.Is(err, cloudflare.ErrZoneNotFound):
+		return "Cloudflare no longer knows the zone"
+	case errors.Is(err, cloudflare.ErrRetentionDisabled):
+		return "log retention is off for the zone, so Logpull has no logs; turn on the zone's retention flag"
+	case cloudflare.IsPermanent(err):
+		return "Cloudflare refused the request"
+	}
+	return ""
+}
+
+// isTransient reports whether err is a Cloudflare failure expected to pass on
+// its own: a rate limit or a server error.
+func isTransient(err error) bool {
+	return errors.Is(err, cloudflare.ErrRateLimited) || errors.Is(err, cloudflare.ErrServer)
+}
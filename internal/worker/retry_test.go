@@ -0,0 +1,61 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+)
+
+func TestRetryDelay(t *testing.T) {
+	rl := fmt.Errorf("fetch logs: %w", &cloudflare.RateLimitError{RetryAfter: 42 * time.Second})
+	assert.Equal(t, 42*time.Second, RetryDelay(5, rl, nil), "429s wait exactly Retry-After")
+	assert.Equal(t, time.Second, RetryDelay(0, &cloudflare.RateLimitError{}, nil))
+
+	for n, want := range map[int]time.Duration{0: 15 * time.Second, 3: 120 * time.Second, 20: 30 * time.Minute, 100: 30 * time.Minute} {
+		d := RetryDelay(n, errors.New("boom"), nil)
+		assert.GreaterOrEqual(t, d, want/2, "retry %d", n)
+		assert.LessOrEqual(t, d, want, "retry %d", n)
+	}
+}
+
+func TestIsFailure(t *testing.T) {
+	assert.False(t, IsFailure(fmt.Errorf("x: %w", &cloudflare.RateLimitError{})))
+	assert.True(t, IsFailure(errors.New("boom")))
+}
+
+func TestSkipPermanent(t *testing.T) {
+	run := func(err error) error {
+		h := SkipPermanent(asynq.HandlerFunc(func(context.Context, *asynq.Task) error { return err }))
+		return h.ProcessTask(context.Background(), asynq.NewTask("t", nil))
+	}
+
+	assert.NoError(t, run(nil))
+
+	err := run(fmt.Errorf("fetch: %w", &cloudflare.APIError{StatusCode: 400}))
+	assert.ErrorIs(t, err, asynq.SkipRetry)
+	var se *cloudflare.APIError
+	assert.ErrorAs(t, err, &se, "the original error is kept")
+
+	assert.ErrorIs(t, run(cloudflare.ErrInvalidToken), asynq.SkipRetry)
+	assert.NotErrorIs(t, run(&cloudflare.APIError{StatusCode: 503}), asynq.SkipRetry)
+	assert.NotErrorIs(t, run(&cloudflare.RateLimitError{}), asynq.SkipRetry)
+}
+
+func TestCloudflareAlert(t *testing.T) {
+	assert.Contains(t, cloudflareAlert(fmt.Errorf("pull: %w", cloudflare.ErrInvalidToken)), "rejected the API token")
+	assert.Contains(t, cloudflareAlert(&cloudflare.APIError{StatusCode: 400, Kind: cloudflare.ErrRetentionDisabled}), "retention")
+	assert.NotEmpty(t, cloudflareAlert(&cloudflare.APIError{StatusCode: 400}))
+	assert.Empty(t, cloudflareAlert(&cloudflare.APIError{StatusCode: 503, Kind: cloudflare.ErrServer}))
+	assert.Empty(t, cloudflareAlert(&cloudflare.RateLimitError{}))
+
+	assert.True(t, isTransient(&cloudflare.RateLimitError{}))
+	assert.True(t, isTransient(&cloudflare.APIError{StatusCode: 503, Kind: cloudflare.ErrServer}))
+	assert.False(t, isTransient(errors.New("s3 upload failed")))
+}
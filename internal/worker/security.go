@@ -5,17 +5,18 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
 	"go.uber.org/zap"
-	"golang.org/x/time/rate"
 
+	"github.com/fabriziosalmi/rainlogs/internal/breaker"
 	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
 	"github.com/fabriziosalmi/rainlogs/internal/config"
 	"github.com/fabriziosalmi/rainlogs/internal/db"
-	"github.com/fabriziosalmi/rainlogs/internal/kms"
 	"github.com/fabriziosalmi/rainlogs/internal/models"
 	"github.com/fabriziosalmi/rainlogs/internal/notifications"
 	"github.com/fabriziosalmi/rainlogs/internal/queue"
@@ -25,28 +26,26 @@ import (
 
 type SecurityEventsProcessor struct {
 	db       *db.DB
-	kms      *kms.Encryptor
+	creds    *Credentials
 	storage  *storage.MultiStore
 	queue    *asynq.Client
 	cfCfg    config.CloudflareConfig
 	log      *zap.Logger
-	limiter  *rate.Limiter
+	limits   *RateLimiter
+	breakers *breaker.Breakers
 	notifier notifications.NotificationService
 }
 
-func NewSecurityEventsProcessor(db *db.DB, kms *kms.Encryptor, storage *storage.MultiStore, queue *asynq.Client, cfCfg config.CloudflareConfig, log *zap.Logger, notifier notifications.NotificationService) *SecurityEventsProcessor {
-	var limiter *rate.Limiter
-	if cfCfg.RateLimit > 0 {
-		limiter = rate.NewLimiter(rate.Limit(cfCfg.RateLimit), 1)
-	}
+func NewSecurityEventsProcessor(db *db.DB, creds *Credentials, storage *storage.MultiStore, queue *asynq.Client, cfCfg config.CloudflareConfig, limits *RateLimiter, breakers *breaker.Breakers, log *zap.Logger, notifier notifications.NotificationService) *SecurityEventsProcessor {
 	return &SecurityEventsProcessor{
 		db:       db,
-		kms:      kms,
+		creds:    creds,
 		storage:  storage,
 		queue:    queue,
 		cfCfg:    cfCfg,
 		log:      log,
-		limiter:  limiter,
+		limits:   limits,
+		breakers: breakers,
 		notifier: notifier,
 	}
 }
@@ -64,6 +63,8 @@ func (p *SecurityEventsProcessor) ProcessTask(ctx context.Context, t *asynq.Task
 		CustomerID:  payload.CustomerID,
 		PeriodStart: payload.PeriodStart,
 		PeriodEnd:   payload.PeriodEnd,
+		LogType:     models.LogTypeSecurity,
+		Dataset:     models.DatasetFirewallEvents,
 		Status:      models.JobStatusPending,
 	}
 	if err := p.db.LogJobs.Create(ctx, job); err != nil {
@@ -95,35 +96,32 @@ func (p *SecurityEventsProcessor) ProcessTask(ctx context.Context, t *asynq.Task
 		}
 	}
 
-	// 3. Decrypt CF API Key
-	cfKey, err := p.kms.Decrypt(customer.CFAPIKeyEnc)
+	// 3. Resolve the CF API token: the zone's own, else the customer's
+	cfKey, err := p.creds.ForZone(customer, zone)
 	if err != nil {
-		return p.failJob(ctx, job, fmt.Errorf("decrypt cf key: %w", err))
-	}
-
-	// 4. Rate Limiter
-	if p.limiter != nil {
-		if err := p.limiter.Wait(ctx); err != nil {
-			return p.failJob(ctx, job, fmt.Errorf("rate limiter: %w", err))
-		}
+		return p.failJob(ctx, job, err)
 	}
 
-	// 5. Fetch Security Events
-	cfClient := cloudflare.NewGraphQLClient(cfKey)
-	events, err := cfClient.GetSecurityEvents(ctx, zone.ZoneID, payload.PeriodStart, payload.PeriodEnd)
+	// 4. Fetch Security Events. The client pages past the GraphQL row cap; if
+	// the event bound is hit, only the drained part of the window is archived
+	// in this job and the remainder is enqueued as its own poll, so a truncated
+	// window is never marked done.
+	cfClient := cloudflare.NewGraphQLClient(p.cfCfg, cfKey).WithLimiter(p.limits.ForZone(cfKey, zone.Plan))
+	events, drainedUntil, err := cfClient.GetSecurityEvents(ctx, zone.ZoneID, payload.PeriodStart, payload.PeriodEnd, p.cfCfg.MaxSecurityEvents)
+	recordBreaker(ctx, p.breakers, p.log, p.notifier, customer.ID, breaker.GraphQL, err)
 	if err != nil {
 		return p.failJob(ctx, job, fmt.Errorf("fetch security events: %w", err))
 	}
 
-	if len(events) >= 1000 {
-		p.log.Warn("security events limit reached (1000), potential data loss - consider smaller interval",
+	if drainedUntil.Before(payload.PeriodEnd) {
+		p.log.Warn("security events bound reached, splitting window",
 			zap.String("zone", zone.Name),
-			zap.Time("start", payload.PeriodStart),
+			zap.Int("events", len(events)),
+			zap.Time("drained_until", drainedUntil),
 			zap.Time("end", payload.PeriodEnd),
 		)
-		if err := p.notifier.SendAlert(ctx, zone.ID.String(), "warning", fmt.Sprintf("Security events limit reached (1000) for zone %s. Potential data loss.", zone.Name)); err != nil {
-			p.log.Warn("failed to send security events warning", zap.Error(err))
-		}
+		job.PeriodEnd = drainedUntil
+		p.enqueueRemainder(ctx, zone, payload, drainedUntil)
 	}
 
 	if len(events) == 0 {
@@ -133,7 +131,7 @@ func (p *SecurityEventsProcessor) ProcessTask(ctx context.Context, t *asynq.Task
 		return p.db.LogJobs.Update(ctx, job)
 	}
 
-	// 6. Convert to NDJSON
+	// 5. Convert to NDJSON
 	var buffer []byte
 	for i := range events {
 		line, err := json.Marshal(&events[i])
@@ -145,53 +143,87 @@ func (p *SecurityEventsProcessor) ProcessTask(ctx context.Context, t *asynq.Task
 		buffer = append(buffer, '\n')
 	}
 
-	// 7. Hash & WORM (Same logic as LogPull)
+	// 6. Hash & WORM (Same logic as LogPull)
 	h := sha256.New()
 	h.Write(buffer)
 	hashStr := hex.EncodeToString(h.Sum(nil))
 
-	prevJob, err := p.db.LogJobs.GetLastJob(ctx, zone.ID)
-	prevChainHash := worm.GenesisHash
-	if err == nil && prevJob != nil {
-		prevChainHash = prevJob.ChainHash
-	}
-	chainHash := worm.ChainHash(prevChainHash, hashStr, job.ID.String())
-
-	// 8. Upload to S3
+	// 7. Upload to S3
 	// Note: PutLogs assumes "access logs" folder structure? Or generic?
 	// It uses `customerID/zoneID/year/month/day/...`. This is fine.
 	// Maybe we should verify prefix in storage/s3.go?
-	s3Key, s3HashStr, provider, byteCount, logCount, err := p.storage.PutLogs(ctx, customer.ID, zone.ID, payload.PeriodStart, payload.PeriodEnd, buffer, "security")
+	s3Key, s3HashStr, provider, byteCount, logCount, err := p.storage.PutLogs(ctx, customer.ID, zone.ID, job.PeriodStart, job.PeriodEnd, buffer, job.LogType, job.Dataset)
 	if err != nil {
 		return p.failJob(ctx, job, fmt.Errorf("s3 upload: %w", err))
 	}
 
-	// 9. Update Job
-	job.Status = models.JobStatusDone
-
+	// 8. Finish the job, linked after the last job of the zone's chain
 	job.S3Key = s3Key
 	job.S3Provider = provider
 	job.SHA256 = s3HashStr
-	job.ChainHash = chainHash
 	job.ByteCount = byteCount
 	job.LogCount = logCount
-	if err := p.db.LogJobs.Update(ctx, job); err != nil {
-		return fmt.Errorf("update job: %w", err)
+	err = p.db.LogJobs.FinishChained(ctx, job, func(prev string) string {
+		if prev == "" {
+			prev = worm.GenesisHash
+		}
+		return worm.ChainHash(prev, hashStr, job.ID.String())
+	})
+	if err != nil {
+		return p.failJob(ctx, job, fmt.Errorf("chain job: %w", err))
 	}
 
 	return nil
 }
 
+// enqueueRemainder schedules a follow-up poll for [from, payload.PeriodEnd).
+// If that fails the window stays uncovered, so operators are alerted.
+func (p *SecurityEventsProcessor) enqueueRemainder(ctx context.Context, zone *models.Zone, payload queue.SecurityPollPayload, from time.Time) {
+	remainder := payload
+	remainder.PeriodStart = from
+	t, err := queue.NewSecurityPollTask(remainder)
+	if err == nil {
+		_, err = p.queue.EnqueueContext(ctx, t, asynq.TaskID(fmt.Sprintf("sec-%s-%d", zone.ID, from.Unix())))
+		if errors.Is(err, asynq.ErrTaskIDConflict) {
+			err = nil
+		}
+	}
+	if err != nil {
+		p.log.Error("enqueue security events remainder failed",
+			zap.String("zone", zone.Name),
+			zap.Time("from", from),
+			zap.Error(err),
+		)
+		if alertErr := p.notifier.SendAlert(ctx, zone.ID.String(), "error", fmt.Sprintf("Security events for zone %s after %s were not scheduled: %v", zone.Name, from.Format(time.RFC3339), err)); alertErr != nil {
+			p.log.Warn("failed to send remainder alert", zap.Error(alertErr))
+		}
+	}
+}
+
 func (p *SecurityEventsProcessor) failJob(ctx context.Context, job *models.LogJob, err error) error {
 	job.Attempts++
 	job.Status = models.JobStatusFailed
 	job.ErrMsg = err.Error()
 
-	// Send alert for failed job
-	if alertErr := p.notifier.SendAlert(ctx, job.ZoneID.String(), "error", fmt.Sprintf("Security events job failed for zone %s: %v", job.ZoneID, err)); alertErr != nil {
+	_ = p.db.LogJobs.Update(ctx, job)
+
+	// Rate limits and Cloudflare server errors are retried without an alert;
+	// the circuit breaker alerts if they persist.
+	if isTransient(err) {
+		return err
+	}
+	msg := fmt.Sprintf("Security events job failed for zone %s: %v", job.ZoneID, err)
+	if alert := cloudflareAlert(err); alert != "" {
+		msg = fmt.Sprintf("Security events job failed for zone %s: %s (%v)", job.ZoneID, alert, err)
+	}
+	if alertErr := p.notifier.SendAlert(ctx, job.ZoneID.String(), "error", msg); alertErr != nil {
 		p.log.Warn("failed to send failure alert", zap.Error(alertErr))
 	}
-
-	_ = p.db.LogJobs.Update(ctx, job)
+	// A zone the GraphQL dataset isn't available to may have changed plan.
+	if errors.Is(err, cloudflare.ErrNotEntitled) {
+		if err := requestPlanCheck(ctx, p.queue, job.CustomerID); err != nil {
+			p.log.Error("failed to request plan check", zap.String("customer_id", job.CustomerID.String()), zap.Error(err))
+		}
+	}
 	return err
 }
@@ -0,0 +1,298 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/breaker"
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+	"github.com/fabriziosalmi/rainlogs/internal/db"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/notifications"
+	"github.com/fabriziosalmi/rainlogs/internal/queue"
+	"github.com/fabriziosalmi/rainlogs/internal/storage"
+	"github.com/fabriziosalmi/rainlogs/pkg/worm"
+)
+
+// settleDueBatch bounds the re-pulls the scheduler enqueues per tick; the
+// rest are picked up on the next ticks.
+const settleDueBatch = 500
+
+// LogSettleProcessor re-pulls the window of a done Logpull job once its zone's
+// settle delay has passed. Logpull keeps receiving lines for a window after it
+// was first pulled; re-pulled lines whose RayID the archived object lacks are
+// archived as a supplementary job of the zone's chain, linked to the original
+// job, which is then marked settled.
+type LogSettleProcessor struct {
+	db       *db.DB
+	creds    *Credentials
+	storage  *storage.MultiStore
+	queue    *asynq.Client
+	cfCfg    config.CloudflareConfig
+	limits   *RateLimiter
+	breakers *breaker.Breakers
+	log      *zap.Logger
+	notifier notifications.NotificationService
+}
+
+func NewLogSettleProcessor(db *db.DB, creds *Credentials, storage *storage.MultiStore, queue *asynq.Client, cfCfg config.CloudflareConfig, limits *RateLimiter, breakers *breaker.Breakers, log *zap.Logger, notifier notifications.NotificationService) *LogSettleProcessor {
+	return &LogSettleProcessor{
+		db:       db,
+		creds:    creds,
+		storage:  storage,
+		queue:    queue,
+		cfCfg:    cfCfg,
+		limits:   limits,
+		breakers: breakers,
+		log:      log,
+		notifier: notifier,
+	}
+}
+
+func (p *LogSettleProcessor) ProcessTask(ctx context.Context, t *asynq.Task) error {
+	payload, err := queue.ParseLogSettlePayload(t)
+	if err != nil {
+		return fmt.Errorf("parse payload: %w", err)
+	}
+
+	job, err := p.db.LogJobs.GetByID(ctx, payload.JobID)
+	if err != nil {
+		return fmt.Errorf("get job: %w", err)
+	}
+	if job.SettledAt != nil || job.SettlesJobID != nil || job.Status != models.JobStatusDone || job.LogType != models.LogTypeLogpull {
+		return nil
+	}
+	// A previous attempt may have archived the late lines and failed to mark
+	// the job.
+	settlement, err := p.db.LogJobs.GetSettlement(ctx, job.ID)
+	if err != nil {
+		return fmt.Errorf("get settlement: %w", err)
+	}
+	if settlement != nil {
+		return p.markSettled(ctx, job)
+	}
+
+	customer, err := p.db.Customers.GetByID(ctx, job.CustomerID)
+	if err != nil {
+		return fmt.Errorf("get customer: %w", err)
+	}
+	zone, err := p.db.Zones.GetByID(ctx, job.ZoneID)
+	if err != nil {
+		return fmt.Errorf("get zone: %w", err)
+	}
+	apiKey, err := p.creds.ForZone(customer, zone)
+	if err != nil {
+		return err
+	}
+	fields, err := cloudflare.ResolveFields(zone.FieldProfile, zone.LogFields)
+	if err != nil {
+		return fmt.Errorf("resolve log fields: %w", err)
+	}
+
+	// Empty windows are done without an object.
+	seen := make(map[string]struct{})
+	if job.S3Key != "" {
+		if seen, err = p.archivedKeys(ctx, job.S3Key); err != nil {
+			return err
+		}
+	}
+
+	client := cloudflare.NewClient(p.cfCfg, zone.ZoneID, apiKey).WithLimiter(p.limits.ForZone(apiKey, zone.Plan))
+	body, err := client.StreamLogs(ctx, job.PeriodStart, job.PeriodEnd, fields)
+	recordBreaker(ctx, p.breakers, p.log, p.notifier, customer.ID, breaker.Logpull, err)
+	if err != nil {
+		return fmt.Errorf("re-pull logs: %w", err)
+	}
+	// Late lines are spooled to disk: a window can gain many of them.
+	spool, err := os.CreateTemp("", "rainlogs-settle-*.ndjson")
+	if err != nil {
+		body.Close()
+		return fmt.Errorf("spool late lines: %w", err)
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+	h := sha256.New()
+	lines, size, err := lateLines(body, seen, io.MultiWriter(spool, h))
+	body.Close()
+	if err != nil {
+		return fmt.Errorf("re-pull logs: %w", err)
+	}
+
+	if lines == 0 {
+		settleRepulls.WithLabelValues("complete").Inc()
+		return p.markSettled(ctx, job)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("spool late lines: %w", err)
+	}
+	if err := p.archive(ctx, job, spool, hex.EncodeToString(h.Sum(nil))); err != nil {
+		return err
+	}
+	settleRepulls.WithLabelValues("late").Inc()
+	settleLateLines.WithLabelValues(zone.ID.String()).Add(float64(lines))
+	settleLateBytes.WithLabelValues(zone.ID.String()).Add(float64(size))
+	p.log.Info("late logs archived",
+		zap.String("zone", zone.Name),
+		zap.String("job_id", job.ID.String()),
+		zap.Int64("late_lines", lines),
+		zap.Int64("late_bytes", size),
+	)
+	return p.markSettled(ctx, job)
+}
+
+// archivedKeys streams the archived object at key and returns the keys of
+// its lines.
+func (p *LogSettleProcessor) archivedKeys(ctx context.Context, key string) (map[string]struct{}, error) {
+	archived, err := p.storage.GetLogsStream(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("s3 download: %w", err)
+	}
+	defer archived.Close()
+	seen, err := lineKeys(archived)
+	if err != nil {
+		return nil, fmt.Errorf("s3 download: %w", err)
+	}
+	return seen, nil
+}
+
+// archive stores the late lines of job's window, read from late, as a
+// supplementary job linked to it, chained after the last job of the zone's
+// chain. rawHash is the SHA-256 of the lines.
+func (p *LogSettleProcessor) archive(ctx context.Context, job *models.LogJob, late io.Reader, rawHash string) error {
+	sup := &models.LogJob{
+		ID:           uuid.New(),
+		ZoneID:       job.ZoneID,
+		CustomerID:   job.CustomerID,
+		PeriodStart:  job.PeriodStart,
+		PeriodEnd:    job.PeriodEnd,
+		LogType:      job.LogType,
+		Dataset:      job.Dataset,
+		Status:       models.JobStatusPending,
+		SettlesJobID: &job.ID,
+	}
+	if err := p.db.LogJobs.Create(ctx, sup); err != nil {
+		return fmt.Errorf("create supplementary job: %w", err)
+	}
+
+	s3Key, s3HashStr, provider, byteCount, logCount, err := p.storage.PutLogsStream(ctx, sup.CustomerID, sup.ZoneID, sup.PeriodStart, sup.PeriodEnd, late, sup.LogType, sup.Dataset, sup.ID)
+	if err != nil {
+		return p.failJob(ctx, sup, fmt.Errorf("s3 upload: %w", err))
+	}
+	sup.S3Key, sup.S3Provider, sup.SHA256 = s3Key, provider, s3HashStr
+	sup.ByteCount, sup.LogCount = byteCount, logCount
+
+	err = p.db.LogJobs.FinishChained(ctx, sup, func(prev string) string {
+		if prev == "" {
+			prev = worm.GenesisHash
+		}
+		return worm.ChainHash(prev, rawHash, sup.ID.String())
+	})
+	if err != nil {
+		return p.failJob(ctx, sup, fmt.Errorf("chain job: %w", err))
+	}
+
+	verifyTask, err := queue.NewLogVerifyTask(queue.LogVerifyPayload{JobID: sup.ID})
+	if err == nil {
+		_, err = p.queue.EnqueueContext(ctx, verifyTask)
+	}
+	if err != nil {
+		p.log.Error("enqueue verify task failed – WORM integrity check deferred",
+			zap.String("job_id", sup.ID.String()),
+			zap.Error(err),
+		)
+	}
+	return nil
+}
+
+func (p *LogSettleProcessor) markSettled(ctx context.Context, job *models.LogJob) error {
+	if err := p.db.LogJobs.MarkSettled(ctx, job.ID); err != nil {
+		return fmt.Errorf("mark settled: %w", err)
+	}
+	return nil
+}
+
+func (p *LogSettleProcessor) failJob(ctx context.Context, job *models.LogJob, err error) error {
+	job.Attempts++
+	job.Status = models.JobStatusFailed
+	job.ErrMsg = err.Error()
+	_ = p.db.LogJobs.Update(ctx, job)
+	return err
+}
+
+// lineKey identifies a Logpull line across pulls of its window: its RayID, or
+// a hash of the line when it has none (e.g. RayID is not among the zone's
+// fields).
+func lineKey(line []byte) string {
+	var v struct {
+		RayID string `json:"RayID"`
+	}
+	if json.Unmarshal(line, &v) == nil && v.RayID != "" {
+		return v.RayID
+	}
+	sum := sha256.Sum256(line)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// lineKeys reads an archived NDJSON object from r and returns the keys of its
+// lines.
+func lineKeys(r io.Reader) (map[string]struct{}, error) {
+	keys := make(map[string]struct{})
+	err := eachLine(r, func(line []byte) error {
+		keys[lineKey(line)] = struct{}{}
+		return nil
+	})
+	return keys, err
+}
+
+// lateLines reads re-pulled NDJSON from r and writes the lines whose key is
+// not in seen to w, newline-terminated. It returns their count and size. Keys
+// of written lines are added to seen, so a line repeated in r is written once.
+func lateLines(r io.Reader, seen map[string]struct{}, w io.Writer) (lines, size int64, err error) {
+	err = eachLine(r, func(line []byte) error {
+		key := lineKey(line)
+		if _, ok := seen[key]; ok {
+			return nil
+		}
+		seen[key] = struct{}{}
+		n, err := w.Write(append(line, '\n'))
+		lines++
+		size += int64(n)
+		return err
+	})
+	return lines, size, err
+}
+
+// eachLine calls fn with each non-blank line of r, trimmed. Lines may be of
+// any length.
+func eachLine(r io.Reader, fn func(line []byte) error) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if err := fn(trimmed); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package worker
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLineKey(t *testing.T) {
+	assert.Equal(t, "8f1a", lineKey([]byte(`{"RayID":"8f1a","ClientIP":"192.0.2.1"}`)))
+
+	// Lines without a RayID are keyed by their content.
+	a := lineKey([]byte(`{"ClientIP":"192.0.2.1"}`))
+	assert.True(t, strings.HasPrefix(a, "sha256:"))
+	assert.Equal(t, a, lineKey([]byte(`{"ClientIP":"192.0.2.1"}`)))
+	assert.NotEqual(t, a, lineKey([]byte(`{"ClientIP":"192.0.2.2"}`)))
+	assert.True(t, strings.HasPrefix(lineKey([]byte(`not json`)), "sha256:"))
+}
+
+func TestLateLines(t *testing.T) {
+	archived := "{\"RayID\":\"a\"}\n{\"RayID\":\"b\"}\n{\"ClientIP\":\"192.0.2.1\"}\n"
+	seen, err := lineKeys(strings.NewReader(archived))
+	require.NoError(t, err)
+	require.Len(t, seen, 3)
+
+	repulled := strings.Join([]string{
+		`{"RayID":"a"}`,
+		`{"RayID":"c","EdgeStartTimestamp":1}`,
+		`{"RayID":"b"}`,
+		`{"ClientIP":"192.0.2.1"}`,
+		`{"ClientIP":"192.0.2.9"}`,
+		`{"RayID":"c","EdgeStartTimestamp":1}`,
+		``,
+		`{"RayID":"d"}`, // last line without a trailing newline
+	}, "\n")
+	var late bytes.Buffer
+	n, size, err := lateLines(strings.NewReader(repulled), seen, &late)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, "{\"RayID\":\"c\",\"EdgeStartTimestamp\":1}\n{\"ClientIP\":\"192.0.2.9\"}\n{\"RayID\":\"d\"}\n", late.String())
+	assert.Equal(t, int64(late.Len()), size)
+
+	seen, err = lineKeys(strings.NewReader(archived))
+	require.NoError(t, err)
+	late.Reset()
+	n, size, err = lateLines(strings.NewReader(archived), seen, &late)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.Zero(t, size)
+	assert.Empty(t, late.String())
+}
@@ -0,0 +1,299 @@
+package worker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errSpoolFull is returned by Append when the spool has reached its size limit.
+var errSpoolFull = errors.New("spool: size limit reached")
+
+const (
+	spoolActiveExt = ".open"
+	spoolSealedExt = ".ndjson"
+)
+
+// spoolSegment is a sealed spool file waiting to be archived.
+type spoolSegment struct {
+	Path       string
+	Start, End time.Time
+	Size       int64
+}
+
+// zoneSpool is a per-zone write-ahead log for Instant Logs lines. Every line is
+// written to the active segment before it counts as received; segments are
+// sealed when a batch is flushed and deleted only once archived, so a crash
+// loses nothing that reached the disk. Instant Logs has no acknowledgements to
+// hold back, so the active segment is fsynced on a timer (Sync) and at
+// sealing rather than per line: a crashed process loses nothing, but a crashed
+// host may lose the lines of the last sync interval. While the stream stays connected each
+// segment starts where the previous one ended, so quiet stretches between
+// segments count as covered.
+//
+// Layout: <dir>/<start>.open is the active segment and <dir>/<start>-<end>.ndjson
+// are sealed ones, with start/end in Unix nanoseconds.
+type zoneSpool struct {
+	dir      string
+	maxBytes int64
+
+	active      *os.File
+	activeStart time.Time
+	activeLines int
+	lastEnd     time.Time // end of the last segment sealed since the last Break
+	size        int64     // bytes across all segments
+}
+
+// openZoneSpool opens (or creates) the spool in dir. Segments left behind by a
+// previous process are adopted: an active segment is repaired and sealed so it
+// is archived with the rest.
+func openZoneSpool(dir string, maxBytes int64) (*zoneSpool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("spool: mkdir: %w", err)
+	}
+	s := &zoneSpool{dir: dir, maxBytes: maxBytes}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("spool: read dir: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		switch filepath.Ext(name) {
+		case spoolActiveExt:
+			if err := s.adoptActive(name); err != nil {
+				return nil, err
+			}
+		case spoolSealedExt:
+			info, err := e.Info()
+			if err != nil {
+				return nil, fmt.Errorf("spool: stat %s: %w", name, err)
+			}
+			s.size += info.Size()
+		}
+	}
+	return s, nil
+}
+
+// adoptActive seals an active segment left by a crashed process. A trailing
+// partial line (torn write) is cut off; the segment ends at its last write.
+func (s *zoneSpool) adoptActive(name string) error {
+	start, err := parseSpoolTime(strings.TrimSuffix(name, spoolActiveExt))
+	if err != nil {
+		return fmt.Errorf("spool: bad segment name %s: %w", name, err)
+	}
+	path := filepath.Join(s.dir, name)
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("spool: open %s: %w", name, err)
+	}
+	keep, err := completeLines(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("spool: read %s: %w", name, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("spool: stat %s: %w", name, err)
+	}
+
+	if keep == 0 {
+		return os.Remove(path)
+	}
+	if keep < info.Size() {
+		if err := os.Truncate(path, keep); err != nil {
+			return fmt.Errorf("spool: truncate %s: %w", name, err)
+		}
+	}
+	end := info.ModTime()
+	if !end.After(start) {
+		end = start.Add(time.Nanosecond)
+	}
+	if err := os.Rename(path, filepath.Join(s.dir, sealedName(start, end))); err != nil {
+		return fmt.Errorf("spool: seal %s: %w", name, err)
+	}
+	s.size += keep
+	return nil
+}
+
+// completeLines returns how many bytes of r end with its last newline,
+// reading it a line at a time.
+func completeLines(r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+	var n, keep int64
+	for {
+		line, err := br.ReadSlice('\n')
+		n += int64(len(line))
+		switch {
+		case err == nil:
+			keep = n
+		case errors.Is(err, io.EOF):
+			return keep, nil
+		case !errors.Is(err, bufio.ErrBufferFull):
+			return 0, err
+		}
+	}
+}
+
+// Append writes one line to the active segment, opening it if needed. A new
+// segment starts at the end of the previous one, or at now after a Break.
+func (s *zoneSpool) Append(line []byte, now time.Time) error {
+	n := int64(len(line)) + 1
+	if s.maxBytes > 0 && s.size+n > s.maxBytes {
+		return errSpoolFull
+	}
+	if s.active == nil {
+		start := now
+		if !s.lastEnd.IsZero() && s.lastEnd.Before(now) {
+			start = s.lastEnd
+		}
+		f, err := os.OpenFile(filepath.Join(s.dir, strconv.FormatInt(start.UnixNano(), 10)+spoolActiveExt), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("spool: open segment: %w", err)
+		}
+		s.active, s.activeStart, s.activeLines = f, start, 0
+	}
+	// One write per line keeps a torn write confined to the last line.
+	buf := make([]byte, 0, n)
+	buf = append(append(buf, line...), '\n')
+	if _, err := s.active.Write(buf); err != nil {
+		return fmt.Errorf("spool: write: %w", err)
+	}
+	s.size += n
+	s.activeLines++
+	return nil
+}
+
+// Lines returns the number of lines in the active segment.
+func (s *zoneSpool) Lines() int { return s.activeLines }
+
+// Size returns the bytes held across all segments.
+func (s *zoneSpool) Size() int64 { return s.size }
+
+// Usage returns Size as a fraction of the size limit (0 when unlimited).
+func (s *zoneSpool) Usage() float64 {
+	if s.maxBytes <= 0 {
+		return 0
+	}
+	return float64(s.size) / float64(s.maxBytes)
+}
+
+// Sync flushes the active segment to stable storage.
+func (s *zoneSpool) Sync() error {
+	if s.active == nil {
+		return nil
+	}
+	return s.active.Sync()
+}
+
+// Seal closes the active segment so it can be archived, covering
+// [its start, now). Without an active segment the stream saw nothing, and the
+// next segment still starts at the previous end.
+func (s *zoneSpool) Seal(now time.Time) error {
+	if s.active == nil {
+		return nil
+	}
+	f := s.active
+	s.active = nil
+	path := f.Name()
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("spool: sync: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("spool: close: %w", err)
+	}
+	end := now
+	if !end.After(s.activeStart) {
+		end = s.activeStart.Add(time.Nanosecond)
+	}
+	if err := os.Rename(path, filepath.Join(s.dir, sealedName(s.activeStart, end))); err != nil {
+		return fmt.Errorf("spool: seal: %w", err)
+	}
+	s.lastEnd = end
+	return nil
+}
+
+// Break seals the active segment at now and marks a break in the stream: the
+// next segment starts at its first line, so time the stream was down isn't
+// counted as covered.
+func (s *zoneSpool) Break(now time.Time) error {
+	err := s.Seal(now)
+	s.lastEnd = time.Time{}
+	return err
+}
+
+// Sealed lists sealed segments, oldest first.
+func (s *zoneSpool) Sealed() ([]spoolSegment, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("spool: read dir: %w", err)
+	}
+	var segs []spoolSegment
+	for _, e := range entries {
+		name := e.Name()
+		if filepath.Ext(name) != spoolSealedExt {
+			continue
+		}
+		from, to, ok := strings.Cut(strings.TrimSuffix(name, spoolSealedExt), "-")
+		if !ok {
+			continue
+		}
+		start, err1 := parseSpoolTime(from)
+		end, err2 := parseSpoolTime(to)
+		info, err3 := e.Info()
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		segs = append(segs, spoolSegment{Path: filepath.Join(s.dir, name), Start: start, End: end, Size: info.Size()})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Start.Before(segs[j].Start) })
+	return segs, nil
+}
+
+// Remove deletes an archived segment.
+func (s *zoneSpool) Remove(seg spoolSegment) error {
+	if err := os.Remove(seg.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("spool: remove: %w", err)
+	}
+	s.size -= seg.Size
+	return nil
+}
+
+// Close syncs and closes the active segment without sealing it; the next
+// openZoneSpool adopts it.
+func (s *zoneSpool) Close() error {
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Sync()
+	if cerr := s.active.Close(); err == nil {
+		err = cerr
+	}
+	s.active = nil
+	return err
+}
+
+// open returns a reader for a sealed segment.
+func (seg spoolSegment) open() (io.ReadSeekCloser, error) {
+	return os.Open(seg.Path)
+}
+
+func sealedName(start, end time.Time) string {
+	return strconv.FormatInt(start.UnixNano(), 10) + "-" + strconv.FormatInt(end.UnixNano(), 10) + spoolSealedExt
+}
+
+func parseSpoolTime(s string) (time.Time, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, n).UTC(), nil
+}
@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZoneSpool_SealAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := openZoneSpool(dir, 0)
+	require.NoError(t, err)
+
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, spool.Append([]byte(`{"RayID":"a"}`), start))
+	require.NoError(t, spool.Append([]byte(`{"RayID":"b"}`), start.Add(time.Second)))
+	assert.Equal(t, 2, spool.Lines())
+
+	end := start.Add(30 * time.Second)
+	require.NoError(t, spool.Seal(end))
+
+	segs, err := spool.Sealed()
+	require.NoError(t, err)
+	require.Len(t, segs, 1)
+	assert.True(t, segs[0].Start.Equal(start))
+	assert.True(t, segs[0].End.Equal(end))
+
+	data, err := os.ReadFile(segs[0].Path)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"RayID\":\"a\"}\n{\"RayID\":\"b\"}\n", string(data))
+
+	require.NoError(t, spool.Remove(segs[0]))
+	assert.Zero(t, spool.Size())
+}
+
+func TestZoneSpool_SegmentsAreContiguous(t *testing.T) {
+	spool, err := openZoneSpool(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, spool.Append([]byte(`{"RayID":"a"}`), start))
+	require.NoError(t, spool.Seal(start.Add(30*time.Second)))
+	// A flush without lines leaves no segment behind.
+	require.NoError(t, spool.Seal(start.Add(60*time.Second)))
+	require.NoError(t, spool.Append([]byte(`{"RayID":"b"}`), start.Add(75*time.Second)))
+	require.NoError(t, spool.Break(start.Add(80*time.Second)))
+	// After a break the next segment starts at its first line.
+	require.NoError(t, spool.Append([]byte(`{"RayID":"c"}`), start.Add(5*time.Minute)))
+	require.NoError(t, spool.Seal(start.Add(6*time.Minute)))
+
+	segs, err := spool.Sealed()
+	require.NoError(t, err)
+	require.Len(t, segs, 3)
+	assert.True(t, segs[0].Start.Equal(start))
+	assert.True(t, segs[1].Start.Equal(segs[0].End))
+	assert.True(t, segs[1].End.Equal(start.Add(80*time.Second)))
+	assert.True(t, segs[2].Start.Equal(start.Add(5*time.Minute)))
+}
+
+func TestZoneSpool_AdoptsCrashedSegment(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := openZoneSpool(dir, 0)
+	require.NoError(t, err)
+
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, spool.Append([]byte(`{"RayID":"a"}`), start))
+	require.NoError(t, spool.Close())
+
+	// Simulate a torn write: a partial line after the last complete one.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	f, err := os.OpenFile(filepath.Join(dir, entries[0].Name()), os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"RayID":`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reopened, err := openZoneSpool(dir, 0)
+	require.NoError(t, err)
+	segs, err := reopened.Sealed()
+	require.NoError(t, err)
+	require.Len(t, segs, 1)
+	assert.True(t, segs[0].Start.Equal(start))
+
+	data, err := os.ReadFile(segs[0].Path)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"RayID\":\"a\"}\n", string(data))
+	assert.Equal(t, int64(len(data)), reopened.Size())
+}
+
+func TestCompleteLines(t *testing.T) {
+	long := strings.Repeat("x", 10000) // longer than the read buffer
+	for _, tc := range []struct {
+		in   string
+		want int64
+	}{
+		{"", 0},
+		{"partial", 0},
+		{"a\nb\n", 4},
+		{"a\n" + long + "\n" + long, int64(len(long)) + 3},
+	} {
+		got, err := completeLines(strings.NewReader(tc.in))
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, got)
+	}
+}
+
+func TestZoneSpool_SizeLimit(t *testing.T) {
+	spool, err := openZoneSpool(t.TempDir(), 10)
+	require.NoError(t, err)
+
+	now := time.Now()
+	require.NoError(t, spool.Append([]byte("12345678"), now))
+	assert.ErrorIs(t, spool.Append([]byte("x"), now), errSpoolFull)
+	assert.InDelta(t, 0.9, spool.Usage(), 0.001)
+}
@@ -0,0 +1,86 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+// adaptiveWindowHistory is how many recent jobs of a zone its adaptive window
+// is sized from.
+const adaptiveWindowHistory = 24
+
+// windowPolicy sizes the Logpull windows of zones with adaptive windows, so
+// that their objects come close to a target size whatever the zone's traffic.
+type windowPolicy struct {
+	targetBytes int64
+	targetLines int64
+	minWindow   time.Duration
+	maxWindow   time.Duration
+}
+
+func newWindowPolicy(cfg config.WorkerConfig) windowPolicy {
+	return windowPolicy{
+		targetBytes: cfg.AdaptiveWindowTargetBytes,
+		targetLines: cfg.AdaptiveWindowTargetLines,
+		minWindow:   cfg.AdaptiveWindowMin,
+		maxWindow:   cfg.AdaptiveWindowMax,
+	}
+}
+
+// size returns the window that, at the rate of the recent jobs, yields about
+// targetBytes and at most targetLines per object, truncated to the minute and
+// kept within the bounds. Zones without history start from fallback; zones
+// without traffic get the longest window.
+func (p windowPolicy) size(recent []*models.LogJob, fallback time.Duration) time.Duration {
+	var span time.Duration
+	var bytes, lines int64
+	for _, j := range recent {
+		span += j.PeriodEnd.Sub(j.PeriodStart)
+		bytes += j.ByteCount
+		lines += j.LogCount
+	}
+
+	w := fallback
+	if span > 0 {
+		w = p.scale(span, p.targetBytes, bytes)
+		if p.targetLines > 0 {
+			w = min(w, p.scale(span, p.targetLines, lines))
+		}
+	}
+	return max(p.minWindow, min(w.Truncate(time.Minute), p.maxWindow))
+}
+
+// scale returns the span in which observed would grow to target, capped at
+// maxWindow.
+func (p windowPolicy) scale(span time.Duration, target, observed int64) time.Duration {
+	if observed <= 0 {
+		return p.maxWindow
+	}
+	w := float64(span) * float64(target) / float64(observed)
+	if w >= float64(p.maxWindow) {
+		return p.maxWindow
+	}
+	return time.Duration(w)
+}
+
+// adaptiveWindow sizes the next Logpull window of zone from its recent jobs.
+// Until it has history, zone keeps its current window, or its pull interval.
+func (s *ZoneScheduler) adaptiveWindow(ctx context.Context, zone *models.Zone) time.Duration {
+	fallback := time.Duration(zone.PullIntervalSecs) * time.Second
+	if zone.WindowSecs > 0 {
+		fallback = time.Duration(zone.WindowSecs) * time.Second
+	}
+	recent, err := s.db.LogJobs.ListRecentDone(ctx, zone.ID, models.LogTypeLogpull, adaptiveWindowHistory)
+	if err != nil {
+		s.log.Warn("scheduler: list recent jobs", zap.String("zone_id", zone.ID.String()), zap.Error(err))
+		recent = nil
+	}
+	w := s.window.size(recent, fallback)
+	adaptiveWindowSeconds.Observe(w.Seconds())
+	return w
+}
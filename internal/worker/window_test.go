@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+)
+
+func jobsOf(n int, window time.Duration, bytes, lines int64) []*models.LogJob {
+	start := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	jobs := make([]*models.LogJob, n)
+	for i := range jobs {
+		from := start.Add(time.Duration(i) * window)
+		jobs[i] = &models.LogJob{PeriodStart: from, PeriodEnd: from.Add(window), ByteCount: bytes, LogCount: lines}
+	}
+	return jobs
+}
+
+func TestWindowPolicySize(t *testing.T) {
+	p := windowPolicy{targetBytes: 64 << 20, minWindow: 5 * time.Minute, maxWindow: time.Hour}
+
+	// 8 MiB per 5 minutes: 64 MiB takes 40 minutes.
+	assert.Equal(t, 40*time.Minute, p.size(jobsOf(12, 5*time.Minute, 8<<20, 1000), time.Hour))
+
+	// Busy zones are held at the minimum, quiet and silent ones at the maximum.
+	assert.Equal(t, 5*time.Minute, p.size(jobsOf(12, 5*time.Minute, 1<<30, 1000), time.Hour))
+	assert.Equal(t, time.Hour, p.size(jobsOf(12, time.Hour, 1<<10, 10), time.Hour))
+	assert.Equal(t, time.Hour, p.size(jobsOf(12, time.Hour, 0, 0), time.Hour))
+
+	// Without history the fallback is used, within bounds.
+	assert.Equal(t, 15*time.Minute, p.size(nil, 15*time.Minute))
+	assert.Equal(t, 5*time.Minute, p.size(nil, time.Minute))
+
+	// Sizes are truncated to the minute: 64 MiB in 7.5 minutes.
+	assert.Equal(t, 7*time.Minute, p.size(jobsOf(4, 5*time.Minute, 64<<20*2/3, 1000), time.Hour))
+
+	// A line target shortens the window when it is reached first.
+	p.targetLines = 100000
+	assert.Equal(t, 25*time.Minute, p.size(jobsOf(12, 5*time.Minute, 8<<20, 20000), time.Hour))
+	assert.Equal(t, 40*time.Minute, p.size(jobsOf(12, 5*time.Minute, 8<<20, 1000), time.Hour))
+}
@@ -1,22 +1,24 @@
 package worker
 
 import (
+	"bufio"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
+	"io"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
 	"go.uber.org/zap"
 
+	"github.com/fabriziosalmi/rainlogs/internal/breaker"
 	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
 	"github.com/fabriziosalmi/rainlogs/internal/config"
 	"github.com/fabriziosalmi/rainlogs/internal/db"
-	"github.com/fabriziosalmi/rainlogs/internal/kms"
 	"github.com/fabriziosalmi/rainlogs/internal/models"
 	"github.com/fabriziosalmi/rainlogs/internal/notifications"
 	"github.com/fabriziosalmi/rainlogs/internal/queue"
@@ -25,107 +27,186 @@ import (
 )
 
 type LogPullProcessor struct {
-	db       *db.DB
-	kms      *kms.Encryptor
-	storage  *storage.MultiStore
-	queue    *asynq.Client
-	cfCfg    config.CloudflareConfig
-	log      *zap.Logger
-	notifier notifications.NotificationService
+	jobs      PullJobs
+	customers CustomerReader
+	zones     ZoneReader
+	creds     *Credentials
+	storage   ArchiveStorage
+	queue     TaskEnqueuer
+	cfCfg     config.CloudflareConfig
+	log       *zap.Logger
+	notifier  notifications.NotificationService
+	limits    *RateLimiter
+	breakers  *breaker.Breakers
 
 	conf config.Config
 }
 
-func NewLogPullProcessor(db *db.DB, kms *kms.Encryptor, storage *storage.MultiStore, queue *asynq.Client, cfg config.Config, log *zap.Logger, notifier notifications.NotificationService) *LogPullProcessor {
-	// Instead of a global limiter, we will use a per-plan strategy in ProcessTask
+func NewLogPullProcessor(db *db.DB, creds *Credentials, storage *storage.MultiStore, queue *asynq.Client, cfg config.Config, limits *RateLimiter, breakers *breaker.Breakers, log *zap.Logger, notifier notifications.NotificationService) *LogPullProcessor {
 	return &LogPullProcessor{
-		db:       db,
-		kms:      kms,
-		storage:  storage,
-		queue:    queue,
-		cfCfg:    cfg.Cloudflare,
-		log:      log,
-		notifier: notifier,
-		conf:     cfg,
+		jobs:      db.LogJobs,
+		customers: db.Customers,
+		zones:     db.Zones,
+		creds:     creds,
+		storage:   storage,
+		queue:     queue,
+		cfCfg:     cfg.Cloudflare,
+		log:       log,
+		notifier:  notifier,
+		limits:    limits,
+		breakers:  breakers,
+		conf:      cfg,
 	}
 }
 
+// pullProgress is written to the asynq task result so operators can follow
+// multi-chunk pulls through the queue inspector.
+type pullProgress struct {
+	ChunksTotal int   `json:"chunks_total"`
+	ChunksDone  int   `json:"chunks_done"`
+	LogCount    int64 `json:"log_count"`
+	ByteCount   int64 `json:"byte_count"`
+}
+
+// ProcessTask pulls the payload window from Cloudflare. Windows longer than the
+// Logpull limit, or than the payload's adaptive window, are split into
+// consecutive chunks that are pulled in order, each recorded as its own LogJob
+// and hash-chain link. Chunks that already have a done job (e.g. from a
+// previous attempt of this task) are skipped.
 func (p *LogPullProcessor) ProcessTask(ctx context.Context, t *asynq.Task) error {
 	payload, err := queue.ParseLogPullPayload(t)
 	if err != nil {
 		return fmt.Errorf("parse payload: %w", err)
 	}
 
-	// 1. Create LogJob
+	size := p.cfCfg.MaxWindowSize
+	if w := time.Duration(payload.WindowSecs) * time.Second; w > 0 && (size <= 0 || w < size) {
+		size = w
+	}
+	windows := cloudflare.SplitWindow(payload.PeriodStart, payload.PeriodEnd, size)
+	progress := pullProgress{ChunksTotal: len(windows)}
+
+	for i, w := range windows {
+		done, err := p.jobs.HasDoneWindow(ctx, payload.ZoneID, models.LogTypeLogpull, w.Start, w.End)
+		if err != nil {
+			return fmt.Errorf("check chunk %d/%d: %w", i+1, len(windows), err)
+		}
+		if !done {
+			job, err := p.pullWindow(ctx, payload, w)
+			if err != nil || job.Status != models.JobStatusDone {
+				// Later chunks must not be chained ahead of a missing one.
+				return err
+			}
+			progress.LogCount += job.LogCount
+			progress.ByteCount += job.ByteCount
+		}
+		progress.ChunksDone++
+		p.reportProgress(t, payload, progress)
+	}
+
+	return nil
+}
+
+// reportProgress logs chunk progress and stores it as the task result.
+func (p *LogPullProcessor) reportProgress(t *asynq.Task, payload queue.LogPullPayload, progress pullProgress) {
+	p.log.Info("log pull progress",
+		zap.String("zone_id", payload.ZoneID.String()),
+		zap.Int("chunks_done", progress.ChunksDone),
+		zap.Int("chunks_total", progress.ChunksTotal),
+		zap.Int64("log_count", progress.LogCount),
+		zap.Int64("byte_count", progress.ByteCount),
+	)
+	rw := t.ResultWriter()
+	if rw == nil {
+		return
+	}
+	b, err := json.Marshal(progress)
+	if err != nil {
+		return
+	}
+	if _, err := rw.Write(b); err != nil {
+		p.log.Warn("write pull progress failed", zap.Error(err))
+	}
+}
+
+// pullJobNamespace scopes the name-based UUIDs of Logpull jobs.
+var pullJobNamespace = uuid.MustParse("1f26d0a0-e2cd-4c13-8886-2d6ba018ff7e")
+
+// pullJobID derives the job ID of a zone's Logpull window from its bounds, so
+// every attempt at pulling the window, retries after a 429 included, maps to
+// the same job.
+func pullJobID(zoneID uuid.UUID, w cloudflare.Window) uuid.UUID {
+	return uuid.NewSHA1(pullJobNamespace, []byte(fmt.Sprintf("%s/%s/%d-%d", zoneID, models.LogTypeLogpull, w.Start.UnixNano(), w.End.UnixNano())))
+}
+
+// pullWindow pulls, archives and chains a single Cloudflare-legal window.
+// The returned job is non-nil whenever err is nil; a job left in a status other
+// than done means the window was not archived.
+func (p *LogPullProcessor) pullWindow(ctx context.Context, payload queue.LogPullPayload, w cloudflare.Window) (*models.LogJob, error) {
+	// 1. Create the window's LogJob, or take up the one an earlier attempt
+	// left failed.
 	job := &models.LogJob{
-		ID:          uuid.New(),
+		ID:          pullJobID(payload.ZoneID, w),
 		ZoneID:      payload.ZoneID,
 		CustomerID:  payload.CustomerID,
-		PeriodStart: payload.PeriodStart,
-		PeriodEnd:   payload.PeriodEnd,
+		PeriodStart: w.Start,
+		PeriodEnd:   w.End,
+		LogType:     models.LogTypeLogpull,
+		Dataset:     models.DatasetHTTPRequests,
 		Status:      models.JobStatusPending,
+		WindowSecs:  payload.WindowSecs,
 	}
-	if err := p.db.LogJobs.Create(ctx, job); err != nil {
-		return fmt.Errorf("create job: %w", err)
+	created, err := p.jobs.CreateIfAbsent(ctx, job)
+	if err == nil && !created {
+		job, err = p.jobs.GetByID(ctx, job.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create job: %w", err)
+	}
+	if job.Status == models.JobStatusDone {
+		return job, nil
 	}
 
 	// 2. Get Customer & Zone
-	customer, err := p.db.Customers.GetByID(ctx, payload.CustomerID)
+	customer, err := p.customers.GetByID(ctx, payload.CustomerID)
 	if err != nil {
-		return p.failJob(ctx, job, fmt.Errorf("get customer: %w", err))
+		return job, p.failJob(ctx, job, fmt.Errorf("get customer: %w", err))
 	}
-	zone, err := p.db.Zones.GetByID(ctx, payload.ZoneID)
+	zone, err := p.zones.GetByID(ctx, payload.ZoneID)
 	if err != nil {
-		return p.failJob(ctx, job, fmt.Errorf("get zone: %w", err))
+		return job, p.failJob(ctx, job, fmt.Errorf("get zone: %w", err))
 	}
 
 	// 2a. Check Quota
 	if customer.QuotaBytes != -1 {
-		usage, err := p.db.LogJobs.GetCurrentUsage(ctx, customer.ID)
+		usage, err := p.jobs.GetCurrentUsage(ctx, customer.ID)
 		if err != nil {
-			return p.failJob(ctx, job, fmt.Errorf("check quota: %w", err))
+			return job, p.failJob(ctx, job, fmt.Errorf("check quota: %w", err))
 		}
 		if usage >= customer.QuotaBytes {
 			msg := fmt.Sprintf("Quota exceeded for customer %s (Usage: %d, Limit: %d)", customer.Name, usage, customer.QuotaBytes)
 			if err := p.notifier.SendAlert(ctx, customer.ID.String(), "warning", msg); err != nil {
 				p.log.Warn("failed to send quota alert", zap.Error(err))
 			}
-			return p.failJob(ctx, job, fmt.Errorf("quota exceeded"))
+			return job, p.failJob(ctx, job, fmt.Errorf("quota exceeded"))
 		}
 	}
 
-	//
-
-	// 3. Decrypt CF API Key
-	// 3. Decrypt CF API Key
-	apiKey, err := p.kms.Decrypt(customer.CFAPIKeyEnc)
+	// 3. Resolve the CF API token: the zone's own, else the customer's
+	apiKey, err := p.creds.ForZone(customer, zone)
 	if err != nil {
-		return p.failJob(ctx, job, fmt.Errorf("decrypt cf key: %w", err))
-	}
-
-	// 4. Rate Limiting based on Plan
-	var waitTime time.Duration
-	// Basic delay based on plan tier to prevent flooding
-	switch zone.Plan {
-	case models.PlanEnterprise:
-		waitTime = 100 * time.Millisecond // ~10 reqs/sec max burst
-	case models.PlanBusiness:
-		waitTime = 500 * time.Millisecond // ~2 reqs/sec
-	default:
-		waitTime = 2 * time.Second // ~0.5 reqs/sec (Free/Pro)
+		return job, p.failJob(ctx, job, err)
 	}
 
-	// Simple in-process delay to space out requests from this worker.
-	// Note: Cloudflare 429s are the real authority.
-	select {
-	case <-time.After(waitTime):
-	case <-ctx.Done():
-		return ctx.Err()
+	// 4. Stream Logs from Cloudflare, paced by the token's budget shared
+	// across all workers.
+	fields, err := cloudflare.ResolveFields(zone.FieldProfile, zone.LogFields)
+	if err != nil {
+		return job, p.failJob(ctx, job, fmt.Errorf("resolve log fields: %w", err))
 	}
-
-	// 5. Pull Logs from Cloudflare
-	cfClient := cloudflare.NewClient(p.cfCfg, zone.ZoneID, apiKey)
-	logs, err := cfClient.PullLogs(ctx, payload.PeriodStart, payload.PeriodEnd, nil)
+	cfClient := cloudflare.NewClient(p.cfCfg, zone.ZoneID, apiKey).WithLimiter(p.limits.ForZone(apiKey, zone.Plan))
+	body, err := cfClient.StreamLogs(ctx, w.Start, w.End, fields)
+	recordBreaker(ctx, p.breakers, p.log, p.notifier, customer.ID, breaker.Logpull, err)
 	if err != nil {
 		// Check for rate limit error
 		var rlErr *cloudflare.RateLimitError
@@ -134,58 +215,56 @@ func (p *LogPullProcessor) ProcessTask(ctx context.Context, t *asynq.Task) error
 				zap.String("zone", zone.Name),
 				zap.Duration("retry_after", rlErr.RetryAfter),
 			)
-			// Return the specific error type to be handled by retry logic
-			// For now, we wrap it to provide context
-			return fmt.Errorf("pull logs: %w", rlErr)
+			// The job fails like any other; the retry delay honours RetryAfter.
+			return job, p.failJob(ctx, job, fmt.Errorf("pull logs: %w", rlErr))
 		}
-		return p.failJob(ctx, job, fmt.Errorf("pull logs: %w", err))
+		return job, p.failJob(ctx, job, fmt.Errorf("pull logs: %w", err))
 	}
+	defer body.Close()
 
-	if len(logs) == 0 {
+	logs := bufio.NewReader(body)
+	if _, err := logs.Peek(1); err != nil {
+		if err != io.EOF {
+			return job, p.failJob(ctx, job, fmt.Errorf("pull logs: read body: %w", err))
+		}
 		job.Status = models.JobStatusDone
 		job.LogCount = 0
 		job.ByteCount = 0
-		return p.db.LogJobs.Update(ctx, job)
+		return job, p.jobs.Update(ctx, job)
 	}
 
-	// 5. Hash & WORM
+	// 5. Hash & upload in a single streaming pass so memory stays constant
+	// regardless of window size. The raw SHA-256 feeds the WORM chain.
 	h := sha256.New()
-	h.Write(logs)
-	hashStr := hex.EncodeToString(h.Sum(nil))
-
-	prevJob, err := p.db.LogJobs.GetLastJob(ctx, zone.ID)
-	prevChainHash := worm.GenesisHash
-	if err == nil && prevJob != nil {
-		prevChainHash = prevJob.ChainHash
-	}
-
-	chainHash := worm.ChainHash(prevChainHash, hashStr, job.ID.String())
-
-	// 6. Upload to S3
-	s3Key, s3HashStr, provider, byteCount, logCount, err := p.storage.PutLogs(ctx, customer.ID, zone.ID, payload.PeriodStart, payload.PeriodEnd, logs, "logs")
+	s3Key, s3HashStr, provider, byteCount, logCount, err := p.storage.PutLogsStream(ctx, customer.ID, zone.ID, w.Start, w.End, io.TeeReader(logs, h), job.LogType, job.Dataset, job.ID)
 	if err != nil {
-		return p.failJob(ctx, job, fmt.Errorf("s3 upload: %w", err))
+		return job, p.failJob(ctx, job, fmt.Errorf("s3 upload: %w", err))
 	}
+	hashStr := hex.EncodeToString(h.Sum(nil))
 
-	// 7. Update Job
-	job.Status = models.JobStatusDone
+	// 6. Finish the job, linked after the last job of the zone's chain
 	job.S3Key = s3Key
 	job.S3Provider = provider
 	job.SHA256 = s3HashStr
-	job.ChainHash = chainHash
 	job.ByteCount = byteCount
 	job.LogCount = logCount
-	if err := p.db.LogJobs.Update(ctx, job); err != nil {
-		return fmt.Errorf("update job: %w", err)
+	err = p.jobs.FinishChained(ctx, job, func(prev string) string {
+		if prev == "" {
+			prev = worm.GenesisHash
+		}
+		return worm.ChainHash(prev, hashStr, job.ID.String())
+	})
+	if err != nil {
+		return job, p.failJob(ctx, job, fmt.Errorf("chain job: %w", err))
 	}
 
-	// 8. Enqueue Verify Task. Creating the task structure is always expected
+	// 7. Enqueue Verify Task. Creating the task structure is always expected
 	// to succeed; failure is a programming error and must stop processing.
 	// Enqueueing may fail transiently (Redis unavailable) – log at ERROR so
 	// operators are alerted; the upload is complete and data is not lost.
 	verifyTask, err := queue.NewLogVerifyTask(queue.LogVerifyPayload{JobID: job.ID})
 	if err != nil {
-		return fmt.Errorf("job %s: create verify task: %w", job.ID, err)
+		return job, fmt.Errorf("job %s: create verify task: %w", job.ID, err)
 	}
 	if _, err := p.queue.EnqueueContext(ctx, verifyTask); err != nil {
 		p.log.Error("enqueue verify task failed – WORM integrity check deferred",
@@ -194,23 +273,39 @@ func (p *LogPullProcessor) ProcessTask(ctx context.Context, t *asynq.Task) error
 		)
 	}
 
-	return nil
+	return job, nil
 }
 
 func (p *LogPullProcessor) failJob(ctx context.Context, job *models.LogJob, err error) error {
 	job.Attempts++
 	job.Status = models.JobStatusFailed
 	job.ErrMsg = err.Error()
-	_ = p.db.LogJobs.Update(ctx, job)
-
-	// If the error is 403 Forbidden, it means the feature is not available (likely not Enterprise).
-	// We should stop retrying to avoid spamming the logs and the API.
-	if strings.Contains(err.Error(), "HTTP 403") {
-		p.log.Error("Cloudflare Logpull API not available (requires Enterprise plan). Stopping retry.",
+	_ = p.jobs.Update(ctx, job)
+
+	switch {
+	case errors.Is(err, cloudflare.ErrNotEntitled), errors.Is(err, cloudflare.ErrMissingPermission):
+		// Logpull is not available to the zone (likely not Enterprise) or
+		// the token. We should stop retrying to avoid spamming the logs and
+		// the API. A zone that lost the entitlement has its plan re-detected
+		// so it moves to a collector that works; the preflight alerts on
+		// missing permissions.
+		p.log.Error("Cloudflare Logpull API not available. Stopping retry.",
 			zap.String("job_id", job.ID.String()),
 			zap.Error(err),
 		)
+		if errors.Is(err, cloudflare.ErrNotEntitled) {
+			if err := requestPlanCheck(ctx, p.queue, job.CustomerID); err != nil {
+				p.log.Error("failed to request plan check", zap.String("customer_id", job.CustomerID.String()), zap.Error(err))
+			}
+		}
 		return nil // Return nil to stop retrying
+	case cloudflare.IsPermanent(err):
+		// Retrying can't help until the customer acts; SkipPermanent
+		// archives the task.
+		msg := fmt.Sprintf("Log pull failed for zone %s: %s (%v)", job.ZoneID, cloudflareAlert(err), err)
+		if alertErr := p.notifier.SendAlert(ctx, job.CustomerID.String(), "error", msg); alertErr != nil {
+			p.log.Warn("failed to send failure alert", zap.Error(alertErr))
+		}
 	}
 
 	return err
@@ -245,13 +340,18 @@ func (p *LogVerifyProcessor) ProcessTask(ctx context.Context, t *asynq.Task) err
 		return fmt.Errorf("job missing s3 key or hash")
 	}
 
-	data, err := p.storage.GetLogs(ctx, job.S3Key)
+	// job.SHA256 is taken over the stored, compressed object: hash it as it
+	// streams in rather than decompressing it into memory.
+	obj, err := p.storage.GetObject(ctx, job.S3Key)
 	if err != nil {
 		return fmt.Errorf("s3 download: %w", err)
 	}
+	defer obj.Close()
 
 	h := sha256.New()
-	h.Write(data)
+	if _, err := io.Copy(h, obj); err != nil {
+		return fmt.Errorf("s3 download: %w", err)
+	}
 
 	hashStr := hex.EncodeToString(h.Sum(nil))
 	if hashStr != job.SHA256 {
@@ -312,18 +412,30 @@ func (p *LogExpireProcessor) ProcessTask(ctx context.Context, t *asynq.Task) err
 }
 
 type ZoneScheduler struct {
-	db       *db.DB
-	queue    *asynq.Client
-	log      *zap.Logger
-	interval time.Duration
+	db                *db.DB
+	queue             *asynq.Client
+	breakers          *breaker.Breakers
+	log               *zap.Logger
+	interval          time.Duration
+	gapScanInterval   time.Duration
+	discoveryInterval time.Duration
+	preflightInterval time.Duration
+	auditLogInterval  time.Duration
+	window            windowPolicy
 }
 
-func NewZoneScheduler(db *db.DB, queue *asynq.Client, log *zap.Logger, interval time.Duration) *ZoneScheduler {
+func NewZoneScheduler(db *db.DB, queue *asynq.Client, breakers *breaker.Breakers, log *zap.Logger, cfg config.WorkerConfig) *ZoneScheduler {
 	return &ZoneScheduler{
-		db:       db,
-		queue:    queue,
-		log:      log,
-		interval: interval,
+		db:                db,
+		queue:             queue,
+		breakers:          breakers,
+		log:               log,
+		interval:          cfg.SchedulerInterval,
+		gapScanInterval:   cfg.GapScanInterval,
+		discoveryInterval: cfg.ZoneDiscoveryInterval,
+		preflightInterval: cfg.TokenCheckInterval,
+		auditLogInterval:  cfg.AuditLogInterval,
+		window:            newWindowPolicy(cfg),
 	}
 }
 
@@ -336,14 +448,39 @@ func (s *ZoneScheduler) Run(ctx context.Context) {
 	expiryTicker := time.NewTicker(24 * time.Hour)
 	defer expiryTicker.Stop()
 
+	gapScanTicker := time.NewTicker(s.gapScanInterval)
+	defer gapScanTicker.Stop()
+
+	s.scheduleDiscovery(ctx)
+	discoveryTicker := time.NewTicker(s.discoveryInterval)
+	defer discoveryTicker.Stop()
+
+	s.schedulePreflights(ctx)
+	preflightTicker := time.NewTicker(s.preflightInterval)
+	defer preflightTicker.Stop()
+
+	s.scheduleAuditLogs(ctx)
+	auditLogTicker := time.NewTicker(s.auditLogInterval)
+	defer auditLogTicker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
 			s.schedule(ctx)
+			s.scheduleDatasets(ctx)
+			s.scheduleSettles(ctx)
 		case <-expiryTicker.C:
 			s.scheduleExpiry(ctx)
+		case <-gapScanTicker.C:
+			s.scheduleGapScans(ctx)
+		case <-discoveryTicker.C:
+			s.scheduleDiscovery(ctx)
+		case <-preflightTicker.C:
+			s.schedulePreflights(ctx)
+		case <-auditLogTicker.C:
+			s.scheduleAuditLogs(ctx)
 		}
 	}
 }
@@ -362,18 +499,36 @@ func (s *ZoneScheduler) schedule(ctx context.Context) {
 			start = *zone.LastPulledAt
 		}
 
+		end := now
 		var task *asynq.Task
 		var taskID string
 
+		// Zones of an account whose collector API keeps failing wait for the
+		// breaker; last_pulled_at stays put, so the window catches up later.
+		if zone.Plan != models.PlanBusiness && !s.breakerAllows(ctx, zone.CustomerID, breaker.FamilyFor(zone.Plan)) {
+			continue
+		}
+
+		// Zones with adaptive windows have their Logpull windows sized to
+		// their traffic; the size also sets when the zone is due next.
+		var windowSecs int
+		if zone.AdaptiveWindow && breaker.FamilyFor(zone.Plan) == breaker.Logpull {
+			windowSecs = int(s.adaptiveWindow(ctx, zone).Seconds())
+		}
+
 		// Dispatch based on plan type
 		switch zone.Plan {
 		case models.PlanEnterprise:
-			// Default LogPull behavior
+			// Default LogPull behavior. Logpull only serves windows ending at
+			// least LogAvailabilityDelay ago; the worker splits the rest into
+			// ≤1h chunks.
+			end = now.Add(-cloudflare.LogAvailabilityDelay)
 			t, err := queue.NewLogPullTask(queue.LogPullPayload{
 				ZoneID:      zone.ID,
 				CustomerID:  zone.CustomerID,
 				PeriodStart: start,
-				PeriodEnd:   now,
+				PeriodEnd:   end,
+				WindowSecs:  windowSecs,
 			})
 			if err != nil {
 				s.log.Error("scheduler: create log pull task", zap.String("zone_id", zone.ID.String()), zap.Error(err))
@@ -388,7 +543,7 @@ func (s *ZoneScheduler) schedule(ctx context.Context) {
 				ZoneID:      zone.ID,
 				CustomerID:  zone.CustomerID,
 				PeriodStart: start,
-				PeriodEnd:   now,
+				PeriodEnd:   end,
 			})
 			if err != nil {
 				s.log.Error("scheduler: create security poll task", zap.String("zone_id", zone.ID.String()), zap.Error(err))
@@ -404,11 +559,13 @@ func (s *ZoneScheduler) schedule(ctx context.Context) {
 
 		default:
 			// Default to Enterprise logic if not specified (backward compatibility)
+			end = now.Add(-cloudflare.LogAvailabilityDelay)
 			t, err := queue.NewLogPullTask(queue.LogPullPayload{
 				ZoneID:      zone.ID,
 				CustomerID:  zone.CustomerID,
 				PeriodStart: start,
-				PeriodEnd:   now,
+				PeriodEnd:   end,
+				WindowSecs:  windowSecs,
 			})
 			if err != nil {
 				s.log.Error("scheduler: create fallback task", zap.String("zone_id", zone.ID.String()), zap.Error(err))
@@ -428,9 +585,116 @@ func (s *ZoneScheduler) schedule(ctx context.Context) {
 			continue
 		}
 
-		if err := s.db.Zones.UpdateLastPulled(ctx, zone.ID, now); err != nil {
+		if err := s.db.Zones.UpdateLastPulled(ctx, zone.ID, end); err != nil {
 			s.log.Error("scheduler: update last pulled", zap.String("zone_id", zone.ID.String()), zap.Error(err))
 		}
+		if windowSecs > 0 && windowSecs != zone.WindowSecs {
+			if err := s.db.Zones.UpdateWindow(ctx, zone.ID, windowSecs); err != nil {
+				s.log.Error("scheduler: update adaptive window", zap.String("zone_id", zone.ID.String()), zap.Error(err))
+			}
+		}
+	}
+}
+
+// breakerAllows reports whether the customer's circuit breaker for family lets
+// a task through. If Redis can't tell, it does, and the task's own retries
+// apply.
+func (s *ZoneScheduler) breakerAllows(ctx context.Context, customerID uuid.UUID, f breaker.Family) bool {
+	allowed, err := s.breakers.Allow(ctx, customerID, f)
+	if err != nil {
+		s.log.Warn("scheduler: check circuit breaker", zap.String("customer_id", customerID.String()), zap.Error(err))
+		return true
+	}
+	return allowed
+}
+
+// scheduleDatasets enqueues pulls of the zone datasets that RainLogs pulls on
+// their own schedule, next to the plan collector. Firewall events are the only
+// such dataset and go through the security events poller.
+func (s *ZoneScheduler) scheduleDatasets(ctx context.Context) {
+	due, err := s.db.Datasets.ListDue(ctx)
+	if err != nil {
+		s.log.Error("scheduler: list due datasets", zap.Error(err))
+		return
+	}
+
+	for _, ds := range due {
+		zone, err := s.db.Zones.GetByID(ctx, ds.ZoneID)
+		if err != nil {
+			s.log.Error("scheduler: get dataset zone", zap.String("zone_id", ds.ZoneID.String()), zap.Error(err))
+			continue
+		}
+		if !models.DatasetPullable(zone.Plan, ds.Dataset) {
+			continue
+		}
+		if !s.breakerAllows(ctx, zone.CustomerID, breaker.GraphQL) {
+			continue
+		}
+
+		end := time.Now().UTC()
+		start := end.Add(-time.Duration(ds.PullIntervalSecs) * time.Second)
+		if ds.LastPulledAt != nil {
+			start = *ds.LastPulledAt
+		}
+		t, err := queue.NewSecurityPollTask(queue.SecurityPollPayload{
+			ZoneID:      zone.ID,
+			CustomerID:  zone.CustomerID,
+			PeriodStart: start,
+			PeriodEnd:   end,
+		})
+		if err != nil {
+			s.log.Error("scheduler: create dataset poll task", zap.String("zone_id", zone.ID.String()), zap.Error(err))
+			continue
+		}
+
+		taskID := fmt.Sprintf("ds-%s-%s-%d", ds.Dataset, zone.ID, start.Unix())
+		if _, err := s.queue.EnqueueContext(ctx, t, asynq.TaskID(taskID)); err != nil {
+			if errors.Is(err, asynq.ErrTaskIDConflict) || errors.Is(err, asynq.ErrDuplicateTask) {
+				continue
+			}
+			s.log.Error("scheduler: enqueue dataset poll task", zap.String("zone_id", zone.ID.String()), zap.Error(err))
+			continue
+		}
+
+		if err := s.db.Datasets.UpdateLastPulled(ctx, zone.ID, ds.Dataset, end); err != nil {
+			s.log.Error("scheduler: update dataset last pulled", zap.String("zone_id", zone.ID.String()), zap.Error(err))
+		}
+	}
+}
+
+// scheduleSettles enqueues the late-arrival re-pull of Logpull jobs of zones
+// in settle mode whose settle delay has passed, within Logpull's retention.
+func (s *ZoneScheduler) scheduleSettles(ctx context.Context) {
+	since := time.Now().UTC().Add(-cloudflare.LogRetention)
+	jobs, err := s.db.LogJobs.ListSettleDue(ctx, since, settleDueBatch)
+	if err != nil {
+		s.log.Error("scheduler: list jobs due a settle re-pull", zap.Error(err))
+		return
+	}
+
+	allowed := make(map[uuid.UUID]bool)
+	for _, job := range jobs {
+		ok, checked := allowed[job.CustomerID]
+		if !checked {
+			ok = s.breakerAllows(ctx, job.CustomerID, breaker.Logpull)
+			allowed[job.CustomerID] = ok
+		}
+		if !ok {
+			continue
+		}
+		t, err := queue.NewLogSettleTask(queue.LogSettlePayload{JobID: job.ID})
+		if err != nil {
+			s.log.Error("scheduler: create settle task", zap.String("job_id", job.ID.String()), zap.Error(err))
+			continue
+		}
+
+		taskID := fmt.Sprintf("settle-%s", job.ID)
+		if _, err := s.queue.EnqueueContext(ctx, t, asynq.TaskID(taskID)); err != nil {
+			if errors.Is(err, asynq.ErrTaskIDConflict) || errors.Is(err, asynq.ErrDuplicateTask) {
+				continue
+			}
+			s.log.Error("scheduler: enqueue settle task", zap.String("job_id", job.ID.String()), zap.Error(err))
+		}
 	}
 }
 
@@ -469,3 +733,126 @@ func (s *ZoneScheduler) scheduleExpiry(ctx context.Context) {
 		}
 	}
 }
+
+// scheduleGapScans enqueues a coverage gap scan for each active zone with a
+// usable token, once per gap scan interval across all scheduler replicas.
+func (s *ZoneScheduler) scheduleGapScans(ctx context.Context) {
+	zones, err := s.db.Zones.ListCollectable(ctx)
+	if err != nil {
+		s.log.Error("scheduler: list zones for gap scan", zap.Error(err))
+		return
+	}
+
+	slot := time.Now().UTC().Truncate(s.gapScanInterval).Unix()
+	for _, zone := range zones {
+		t, err := queue.NewGapScanTask(queue.GapScanPayload{ZoneID: zone.ID})
+		if err != nil {
+			s.log.Error("scheduler: create gap scan task", zap.String("zone_id", zone.ID.String()), zap.Error(err))
+			continue
+		}
+
+		taskID := fmt.Sprintf("gapscan-%s-%d", zone.ID, slot)
+		_, err = s.queue.EnqueueContext(ctx, t, asynq.TaskID(taskID))
+		if err != nil {
+			if errors.Is(err, asynq.ErrTaskIDConflict) || errors.Is(err, asynq.ErrDuplicateTask) {
+				continue
+			}
+			s.log.Error("scheduler: enqueue gap scan task", zap.String("zone_id", zone.ID.String()), zap.Error(err))
+		}
+	}
+}
+
+// scheduleDiscovery enqueues a Cloudflare zone discovery task for each
+// customer, once per discovery interval across all scheduler replicas.
+// Customers without an account still have their zones' plans detected.
+func (s *ZoneScheduler) scheduleDiscovery(ctx context.Context) {
+	customers, err := s.db.Customers.List(ctx)
+	if err != nil {
+		s.log.Error("scheduler: list customers for zone discovery", zap.Error(err))
+		return
+	}
+
+	slot := time.Now().UTC().Truncate(s.discoveryInterval).Unix()
+	for _, c := range customers {
+		if c.DeletedAt != nil {
+			continue
+		}
+		t, err := queue.NewZoneDiscoverTask(queue.ZoneDiscoverPayload{CustomerID: c.ID})
+		if err != nil {
+			s.log.Error("scheduler: create zone discovery task", zap.String("customer_id", c.ID.String()), zap.Error(err))
+			continue
+		}
+
+		taskID := fmt.Sprintf("discover-%s-%d", c.ID, slot)
+		_, err = s.queue.EnqueueContext(ctx, t, asynq.TaskID(taskID))
+		if err != nil {
+			if errors.Is(err, asynq.ErrTaskIDConflict) || errors.Is(err, asynq.ErrDuplicateTask) {
+				continue
+			}
+			s.log.Error("scheduler: enqueue zone discovery task", zap.String("customer_id", c.ID.String()), zap.Error(err))
+		}
+	}
+}
+
+// schedulePreflights enqueues a Cloudflare token preflight for each customer,
+// once per token check interval across all scheduler replicas.
+func (s *ZoneScheduler) schedulePreflights(ctx context.Context) {
+	customers, err := s.db.Customers.List(ctx)
+	if err != nil {
+		s.log.Error("scheduler: list customers for token preflight", zap.Error(err))
+		return
+	}
+
+	slot := time.Now().UTC().Truncate(s.preflightInterval).Unix()
+	for _, c := range customers {
+		if c.DeletedAt != nil {
+			continue
+		}
+		t, err := queue.NewPreflightTask(queue.PreflightPayload{CustomerID: c.ID})
+		if err != nil {
+			s.log.Error("scheduler: create token preflight task", zap.String("customer_id", c.ID.String()), zap.Error(err))
+			continue
+		}
+
+		taskID := fmt.Sprintf("preflight-%s-%d", c.ID, slot)
+		_, err = s.queue.EnqueueContext(ctx, t, asynq.TaskID(taskID))
+		if err != nil {
+			if errors.Is(err, asynq.ErrTaskIDConflict) || errors.Is(err, asynq.ErrDuplicateTask) {
+				continue
+			}
+			s.log.Error("scheduler: enqueue token preflight task", zap.String("customer_id", c.ID.String()), zap.Error(err))
+		}
+	}
+}
+
+// scheduleAuditLogs enqueues an account audit log pull for each customer with
+// a Cloudflare account, once per audit log interval across all scheduler
+// replicas.
+func (s *ZoneScheduler) scheduleAuditLogs(ctx context.Context) {
+	customers, err := s.db.Customers.List(ctx)
+	if err != nil {
+		s.log.Error("scheduler: list customers for audit logs", zap.Error(err))
+		return
+	}
+
+	slot := time.Now().UTC().Truncate(s.auditLogInterval).Unix()
+	for _, c := range customers {
+		if c.DeletedAt != nil || c.CFAccountID == "" {
+			continue
+		}
+		t, err := queue.NewAuditLogPullTask(queue.AuditLogPullPayload{CustomerID: c.ID})
+		if err != nil {
+			s.log.Error("scheduler: create audit log task", zap.String("customer_id", c.ID.String()), zap.Error(err))
+			continue
+		}
+
+		taskID := fmt.Sprintf("audit-%s-%d", c.ID, slot)
+		_, err = s.queue.EnqueueContext(ctx, t, asynq.TaskID(taskID))
+		if err != nil {
+			if errors.Is(err, asynq.ErrTaskIDConflict) || errors.Is(err, asynq.ErrDuplicateTask) {
+				continue
+			}
+			s.log.Error("scheduler: enqueue audit log task", zap.String("customer_id", c.ID.String()), zap.Error(err))
+		}
+	}
+}
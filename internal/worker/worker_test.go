@@ -0,0 +1,269 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare"
+	"github.com/fabriziosalmi/rainlogs/internal/cloudflare/cftest"
+	"github.com/fabriziosalmi/rainlogs/internal/config"
+	"github.com/fabriziosalmi/rainlogs/internal/kms"
+	"github.com/fabriziosalmi/rainlogs/internal/models"
+	"github.com/fabriziosalmi/rainlogs/internal/notifications"
+	"github.com/fabriziosalmi/rainlogs/internal/queue"
+	"github.com/fabriziosalmi/rainlogs/internal/storage"
+	"github.com/fabriziosalmi/rainlogs/pkg/worm"
+)
+
+// MockPullJobs simulates log job database access; FinishChained links jobs
+// in the order they finish, like the database does.
+type MockPullJobs struct {
+	mock.Mock
+	head string
+}
+
+func (m *MockPullJobs) HasDoneWindow(ctx context.Context, zoneID uuid.UUID, logType string, start, end time.Time) (bool, error) {
+	args := m.Called(ctx, zoneID, logType, start, end)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockPullJobs) CreateIfAbsent(ctx context.Context, j *models.LogJob) (bool, error) {
+	args := m.Called(ctx, j)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockPullJobs) GetByID(ctx context.Context, id uuid.UUID) (*models.LogJob, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.LogJob), args.Error(1)
+}
+
+func (m *MockPullJobs) Update(ctx context.Context, j *models.LogJob) error {
+	args := m.Called(ctx, j)
+	return args.Error(0)
+}
+
+func (m *MockPullJobs) GetCurrentUsage(ctx context.Context, customerID uuid.UUID) (int64, error) {
+	args := m.Called(ctx, customerID)
+	return int64(args.Int(0)), args.Error(1)
+}
+
+func (m *MockPullJobs) FinishChained(ctx context.Context, j *models.LogJob, link func(prevChainHash string) string) error {
+	args := m.Called(ctx, j)
+	if err := args.Error(0); err != nil {
+		return err
+	}
+	j.Status, j.ChainHash = models.JobStatusDone, link(m.head)
+	m.head = j.ChainHash
+	return nil
+}
+
+// MockCustomers simulates customer lookups
+type MockCustomers struct {
+	mock.Mock
+}
+
+func (m *MockCustomers) GetByID(ctx context.Context, id uuid.UUID) (*models.Customer, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.Customer), args.Error(1)
+}
+
+// MockZones simulates zone lookups
+type MockZones struct {
+	mock.Mock
+}
+
+func (m *MockZones) GetByID(ctx context.Context, id uuid.UUID) (*models.Zone, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.Zone), args.Error(1)
+}
+
+type pullFixture struct {
+	cf        *cftest.Server
+	proc      *LogPullProcessor
+	jobs      *MockPullJobs
+	queue     *MockTaskEnqueuer
+	store     *storage.MultiStore
+	customer  *models.Customer
+	zone      *models.Zone
+	from, mid time.Time
+	to        time.Time
+}
+
+// newPullFixture wires a LogPullProcessor to the fake Cloudflare server, a
+// filesystem store and mocked repositories, for an Enterprise zone pulled
+// over the last full hour but ten minutes.
+func newPullFixture(t *testing.T) *pullFixture {
+	cf := cftest.NewServer()
+	t.Cleanup(cf.Close)
+	cf.Token = "cf-token"
+
+	enc, err := kms.New("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	require.NoError(t, err)
+	keyEnc, err := enc.Encrypt("cf-token")
+	require.NoError(t, err)
+	fs, err := storage.NewFSStore(t.TempDir())
+	require.NoError(t, err)
+
+	customer := &models.Customer{ID: uuid.New(), Name: "Acme", CFAPIKeyEnc: keyEnc, QuotaBytes: -1}
+	zone := &models.Zone{ID: uuid.New(), CustomerID: customer.ID, ZoneID: "cfzone1", Name: "acme.example", Plan: models.PlanEnterprise}
+	customers, zones := new(MockCustomers), new(MockZones)
+	customers.On("GetByID", mock.Anything, customer.ID).Return(customer, nil)
+	zones.On("GetByID", mock.Anything, zone.ID).Return(zone, nil)
+
+	f := &pullFixture{
+		cf:       cf,
+		jobs:     new(MockPullJobs),
+		queue:    new(MockTaskEnqueuer),
+		store:    storage.NewMultiStore(fs),
+		customer: customer,
+		zone:     zone,
+	}
+	f.to = time.Now().UTC().Add(-10 * time.Minute).Truncate(time.Minute)
+	f.from = f.to.Add(-time.Hour)
+	f.mid = f.from.Add(30 * time.Minute)
+	f.proc = &LogPullProcessor{
+		jobs:      f.jobs,
+		customers: customers,
+		zones:     zones,
+		creds:     NewCredentials(enc),
+		storage:   f.store,
+		queue:     f.queue,
+		cfCfg:     config.CloudflareConfig{BaseURL: cf.URL},
+		log:       zap.NewNop(),
+		notifier:  &notifications.ConsoleNotifier{},
+	}
+	return f
+}
+
+func (f *pullFixture) task(t *testing.T) *asynq.Task {
+	task, err := queue.NewLogPullTask(queue.LogPullPayload{
+		ZoneID:      f.zone.ID,
+		CustomerID:  f.customer.ID,
+		PeriodStart: f.from,
+		PeriodEnd:   f.to,
+		WindowSecs:  1800,
+	})
+	require.NoError(t, err)
+	return task
+}
+
+func TestLogPullProcessor_PullsChunksThroughCloudflare(t *testing.T) {
+	f := newPullFixture(t)
+	f.cf.GzipLogs = true
+	f.cf.AddLogs(f.zone.ZoneID,
+		cftest.Log{Time: f.from, Line: `{"RayID":"a"}`},
+		cftest.Log{Time: f.mid.Add(-time.Second), Line: `{"RayID":"b"}`},
+		cftest.Log{Time: f.mid, Line: `{"RayID":"c"}`},
+		cftest.Log{Time: f.to, Line: `{"RayID":"after"}`},
+	)
+	ctx := context.Background()
+
+	var created []*models.LogJob
+	f.jobs.On("HasDoneWindow", ctx, f.zone.ID, models.LogTypeLogpull, mock.Anything, mock.Anything).Return(false, nil)
+	f.jobs.On("CreateIfAbsent", ctx, mock.Anything).Run(func(args mock.Arguments) {
+		created = append(created, args.Get(1).(*models.LogJob))
+	}).Return(true, nil)
+	f.jobs.On("FinishChained", ctx, mock.Anything).Return(nil)
+	f.queue.On("EnqueueContext", ctx, queue.TypeLogVerify).Return(nil)
+
+	require.NoError(t, f.proc.ProcessTask(ctx, f.task(t)))
+
+	require.Len(t, created, 2)
+	want := []string{"{\"RayID\":\"a\"}\n{\"RayID\":\"b\"}\n", "{\"RayID\":\"c\"}\n"}
+	prev := worm.GenesisHash
+	for i, job := range created {
+		assert.Equal(t, models.JobStatusDone, job.Status)
+		assert.Equal(t, "filesystem", job.S3Provider)
+		data, err := f.store.GetLogs(ctx, job.S3Key)
+		require.NoError(t, err)
+		assert.Equal(t, want[i], string(data))
+
+		raw := sha256.Sum256([]byte(want[i]))
+		assert.Equal(t, worm.ChainHash(prev, hex.EncodeToString(raw[:]), job.ID.String()), job.ChainHash)
+		prev = job.ChainHash
+	}
+	assert.True(t, created[0].PeriodEnd.Equal(f.mid))
+	assert.Equal(t, 2, f.cf.Calls(cftest.Logpull))
+	f.queue.AssertNumberOfCalls(t, "EnqueueContext", 2)
+}
+
+func TestLogPullProcessor_SkipsDoneChunks(t *testing.T) {
+	f := newPullFixture(t)
+	f.cf.AddLogs(f.zone.ZoneID, cftest.Log{Time: f.mid, Line: `{"RayID":"c"}`})
+	ctx := context.Background()
+
+	f.jobs.On("HasDoneWindow", ctx, f.zone.ID, models.LogTypeLogpull, f.from, f.mid).Return(true, nil)
+	f.jobs.On("HasDoneWindow", ctx, f.zone.ID, models.LogTypeLogpull, f.mid, f.to).Return(false, nil)
+	f.jobs.On("CreateIfAbsent", ctx, mock.Anything).Return(true, nil).Once()
+	f.jobs.On("FinishChained", ctx, mock.Anything).Return(nil).Once()
+	f.queue.On("EnqueueContext", ctx, queue.TypeLogVerify).Return(nil)
+
+	require.NoError(t, f.proc.ProcessTask(ctx, f.task(t)))
+	assert.Equal(t, 1, f.cf.Calls(cftest.Logpull))
+	f.jobs.AssertExpectations(t)
+}
+
+func TestLogPullProcessor_Faults(t *testing.T) {
+	f := newPullFixture(t)
+	ctx := context.Background()
+
+	// Every attempt at the first window takes up the job the first one
+	// created.
+	var updates []models.LogJob
+	var job *models.LogJob
+	f.jobs.On("HasDoneWindow", ctx, f.zone.ID, models.LogTypeLogpull, mock.Anything, mock.Anything).Return(false, nil)
+	f.jobs.On("CreateIfAbsent", ctx, mock.Anything).Run(func(args mock.Arguments) {
+		job = args.Get(1).(*models.LogJob)
+	}).Return(true, nil).Once()
+	f.jobs.On("CreateIfAbsent", ctx, mock.Anything).Return(false, nil)
+	f.jobs.On("Update", ctx, mock.Anything).Run(func(args mock.Arguments) {
+		updates = append(updates, *args.Get(1).(*models.LogJob))
+	}).Return(nil)
+
+	// A 429 fails the job and the task, so asynq retries it later; later
+	// chunks are not pulled ahead of the missing one.
+	f.cf.Inject(cftest.Logpull, cftest.RateLimited(7*time.Second))
+	err := f.proc.ProcessTask(ctx, f.task(t))
+	require.Error(t, err)
+	assert.Equal(t, 7*time.Second, RetryDelay(1, err, f.task(t)))
+	require.Len(t, updates, 1)
+	assert.Equal(t, models.JobStatusFailed, updates[0].Status)
+	assert.Equal(t, 1, f.cf.Calls(cftest.Logpull))
+	assert.Equal(t, pullJobID(f.zone.ID, cloudflare.Window{Start: f.from, End: f.mid}), job.ID)
+	f.jobs.On("GetByID", ctx, job.ID).Return(job, nil)
+
+	// Without the Logpull entitlement the task stops and a plan check is
+	// requested.
+	f.cf.Inject(cftest.Logpull, cftest.NotEntitled())
+	f.queue.On("EnqueueContext", ctx, queue.TypeZoneDiscover).Return(nil).Once()
+	require.NoError(t, f.proc.ProcessTask(ctx, f.task(t)))
+	require.Len(t, updates, 2)
+	assert.Equal(t, models.JobStatusFailed, updates[1].Status)
+	f.queue.AssertExpectations(t)
+
+	// A token lacking Logs:Read says nothing about the plan: the task stops
+	// and the preflight reports the permission.
+	f.cf.Inject(cftest.Logpull, cftest.Forbidden())
+	require.NoError(t, f.proc.ProcessTask(ctx, f.task(t)))
+	require.Len(t, updates, 3)
+	assert.Equal(t, models.JobStatusFailed, updates[2].Status)
+	f.queue.AssertExpectations(t)
+
+	for i, u := range updates {
+		assert.Equal(t, job.ID, u.ID)
+		assert.Equal(t, i+1, u.Attempts)
+	}
+}
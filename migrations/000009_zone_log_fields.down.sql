@@ -0,0 +1,2 @@
+ALTER TABLE zones DROP COLUMN IF EXISTS field_profile;
+ALTER TABLE zones DROP COLUMN IF EXISTS log_fields;
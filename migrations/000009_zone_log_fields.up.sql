@@ -0,0 +1,4 @@
+-- Per-zone Logpull field selection. An empty log_fields and field_profile
+-- keep Cloudflare's default field set.
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS log_fields TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS field_profile TEXT NOT NULL DEFAULT '';
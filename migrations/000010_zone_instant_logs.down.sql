@@ -0,0 +1,3 @@
+ALTER TABLE zones DROP COLUMN IF EXISTS instant_filter;
+ALTER TABLE zones DROP COLUMN IF EXISTS instant_sample;
+ALTER TABLE zones DROP COLUMN IF EXISTS instant_fields;
@@ -0,0 +1,5 @@
+-- Per-zone Instant Logs session settings. Empty instant_fields keeps the
+-- default field set; instant_sample 0 or 1 streams every request.
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS instant_fields TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS instant_sample INTEGER NOT NULL DEFAULT 0 CHECK (instant_sample >= 0);
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS instant_filter JSONB;
@@ -0,0 +1,15 @@
+-- Historical Logpull backfills. Progress is derived from log_jobs covering
+-- the range, so only the request itself is stored.
+CREATE TABLE IF NOT EXISTS backfills (
+    id              UUID PRIMARY KEY,
+    customer_id     UUID NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    zone_id         UUID NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    period_start    TIMESTAMPTZ NOT NULL,
+    period_end      TIMESTAMPTZ NOT NULL,
+    windows_total   INTEGER NOT NULL,
+    windows_skipped INTEGER NOT NULL DEFAULT 0,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (period_end > period_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_backfills_zone ON backfills(zone_id, created_at DESC);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_log_jobs_zone_type_period;
+ALTER TABLE log_jobs DROP COLUMN IF EXISTS log_type;
@@ -0,0 +1,18 @@
+-- Record which source a job archived (logs, security or instant) so coverage
+-- can be computed per log type. Existing rows take it from the object key, or
+-- from the zone's plan when the job never uploaded one.
+ALTER TABLE log_jobs ADD COLUMN IF NOT EXISTS log_type TEXT NOT NULL DEFAULT 'logs';
+
+UPDATE log_jobs SET log_type = split_part(s3_key, '/', 1)
+WHERE split_part(s3_key, '/', 1) IN ('security', 'instant');
+
+UPDATE log_jobs j SET log_type = CASE z.plan
+        WHEN 'free_pro' THEN 'security'
+        WHEN 'business' THEN 'instant'
+        ELSE 'logs'
+    END
+FROM zones z
+WHERE j.zone_id = z.id AND COALESCE(j.s3_key, '') = '';
+
+CREATE INDEX IF NOT EXISTS idx_log_jobs_zone_type_period
+    ON log_jobs(zone_id, log_type, period_start);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS cloudflare_zones;
+ALTER TABLE zones DROP COLUMN IF EXISTS cf_deleted_at;
+ALTER TABLE customers DROP COLUMN IF EXISTS auto_enroll_zones;
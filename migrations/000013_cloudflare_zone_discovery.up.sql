@@ -0,0 +1,18 @@
+-- Cloudflare account zone discovery. cloudflare_zones holds the latest
+-- listing of every zone the customer's token can see; registered zones are
+-- flagged with cf_deleted_at once they disappear from it.
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS auto_enroll_zones BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS cf_deleted_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS cloudflare_zones (
+    customer_id   UUID        NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+    cf_zone_id    TEXT        NOT NULL,
+    name          TEXT        NOT NULL,
+    status        TEXT        NOT NULL,
+    cf_plan       TEXT        NOT NULL,
+    plan          TEXT        NOT NULL,
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    deleted_at    TIMESTAMPTZ,
+    PRIMARY KEY (customer_id, cf_zone_id)
+);
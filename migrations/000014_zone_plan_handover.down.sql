@@ -0,0 +1,2 @@
+ALTER TABLE zones DROP COLUMN IF EXISTS plan_changed_at;
+ALTER TABLE zones DROP COLUMN IF EXISTS previous_plan;
@@ -0,0 +1,6 @@
+-- Plan handover. When the worker detects that a zone's Cloudflare plan
+-- selects a different collector, it records the plan the zone came from and
+-- the instant the new collector takes over; the old one keeps collecting up
+-- to that instant so the switch leaves neither a gap nor an overlap.
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS previous_plan   TEXT NOT NULL DEFAULT '';
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS plan_changed_at TIMESTAMPTZ;
@@ -0,0 +1,11 @@
+-- Cloudflare API token preflight. The worker verifies each customer's token
+-- and probes the permissions every registered zone's collector needs; the
+-- results are kept here so the API can show them.
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS cf_token_status           TEXT NOT NULL DEFAULT '';
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS cf_token_error            TEXT NOT NULL DEFAULT '';
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS cf_token_expires_at       TIMESTAMPTZ;
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS cf_token_checked_at       TIMESTAMPTZ;
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS cf_token_expiry_alerted_at TIMESTAMPTZ;
+
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS missing_permissions    TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS permissions_checked_at TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+ALTER TABLE zones DROP COLUMN IF EXISTS logpush_challenge_at;
+ALTER TABLE zones DROP COLUMN IF EXISTS logpush_challenge;
+ALTER TABLE zones DROP COLUMN IF EXISTS logpush_secret_hash;
@@ -0,0 +1,6 @@
+-- Logpush HTTP destination. Zones that push logs to RainLogs authenticate
+-- with a per-zone secret header; only its SHA-256 is kept. The last
+-- ownership challenge Cloudflare delivered is kept for the customer to read.
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS logpush_secret_hash  TEXT NOT NULL DEFAULT '';
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS logpush_challenge    TEXT NOT NULL DEFAULT '';
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS logpush_challenge_at TIMESTAMPTZ;
@@ -0,0 +1,9 @@
+ALTER TABLE log_exports DROP COLUMN IF EXISTS filter_datasets;
+
+DROP TABLE IF EXISTS zone_datasets;
+
+DROP INDEX IF EXISTS idx_log_jobs_zone_dataset_created;
+
+UPDATE log_jobs SET log_type = 'logpush_' || dataset WHERE log_type = 'logpush';
+
+ALTER TABLE log_jobs DROP COLUMN IF EXISTS dataset;
@@ -0,0 +1,29 @@
+-- Datasets. Every job records the Cloudflare dataset its lines belong to, and
+-- each zone keeps one WORM chain per dataset; chains built before this
+-- migration span all of a zone's jobs. Logpush jobs move from
+-- 'logpush_<dataset>' log types to 'logpush' plus the dataset.
+ALTER TABLE log_jobs ADD COLUMN IF NOT EXISTS dataset TEXT NOT NULL DEFAULT 'http_requests';
+
+UPDATE log_jobs SET dataset = 'firewall_events' WHERE log_type = 'security';
+
+UPDATE log_jobs SET dataset = substr(log_type, length('logpush_') + 1), log_type = 'logpush'
+WHERE log_type LIKE 'logpush\_%';
+
+CREATE INDEX IF NOT EXISTS idx_log_jobs_zone_dataset_created
+    ON log_jobs(zone_id, dataset, created_at DESC);
+
+-- Datasets a zone archives besides the one its plan collector pulls, with
+-- their own pull schedule (pullable datasets only) and retention.
+CREATE TABLE IF NOT EXISTS zone_datasets (
+    zone_id            UUID NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    dataset            TEXT NOT NULL,
+    pull_interval_secs INTEGER NOT NULL DEFAULT 0,
+    retention_days     INTEGER NOT NULL DEFAULT 0,
+    last_pulled_at     TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (zone_id, dataset),
+    CHECK (pull_interval_secs >= 0 AND retention_days >= 0)
+);
+
+ALTER TABLE log_exports ADD COLUMN IF NOT EXISTS filter_datasets TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_log_jobs_customer_account_dataset;
+
+DELETE FROM log_jobs WHERE zone_id IS NULL;
+ALTER TABLE log_jobs ALTER COLUMN zone_id SET NOT NULL;
+
+ALTER TABLE customers DROP COLUMN IF EXISTS audit_logs_archived_until;
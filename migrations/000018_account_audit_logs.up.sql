@@ -0,0 +1,9 @@
+-- Account audit log archival. Audit logs belong to a customer's Cloudflare
+-- account rather than a zone: their jobs have no zone and form one chain per
+-- customer. The customer keeps the high-watermark the collector resumes from.
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS audit_logs_archived_until TIMESTAMPTZ;
+
+ALTER TABLE log_jobs ALTER COLUMN zone_id DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_log_jobs_customer_account_dataset
+    ON log_jobs (customer_id, dataset, created_at DESC) WHERE zone_id IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE zones DROP COLUMN IF EXISTS cf_api_key_rotated_at;
+ALTER TABLE zones DROP COLUMN IF EXISTS cf_api_key_enc;
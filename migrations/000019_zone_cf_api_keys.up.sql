@@ -0,0 +1,4 @@
+-- Zone-scoped Cloudflare API tokens. A zone's own KMS-encrypted token, when
+-- set, is used by its collectors instead of the customer's account-wide one.
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS cf_api_key_enc        TEXT NOT NULL DEFAULT '';
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS cf_api_key_rotated_at TIMESTAMPTZ;
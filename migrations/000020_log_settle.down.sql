@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_log_jobs_settle_due;
+DROP INDEX IF EXISTS idx_log_jobs_settles_job_id;
+
+ALTER TABLE log_jobs DROP COLUMN IF EXISTS settled_at;
+ALTER TABLE log_jobs DROP COLUMN IF EXISTS settles_job_id;
+
+ALTER TABLE zones DROP COLUMN IF EXISTS settle_delay_secs;
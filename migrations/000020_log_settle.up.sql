@@ -0,0 +1,14 @@
+-- Late-arrival re-pulls ("settle" mode). Logpull keeps receiving lines for a
+-- window after it was first pulled. Zones with a settle delay have each window
+-- pulled again once the delay has passed; lines the archive lacks are stored
+-- as a supplementary job of the same chain, linked to the original job.
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS settle_delay_secs INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE log_jobs ADD COLUMN IF NOT EXISTS settles_job_id UUID REFERENCES log_jobs(id);
+ALTER TABLE log_jobs ADD COLUMN IF NOT EXISTS settled_at     TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_log_jobs_settles_job_id
+    ON log_jobs(settles_job_id) WHERE settles_job_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_log_jobs_settle_due
+    ON log_jobs(zone_id, period_end)
+    WHERE log_type = 'logs' AND status = 'done' AND settled_at IS NULL AND settles_job_id IS NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE log_jobs DROP COLUMN IF EXISTS window_secs;
+
+ALTER TABLE zones DROP COLUMN IF EXISTS window_secs;
+ALTER TABLE zones DROP COLUMN IF EXISTS adaptive_window;
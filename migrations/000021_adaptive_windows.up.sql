@@ -0,0 +1,8 @@
+-- Adaptive pull windows. Zones with adaptive_window set have their Logpull
+-- windows sized by the scheduler from the volume of their recent jobs instead
+-- of pull_interval_secs; window_secs holds the size currently in use. Each job
+-- records the window it was pulled with.
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS adaptive_window BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS window_secs     INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE log_jobs ADD COLUMN IF NOT EXISTS window_secs INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_log_jobs_zone_dataset_chained;
+
+ALTER TABLE log_jobs DROP COLUMN IF EXISTS chained_at;
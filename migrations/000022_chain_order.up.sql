@@ -0,0 +1,8 @@
+-- Chain order. Jobs are linked into their chain when they finish, which need
+-- not be the order they were created in; chained_at records when a job was
+-- linked, so the next job links to the one linked last. Jobs chained before
+-- this migration have none and sort before all others.
+ALTER TABLE log_jobs ADD COLUMN IF NOT EXISTS chained_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_log_jobs_zone_dataset_chained
+    ON log_jobs(zone_id, dataset, chained_at DESC NULLS LAST) WHERE status = 'done';
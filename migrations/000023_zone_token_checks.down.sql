@@ -0,0 +1,5 @@
+ALTER TABLE zones DROP COLUMN IF EXISTS cf_token_expiry_alerted_at;
+ALTER TABLE zones DROP COLUMN IF EXISTS cf_token_checked_at;
+ALTER TABLE zones DROP COLUMN IF EXISTS cf_token_expires_at;
+ALTER TABLE zones DROP COLUMN IF EXISTS cf_token_error;
+ALTER TABLE zones DROP COLUMN IF EXISTS cf_token_status;
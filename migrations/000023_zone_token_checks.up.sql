@@ -0,0 +1,7 @@
+-- Zone token preflight. Zones with their own Cloudflare API token have it
+-- verified on its own, like the customer's, and the result kept here.
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS cf_token_status            TEXT NOT NULL DEFAULT '';
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS cf_token_error             TEXT NOT NULL DEFAULT '';
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS cf_token_expires_at        TIMESTAMPTZ;
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS cf_token_checked_at        TIMESTAMPTZ;
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS cf_token_expiry_alerted_at TIMESTAMPTZ;
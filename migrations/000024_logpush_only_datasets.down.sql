@@ -0,0 +1 @@
+ALTER TABLE zone_datasets DROP CONSTRAINT IF EXISTS zone_datasets_pull_firewall_events_only;
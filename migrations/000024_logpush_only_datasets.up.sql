@@ -0,0 +1,7 @@
+-- Only firewall events are pulled on a dataset schedule; every other dataset
+-- is Logpush-only and takes no pull interval.
+UPDATE zone_datasets SET pull_interval_secs = 0
+WHERE dataset <> 'firewall_events' AND pull_interval_secs <> 0;
+
+ALTER TABLE zone_datasets ADD CONSTRAINT zone_datasets_pull_firewall_events_only
+    CHECK (pull_interval_secs = 0 OR dataset = 'firewall_events');